@@ -0,0 +1,34 @@
+-- Migration: Create auth_sessions table for refresh tokens and server-side logout
+-- Every login creates a session. Access tokens (JWT) carry the session ID in the
+-- "sid" claim and are rejected once the session is revoked or expired.
+-- Refresh tokens are opaque, rotate on every use and only their hash is stored.
+
+-- ============================================================================
+-- AUTH SESSIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id SERIAL PRIMARY KEY,
+    session_id VARCHAR(64) UNIQUE NOT NULL,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    refresh_token_hash VARCHAR(64) NOT NULL, -- SHA-256 hex of the current refresh token secret
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    refreshed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL, -- Refresh token (and session) expiry
+    revoked_at TIMESTAMP -- NULL while the session is active
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_user_uid ON auth_sessions(user_uid);
+
+-- Partial index for "logout everywhere" and active session lookups
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_active
+    ON auth_sessions(user_uid) WHERE revoked_at IS NULL;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- session_id: Random identifier embedded in access tokens as the "sid" claim
+-- refresh_token_hash: Only the hash is stored, a database leak does not leak tokens
+-- Rotation: each refresh replaces refresh_token_hash; presenting an older refresh
+--   token for the same session is treated as token theft and revokes the session
+-- Deleting a user cascades to all of their sessions
@@ -0,0 +1,18 @@
+-- Migration: Add device information to auth_sessions
+-- Lets users see where they are signed in and revoke individual devices
+
+ALTER TABLE auth_sessions
+ADD COLUMN IF NOT EXISTS user_agent TEXT;
+
+ALTER TABLE auth_sessions
+ADD COLUMN IF NOT EXISTS ip_address VARCHAR(64);
+
+ALTER TABLE auth_sessions
+ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- user_agent / ip_address: Captured at login and updated on every refresh
+-- last_seen_at: Updated when an access token of the session is used
+--   (throttled to at most once per minute per session to limit writes)
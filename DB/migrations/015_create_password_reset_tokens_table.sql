@@ -0,0 +1,25 @@
+-- Migration: Create password_reset_tokens table for the forgotten password flow
+-- Reset tokens are emailed to the user, expire after a short time and can only
+-- be redeemed once. Only their hash is stored.
+
+-- ============================================================================
+-- PASSWORD RESET TOKENS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 hex of the emailed token
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP -- NULL until the token is redeemed or superseded
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_uid ON password_reset_tokens(user_uid);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Requesting a new token marks every outstanding token of the user as used
+-- Redeeming a token changes the password and revokes all sessions of the user
+-- Deleting a user cascades to all of their reset tokens
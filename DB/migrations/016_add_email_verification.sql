@@ -0,0 +1,36 @@
+-- Migration: Add email verification
+-- Adds email_verified_at to users and a table of emailed verification tokens.
+-- An email address counts as verified only while email_verified_at is set;
+-- changing or clearing the email resets it.
+
+-- ============================================================================
+-- USERS TABLE
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+-- ============================================================================
+-- EMAIL VERIFICATION TOKENS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id SERIAL PRIMARY KEY,
+    token_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 hex of the emailed token
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    email VARCHAR(255) NOT NULL, -- Address the token was sent to
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP -- NULL until the token is redeemed or superseded
+);
+
+-- Used for resend throttling (latest tokens of a user)
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_created
+    ON email_verification_tokens(user_uid, created_at DESC);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A token only verifies the address stored in its email column; if the user
+--   changed their email after the token was sent, the token is rejected
+-- Sending a new token marks the outstanding tokens of the user as used
+-- Existing users start unverified
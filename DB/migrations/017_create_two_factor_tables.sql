@@ -0,0 +1,40 @@
+-- Migration: Create tables for TOTP two-factor authentication
+-- A user enrolls by scanning a TOTP secret into an authenticator app and
+-- confirming a code. Recovery codes allow logging in without the app.
+
+-- ============================================================================
+-- USER TOTP TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS user_totp (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) UNIQUE NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    secret VARCHAR(64) NOT NULL, -- Base32 encoded TOTP secret (RFC 6238, SHA1, 6 digits, 30s)
+    last_used_step BIGINT DEFAULT 0 NOT NULL, -- Last accepted time step, prevents code reuse
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    enabled_at TIMESTAMP -- NULL while enrollment is pending confirmation
+);
+
+-- ============================================================================
+-- USER RECOVERY CODES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    code_hash VARCHAR(64) NOT NULL, -- SHA-256 hex of the normalized code
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    used_at TIMESTAMP, -- NULL while the code can still be used
+    UNIQUE(user_uid, code_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_uid ON user_recovery_codes(user_uid);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Two-factor authentication is enabled for a user when user_totp.enabled_at is set
+-- The TOTP secret must be readable to verify codes, so it is stored as is;
+--   recovery codes are only stored hashed and shown to the user once
+-- Regenerating recovery codes deletes the previous set
+-- Admin accounts must enroll before they can log in
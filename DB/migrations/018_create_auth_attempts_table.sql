@@ -0,0 +1,24 @@
+-- Migration: Create auth_attempts table for brute-force protection
+-- Counts failed login (and registration) attempts per key so that all
+-- replicas share the same counters. Only used with RATELIMIT_BACKEND=postgres;
+-- the default in-memory backend keeps these counters in process memory.
+
+-- ============================================================================
+-- AUTH ATTEMPTS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS auth_attempts (
+    key VARCHAR(320) PRIMARY KEY, -- "<limiter>:<id>", e.g. "login_ip:203.0.113.7" or "login_user:johndoe"
+    failures INTEGER DEFAULT 0 NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL -- Counter is forgotten after this time
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_attempts_expires_at ON auth_attempts(expires_at);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Rows are not linked to users: keys may refer to IPs or usernames that do not exist
+-- Expired rows are deleted periodically by the server
+-- Deleting the login_user row of a username unlocks the account
@@ -0,0 +1,33 @@
+-- Migration: Create jwt_signing_keys table for asymmetric JWT signing
+-- Access tokens are signed with RS256 or EdDSA keys identified by a "kid".
+-- Keys rotate on a schedule: the next key is published (JWKS) before it starts
+-- signing, and a retired key keeps verifying until the tokens it signed expire.
+-- Replaces the shared JWT_SECRET.
+
+-- ============================================================================
+-- JWT SIGNING KEYS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) UNIQUE NOT NULL, -- Key ID, sent in the JWT header and in the JWKS
+    algorithm VARCHAR(16) NOT NULL, -- 'RS256' or 'EdDSA'
+    private_key TEXT NOT NULL, -- PKCS#8 PEM, or "enc:v1:..." when JWT_KEY_ENCRYPTION_KEY is set
+    public_key TEXT NOT NULL, -- PKIX PEM
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    not_before TIMESTAMP NOT NULL, -- Starts signing new tokens
+    retires_at TIMESTAMP NOT NULL, -- Stops signing new tokens
+    expires_at TIMESTAMP NOT NULL -- Stops verifying tokens (retires_at + token lifetime)
+);
+
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_expires_at ON jwt_signing_keys(expires_at);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Keys are created by the server: at startup and every few minutes it makes sure
+--   a key covers the current time and the next hour (under an advisory lock, so
+--   replicas do not create duplicates)
+-- Keys are deleted one day after they expire
+-- Access tokens signed with the old JWT_SECRET are rejected after this change;
+--   clients obtain new ones with their refresh token (POST /auth/refresh)
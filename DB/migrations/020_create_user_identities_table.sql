@@ -0,0 +1,47 @@
+-- Migration: Create user_identities and oidc_login_states tables for OIDC login
+-- External identities ("Sign in with ...") from OpenID Connect providers are
+-- linked to users.uid. A user can sign in with any linked identity and, when
+-- it has one, with a password.
+
+-- ============================================================================
+-- USER IDENTITIES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    provider VARCHAR(64) NOT NULL, -- Provider name from OIDC_PROVIDERS
+    subject VARCHAR(255) NOT NULL, -- "sub" claim, stable per provider
+    email VARCHAR(255), -- Email reported by the provider at the last login
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_login_at TIMESTAMP,
+    UNIQUE (provider, subject),
+    UNIQUE (user_uid, provider) -- One identity per provider per user
+);
+
+-- ============================================================================
+-- OIDC LOGIN STATES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    id SERIAL PRIMARY KEY,
+    state_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 hex of the state parameter
+    provider VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL, -- PKCE verifier, only its S256 challenge leaves the server
+    nonce VARCHAR(128) NOT NULL, -- Must match the nonce claim of the id_token
+    user_uid VARCHAR(255) REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Set when linking to an existing account
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_login_states_expires_at ON oidc_login_states(expires_at);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A login state is created when the client asks for an authorization URL and
+--   deleted when the callback redeems it, so each state works once
+-- Expired states are deleted whenever a new one is created
+-- Accounts provisioned from an identity have no password_hash until the user
+--   resets their password
+-- Deleting a user cascades to their identities and pending login states
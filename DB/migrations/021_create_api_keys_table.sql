@@ -0,0 +1,32 @@
+-- Migration: Create api_keys table for personal API keys
+-- Users (and admins) mint named keys with scopes and an expiry for automation
+-- scripts, so they do not have to log in with a password. Keys are sent as
+-- "Authorization: Bearer hifi_<key_id>_<secret>". Only the secret's hash is stored.
+
+-- ============================================================================
+-- API KEYS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    key_id VARCHAR(32) UNIQUE NOT NULL, -- Public identifier, part of the key
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL, -- SHA-256 hex of the secret
+    scopes TEXT[] NOT NULL DEFAULT '{}', -- e.g. {videos:write, admin:read}
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP -- NULL while the key is active
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_uid ON api_keys(user_uid);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A write scope also grants the read scope of the same resource
+-- Admin scopes can only be granted by admins and are checked against the
+--   user's current role (and two-factor status) on every request
+-- Revoked and expired keys are kept for auditing
+-- Deleting a user cascades to all of their API keys
@@ -0,0 +1,127 @@
+-- Migration: Create roles and permissions tables for staff accounts
+-- Staff roles (admin, moderator, support, analyst) carry sets of permissions
+-- that gate the admin endpoints one by one. Roles are granted and revoked
+-- through the admin API and every change is recorded in role_audit_log.
+-- users.role keeps describing the account type (user, creator); users.role
+-- 'admin' is still honoured and holds every permission.
+
+-- ============================================================================
+-- PERMISSIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(64) PRIMARY KEY, -- e.g. comments.delete
+    description TEXT NOT NULL
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users.read', 'List users and followers'),
+    ('users.delete', 'Delete user accounts'),
+    ('users.unlock', 'Lift login lockouts'),
+    ('videos.read', 'List videos'),
+    ('videos.delete', 'Delete videos'),
+    ('comments.read', 'List comments and replies'),
+    ('comments.delete', 'Delete comments and replies'),
+    ('counters.read', 'Read global counters'),
+    ('counters.resync', 'Resync global counters'),
+    ('sessions.read', 'List the sessions of a user'),
+    ('sessions.revoke', 'Revoke the sessions of a user'),
+    ('api_keys.read', 'List the API keys of a user'),
+    ('api_keys.revoke', 'Revoke the API keys of a user'),
+    ('roles.read', 'List roles, role grants and the role audit log'),
+    ('roles.manage', 'Grant and revoke roles')
+ON CONFLICT (name) DO NOTHING;
+
+-- ============================================================================
+-- ROLES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(32) PRIMARY KEY,
+    description TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to every admin endpoint'),
+    ('moderator', 'Reviews and removes content'),
+    ('support', 'Helps users with their accounts'),
+    ('analyst', 'Read-only access to content and counters')
+ON CONFLICT (name) DO NOTHING;
+
+-- ============================================================================
+-- ROLE PERMISSIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(32) NOT NULL REFERENCES roles(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    permission VARCHAR(64) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+-- admin holds every permission
+INSERT INTO role_permissions (role, permission)
+SELECT 'admin', name FROM permissions
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('moderator', 'users.read'),
+    ('moderator', 'videos.read'),
+    ('moderator', 'videos.delete'),
+    ('moderator', 'comments.read'),
+    ('moderator', 'comments.delete'),
+    ('support', 'users.read'),
+    ('support', 'users.unlock'),
+    ('support', 'sessions.read'),
+    ('support', 'sessions.revoke'),
+    ('support', 'api_keys.read'),
+    ('support', 'api_keys.revoke'),
+    ('analyst', 'users.read'),
+    ('analyst', 'videos.read'),
+    ('analyst', 'comments.read'),
+    ('analyst', 'counters.read')
+ON CONFLICT DO NOTHING;
+
+-- ============================================================================
+-- USER ROLES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    role VARCHAR(32) NOT NULL REFERENCES roles(name) ON DELETE CASCADE ON UPDATE CASCADE,
+    granted_by VARCHAR(255), -- NULL when granted by this migration
+    granted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_uid, role)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role);
+
+-- Existing admins get the admin role
+INSERT INTO user_roles (user_uid, role)
+SELECT uid, 'admin' FROM users WHERE role = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- ============================================================================
+-- ROLE AUDIT LOG TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS role_audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_uid VARCHAR(255) NOT NULL, -- Staff member who made the change
+    target_uid VARCHAR(255) NOT NULL, -- User whose roles changed
+    role VARCHAR(32) NOT NULL,
+    action VARCHAR(16) NOT NULL CHECK (action IN ('grant', 'revoke')),
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_audit_log_target_uid ON role_audit_log(target_uid, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_role_audit_log_created_at ON role_audit_log(created_at DESC);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A user's permissions are the union of the permissions of their roles;
+--   users.role 'admin' implies every permission
+-- Staff (any role, or users.role 'admin') must use two-factor authentication
+-- role_audit_log has no foreign keys so that it survives deleted users
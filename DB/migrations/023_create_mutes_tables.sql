@@ -0,0 +1,42 @@
+-- Migration: Create mutes and muted_words tables
+-- A mute is a softer, private alternative to a block: the muter stops seeing
+-- the muted user's videos in the feeds and their comments, while the muted
+-- user sees no change. Muted keywords and tags hide matching videos from the
+-- muter's feeds.
+
+-- ============================================================================
+-- MUTES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS mutes (
+    id SERIAL PRIMARY KEY,
+    muted_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who muted
+    muted_to VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who is muted
+    muted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(muted_by, muted_to)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mutes_muted_by ON mutes(muted_by);
+
+-- ============================================================================
+-- MUTED WORDS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS muted_words (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    word VARCHAR(100) NOT NULL, -- Stored lowercased
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('keyword', 'tag')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(user_uid, word, kind)
+);
+
+CREATE INDEX IF NOT EXISTS idx_muted_words_user_uid ON muted_words(user_uid);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Mutes are only visible to the muter and do not touch followers or counters
+-- A keyword matches videos whose title or description contains it (case-insensitive)
+-- A tag matches videos with that tag (case-insensitive)
+-- Deleting a user cascades to their mutes and muted words, and to mutes of them
@@ -0,0 +1,33 @@
+-- Migration: Add private accounts and follow requests
+-- Adds is_private to users. Following a private account creates a pending
+-- follow request that the account can approve or reject; only approved
+-- followers (and the owner) see the account's videos.
+
+-- ============================================================================
+-- USERS TABLE
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_private BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- ============================================================================
+-- FOLLOW REQUESTS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS follow_requests (
+    id SERIAL PRIMARY KEY,
+    requested_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who wants to follow
+    requested_to VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Private account to follow
+    requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(requested_by, requested_to)
+);
+
+-- UNIQUE(requested_by, requested_to) covers outgoing requests
+CREATE INDEX IF NOT EXISTS idx_follow_requests_requested_to ON follow_requests(requested_to, requested_at DESC);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Approving a request deletes it and inserts the follow; rejecting deletes it
+-- Making an account public approves its pending requests
+-- Blocking deletes the requests between the two users in both directions
+-- Existing accounts start public
@@ -0,0 +1,100 @@
+-- Migration: Deduplicate video views
+-- Views are recorded by POST /social/videos/view/{videoID} once the client
+-- reports a minimum watch time. Authenticated views are kept once per user in
+-- views; anonymous views are kept in anonymous_views under a hash of the
+-- client's IP address and user agent, once per hash and video within a time
+-- window. videos.video_views is the number of rows of both tables for the video,
+-- plus the views counted before this migration (videos.legacy_views).
+
+-- ============================================================================
+-- ANONYMOUS VIEWS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS anonymous_views (
+    id SERIAL PRIMARY KEY,
+    viewer_hash VARCHAR(64) NOT NULL, -- blake3 hash of the salted IP address and user agent
+    viewed_to VARCHAR(255) NOT NULL REFERENCES videos(video_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Video which is viewed
+    viewed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Dedup lookup: latest view of a video by a hash
+CREATE INDEX IF NOT EXISTS idx_anonymous_views_viewer_hash ON anonymous_views(viewer_hash, viewed_to, viewed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_anonymous_views_viewed_to ON anonymous_views(viewed_to);
+
+-- ============================================================================
+-- VIDEO VIEWS TRIGGER
+-- ============================================================================
+
+-- Function to keep videos.video_views equal to the views of the video
+CREATE OR REPLACE FUNCTION update_video_views()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE videos SET video_views = video_views + 1 WHERE video_id = NEW.viewed_to;
+        RETURN NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE videos SET video_views = video_views - 1 WHERE video_id = OLD.viewed_to;
+        RETURN OLD;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop existing triggers if they exist (for idempotency)
+DROP TRIGGER IF EXISTS trigger_video_views ON views;
+DROP TRIGGER IF EXISTS trigger_anonymous_video_views ON anonymous_views;
+DROP TRIGGER IF EXISTS trigger_anonymous_views_counter ON anonymous_views;
+
+CREATE TRIGGER trigger_video_views
+    AFTER INSERT OR DELETE ON views
+    FOR EACH ROW
+    EXECUTE FUNCTION update_video_views();
+
+CREATE TRIGGER trigger_anonymous_video_views
+    AFTER INSERT OR DELETE ON anonymous_views
+    FOR EACH ROW
+    EXECUTE FUNCTION update_video_views();
+
+-- Anonymous views count towards system_counters.views_count too
+CREATE TRIGGER trigger_anonymous_views_counter
+    AFTER INSERT OR DELETE ON anonymous_views
+    FOR EACH ROW
+    EXECUTE FUNCTION update_views_counter();
+
+-- ============================================================================
+-- DERIVE VIEW COUNTS
+-- ============================================================================
+
+-- The old counts were incremented on every GET /videos/{videoID} and cannot be
+-- deduplicated. The first run keeps the part of each count that is not backed
+-- by view rows as a one-time offset, so no count goes down. The updates below
+-- only apply while legacy_views is still NULL (the first run), so later runs
+-- (migrations run on every boot) leave the counts to the triggers.
+ALTER TABLE videos ADD COLUMN IF NOT EXISTS legacy_views INTEGER;
+
+UPDATE system_counters SET
+    views_count = (SELECT COUNT(*) FROM views) + (SELECT COUNT(*) FROM anonymous_views),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = 1 AND EXISTS (SELECT 1 FROM videos WHERE legacy_views IS NULL);
+
+-- video_views becomes legacy_views plus the view rows, i.e. the larger of the two counts
+UPDATE videos v SET
+    legacy_views = GREATEST(COALESCE(v.video_views, 0)
+        - (SELECT COUNT(*) FROM views WHERE viewed_to = v.video_id)
+        - (SELECT COUNT(*) FROM anonymous_views WHERE viewed_to = v.video_id), 0),
+    video_views = GREATEST(COALESCE(v.video_views, 0),
+        (SELECT COUNT(*) FROM views WHERE viewed_to = v.video_id)
+        + (SELECT COUNT(*) FROM anonymous_views WHERE viewed_to = v.video_id))
+WHERE v.legacy_views IS NULL;
+
+ALTER TABLE videos ALTER COLUMN legacy_views SET DEFAULT 0;
+ALTER TABLE videos ALTER COLUMN legacy_views SET NOT NULL;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- GET /videos/{videoID} no longer counts a view
+-- An authenticated user counts once per video (UNIQUE(viewed_by, viewed_to))
+-- Anonymous rows are kept, since the counts are derived from them
+-- legacy_views is only set on the first run and is 0 for videos uploaded later
+-- Re-running this migration does not touch the counts; the triggers keep them in sync
@@ -0,0 +1,34 @@
+-- Migration: Add comment and reply edits
+-- Authors can edit their comments and replies. An edit sets edited_at and
+-- keeps the previous text in comment_edits, which moderators can read.
+
+-- ============================================================================
+-- COMMENTS AND REPLIES TABLES
+-- ============================================================================
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP; -- NULL if never edited
+ALTER TABLE replies ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP; -- NULL if never edited
+
+-- ============================================================================
+-- COMMENT EDITS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS comment_edits (
+    id SERIAL PRIMARY KEY,
+    comment_id VARCHAR(255) REFERENCES comments(comment_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Edited comment, NULL for a reply
+    reply_id VARCHAR(255) REFERENCES replies(reply_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Edited reply, NULL for a comment
+    edited_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Author who edited
+    previous_text TEXT NOT NULL, -- Text before the edit
+    edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CHECK (num_nonnulls(comment_id, reply_id) = 1)
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_edits_comment_id ON comment_edits(comment_id, edited_at DESC);
+CREATE INDEX IF NOT EXISTS idx_comment_edits_reply_id ON comment_edits(reply_id, edited_at DESC);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- One row per edit, holding the text it replaced; the current text stays in comments / replies
+-- Re-posting a reply to the same comment (POST /social/videos/reply/{commentID}) is an edit too
+-- Deleting a comment or reply deletes its history
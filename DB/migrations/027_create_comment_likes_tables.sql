@@ -0,0 +1,40 @@
+-- Migration: Add likes on comments and replies
+-- A user likes a comment or reply at most once; total_likes is kept in step
+-- with the like rows in the same transaction.
+
+-- ============================================================================
+-- COMMENTS AND REPLIES TABLES
+-- ============================================================================
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS total_likes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE replies ADD COLUMN IF NOT EXISTS total_likes INTEGER NOT NULL DEFAULT 0;
+
+-- ============================================================================
+-- LIKES TABLES
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS comment_likes (
+    id SERIAL PRIMARY KEY,
+    liked_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who liked
+    comment_id VARCHAR(255) NOT NULL REFERENCES comments(comment_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Comment which is liked
+    liked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(liked_by, comment_id)
+);
+
+CREATE TABLE IF NOT EXISTS reply_likes (
+    id SERIAL PRIMARY KEY,
+    liked_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who liked
+    reply_id VARCHAR(255) NOT NULL REFERENCES replies(reply_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Reply which is liked
+    liked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(liked_by, reply_id)
+);
+
+-- UNIQUE(liked_by, ...) covers the caller's likes
+CREATE INDEX IF NOT EXISTS idx_comment_likes_comment_id ON comment_likes(comment_id);
+CREATE INDEX IF NOT EXISTS idx_reply_likes_reply_id ON reply_likes(reply_id);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Newest / oldest comment orders use idx_comments_commented_to_at (migration 012)
+-- The "top" order ranks by likes and age at query time, so it is not indexed
@@ -0,0 +1,19 @@
+-- Migration: Add pinned and hearted comments
+-- The owner of a video pins at most one of its comments to the top of the
+-- comment list and hearts any number of them.
+
+-- ============================================================================
+-- COMMENTS TABLE
+-- ============================================================================
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP; -- NULL unless pinned by the video owner
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hearted_at TIMESTAMP; -- NULL unless hearted by the video owner
+
+-- At most one pinned comment per video
+CREATE UNIQUE INDEX IF NOT EXISTS idx_comments_pinned_video ON comments(commented_to) WHERE pinned_at IS NOT NULL;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Pinning a comment unpins the video's previous pinned comment in the same transaction
+-- Deleting the pinned comment leaves the video without one
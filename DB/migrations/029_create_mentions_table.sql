@@ -0,0 +1,32 @@
+-- Migration: Add @mentions in comments, replies and video descriptions
+-- A mention row is kept for each @username that resolved to a user when the
+-- text was written or edited; its offsets locate the mention in the text.
+
+-- ============================================================================
+-- MENTIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS mentions (
+    id SERIAL PRIMARY KEY,
+    mentioned_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who is mentioned
+    mentioned_by VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Author of the text
+    comment_id VARCHAR(255) REFERENCES comments(comment_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Set for a mention in a comment
+    reply_id VARCHAR(255) REFERENCES replies(reply_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Set for a mention in a reply
+    video_id VARCHAR(255) REFERENCES videos(video_id) ON DELETE CASCADE ON UPDATE CASCADE, -- Set for a mention in a video description
+    start_offset INTEGER NOT NULL, -- Character offset of the @ in the text
+    end_offset INTEGER NOT NULL, -- Character offset just past the username
+    mentioned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CHECK (num_nonnulls(comment_id, reply_id, video_id) = 1)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mentions_comment_id ON mentions(comment_id) WHERE comment_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_mentions_reply_id ON mentions(reply_id) WHERE reply_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_mentions_video_id ON mentions(video_id) WHERE video_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_mentions_mentioned_uid_at ON mentions(mentioned_uid, mentioned_at DESC);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Editing a comment or reply replaces its mention rows
+-- Mentions of nonexistent users, and of users who blocked (or are blocked by)
+-- the author, get no row
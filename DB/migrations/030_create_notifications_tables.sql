@@ -0,0 +1,58 @@
+-- Migration: Create notifications, notification_actors and notification_preferences tables
+-- A user is notified when someone follows them, comments on their video,
+-- replies to their comment, upvotes their video or mentions them. Bursts are
+-- aggregated: an unread notification of the same type on the same target
+-- counts every user who acted ("12 people upvoted your video").
+
+-- ============================================================================
+-- NOTIFICATIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who is notified
+    type VARCHAR(16) NOT NULL CHECK (type IN ('follow', 'comment', 'reply', 'upvote', 'mention')),
+    actor_uid VARCHAR(255) REFERENCES users(uid) ON DELETE SET NULL ON UPDATE CASCADE, -- Latest user who acted
+    actor_count INTEGER NOT NULL DEFAULT 1, -- Distinct users who acted
+    video_id VARCHAR(255) REFERENCES videos(video_id) ON DELETE CASCADE ON UPDATE CASCADE,
+    comment_id VARCHAR(255) REFERENCES comments(comment_id) ON DELETE CASCADE ON UPDATE CASCADE,
+    reply_id VARCHAR(255) REFERENCES replies(reply_id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL, -- Last time a user acted
+    read_at TIMESTAMP -- NULL while unread
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_uid_updated_at ON notifications(user_uid, updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_uid_unread ON notifications(user_uid, type) WHERE read_at IS NULL;
+
+-- ============================================================================
+-- NOTIFICATION ACTORS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS notification_actors (
+    id SERIAL PRIMARY KEY,
+    notification_id INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    actor_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    acted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(notification_id, actor_uid)
+);
+
+-- ============================================================================
+-- NOTIFICATION PREFERENCES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id SERIAL PRIMARY KEY,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    type VARCHAR(16) NOT NULL CHECK (type IN ('follow', 'comment', 'reply', 'upvote', 'mention')),
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(user_uid, type)
+);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A type without a preference row is enabled
+-- Notifications go away with the video, comment or reply they are about
+-- Taking back a follow or an upvote does not remove the notification
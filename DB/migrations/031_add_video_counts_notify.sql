@@ -0,0 +1,49 @@
+-- Migration: Publish video count changes to the event stream
+-- Clients watching a video get its live view, vote and comment counts over
+-- GET /stream. Counts change in many places (the views triggers of migration
+-- 025, votes, comments and their deletes), so a trigger on videos publishes
+-- every change with pg_notify on the hifi_stream channel that every API
+-- instance listens on (Services/Realtime).
+
+-- ============================================================================
+-- VIDEO COUNTS TRIGGER
+-- ============================================================================
+
+-- Function to publish the counts of a video; delivered when the transaction commits
+CREATE OR REPLACE FUNCTION notify_video_counts()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('hifi_stream', json_build_object(
+        'topic', 'video:' || NEW.video_id,
+        'event', 'counts',
+        'data', json_build_object(
+            'video_id', NEW.video_id,
+            'views', NEW.video_views,
+            'upvotes', NEW.video_upvotes,
+            'downvotes', NEW.video_downvotes,
+            'comments', NEW.video_comments
+        )
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Drop existing trigger if it exists (for idempotency)
+DROP TRIGGER IF EXISTS trigger_video_counts_notify ON videos;
+
+CREATE TRIGGER trigger_video_counts_notify
+    AFTER UPDATE OF video_views, video_upvotes, video_downvotes, video_comments ON videos
+    FOR EACH ROW
+    WHEN (
+        OLD.video_views IS DISTINCT FROM NEW.video_views
+        OR OLD.video_upvotes IS DISTINCT FROM NEW.video_upvotes
+        OR OLD.video_downvotes IS DISTINCT FROM NEW.video_downvotes
+        OR OLD.video_comments IS DISTINCT FROM NEW.video_comments
+    )
+    EXECUTE FUNCTION notify_video_counts();
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- The channel name and payload format must match Services/Realtime (Channel, Message)
+-- Events are not stored: a client that was not connected refetches the video
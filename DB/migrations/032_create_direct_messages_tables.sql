@@ -0,0 +1,78 @@
+-- Migration: Create conversations, conversation_members, messages and message_deletions tables
+-- 1:1 direct messages between users. Each pair of users has at most one
+-- conversation; every participant keeps their own read position (for read
+-- receipts) and can delete messages for themselves. Adds messages_from to
+-- users: who may message the user (followers or mutual follows only).
+
+-- ============================================================================
+-- USERS TABLE
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS messages_from VARCHAR(16) NOT NULL DEFAULT 'followers'
+    CHECK (messages_from IN ('followers', 'mutual'));
+
+-- ============================================================================
+-- CONVERSATIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    user_a VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Participant with the smaller UID
+    user_b VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Participant with the larger UID
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_message_at TIMESTAMP, -- NULL until the first message
+    CHECK (user_a < user_b),
+    UNIQUE(user_a, user_b)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_user_b ON conversations(user_b);
+
+-- ============================================================================
+-- CONVERSATION MEMBERS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS conversation_members (
+    id SERIAL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    last_read_message_id BIGINT NOT NULL DEFAULT 0, -- Messages up to this ID are read
+    read_at TIMESTAMP, -- When last_read_message_id last moved
+    UNIQUE(conversation_id, user_uid)
+);
+
+-- A user's conversations
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user_uid ON conversation_members(user_uid);
+
+-- ============================================================================
+-- MESSAGES TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS messages (
+    id BIGSERIAL PRIMARY KEY, -- Also the pagination cursor
+    conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    sender_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    body TEXT NOT NULL,
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Cursor pagination: messages of a conversation, newest first
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id, id DESC);
+
+-- ============================================================================
+-- MESSAGE DELETIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS message_deletions (
+    id SERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- User who no longer sees the message
+    deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    UNIQUE(message_id, user_uid)
+);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Both participants get a conversation_members row when the conversation is created
+-- A message deleted by both participants is removed
+-- Deleting a user removes their conversations with everything in them
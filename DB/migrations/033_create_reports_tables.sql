@@ -0,0 +1,89 @@
+-- Migration: Create reports and moderation_actions tables
+-- Users report videos, comments, replies and users with a reason from a fixed
+-- taxonomy. Moderators work through the open reports grouped by target and
+-- dismiss them, take the content down, or warn or suspend its author; every
+-- action is recorded in moderation_actions with the moderator's UID. Adds
+-- suspended_until to users and the permissions gating the moderation queue.
+
+-- ============================================================================
+-- USERS TABLE
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP; -- NULL (or in the past) when not suspended
+
+-- ============================================================================
+-- MODERATION ACTIONS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id SERIAL PRIMARY KEY,
+    moderator_uid VARCHAR(255) NOT NULL, -- Staff member who took the action
+    action VARCHAR(16) NOT NULL CHECK (action IN ('dismiss', 'take_down', 'warn', 'suspend', 'unsuspend')),
+    target_type VARCHAR(16) NOT NULL CHECK (target_type IN ('video', 'comment', 'reply', 'user')),
+    target_id VARCHAR(255) NOT NULL, -- video_id, comment_id, reply_id or uid
+    target_uid VARCHAR(255) NOT NULL, -- Author of the content (the user itself for user targets)
+    reason TEXT,
+    suspended_until TIMESTAMP, -- Set for suspend
+    reports_resolved INTEGER NOT NULL DEFAULT 0, -- Open reports closed by the action
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_target ON moderation_actions(target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_target_uid ON moderation_actions(target_uid, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_created_at ON moderation_actions(created_at DESC);
+
+-- ============================================================================
+-- REPORTS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL PRIMARY KEY,
+    reporter_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    target_type VARCHAR(16) NOT NULL CHECK (target_type IN ('video', 'comment', 'reply', 'user')),
+    target_id VARCHAR(255) NOT NULL, -- video_id, comment_id, reply_id or uid
+    target_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE, -- Author of the content (the user itself for user targets)
+    reason VARCHAR(32) NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate_speech', 'violence', 'sexual_content',
+        'self_harm', 'misinformation', 'impersonation', 'copyright', 'other')),
+    details TEXT, -- Free text from the reporter
+    status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'actioned')),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP,
+    resolved_by VARCHAR(255), -- Moderator who resolved the report
+    action_id INTEGER REFERENCES moderation_actions(id) ON DELETE SET NULL -- Action that resolved the report
+);
+
+-- A reporter has at most one open report per target
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_reporter_target ON reports(reporter_uid, target_type, target_id) WHERE status = 'open';
+
+-- The moderation queue: open reports grouped by target
+CREATE INDEX IF NOT EXISTS idx_reports_open_target ON reports(target_type, target_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_reports_target ON reports(target_type, target_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_reports_reporter_uid ON reports(reporter_uid, created_at DESC);
+
+-- ============================================================================
+-- PERMISSIONS
+-- ============================================================================
+
+INSERT INTO permissions (name, description) VALUES
+    ('reports.read', 'List reports, the moderation queue and the moderation log'),
+    ('reports.manage', 'Dismiss reports and warn authors'),
+    ('users.suspend', 'Suspend users and lift suspensions')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'reports.read'),
+    ('admin', 'reports.manage'),
+    ('admin', 'users.suspend'),
+    ('moderator', 'reports.read'),
+    ('moderator', 'reports.manage'),
+    ('moderator', 'users.suspend')
+ON CONFLICT DO NOTHING;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- target_id has no foreign key so reports outlive the content they are about
+-- Reports are deleted with their reporter and with the reported user
+-- moderation_actions has no foreign keys, like role_audit_log, so the log survives deleted users
+-- Taking content down also needs videos.delete or comments.delete
+-- A suspended user cannot start sessions; suspending revokes their sessions and API keys
@@ -0,0 +1,18 @@
+-- Migration: Make room for encrypted TOTP secrets
+-- When JWT_KEY_ENCRYPTION_KEY is set, TOTP secrets are sealed with AES-GCM
+-- like the JWT signing keys ("enc:v1:" followed by base64), which does not fit
+-- in the original VARCHAR(64) column.
+
+-- ============================================================================
+-- USER TOTP TABLE
+-- ============================================================================
+
+-- Base32 TOTP secret, or "enc:v1:..." when JWT_KEY_ENCRYPTION_KEY is set
+ALTER TABLE user_totp ALTER COLUMN secret TYPE TEXT;
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- Secrets stored before the encryption key was configured stay readable;
+--   they are sealed the next time the user passes a TOTP check
+-- Keep JWT_KEY_ENCRYPTION_KEY stable, sealed secrets cannot be read without it
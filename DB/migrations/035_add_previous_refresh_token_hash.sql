@@ -0,0 +1,19 @@
+-- Migration: Remember the previous refresh token of each session
+-- Reuse detection used to revoke a session on any refresh token with the right
+-- session ID but the wrong secret. Only a replay of the secret that was rotated
+-- away (the previous one) is evidence of a copied token, so that hash is kept.
+
+-- ============================================================================
+-- AUTH SESSIONS TABLE
+-- ============================================================================
+
+-- SHA-256 hex of the refresh token secret the last refresh replaced
+ALTER TABLE auth_sessions
+ADD COLUMN IF NOT EXISTS previous_refresh_token_hash VARCHAR(64);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- NULL until the session is refreshed for the first time
+-- Presenting the previous secret revokes the session; any other wrong secret is
+--   rejected as an invalid refresh token and leaves the session alone
@@ -0,0 +1,29 @@
+-- Migration: Create stream_tickets table for opening event streams
+-- Browsers' EventSource cannot set the Authorization header, so GET /stream
+-- used to take the access token in the query string, where it ends up in
+-- proxy logs and browser history. A client now exchanges its credential for a
+-- short-lived, single-use ticket (POST /stream/ticket) and passes the ticket.
+
+-- ============================================================================
+-- STREAM TICKETS TABLE
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS stream_tickets (
+    id SERIAL PRIMARY KEY,
+    ticket_hash VARCHAR(64) UNIQUE NOT NULL, -- SHA-256 hex of the ticket
+    user_uid VARCHAR(255) NOT NULL REFERENCES users(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+    session_id VARCHAR(64) REFERENCES auth_sessions(session_id) ON DELETE CASCADE, -- Set for tickets issued to a session
+    api_key_id VARCHAR(32) REFERENCES api_keys(key_id) ON DELETE CASCADE, -- Set for tickets issued to an API key
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_stream_tickets_expires_at ON stream_tickets(expires_at);
+
+-- ============================================================================
+-- NOTES
+-- ============================================================================
+-- A ticket is deleted when a stream redeems it, so each ticket opens one stream
+-- Redeeming checks that the session or API key that asked for the ticket is
+--   still active; the stream gets the same principal (and API key scopes)
+-- Expired tickets are deleted whenever a new one is created
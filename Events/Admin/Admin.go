@@ -3,10 +3,13 @@ package admin
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,57 +22,69 @@ import (
 	Videos "hifi/Events/Videos"
 	Auth "hifi/Services/Auth"
 	Mdb "hifi/Services/Mdb"
+	Ratelimit "hifi/Services/Ratelimit"
 	storage "hifi/Services/Storage"
 	Utils "hifi/Utils"
 )
 
-var GetClaims func(r *http.Request) (*Auth.Token, bool) = Auth.GetClaims
+// MaxRoleReasonLength is the maximum length of the reason recorded with a role change
+const MaxRoleReasonLength = 500
 
 // Handle sets up the routes for admin endpoints
+// Events.Handler mounts them behind RequireAuth; each route then requires its permission
+// (held through a staff role, see Services/Auth/Roles.go) and two-factor authentication.
+// API keys additionally need admin:read or admin:write
 func Handle(r chi.Router) {
-	// List endpoints
-	r.Get("/users", ListUsers)
-	r.Get("/videos", ListVideos)
-	r.Get("/comments", ListComments)
-	r.Get("/replies", ListReplies)
-	r.Get("/followers", ListFollowers)
-	r.Get("/counters", GetCounters)
-	r.Post("/counters/resync", ResyncCounters)
-
-	// Delete endpoints
-	r.Delete("/users/{uid}", DeleteUser)
-	r.Delete("/videos/{videoID}", DeleteVideo)
-	r.Delete("/comments/{commentID}", DeleteComment)
-	r.Delete("/replies/{replyID}", DeleteReply)
-}
-
-// requireAdmin checks if the authenticated user has admin role
-func requireAdmin(w http.ResponseWriter, r *http.Request) (*Users.User, bool) {
-	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return nil, false
+	read := func(permission string) chi.Router {
+		return r.With(Auth.RequireScope(Auth.ScopeAdminRead), Auth.RequirePermission(permission), Auth.RequireTwoFactor)
 	}
-
-	// Fetch user to check role
-	user, err := fetchUserByUID(ctx, claims.UID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
-		} else {
-			log.Printf("requireAdmin: failed to fetch user: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
-		}
-		return nil, false
+	write := func(permission string) chi.Router {
+		return r.With(Auth.RequireScope(Auth.ScopeAdminWrite), Auth.RequirePermission(permission), Auth.RequireTwoFactor)
 	}
 
-	if user.Role != "admin" {
-		Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: admin access required")
-		return nil, false
-	}
+	// List endpoints
+	read(Auth.PermUsersRead).Get("/users", ListUsers)
+	read(Auth.PermVideosRead).Get("/videos", ListVideos)
+	read(Auth.PermCommentsRead).Get("/comments", ListComments)
+	read(Auth.PermCommentsRead).Get("/replies", ListReplies)
+	read(Auth.PermCommentsRead).Get("/comments/{commentID}/edits", ListCommentEdits)
+	read(Auth.PermCommentsRead).Get("/replies/{replyID}/edits", ListReplyEdits)
+	read(Auth.PermUsersRead).Get("/followers", ListFollowers)
+	read(Auth.PermCountersRead).Get("/counters", GetCounters)
+	write(Auth.PermCountersResync).Post("/counters/resync", ResyncCounters)
 
-	return user, true
+	// Delete endpoints
+	write(Auth.PermUsersDelete).Delete("/users/{uid}", DeleteUser)
+	write(Auth.PermVideosDelete).Delete("/videos/{videoID}", DeleteVideo)
+	write(Auth.PermCommentsDelete).Delete("/comments/{commentID}", DeleteComment)
+	write(Auth.PermCommentsDelete).Delete("/replies/{replyID}", DeleteReply)
+
+	// Session endpoints
+	read(Auth.PermSessionsRead).Get("/users/{uid}/sessions", ListUserSessions)
+	write(Auth.PermSessionsRevoke).Delete("/users/{uid}/sessions", RevokeUserSessions)
+	write(Auth.PermSessionsRevoke).Delete("/users/{uid}/sessions/{sessionID}", RevokeUserSession)
+
+	// Brute-force protection
+	write(Auth.PermUsersUnlock).Post("/users/{uid}/unlock", UnlockUser)
+
+	// API key endpoints
+	read(Auth.PermAPIKeysRead).Get("/users/{uid}/api-keys", ListUserAPIKeys)
+	write(Auth.PermAPIKeysRevoke).Delete("/users/{uid}/api-keys", RevokeUserAPIKeys)
+	write(Auth.PermAPIKeysRevoke).Delete("/users/{uid}/api-keys/{keyID}", RevokeUserAPIKey)
+
+	// Role endpoints
+	read(Auth.PermRolesRead).Get("/roles", ListRoles)
+	read(Auth.PermRolesRead).Get("/roles/audit", ListRoleAudit)
+	read(Auth.PermRolesRead).Get("/users/{uid}/roles", ListUserRoles)
+	write(Auth.PermRolesManage).Post("/users/{uid}/roles", GrantUserRole)
+	write(Auth.PermRolesManage).Delete("/users/{uid}/roles/{role}", RevokeUserRole)
+
+	// Moderation queue endpoints (see Reports.go)
+	read(Auth.PermReportsRead).Get("/reports", ListReportQueue)
+	read(Auth.PermReportsRead).Get("/reports/{targetType}/{targetID}", GetReportTarget)
+	write(Auth.PermReportsManage).Post("/reports/{targetType}/{targetID}/actions", ModerateReportTarget)
+	read(Auth.PermReportsRead).Get("/moderation/actions", ListModerationActions)
+	write(Auth.PermUsersSuspend).Post("/users/{uid}/unsuspend", UnsuspendUser)
 }
 
 // fetchUserByUID retrieves a user by their UID
@@ -77,13 +92,13 @@ func fetchUserByUID(ctx context.Context, uid string) (*Users.User, error) {
 	var user Users.User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		uid,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -113,10 +128,6 @@ func getStringValue(s *string) interface{} {
 // ListUsers lists all users with pagination and optional filters
 func ListUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -158,7 +169,7 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 	updatedBeforeStr := r.URL.Query().Get("updated_before")
 
 	// Build query with filters
-	query := `SELECT id, uid, username, name, role, profile_picture, bio, email, 
+	query := `SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 		followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users`
 	args := []interface{}{}
@@ -295,7 +306,7 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 		var bioNull, emailNull sql.NullString
 		if err := rows.Scan(
 			&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-			&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+			&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 			&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 		); err != nil {
 			log.Printf("ListUsers: failed to scan user: %v", err)
@@ -370,10 +381,6 @@ func ListUsers(w http.ResponseWriter, r *http.Request) {
 // ListVideos lists all videos with pagination and optional filters
 func ListVideos(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -673,10 +680,6 @@ func ListVideos(w http.ResponseWriter, r *http.Request) {
 // ListComments lists all comments with pagination and optional filters
 func ListComments(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -700,7 +703,7 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 
 	// Build query with optional filter
 	query := `SELECT id, comment_id, commented_by, commented_to, commented_at, comment, 
-		comment_by_username, total_replies
+		comment_by_username, total_replies, edited_at, total_likes, pinned_at, hearted_at
 		FROM comments`
 	args := []interface{}{}
 	argPos := 1
@@ -730,7 +733,8 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(
 			&comment.ID, &comment.CommentID, &comment.CommentedBy, &comment.CommentedTo,
 			&comment.CommentedAt, &comment.Comment, &comment.CommentByUsername,
-			&comment.TotalReplies,
+			&comment.TotalReplies, &comment.EditedAt, &comment.TotalLikes,
+			&comment.PinnedAt, &comment.HeartedAt,
 		); err != nil {
 			log.Printf("ListComments: failed to scan comment: %v", err)
 			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch comments")
@@ -757,10 +761,6 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 // ListReplies lists all replies with pagination and optional filters
 func ListReplies(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -783,7 +783,7 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build query with optional filter
-	query := `SELECT id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username
+	query := `SELECT id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username, edited_at, total_likes
 		FROM replies`
 	args := []interface{}{}
 	argPos := 1
@@ -812,7 +812,7 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 		var reply Social.Replies
 		if err := rows.Scan(
 			&reply.ID, &reply.ReplyID, &reply.RepliedBy, &reply.RepliedTo,
-			&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername,
+			&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername, &reply.EditedAt, &reply.TotalLikes,
 		); err != nil {
 			log.Printf("ListReplies: failed to scan reply: %v", err)
 			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch replies")
@@ -839,10 +839,6 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 // ListFollowers lists all follower relationships with pagination and optional filters
 func ListFollowers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -993,13 +989,9 @@ func ListFollowers(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// DeleteUser deletes a user by UID (admin only)
+// DeleteUser deletes a user by UID (requires users.delete)
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	uid := chi.URLParam(r, "uid")
 	if uid == "" {
@@ -1011,13 +1003,13 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var existing Users.User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		uid,
 	).Scan(
 		&existing.ID, &existing.UID, &existing.Username, &existing.Name, &existing.Role,
-		&existing.ProfilePicture, &bioNull, &emailNull, &existing.Followers, &existing.Following,
+		&existing.ProfilePicture, &bioNull, &emailNull, &existing.EmailVerified, &existing.IsPrivate, &existing.Followers, &existing.Following,
 		&existing.TotalStreams, &existing.TotalVideos, &existing.CreatedAt, &existing.UpdatedAt,
 	)
 	if err != nil {
@@ -1082,13 +1074,9 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	Utils.SendSuccessResponse(w, map[string]string{"message": "User deleted successfully"})
 }
 
-// DeleteVideo deletes a video by videoID (admin only)
+// DeleteVideo deletes a video by videoID (requires videos.delete)
 func DeleteVideo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -1096,132 +1084,126 @@ func DeleteVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if video exists
-	var video Videos.Videos
-	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, video_id, video_url, video_thumbnail, video_title, video_description, 
-			video_tags, video_views, video_upvotes, video_downvotes, video_comments, 
-			user_uid, user_username, created_at, updated_at
-		FROM videos WHERE video_id = $1`,
-		videoID,
-	).Scan(
-		&video.ID, &video.VideoID, &video.VideoURL, &video.VideoThumbnail,
-		&video.VideoTitle, &video.VideoDescription, &video.VideoTags,
-		&video.VideoViews, &video.VideoUpvotes, &video.VideoDownvotes,
-		&video.VideoComments, &video.UserUID, &video.UserUsername,
-		&video.CreatedAt, &video.UpdatedAt,
-	)
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("DeleteVideo: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete video")
+		return
+	}
+	defer tx.Rollback()
+
+	video, err := removeVideo(ctx, tx, videoID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
 		} else {
-			log.Printf("DeleteVideo: failed to fetch video: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to load video")
+			log.Printf("DeleteVideo: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete video")
 		}
 		return
 	}
 
-	// Delete video and thumbnail files from R2 storage
+	if err := tx.Commit(); err != nil {
+		log.Printf("DeleteVideo: failed to commit video deletion: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete video")
+		return
+	}
+	purgeVideo(ctx, video)
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Video deleted successfully"})
+}
+
+// removeVideo deletes a video in tx (related data cascades) and decrements its owner's video count
+// Returns sql.ErrNoRows if the video does not exist; used by DeleteVideo and the moderation queue
+// Once tx commits, the returned video is passed to purgeVideo to delete its files
+func removeVideo(ctx context.Context, tx *sql.Tx, videoID string) (*Videos.Videos, error) {
+	// Deleting the row first means only one of two concurrent deletes decrements the count
+	// (CASCADE will handle related data: upvotes, downvotes, comments, replies, views)
+	var video Videos.Videos
+	err := tx.QueryRowContext(ctx,
+		"DELETE FROM videos WHERE video_id = $1 RETURNING video_id, video_url, video_thumbnail, user_uid",
+		videoID,
+	).Scan(&video.VideoID, &video.VideoURL, &video.VideoThumbnail, &video.UserUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete video: %w", err)
+	}
+
+	// Update user's total_videos count
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET total_videos = total_videos - 1 WHERE uid = $1",
+		video.UserUID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user video count: %w", err)
+	}
+
+	return &video, nil
+}
+
+// purgeVideo deletes the files of a video removed by removeVideo from storage and the video from the search index
+// Called after the deletion commits, so a rolled back deletion never loses the files; failures are only logged
+func purgeVideo(ctx context.Context, video *Videos.Videos) {
 	// Use the stored paths from the database
 	video_obj_key := video.VideoURL
 	thumbnail_obj_key := video.VideoThumbnail
 
-	log.Printf("DeleteVideo: Video URL from DB: %s", video_obj_key)
-	log.Printf("DeleteVideo: Thumbnail URL from DB: %s", thumbnail_obj_key)
+	log.Printf("purgeVideo: Video URL from DB: %s", video_obj_key)
+	log.Printf("purgeVideo: Thumbnail URL from DB: %s", thumbnail_obj_key)
 
 	// Verify files exist before attempting deletion
 	videoExists, _ := storage.IsFileExists(video_obj_key)
 	thumbnailExists, _ := storage.IsFileExists(thumbnail_obj_key)
-	log.Printf("DeleteVideo: Video exists in R2: %v, Thumbnail exists in R2: %v", videoExists, thumbnailExists)
+	log.Printf("purgeVideo: Video exists in R2: %v, Thumbnail exists in R2: %v", videoExists, thumbnailExists)
 
 	// Delete video file from storage
 	if videoExists {
 		if err := storage.DeleteFile(ctx, video_obj_key); err != nil {
-			log.Printf("DeleteVideo: CRITICAL ERROR - failed to delete video file from storage (%s): %v", video_obj_key, err)
+			log.Printf("purgeVideo: CRITICAL ERROR - failed to delete video file from storage (%s): %v", video_obj_key, err)
 			// Continue with deletion even if storage cleanup fails
 		} else {
 			// Verify deletion by checking if file still exists
 			exists, checkErr := storage.IsFileExists(video_obj_key)
 			if checkErr == nil && exists {
-				log.Printf("DeleteVideo: CRITICAL WARNING - video file still exists after deletion attempt: %s", video_obj_key)
+				log.Printf("purgeVideo: CRITICAL WARNING - video file still exists after deletion attempt: %s", video_obj_key)
 			} else {
-				log.Printf("DeleteVideo: successfully deleted and verified video file: %s", video_obj_key)
+				log.Printf("purgeVideo: successfully deleted and verified video file: %s", video_obj_key)
 			}
 		}
 	} else {
-		log.Printf("DeleteVideo: video file does not exist in R2, skipping deletion: %s", video_obj_key)
+		log.Printf("purgeVideo: video file does not exist in R2, skipping deletion: %s", video_obj_key)
 	}
 
 	// Delete thumbnail file from storage
 	if thumbnailExists {
 		if err := storage.DeleteFile(ctx, thumbnail_obj_key); err != nil {
-			log.Printf("DeleteVideo: CRITICAL ERROR - failed to delete thumbnail file from storage (%s): %v", thumbnail_obj_key, err)
+			log.Printf("purgeVideo: CRITICAL ERROR - failed to delete thumbnail file from storage (%s): %v", thumbnail_obj_key, err)
 			// Continue with deletion even if storage cleanup fails
 		} else {
 			// Verify deletion by checking if file still exists
 			exists, checkErr := storage.IsFileExists(thumbnail_obj_key)
 			if checkErr == nil && exists {
-				log.Printf("DeleteVideo: CRITICAL WARNING - thumbnail file still exists after deletion attempt: %s", thumbnail_obj_key)
+				log.Printf("purgeVideo: CRITICAL WARNING - thumbnail file still exists after deletion attempt: %s", thumbnail_obj_key)
 			} else {
-				log.Printf("DeleteVideo: successfully deleted and verified thumbnail file: %s", thumbnail_obj_key)
+				log.Printf("purgeVideo: successfully deleted and verified thumbnail file: %s", thumbnail_obj_key)
 			}
 		}
 	} else {
-		log.Printf("DeleteVideo: thumbnail file does not exist in R2, skipping deletion: %s", thumbnail_obj_key)
-	}
-
-	// Use transaction for atomicity
-	tx, err := Mdb.DB.BeginTx(ctx, nil)
-	if err != nil {
-		log.Printf("DeleteVideo: failed to begin transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start transaction")
-		return
-	}
-	defer tx.Rollback()
-
-	// Delete video (CASCADE will handle related data: upvotes, downvotes, comments, replies, views)
-	_, err = tx.ExecContext(ctx, "DELETE FROM videos WHERE video_id = $1", videoID)
-	if err != nil {
-		log.Printf("DeleteVideo: failed to delete video: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete video")
-		return
-	}
-
-	// Update user's total_videos count
-	_, err = tx.ExecContext(ctx,
-		"UPDATE users SET total_videos = total_videos - 1 WHERE uid = $1",
-		video.UserUID,
-	)
-	if err != nil {
-		log.Printf("DeleteVideo: failed to update user video count: %v", err)
-		// Don't fail the request, just log the error
-	}
-
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("DeleteVideo: failed to commit transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to complete deletion")
-		return
+		log.Printf("purgeVideo: thumbnail file does not exist in R2, skipping deletion: %s", thumbnail_obj_key)
 	}
 
 	// Delete video from Elasticsearch (non-blocking, log errors but don't fail deletion)
 	go func() {
 		esCtx := context.Background()
-		if err := Search.DeleteVideo(esCtx, videoID); err != nil {
-			log.Printf("DeleteVideo: failed to delete video from Elasticsearch: %v", err)
+		if err := Search.DeleteVideo(esCtx, video.VideoID); err != nil {
+			log.Printf("purgeVideo: failed to delete video from Elasticsearch: %v", err)
 		}
 	}()
-
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Video deleted successfully"})
 }
 
-// DeleteComment deletes a comment by commentID (admin only)
+// DeleteComment deletes a comment by commentID (requires comments.delete)
+// Replies and edit history are deleted with it and the video's comment count is kept in step
 func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	commentID := chi.URLParam(r, "commentID")
 	if commentID == "" {
@@ -1229,66 +1211,37 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if comment exists and get video_id
-	var commentedTo string
-	err := Mdb.DB.QueryRowContext(ctx,
-		"SELECT commented_to FROM comments WHERE comment_id = $1",
-		commentID,
-	).Scan(&commentedTo)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
-		} else {
-			log.Printf("DeleteComment: failed to fetch comment: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to load comment")
-		}
-		return
-	}
-
-	// Use transaction for atomicity
 	tx, err := Mdb.DB.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("DeleteComment: failed to begin transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start transaction")
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
 		return
 	}
 	defer tx.Rollback()
 
-	// Delete comment (CASCADE will handle replies)
-	_, err = tx.ExecContext(ctx, "DELETE FROM comments WHERE comment_id = $1", commentID)
-	if err != nil {
-		log.Printf("DeleteComment: failed to delete comment: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
+	if err := Social.RemoveComment(ctx, tx, commentID); err != nil {
+		if errors.Is(err, Social.ErrCommentNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		} else {
+			log.Printf("DeleteComment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
+		}
 		return
 	}
 
-	// Update video comment count
-	_, err = tx.ExecContext(ctx,
-		"UPDATE videos SET video_comments = video_comments - 1 WHERE video_id = $1",
-		commentedTo,
-	)
-	if err != nil {
-		log.Printf("DeleteComment: failed to update video comment count: %v", err)
-		// Don't fail the request, just log the error
-	}
-
-	// Commit transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf("DeleteComment: failed to commit transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to complete deletion")
+		log.Printf("DeleteComment: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
 		return
 	}
 
 	Utils.SendSuccessResponse(w, map[string]string{"message": "Comment deleted successfully"})
 }
 
-// DeleteReply deletes a reply by replyID (admin only)
+// DeleteReply deletes a reply by replyID (requires comments.delete)
+// The comment's reply count is kept in step
 func DeleteReply(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	replyID := chi.URLParam(r, "replyID")
 	if replyID == "" {
@@ -1296,68 +1249,96 @@ func DeleteReply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if reply exists and get comment_id
-	var repliedTo string
-	err := Mdb.DB.QueryRowContext(ctx,
-		"SELECT replied_to FROM replies WHERE reply_id = $1",
-		replyID,
-	).Scan(&repliedTo)
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("DeleteReply: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := Social.RemoveReply(ctx, tx, replyID); err != nil {
+		if errors.Is(err, Social.ErrReplyNotFound) {
 			Utils.SendErrorResponse(w, http.StatusNotFound, "Reply not found")
 		} else {
-			log.Printf("DeleteReply: failed to fetch reply: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to load reply")
+			log.Printf("DeleteReply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
 		}
 		return
 	}
 
-	// Use transaction for atomicity
-	tx, err := Mdb.DB.BeginTx(ctx, nil)
-	if err != nil {
-		log.Printf("DeleteReply: failed to begin transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start transaction")
+	if err := tx.Commit(); err != nil {
+		log.Printf("DeleteReply: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
 		return
 	}
-	defer tx.Rollback()
 
-	// Delete reply
-	_, err = tx.ExecContext(ctx, "DELETE FROM replies WHERE reply_id = $1", replyID)
-	if err != nil {
-		log.Printf("DeleteReply: failed to delete reply: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Reply deleted successfully"})
+}
+
+// ListCommentEdits lists the edit history of a comment, newest first (requires comments.read)
+func ListCommentEdits(w http.ResponseWriter, r *http.Request) {
+	listEdits(w, r, "ListCommentEdits", "comment_id", chi.URLParam(r, "commentID"))
+}
+
+// ListReplyEdits lists the edit history of a reply, newest first (requires comments.read)
+func ListReplyEdits(w http.ResponseWriter, r *http.Request) {
+	listEdits(w, r, "ListReplyEdits", "reply_id", chi.URLParam(r, "replyID"))
+}
+
+// listEdits lists the comment_edits rows whose column (comment_id or reply_id) is id
+func listEdits(w http.ResponseWriter, r *http.Request, handler, column, id string) {
+	ctx := r.Context()
+
+	if id == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "ID is required")
 		return
 	}
 
-	// Update comment reply count
-	_, err = tx.ExecContext(ctx,
-		"UPDATE comments SET total_replies = total_replies - 1 WHERE comment_id = $1",
-		repliedTo,
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, comment_id, reply_id, edited_by, previous_text, edited_at
+		FROM comment_edits
+		WHERE `+column+` = $1
+		ORDER BY edited_at DESC, id DESC`,
+		id,
 	)
 	if err != nil {
-		log.Printf("DeleteReply: failed to update comment reply count: %v", err)
-		// Don't fail the request, just log the error
+		log.Printf("%s: failed to query edits: %v", handler, err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch edits")
+		return
 	}
+	defer rows.Close()
 
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("DeleteReply: failed to commit transaction: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to complete deletion")
+	edits := []Social.CommentEdits{}
+	for rows.Next() {
+		var edit Social.CommentEdits
+		if err := rows.Scan(
+			&edit.ID, &edit.CommentID, &edit.ReplyID, &edit.EditedBy, &edit.PreviousText, &edit.EditedAt,
+		); err != nil {
+			log.Printf("%s: failed to scan edit: %v", handler, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch edits")
+			return
+		}
+		edits = append(edits, edit)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("%s: row iteration error: %v", handler, err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate edits")
 		return
 	}
 
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Reply deleted successfully"})
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"edits": edits,
+		"count": len(edits),
+	})
 }
 
-// GetCounters returns aggregated counters for all entities (admin only)
+// GetCounters returns aggregated counters for all entities (requires counters.read)
 // Uses dedicated system_counters table maintained by database triggers
 // Provides instant, 100% accurate counts without scanning large tables
 func GetCounters(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	var counters struct {
 		Users    int `json:"users"`
@@ -1401,14 +1382,10 @@ func GetCounters(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// ResyncCounters manually resyncs counters with actual table counts (admin only)
+// ResyncCounters manually resyncs counters with actual table counts (requires counters.resync)
 // Useful if counters get out of sync due to direct database operations or trigger failures
 func ResyncCounters(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	_, ok := requireAdmin(w, r)
-	if !ok {
-		return
-	}
 
 	// Use transaction to ensure atomicity
 	tx, err := Mdb.DB.BeginTx(ctx, nil)
@@ -1441,7 +1418,7 @@ func ResyncCounters(w http.ResponseWriter, r *http.Request) {
 			replies_count = (SELECT COUNT(*) FROM replies),
 			upvotes_count = (SELECT COUNT(*) FROM upvotes),
 			downvotes_count = (SELECT COUNT(*) FROM downvotes),
-			views_count = (SELECT COUNT(*) FROM views),
+			views_count = (SELECT COUNT(*) FROM views) + (SELECT COUNT(*) FROM anonymous_views),
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = 1`,
 	)
@@ -1459,3 +1436,387 @@ func ResyncCounters(w http.ResponseWriter, r *http.Request) {
 
 	Utils.SendSuccessResponse(w, map[string]string{"message": "Counters resynced successfully"})
 }
+
+// ListUserSessions lists the active sessions of any user (requires sessions.read)
+func ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	sessions, err := Auth.ListSessions(ctx, uid)
+	if err != nil {
+		log.Printf("ListUserSessions: failed to list sessions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"uid":      uid,
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// RevokeUserSession revokes a single session of any user (requires sessions.revoke)
+func RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	sessionID := chi.URLParam(r, "sessionID")
+	if uid == "" || sessionID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID and session ID are required")
+		return
+	}
+
+	revoked, err := Auth.RevokeUserSession(ctx, uid, sessionID)
+	if err != nil {
+		log.Printf("RevokeUserSession: failed to revoke session: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if !revoked {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Session revoked successfully"})
+}
+
+// RevokeUserSessions revokes every session of any user (requires sessions.revoke)
+func RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	revoked, err := Auth.RevokeUserSessions(ctx, uid)
+	if err != nil {
+		log.Printf("RevokeUserSessions: failed to revoke sessions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":          "Sessions revoked successfully",
+		"revoked_sessions": revoked,
+	})
+}
+
+// UnlockUser clears the failed login and two-factor attempts of a user, lifting a lockout (requires users.unlock)
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	user, err := fetchUserByUID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("UnlockUser: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	if err := Ratelimit.LoginUser.Reset(ctx, user.Username); err != nil {
+		log.Printf("UnlockUser: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+	if err := Ratelimit.LoginTwoFactor.Reset(ctx, user.UID); err != nil {
+		log.Printf("UnlockUser: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":  "User unlocked successfully",
+		"uid":      user.UID,
+		"username": user.Username,
+	})
+}
+
+// ListUserAPIKeys lists the active API keys of any user (requires api_keys.read)
+func ListUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	keys, err := Auth.ListAPIKeys(ctx, uid)
+	if err != nil {
+		log.Printf("ListUserAPIKeys: failed to list api keys: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"uid":      uid,
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+// RevokeUserAPIKey revokes a single API key of any user (requires api_keys.revoke)
+func RevokeUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	keyID := chi.URLParam(r, "keyID")
+	if uid == "" || keyID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID and key ID are required")
+		return
+	}
+
+	revoked, err := Auth.RevokeAPIKey(ctx, uid, keyID)
+	if err != nil {
+		log.Printf("RevokeUserAPIKey: failed to revoke api key: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if !revoked {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "API key revoked successfully"})
+}
+
+// RevokeUserAPIKeys revokes every API key of any user (requires api_keys.revoke)
+func RevokeUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	revoked, err := Auth.RevokeUserAPIKeys(ctx, Mdb.DB, uid)
+	if err != nil {
+		log.Printf("RevokeUserAPIKeys: failed to revoke api keys: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke API keys")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":          "API keys revoked successfully",
+		"revoked_api_keys": revoked,
+	})
+}
+
+// ListRoles lists the staff roles and their permissions (requires roles.read)
+func ListRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	roles, err := Auth.ListRoles(ctx)
+	if err != nil {
+		log.Printf("ListRoles: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list roles")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"roles": roles,
+		"count": len(roles),
+	})
+}
+
+// ListUserRoles lists the roles granted to a user and the permissions they add up to (requires roles.read)
+func ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	user, err := fetchUserByUID(ctx, uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("ListUserRoles: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	roles, err := Auth.ListUserRoles(ctx, uid)
+	if err != nil {
+		log.Printf("ListUserRoles: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list roles")
+		return
+	}
+	granted, err := Auth.UserPermissions(ctx, uid)
+	if err != nil {
+		log.Printf("ListUserRoles: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list roles")
+		return
+	}
+	permissions := make([]string, 0, len(granted))
+	for permission := range granted {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"uid":          user.UID,
+		"username":     user.Username,
+		"account_role": user.Role,
+		"roles":        roles,
+		"permissions":  permissions,
+	})
+}
+
+// GrantUserRole grants a staff role to a user and records it in the role audit log (requires roles.manage)
+func GrantUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("GrantUserRole: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var payload struct {
+		Role   string `json:"role"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	payload.Role = strings.ToLower(strings.TrimSpace(payload.Role))
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if payload.Role == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Role is required")
+		return
+	}
+	if len(payload.Reason) > MaxRoleReasonLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Reason must be at most %d characters", MaxRoleReasonLength))
+		return
+	}
+
+	if _, err := fetchUserByUID(ctx, uid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("GrantUserRole: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	if err := Auth.GrantRole(ctx, claims.UID, uid, payload.Role, payload.Reason); err != nil {
+		switch {
+		case errors.Is(err, Auth.ErrUnknownRole):
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Unknown role")
+		case errors.Is(err, Auth.ErrRoleAlreadyGranted):
+			Utils.SendErrorResponse(w, http.StatusConflict, "User already has this role")
+		case errors.Is(err, Auth.ErrOwnRoleChange):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot change your own roles")
+		default:
+			log.Printf("GrantUserRole: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to grant role")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{
+		"message": "Role granted successfully",
+		"uid":     uid,
+		"role":    payload.Role,
+	})
+}
+
+// RevokeUserRole revokes a staff role from a user and records it in the role audit log (requires roles.manage)
+// An optional reason is taken from the ?reason= query parameter
+func RevokeUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	uid := chi.URLParam(r, "uid")
+	role := strings.ToLower(chi.URLParam(r, "role"))
+	if uid == "" || role == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID and role are required")
+		return
+	}
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if len(reason) > MaxRoleReasonLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Reason must be at most %d characters", MaxRoleReasonLength))
+		return
+	}
+
+	if err := Auth.RevokeRole(ctx, claims.UID, uid, role, reason); err != nil {
+		switch {
+		case errors.Is(err, Auth.ErrRoleNotGranted):
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User does not have this role")
+		case errors.Is(err, Auth.ErrOwnRoleChange):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot change your own roles")
+		default:
+			log.Printf("RevokeUserRole: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to revoke role")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{
+		"message": "Role revoked successfully",
+		"uid":     uid,
+		"role":    role,
+	})
+}
+
+// ListRoleAudit lists role grants and revocations, newest first (requires roles.read)
+// ?uid= restricts the log to one user
+func ListRoleAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := 20
+	offset := 0
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	uid := strings.TrimSpace(r.URL.Query().Get("uid"))
+
+	entries, total, err := Auth.ListRoleAudit(ctx, uid, limit, offset)
+	if err != nil {
+		log.Printf("ListRoleAudit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list role audit log")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
@@ -0,0 +1,612 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Reports "hifi/Events/Reports"
+	Social "hifi/Events/Social"
+	Videos "hifi/Events/Videos"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// MaxModerationReasonLength is the maximum length of the reason recorded with a moderation action
+const MaxModerationReasonLength = 500
+
+// Suspension lengths in days
+const (
+	DefaultSuspendDays = 7
+	MaxSuspendDays     = 365
+)
+
+// pagination parses ?limit= (default 20, at most 100) and ?offset=
+func pagination(r *http.Request) (limit, offset int) {
+	limit = 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	return limit, offset
+}
+
+// reportStatus parses ?status=, which defaults to open
+func reportStatus(r *http.Request) (string, bool) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	switch status {
+	case "":
+		return Reports.StatusOpen, true
+	case Reports.StatusOpen, Reports.StatusDismissed, Reports.StatusActioned:
+		return status, true
+	}
+	return "", false
+}
+
+// ListReportQueue lists reported targets grouped with their report counts, most reported first (requires reports.read)
+// Query params: ?status=open|dismissed|actioned (default open)&target_type=video|comment|reply|user&limit=20&offset=0
+func ListReportQueue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset := pagination(r)
+	status, ok := reportStatus(r)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Status must be open, dismissed or actioned")
+		return
+	}
+	targetType := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("target_type")))
+	if targetType != "" && !Reports.IsTargetType(targetType) {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Target type must be video, comment, reply or user")
+		return
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT g.target_type, g.target_id, g.target_uid, tu.username,
+			CASE g.target_type
+				WHEN 'video' THEN v.id IS NOT NULL
+				WHEN 'comment' THEN c.id IS NOT NULL
+				WHEN 'reply' THEN rp.id IS NOT NULL
+				ELSE tu.id IS NOT NULL
+			END as target_exists,
+			LEFT(CASE g.target_type
+				WHEN 'video' THEN v.video_title
+				WHEN 'comment' THEN c.comment
+				WHEN 'reply' THEN rp.reply
+				ELSE tu.username
+			END, 200) as preview,
+			g.report_count, g.reasons, g.first_reported_at, g.last_reported_at,
+			COUNT(*) OVER() as total_count
+		FROM (
+			SELECT target_type, target_id, target_uid, SUM(n)::int as report_count, json_object_agg(reason, n) as reasons,
+				MIN(first_at) as first_reported_at, MAX(last_at) as last_reported_at
+			FROM (
+				SELECT target_type, target_id, target_uid, reason, COUNT(*) as n,
+					MIN(created_at) as first_at, MAX(created_at) as last_at
+				FROM reports
+				WHERE status = $1 AND ($2 = '' OR target_type = $2)
+				GROUP BY target_type, target_id, target_uid, reason
+			) by_reason
+			GROUP BY target_type, target_id, target_uid
+		) g
+		LEFT JOIN users tu ON tu.uid = g.target_uid
+		LEFT JOIN videos v ON g.target_type = 'video' AND v.video_id = g.target_id
+		LEFT JOIN comments c ON g.target_type = 'comment' AND c.comment_id = g.target_id
+		LEFT JOIN replies rp ON g.target_type = 'reply' AND rp.reply_id = g.target_id
+		ORDER BY g.report_count DESC, g.first_reported_at ASC
+		LIMIT $3 OFFSET $4`,
+		status, targetType, limit, offset,
+	)
+	if err != nil {
+		log.Printf("ListReportQueue: failed to query reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+	defer rows.Close()
+
+	targets := []Reports.ReportTargets{}
+	var total int
+	for rows.Next() {
+		var target Reports.ReportTargets
+		var reasons []byte
+		if err := rows.Scan(
+			&target.TargetType, &target.TargetID, &target.TargetUID, &target.TargetUsername,
+			&target.TargetExists, &target.Preview,
+			&target.ReportCount, &reasons, &target.FirstReportedAt, &target.LastReportedAt,
+			&total, // total_count from window function
+		); err != nil {
+			log.Printf("ListReportQueue: failed to scan report target: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+			return
+		}
+		if err := json.Unmarshal(reasons, &target.Reasons); err != nil {
+			log.Printf("ListReportQueue: failed to decode reasons: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+			return
+		}
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ListReportQueue: failed to iterate report targets: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"targets": targets,
+		"status":  status,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// queryModerationActions returns moderation actions, newest first, and their total
+// Empty filters match every action
+func queryModerationActions(ctx context.Context, targetType, targetID, targetUID, moderatorUID, action string, limit, offset int) ([]Reports.ModerationActions, int, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, moderator_uid, action, target_type, target_id, target_uid, reason, suspended_until, reports_resolved, created_at,
+			COUNT(*) OVER() as total_count
+		FROM moderation_actions
+		WHERE ($1 = '' OR target_type = $1) AND ($2 = '' OR target_id = $2) AND ($3 = '' OR target_uid = $3)
+			AND ($4 = '' OR moderator_uid = $4) AND ($5 = '' OR action = $5)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6 OFFSET $7`,
+		targetType, targetID, targetUID, moderatorUID, action, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query moderation actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := []Reports.ModerationActions{}
+	var total int
+	for rows.Next() {
+		var a Reports.ModerationActions
+		if err := rows.Scan(
+			&a.ID, &a.ModeratorUID, &a.Action, &a.TargetType, &a.TargetID, &a.TargetUID,
+			&a.Reason, &a.SuspendedUntil, &a.ReportsResolved, &a.CreatedAt,
+			&total, // total_count from window function
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan moderation action: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate moderation actions: %w", err)
+	}
+	return actions, total, nil
+}
+
+// GetReportTarget lists the reports of a target, newest first, with the moderation actions taken on it (requires reports.read)
+// Query params: ?status=open|dismissed|actioned (default every status)&limit=20&offset=0
+func GetReportTarget(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	targetType := chi.URLParam(r, "targetType")
+	targetID := chi.URLParam(r, "targetID")
+	if !Reports.IsTargetType(targetType) || targetID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Target type must be video, comment, reply or user")
+		return
+	}
+
+	limit, offset := pagination(r)
+	status := ""
+	if r.URL.Query().Get("status") != "" {
+		var ok bool
+		if status, ok = reportStatus(r); !ok {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Status must be open, dismissed or actioned")
+			return
+		}
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT r.id, r.reporter_uid, u.username, r.target_type, r.target_id, r.target_uid, r.reason, r.details,
+			r.status, r.created_at, r.resolved_at, r.resolved_by,
+			COUNT(*) OVER() as total_count
+		FROM reports r
+		LEFT JOIN users u ON u.uid = r.reporter_uid
+		WHERE r.target_type = $1 AND r.target_id = $2 AND ($3 = '' OR r.status = $3)
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $4 OFFSET $5`,
+		targetType, targetID, status, limit, offset,
+	)
+	if err != nil {
+		log.Printf("GetReportTarget: failed to query reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+	defer rows.Close()
+
+	reports := []Reports.Reports{}
+	var total int
+	for rows.Next() {
+		var report Reports.Reports
+		if err := rows.Scan(
+			&report.ID, &report.ReporterUID, &report.ReporterUsername, &report.TargetType, &report.TargetID, &report.TargetUID,
+			&report.Reason, &report.Details, &report.Status, &report.CreatedAt, &report.ResolvedAt, &report.ResolvedBy,
+			&total, // total_count from window function
+		); err != nil {
+			log.Printf("GetReportTarget: failed to scan report: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+			return
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("GetReportTarget: failed to iterate reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+	if total == 0 && offset == 0 && status == "" {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "No reports for this target")
+		return
+	}
+
+	actions, _, err := queryModerationActions(ctx, targetType, targetID, "", "", "", 100, 0)
+	if err != nil {
+		log.Printf("GetReportTarget: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch moderation actions")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"target_type": targetType,
+		"target_id":   targetID,
+		"reports":     reports,
+		"actions":     actions,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+// insertModerationAction records a moderation action and sets its ID and time
+func insertModerationAction(ctx context.Context, tx *sql.Tx, action *Reports.ModerationActions) error {
+	action.CreatedAt = time.Now()
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO moderation_actions (moderator_uid, action, target_type, target_id, target_uid, reason,
+			suspended_until, reports_resolved, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`,
+		action.ModeratorUID, action.Action, action.TargetType, action.TargetID, action.TargetUID, getStringValue(action.Reason),
+		action.SuspendedUntil, action.ReportsResolved, action.CreatedAt,
+	).Scan(&action.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record moderation action: %w", err)
+	}
+	return nil
+}
+
+// takeDown removes a reported video, comment or reply in tx through the same paths as the delete endpoints
+// Content that is already gone (e.g. deleted by its author) is not an error; removed is false then
+// video is set when a video was removed: pass it to purgeVideo once tx commits
+func takeDown(ctx context.Context, tx *sql.Tx, targetType, targetID string) (removed bool, video *Videos.Videos, err error) {
+	switch targetType {
+	case Reports.TargetVideo:
+		video, err = removeVideo(ctx, tx, targetID)
+	case Reports.TargetComment:
+		err = Social.RemoveComment(ctx, tx, targetID)
+	case Reports.TargetReply:
+		err = Social.RemoveReply(ctx, tx, targetID)
+	default:
+		return false, nil, fmt.Errorf("cannot take down a %s", targetType)
+	}
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, Social.ErrCommentNotFound) || errors.Is(err, Social.ErrReplyNotFound) {
+		return false, nil, nil
+	}
+	return err == nil, video, err
+}
+
+// ModerateReportTarget resolves the open reports of a target with one action (requires reports.manage)
+// dismiss closes them without action; take_down deletes the video, comment or reply (also requires
+// videos.delete or comments.delete); warn records a warning against the author; suspend suspends the
+// author for suspend_days (default 7) and signs them out (also requires users.suspend)
+// The action is recorded in moderation_actions with the moderator's UID
+// Body: {"action": "suspend", "reason": "Repeated harassment", "suspend_days": 7}
+func ModerateReportTarget(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	targetType := chi.URLParam(r, "targetType")
+	targetID := chi.URLParam(r, "targetID")
+	if !Reports.IsTargetType(targetType) || targetID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Target type must be video, comment, reply or user")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ModerateReportTarget: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var payload struct {
+		Action      string `json:"action"`
+		Reason      string `json:"reason"`
+		SuspendDays int    `json:"suspend_days"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	payload.Action = strings.ToLower(strings.TrimSpace(payload.Action))
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if len(payload.Reason) > MaxModerationReasonLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Reason must be at most %d characters", MaxModerationReasonLength))
+		return
+	}
+
+	action := Reports.ModerationActions{
+		ModeratorUID: claims.UID,
+		Action:       payload.Action,
+		TargetType:   targetType,
+		TargetID:     targetID,
+	}
+	if payload.Reason != "" {
+		action.Reason = &payload.Reason
+	}
+	resolution := Reports.StatusActioned
+
+	switch payload.Action {
+	case Reports.ActionDismiss:
+		resolution = Reports.StatusDismissed
+	case Reports.ActionWarn:
+	case Reports.ActionTakeDown:
+		permission := Auth.PermCommentsDelete
+		switch targetType {
+		case Reports.TargetUser:
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Users cannot be taken down, suspend them instead")
+			return
+		case Reports.TargetVideo:
+			permission = Auth.PermVideosDelete
+		}
+		if !claims.HasPermission(permission) {
+			Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+permission+" permission required")
+			return
+		}
+	case Reports.ActionSuspend:
+		if !claims.HasPermission(Auth.PermUsersSuspend) {
+			Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+Auth.PermUsersSuspend+" permission required")
+			return
+		}
+		if payload.SuspendDays == 0 {
+			payload.SuspendDays = DefaultSuspendDays
+		}
+		if payload.SuspendDays < 1 || payload.SuspendDays > MaxSuspendDays {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Suspend days must be between 1 and %d", MaxSuspendDays))
+			return
+		}
+		until := time.Now().AddDate(0, 0, payload.SuspendDays)
+		action.SuspendedUntil = &until
+	default:
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Action must be dismiss, take_down, warn or suspend")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("ModerateReportTarget: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resolve reports")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the open reports so that two moderators cannot act on the same reports twice
+	err = func() error {
+		rows, err := tx.QueryContext(ctx,
+			"SELECT target_uid FROM reports WHERE target_type = $1 AND target_id = $2 AND status = 'open' FOR UPDATE",
+			targetType, targetID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to lock reports: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := rows.Scan(&action.TargetUID); err != nil {
+				return fmt.Errorf("failed to scan report: %w", err)
+			}
+			action.ReportsResolved++
+		}
+		return rows.Err()
+	}()
+	if err != nil {
+		log.Printf("ModerateReportTarget: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resolve reports")
+		return
+	}
+	if action.ReportsResolved == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "No open reports for this target")
+		return
+	}
+	if action.TargetUID == claims.UID {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot act on reports about yourself")
+		return
+	}
+
+	// The take-down runs in tx too, so the content is only removed if the reports are resolved
+	removed := false
+	var removedVideo *Videos.Videos
+	switch payload.Action {
+	case Reports.ActionTakeDown:
+		if removed, removedVideo, err = takeDown(ctx, tx, targetType, targetID); err != nil {
+			log.Printf("ModerateReportTarget: failed to take down %s: %v", targetType, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to take down content")
+			return
+		}
+	case Reports.ActionSuspend:
+		if err := Auth.SuspendUser(ctx, tx, action.TargetUID, *action.SuspendedUntil); err != nil {
+			if errors.Is(err, Auth.ErrUserNotFound) {
+				Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+			} else {
+				log.Printf("ModerateReportTarget: %v", err)
+				Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to suspend user")
+			}
+			return
+		}
+	}
+
+	if err := insertModerationAction(ctx, tx, &action); err != nil {
+		log.Printf("ModerateReportTarget: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resolve reports")
+		return
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE reports SET status = $1, resolved_at = $2, resolved_by = $3, action_id = $4
+		WHERE target_type = $5 AND target_id = $6 AND status = 'open'`,
+		resolution, action.CreatedAt, claims.UID, action.ID, targetType, targetID,
+	); err != nil {
+		log.Printf("ModerateReportTarget: failed to resolve reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resolve reports")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("ModerateReportTarget: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to resolve reports")
+		return
+	}
+	if removedVideo != nil {
+		purgeVideo(ctx, removedVideo)
+	}
+
+	response := map[string]interface{}{
+		"message": "Reports resolved successfully",
+		"status":  resolution,
+		"action":  action,
+	}
+	if payload.Action == Reports.ActionTakeDown {
+		response["content_removed"] = removed // False when the content was already deleted
+	}
+	Utils.SendSuccessResponse(w, response)
+}
+
+// ListModerationActions lists the moderation log, newest first (requires reports.read)
+// Query params: ?moderator_uid=&target_uid=&action=&limit=20&offset=0
+func ListModerationActions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, offset := pagination(r)
+	query := r.URL.Query()
+	moderatorUID := strings.TrimSpace(query.Get("moderator_uid"))
+	targetUID := strings.TrimSpace(query.Get("target_uid"))
+	action := strings.ToLower(strings.TrimSpace(query.Get("action")))
+
+	actions, total, err := queryModerationActions(ctx, "", "", targetUID, moderatorUID, action, limit, offset)
+	if err != nil {
+		log.Printf("ListModerationActions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list moderation actions")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"actions": actions,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// UnsuspendUser lifts a user's suspension early and records it in the moderation log (requires users.suspend)
+// Body (optional): {"reason": "Suspended by mistake"}
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	uid := chi.URLParam(r, "uid")
+	if uid == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "User UID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("UnsuspendUser: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var payload struct {
+		Reason string `json:"reason"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+	}
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if len(payload.Reason) > MaxModerationReasonLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Reason must be at most %d characters", MaxModerationReasonLength))
+		return
+	}
+
+	if _, err := fetchUserByUID(ctx, uid); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("UnsuspendUser: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("UnsuspendUser: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to lift suspension")
+		return
+	}
+	defer tx.Rollback()
+
+	lifted, err := Auth.LiftSuspension(ctx, tx, uid)
+	if err != nil {
+		log.Printf("UnsuspendUser: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to lift suspension")
+		return
+	}
+	if !lifted {
+		Utils.SendErrorResponse(w, http.StatusConflict, "User is not suspended")
+		return
+	}
+
+	action := Reports.ModerationActions{
+		ModeratorUID: claims.UID,
+		Action:       Reports.ActionUnsuspend,
+		TargetType:   Reports.TargetUser,
+		TargetID:     uid,
+		TargetUID:    uid,
+	}
+	if payload.Reason != "" {
+		action.Reason = &payload.Reason
+	}
+	if err := insertModerationAction(ctx, tx, &action); err != nil {
+		log.Printf("UnsuspendUser: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to lift suspension")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("UnsuspendUser: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to lift suspension")
+		return
+	}
+
+	// Revoking API keys cannot be undone, so the user has to create new ones
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Suspension lifted successfully",
+		"note":    "API keys revoked by the suspension stay revoked; the user must create new API keys",
+		"action":  action,
+	})
+}
@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	Social "hifi/Events/Social"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+func TestTakeDownComment(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	admin, moderator, author := users[0], users[1], users[2]
+	videoID := Testdb.CreateVideo(t, admin)
+	if err := Auth.GrantRole(context.Background(), admin.UID, moderator.UID, Auth.RoleModerator, ""); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	t.Cleanup(func() {
+		Mdb.DB.Exec("DELETE FROM role_audit_log WHERE target_uid = $1", moderator.UID)
+	})
+	router := Testdb.Router(func(r chi.Router) {
+		r.Post("/videos/comment/{videoID}", Social.Comment)
+		r.With(Auth.RequirePermission(Auth.PermReportsManage)).Post("/reports/{targetType}/{targetID}/actions", ModerateReportTarget)
+	})
+
+	if code := Testdb.Request(t, router, author.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "spam"}`); code != http.StatusOK {
+		t.Fatalf("commenting: status %d", code)
+	}
+	var commentID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1", videoID).Scan(&commentID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+	if _, err := Mdb.DB.Exec(
+		"INSERT INTO reports (reporter_uid, target_type, target_id, target_uid, reason) VALUES ($1, 'comment', $2, $3, 'spam')",
+		admin.UID, commentID, author.UID,
+	); err != nil {
+		t.Fatalf("failed to report comment: %v", err)
+	}
+
+	// The comment is removed in the same transaction that resolves the report
+	path := "/reports/comment/" + commentID + "/actions"
+	var res struct {
+		ContentRemoved bool `json:"content_removed"`
+	}
+	if code := Testdb.RequestData(t, router, moderator.UID, http.MethodPost, path, `{"action": "take_down"}`, &res); code != http.StatusOK || !res.ContentRemoved {
+		t.Fatalf("take down: status %d, content removed %v", code, res.ContentRemoved)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE comment_id = $1", commentID); got != 0 {
+		t.Errorf("comment still exists after take down")
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_comments FROM videos WHERE video_id = $1", videoID); got != 0 {
+		t.Errorf("video has %d comments after take down, want 0", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM reports WHERE target_id = $1 AND status = 'actioned' AND action_id IS NOT NULL", commentID); got != 1 {
+		t.Errorf("%d actioned reports, want 1", got)
+	}
+	if code := Testdb.Request(t, router, moderator.UID, http.MethodPost, path, `{"action": "take_down"}`); code != http.StatusNotFound {
+		t.Errorf("second take down: status %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests run against the Postgres database of Utils/Testdb and are skipped when
+// HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func TestRolePermissions(t *testing.T) {
+	Testdb.Require(t)
+	ctx := context.Background()
+	users := Testdb.CreateUsers(t, 3)
+	admin, moderator, user := users[0], users[1], users[2]
+	t.Cleanup(func() {
+		Mdb.DB.Exec("DELETE FROM role_audit_log WHERE target_uid = ANY($1)", pq.Array([]string{admin.UID, moderator.UID, user.UID}))
+	})
+
+	if err := Auth.GrantRole(ctx, admin.UID, moderator.UID, " Moderator ", "reviews reports"); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	if err := Auth.GrantRole(ctx, admin.UID, moderator.UID, Auth.RoleModerator, ""); !errors.Is(err, Auth.ErrRoleAlreadyGranted) {
+		t.Errorf("granting a held role: %v, want %v", err, Auth.ErrRoleAlreadyGranted)
+	}
+	if err := Auth.GrantRole(ctx, admin.UID, user.UID, "superuser", ""); !errors.Is(err, Auth.ErrUnknownRole) {
+		t.Errorf("granting an unknown role: %v, want %v", err, Auth.ErrUnknownRole)
+	}
+	if err := Auth.GrantRole(ctx, admin.UID, admin.UID, Auth.RoleModerator, ""); !errors.Is(err, Auth.ErrOwnRoleChange) {
+		t.Errorf("granting a role to oneself: %v, want %v", err, Auth.ErrOwnRoleChange)
+	}
+
+	// A moderator may delete comments but not users
+	router := Testdb.Router(func(r chi.Router) {
+		ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+		r.With(Auth.RequirePermission(Auth.PermCommentsDelete)).Delete("/comments", ok)
+		r.With(Auth.RequirePermission(Auth.PermUsersDelete)).Delete("/users", ok)
+	})
+	if code := Testdb.Request(t, router, moderator.UID, http.MethodDelete, "/comments", ""); code != http.StatusOK {
+		t.Errorf("moderator deleting comments: status %d", code)
+	}
+	if code := Testdb.Request(t, router, moderator.UID, http.MethodDelete, "/users", ""); code != http.StatusForbidden {
+		t.Errorf("moderator deleting users: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := Testdb.Request(t, router, user.UID, http.MethodDelete, "/comments", ""); code != http.StatusForbidden {
+		t.Errorf("user deleting comments: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// users.role 'admin' holds every permission
+	if _, err := Mdb.DB.Exec("UPDATE users SET role = $1 WHERE uid = $2", Auth.RoleAdmin, admin.UID); err != nil {
+		t.Fatalf("failed to make admin: %v", err)
+	}
+	permissions, err := Auth.UserPermissions(ctx, admin.UID)
+	if err != nil {
+		t.Fatalf("UserPermissions: %v", err)
+	}
+	if !permissions[Auth.PermUsersDelete] || !permissions[Auth.PermRolesManage] {
+		t.Errorf("admin permissions = %v, want all", permissions)
+	}
+
+	if err := Auth.RevokeRole(ctx, admin.UID, moderator.UID, Auth.RoleModerator, ""); err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	if err := Auth.RevokeRole(ctx, admin.UID, moderator.UID, Auth.RoleModerator, ""); !errors.Is(err, Auth.ErrRoleNotGranted) {
+		t.Errorf("revoking a role not held: %v, want %v", err, Auth.ErrRoleNotGranted)
+	}
+	permissions, err = Auth.UserPermissions(ctx, moderator.UID)
+	if err != nil {
+		t.Fatalf("UserPermissions: %v", err)
+	}
+	if len(permissions) != 0 {
+		t.Errorf("permissions after revoking = %v, want none", permissions)
+	}
+
+	// Only the grant and the revocation are audited
+	entries, total, err := Auth.ListRoleAudit(ctx, moderator.UID, 10, 0)
+	if err != nil {
+		t.Fatalf("ListRoleAudit: %v", err)
+	}
+	if total != 2 || len(entries) != 2 {
+		t.Fatalf("%d audit entries, want 2", total)
+	}
+	if entries[0].Action != "revoke" || entries[1].Action != "grant" || entries[1].Role != Auth.RoleModerator || entries[1].ActorUID != admin.UID {
+		t.Errorf("audit entries = %+v", entries)
+	}
+	if entries[1].Reason == nil || *entries[1].Reason != "reviews reports" {
+		t.Errorf("grant reason = %v, want %q", entries[1].Reason, "reviews reports")
+	}
+}
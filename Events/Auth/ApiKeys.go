@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	AuthService "hifi/Services/Auth"
+	Utils "hifi/Utils"
+)
+
+// MaxAPIKeyNameLength is the maximum length of an API key name
+const MaxAPIKeyNameLength = 64
+
+// CreateAPIKeyRequest represents the create API key request payload
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // Optional, defaults to 90
+}
+
+// ListAPIKeys lists the active API keys of the authenticated user
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	keys, err := AuthService.ListAPIKeys(ctx, claims.UID)
+	if err != nil {
+		log.Printf("ListAPIKeys: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to list api keys")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+// CreateAPIKey mints a named API key with scopes and an expiry for the authenticated user
+// The key is only returned in this response, the server keeps its hash
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("CreateAPIKey: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input CreateAPIKeyRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > MaxAPIKeyNameLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "name is required and must be at most 64 characters")
+		return
+	}
+	if len(input.Scopes) == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	scopes, err := AuthService.NormalizeScopes(input.Scopes)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	validity := AuthService.DefaultAPIKeyValidity
+	if input.ExpiresInDays != 0 {
+		validity = time.Duration(input.ExpiresInDays) * 24 * time.Hour
+		if input.ExpiresInDays < 0 || validity > AuthService.MaxAPIKeyValidity {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "expires_in_days must be between 1 and 365")
+			return
+		}
+	}
+
+	key, apiKey, err := AuthService.CreateAPIKey(ctx, claims.UID, name, scopes, validity)
+	if err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrAPIKeyForbidden):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "admin scopes require a staff account")
+		case errors.Is(err, AuthService.ErrTooManyAPIKeys):
+			Utils.SendErrorResponse(w, http.StatusConflict, "too many active api keys, revoke one first")
+		default:
+			log.Printf("CreateAPIKey: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to create api key")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"key":     key,
+		"api_key": apiKey,
+		"message": "Store this key now, it will not be shown again",
+	})
+}
+
+// RevokeAPIKey revokes one API key of the authenticated user
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	keyID := chi.URLParam(r, "keyID")
+	if keyID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "key ID is required")
+		return
+	}
+
+	revoked, err := AuthService.RevokeAPIKey(ctx, claims.UID, keyID)
+	if err != nil {
+		log.Printf("RevokeAPIKey: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to revoke api key")
+		return
+	}
+	if !revoked {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "api key not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "API key revoked successfully"})
+}
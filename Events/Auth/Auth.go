@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	Users "hifi/Events/Users"
 	AuthService "hifi/Services/Auth"
 	Mdb "hifi/Services/Mdb"
+	Ratelimit "hifi/Services/Ratelimit"
 	Utils "hifi/Utils"
 )
 
@@ -29,8 +31,54 @@ func nullStringToPtr(ns sql.NullString) *string {
 
 // Handle sets up the routes for authentication endpoints
 func Handle(r chi.Router) {
+	// Public: login, registration and recovery
 	r.Post("/register", Register)
 	r.Post("/login", Login)
+	r.Post("/login/2fa", LoginTwoFactor)
+	r.Post("/refresh", Refresh)
+	r.Post("/password/forgot", ForgotPassword)
+	r.Post("/password/reset", ResetPassword)
+	r.Get("/oidc/providers", OIDCProviders)
+	r.Post("/oidc/{provider}/callback", OIDCCallback)
+
+	// Public, but check credentials themselves: an enrollment challenge token or a session
+	// (enrollment), or a session when ?link=true (OIDC authorize)
+	r.Post("/2fa/enroll", EnrollTwoFactor)
+	r.Post("/2fa/enroll/confirm", ConfirmTwoFactor)
+	r.Get("/oidc/{provider}/authorize", OIDCAuthorize)
+
+	// Account credentials: session tokens only, API keys are rejected
+	r.Group(func(r chi.Router) {
+		r.Use(AuthService.RequireSession)
+
+		r.Post("/logout", Logout)
+		r.Post("/logout/all", LogoutAll)
+		r.Get("/sessions", ListSessions)
+		r.Delete("/sessions/{sessionID}", RevokeSession)
+		r.Put("/password", ChangePassword)
+
+		// Two-factor authentication
+		r.Get("/2fa", TwoFactorStatus)
+		r.Post("/2fa/recovery-codes", RegenerateRecoveryCodes)
+		r.Delete("/2fa", DisableTwoFactor)
+
+		// External identity providers (OIDC)
+		r.Get("/identities", ListIdentities)
+		r.Delete("/identities/{provider}", UnlinkIdentity)
+
+		// Personal API keys
+		r.Get("/api-keys", ListAPIKeys)
+		r.Post("/api-keys", CreateAPIKey)
+		r.Delete("/api-keys/{keyID}", RevokeAPIKey)
+	})
+}
+
+// sessionMeta captures the device information stored with a session
+func sessionMeta(r *http.Request) AuthService.SessionMeta {
+	return AuthService.SessionMeta{
+		UserAgent: r.UserAgent(),
+		IPAddress: Utils.ClientIP(r),
+	}
 }
 
 // RegisterRequest represents the registration request payload
@@ -67,8 +115,8 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if input.Password == "" || len(input.Password) < 6 {
-		Utils.SendErrorResponse(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if err := validatePassword(input.Password); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -76,6 +124,13 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	username := strings.ToLower(strings.TrimSpace(input.Username))
 	name := strings.TrimSpace(input.Name)
 
+	// Every registration attempt costs a bcrypt hash, so attempts are limited per IP
+	registerLimit := limit{Ratelimit.RegisterIP, Utils.ClientIP(r)}
+	if wait := attemptLimits(ctx, registerLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many registration attempts, try again later")
+		return
+	}
+
 	// Check if username is already taken
 	exists, err := Users.CheckUsernameExists(ctx, username)
 	if err != nil {
@@ -115,10 +170,10 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := AuthService.GenerateToken(uid)
+	// Start a session (access + refresh token)
+	tokens, err := AuthService.CreateSession(ctx, uid, sessionMeta(r))
 	if err != nil {
-		log.Printf("Register: failed to generate token: %v", err)
+		log.Printf("Register: failed to create session: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to generate authentication token")
 		return
 	}
@@ -131,17 +186,15 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	Utils.SendSuccessResponse(w, map[string]interface{}{
-		"id":         userID,
-		"uid":        uid,
-		"token":      token,
-		"expires_in": int(AuthService.TokenValidity.Seconds()),
-		"user": map[string]interface{}{
-			"uid":      uid,
-			"username": username,
-			"name":     name,
-		},
-	})
+	response := tokens.Response()
+	response["id"] = userID
+	response["uid"] = uid
+	response["user"] = map[string]interface{}{
+		"uid":      uid,
+		"username": username,
+		"name":     name,
+	}
+	Utils.SendSuccessResponse(w, response)
 }
 
 // LoginRequest represents the login request payload
@@ -151,6 +204,8 @@ type LoginRequest struct {
 }
 
 // Login authenticates a user and returns a JWT token
+// Accounts with two-factor authentication (and staff, who must enroll) get a challenge token instead,
+// which is completed through POST /auth/login/2fa or the enrollment endpoints
 func Login(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -176,18 +231,26 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	// Normalize username
 	username := strings.ToLower(strings.TrimSpace(input.Username))
 
+	// Brute-force protection: back off per IP and per username before spending a bcrypt compare
+	// The attempt counts as a failure until the password turns out to be correct
+	limits := []limit{{Ratelimit.LoginIP, Utils.ClientIP(r)}, {Ratelimit.LoginUser, username}}
+	if wait := attemptLimits(ctx, limits...); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed login attempts, try again later")
+		return
+	}
+
 	// Fetch user by username
 	var user Users.User
 	var passwordHash string
 	var bioNull, emailNull sql.NullString
 	err = Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, password_hash, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, COALESCE(password_hash, ''), profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE username = $1`,
 		username,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&passwordHash, &user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&passwordHash, &user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -195,6 +258,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 			Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid username or password")
 			return
 		}
+		forgiveLimits(ctx, limits...)
 		log.Printf("Login: failed to fetch user: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
 		return
@@ -207,22 +271,171 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid username or password")
 		return
 	}
+	// Only the username counter is cleared, an IP keeps its earlier failures until they expire
+	forgiveLimits(ctx, limits[0])
+	resetLimits(ctx, limits[1])
+
+	// Second step: TOTP code for enrolled accounts, enrollment for staff without 2FA
+	twoFactorEnabled, err := AuthService.TwoFactorEnabled(ctx, user.UID)
+	if err != nil {
+		log.Printf("Login: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+		return
+	}
+	staff, err := AuthService.IsStaff(ctx, user.UID)
+	if err != nil {
+		log.Printf("Login: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+		return
+	}
+	if twoFactorEnabled || staff {
+		loginChallengeResponse(w, user.UID, twoFactorEnabled)
+		return
+	}
 
-	// Generate JWT token
-	token, err := AuthService.GenerateToken(user.UID)
+	// Start a session (access + refresh token)
+	tokens, err := AuthService.CreateSession(ctx, user.UID, sessionMeta(r))
 	if err != nil {
-		log.Printf("Login: failed to generate token: %v", err)
+		if errors.Is(err, AuthService.ErrAccountSuspended) {
+			Utils.SendErrorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		log.Printf("Login: failed to create session: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to generate authentication token")
 		return
 	}
 
+	response := tokens.Response()
+	response["user"] = map[string]interface{}{
+		"uid":      user.UID,
+		"username": user.Username,
+		"name":     user.Name,
+	}
+	Utils.SendSuccessResponse(w, response)
+}
+
+// RefreshRequest represents the refresh request payload
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Refresh: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input RefreshRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if input.RefreshToken == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+
+	tokens, err := AuthService.RefreshSession(ctx, input.RefreshToken, sessionMeta(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrInvalidRefreshToken):
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid refresh token")
+		case errors.Is(err, AuthService.ErrSessionRevoked):
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "session has been revoked")
+		case errors.Is(err, AuthService.ErrSessionExpired):
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "session has expired")
+		default:
+			log.Printf("Refresh: failed to refresh session: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to refresh session")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, tokens.Response())
+}
+
+// Logout revokes the session of the access token used for the request
+func Logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	if err := AuthService.RevokeSession(ctx, claims.SessionID); err != nil {
+		log.Printf("Logout: failed to revoke session: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to logout")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every session of the authenticated user ("logout everywhere")
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	revoked, err := AuthService.RevokeUserSessions(ctx, claims.UID)
+	if err != nil {
+		log.Printf("LogoutAll: failed to revoke sessions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to logout")
+		return
+	}
+
 	Utils.SendSuccessResponse(w, map[string]interface{}{
-		"token":      token,
-		"expires_in": int(AuthService.TokenValidity.Seconds()),
-		"user": map[string]interface{}{
-			"uid":      user.UID,
-			"username": user.Username,
-			"name":     user.Name,
-		},
+		"message":          "Logged out from all sessions",
+		"revoked_sessions": revoked,
 	})
 }
+
+// ListSessions lists the active sessions (signed in devices) of the authenticated user
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	sessions, err := AuthService.ListSessions(ctx, claims.UID)
+	if err != nil {
+		log.Printf("ListSessions: failed to list sessions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == claims.SessionID
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// RevokeSession signs out a single device of the authenticated user
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	sessionID := chi.URLParam(r, "sessionID")
+	if sessionID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "session ID is required")
+		return
+	}
+
+	revoked, err := AuthService.RevokeUserSession(ctx, claims.UID, sessionID)
+	if err != nil {
+		log.Printf("RevokeSession: failed to revoke session: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to revoke session")
+		return
+	}
+	if !revoked {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	AuthService "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests run against the Postgres database of Utils/Testdb and are skipped when
+// HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+// createSession inserts a session row for uid and returns its ID
+func createSession(t *testing.T, uid string, expiresAt time.Time, revoked bool) string {
+	t.Helper()
+	sessionID := "test_" + Testdb.RandomHex(t, 16)
+	var revokedAt *time.Time
+	if revoked {
+		now := time.Now()
+		revokedAt = &now
+	}
+	if _, err := Mdb.DB.Exec(
+		`INSERT INTO auth_sessions (session_id, user_uid, refresh_token_hash, created_at, refreshed_at, expires_at, last_seen_at, revoked_at)
+		VALUES ($1, $2, $3, $4, $4, $5, $4, $6)`,
+		sessionID, uid, Testdb.RandomHex(t, 32), time.Now(), expiresAt, revokedAt,
+	); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	return sessionID
+}
+
+// serveAuth sends a request with a bearer credential (none if empty) and fails the test on a server error
+func serveAuth(t *testing.T, router http.Handler, method, path, credential, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code >= 500 {
+		t.Errorf("%s %s: status %d: %s", method, path, rec.Code, rec.Body.String())
+	}
+	return rec
+}
+
+// authRequest sends a request without a body and returns the status
+func authRequest(t *testing.T, router http.Handler, method, path, credential string) int {
+	t.Helper()
+	return serveAuth(t, router, method, path, credential, "").Code
+}
+
+func TestRefreshSession(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+	ctx := context.Background()
+	meta := AuthService.SessionMeta{}
+
+	first, err := AuthService.CreateSession(ctx, alice.UID, meta)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Every refresh rotates the refresh token of the same session
+	second, err := AuthService.RefreshSession(ctx, first.RefreshToken, meta)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refresh returned session %s with refresh token %q, want session %s with a new token",
+			second.SessionID, second.RefreshToken, first.SessionID)
+	}
+	third, err := AuthService.RefreshSession(ctx, second.RefreshToken, meta)
+	if err != nil {
+		t.Fatalf("second refresh: %v", err)
+	}
+
+	// A wrong secret, or a token older than the previous one, is rejected but does not revoke the session
+	for name, token := range map[string]string{"wrong secret": third.SessionID + ".wrong", "older token": first.RefreshToken} {
+		if _, err := AuthService.RefreshSession(ctx, token, meta); !errors.Is(err, AuthService.ErrInvalidRefreshToken) {
+			t.Errorf("%s: %v, want %v", name, err, AuthService.ErrInvalidRefreshToken)
+		}
+	}
+	if _, err := AuthService.VerifyToken(third.AccessToken); err != nil {
+		t.Fatalf("access token after rejected refreshes: %v", err)
+	}
+
+	// Replaying the token the last refresh replaced means it was copied: the session is revoked
+	if _, err := AuthService.RefreshSession(ctx, second.RefreshToken, meta); !errors.Is(err, AuthService.ErrInvalidRefreshToken) {
+		t.Errorf("reused refresh token: %v, want %v", err, AuthService.ErrInvalidRefreshToken)
+	}
+	if _, err := AuthService.RefreshSession(ctx, third.RefreshToken, meta); !errors.Is(err, AuthService.ErrSessionRevoked) {
+		t.Errorf("current refresh token after reuse: %v, want %v", err, AuthService.ErrSessionRevoked)
+	}
+	if _, err := AuthService.VerifyToken(third.AccessToken); !errors.Is(err, AuthService.ErrSessionRevoked) {
+		t.Errorf("access token after reuse: %v, want %v", err, AuthService.ErrSessionRevoked)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	alice, bob := users[0], users[1]
+	ctx := context.Background()
+	router := chi.NewRouter()
+	router.Route("/auth", Handle)
+
+	sessions := make([]*AuthService.TokenPair, 3)
+	for i := range sessions {
+		pair, err := AuthService.CreateSession(ctx, alice.UID, AuthService.SessionMeta{})
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		sessions[i] = pair
+	}
+	bobs, err := AuthService.CreateSession(ctx, bob.UID, AuthService.SessionMeta{})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// Logout revokes the session of the access token and no other
+	if code := authRequest(t, router, http.MethodPost, "/auth/logout", sessions[0].AccessToken); code != http.StatusOK {
+		t.Fatalf("logout: status %d", code)
+	}
+	if _, err := AuthService.VerifyToken(sessions[0].AccessToken); !errors.Is(err, AuthService.ErrSessionRevoked) {
+		t.Errorf("access token after logout: %v, want %v", err, AuthService.ErrSessionRevoked)
+	}
+	if _, err := AuthService.RefreshSession(ctx, sessions[0].RefreshToken, AuthService.SessionMeta{}); !errors.Is(err, AuthService.ErrSessionRevoked) {
+		t.Errorf("refresh token after logout: %v, want %v", err, AuthService.ErrSessionRevoked)
+	}
+	if code := authRequest(t, router, http.MethodPost, "/auth/logout", sessions[0].AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("logout with a revoked session: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if _, err := AuthService.VerifyToken(sessions[1].AccessToken); err != nil {
+		t.Errorf("other session after logout: %v", err)
+	}
+
+	// Logging out everywhere revokes every session of the user, and only theirs
+	if code := authRequest(t, router, http.MethodPost, "/auth/logout/all", sessions[1].AccessToken); code != http.StatusOK {
+		t.Fatalf("logout everywhere: status %d", code)
+	}
+	for i, session := range sessions {
+		if _, err := AuthService.VerifyToken(session.AccessToken); !errors.Is(err, AuthService.ErrSessionRevoked) {
+			t.Errorf("session %d after logging out everywhere: %v, want %v", i, err, AuthService.ErrSessionRevoked)
+		}
+	}
+	if _, err := AuthService.VerifyToken(bobs.AccessToken); err != nil {
+		t.Errorf("another user's session after logging out everywhere: %v", err)
+	}
+}
+
+func TestSessionInventory(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	alice, bob := users[0], users[1]
+
+	current := createSession(t, alice.UID, time.Now().Add(time.Hour), false)
+	other := createSession(t, alice.UID, time.Now().Add(time.Hour), false)
+	createSession(t, alice.UID, time.Now().Add(time.Hour), true)
+	createSession(t, alice.UID, time.Now().Add(-time.Minute), false)
+	bobs := createSession(t, bob.UID, time.Now().Add(time.Hour), false)
+
+	// The requests come from alice's current session
+	router := Testdb.Router(func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if principal := AuthService.PrincipalFrom(req); principal != nil && principal.UID == alice.UID {
+					principal.SessionID = current
+				}
+				next.ServeHTTP(w, req)
+			})
+		})
+		r.Get("/sessions", ListSessions)
+		r.Delete("/sessions/{sessionID}", RevokeSession)
+	})
+
+	// Revoked and expired sessions are not listed
+	var listed struct {
+		Sessions []AuthService.Session `json:"sessions"`
+	}
+	if code := Testdb.RequestData(t, router, alice.UID, http.MethodGet, "/sessions", "", &listed); code != http.StatusOK {
+		t.Fatalf("list sessions: status %d", code)
+	}
+	if len(listed.Sessions) != 2 {
+		t.Fatalf("%d sessions listed, want 2", len(listed.Sessions))
+	}
+	for _, session := range listed.Sessions {
+		if session.Current != (session.SessionID == current) {
+			t.Errorf("session %s current = %v", session.SessionID, session.Current)
+		}
+	}
+
+	// Sessions of other users cannot be revoked
+	if code := Testdb.Request(t, router, alice.UID, http.MethodDelete, "/sessions/"+bobs, ""); code != http.StatusNotFound {
+		t.Errorf("revoke another user's session: status %d, want %d", code, http.StatusNotFound)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM auth_sessions WHERE session_id = $1 AND revoked_at IS NULL", bobs); got != 1 {
+		t.Error("another user's session was revoked")
+	}
+
+	if code := Testdb.Request(t, router, alice.UID, http.MethodDelete, "/sessions/"+other, ""); code != http.StatusOK {
+		t.Fatalf("revoke session: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodDelete, "/sessions/"+other, ""); code != http.StatusNotFound {
+		t.Errorf("revoke a revoked session: status %d, want %d", code, http.StatusNotFound)
+	}
+	Testdb.RequestData(t, router, alice.UID, http.MethodGet, "/sessions", "", &listed)
+	if len(listed.Sessions) != 1 || listed.Sessions[0].SessionID != current {
+		t.Errorf("sessions after revoking one = %+v, want only the current one", listed.Sessions)
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+
+	key, apiKey, err := AuthService.CreateAPIKey(context.Background(), alice.UID, "test", []string{AuthService.ScopeVideosRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := AuthService.PrincipalFrom(r); principal == nil || principal.UID != alice.UID || principal.APIKeyID != apiKey.KeyID {
+			t.Errorf("principal = %+v, want alice's API key", principal)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	router := chi.NewRouter()
+	router.With(AuthService.RequireAuth, AuthService.RequireScope(AuthService.ScopeVideosRead)).Get("/read", ok)
+	router.With(AuthService.RequireAuth, AuthService.RequireScope(AuthService.ScopeVideosWrite)).Get("/write", ok)
+	router.With(AuthService.RequireSession).Get("/session", ok)
+
+	request := func(path, key string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := request("/read", key); code != http.StatusOK {
+		t.Errorf("granted scope: status %d", code)
+	}
+	if code := request("/write", key); code != http.StatusForbidden {
+		t.Errorf("missing scope: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := request("/session", key); code != http.StatusUnauthorized {
+		t.Errorf("API key on a session-only route: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := request("/read", key+"x"); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if revoked, err := AuthService.RevokeAPIKey(context.Background(), alice.UID, apiKey.KeyID); err != nil || !revoked {
+		t.Fatalf("RevokeAPIKey = %v, %v", revoked, err)
+	}
+	if code := request("/read", key); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"net/http"
+
+	AuthService "hifi/Services/Auth"
+	Utils "hifi/Utils"
+)
+
+// JWKS publishes the public keys that verify access tokens (RFC 7517)
+// The response is the bare key set, not the usual API envelope, so standard JWT libraries can consume it
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	Utils.SendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"keys": AuthService.JWKS(),
+	})
+}
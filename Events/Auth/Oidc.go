@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Search "hifi/Events/Search"
+	Users "hifi/Events/Users"
+	AuthService "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Oidc "hifi/Services/Oidc"
+	Utils "hifi/Utils"
+)
+
+// usernameAttempts is how many candidate usernames are tried when provisioning a user
+const usernameAttempts = 5
+
+// OIDCCallbackRequest represents the payload the frontend posts after the provider redirect
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OIDCProviders lists the identity providers users can sign in with
+func OIDCProviders(w http.ResponseWriter, r *http.Request) {
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"providers": Oidc.ProviderNames(),
+	})
+}
+
+// OIDCAuthorize starts an authorization code + PKCE login and returns the provider URL to send the user to
+// With ?link=true and an access token the identity is linked to the signed in user instead
+func OIDCAuthorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	provider, err := Oidc.GetProvider(chi.URLParam(r, "provider"))
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "unknown identity provider")
+		return
+	}
+
+	var linkUID string
+	if r.URL.Query().Get("link") == "true" {
+		claims, ok := AuthService.GetSessionClaims(r)
+		if !ok {
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		linkUID = claims.UID
+	}
+
+	state, loginState, err := AuthService.CreateOIDCLoginState(ctx, provider.Name, linkUID)
+	if err != nil {
+		log.Printf("OIDCAuthorize: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to start login")
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(ctx, state, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDCAuthorize: provider %s: %v", provider.Name, err)
+		Utils.SendErrorResponse(w, http.StatusBadGateway, "identity provider is unavailable")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"authorization_url": authorizationURL,
+		"state":             state,
+		"expires_in":        int(AuthService.OIDCLoginStateValidity.Seconds()),
+	})
+}
+
+// OIDCCallback completes an authorization code login
+// A known identity signs its user in (with the same two-factor rules as a password login),
+// an unknown identity provisions a new user, and a link state links the identity to its user
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	provider, err := Oidc.GetProvider(chi.URLParam(r, "provider"))
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "unknown identity provider")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("OIDCCallback: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input OIDCCallbackRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if input.Code == "" || input.State == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "code and state are required")
+		return
+	}
+
+	loginState, err := AuthService.RedeemOIDCLoginState(ctx, input.State, provider.Name)
+	if err != nil {
+		if errors.Is(err, AuthService.ErrInvalidOIDCState) {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid or expired state")
+			return
+		}
+		log.Printf("OIDCCallback: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to complete login")
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, input.Code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("OIDCCallback: provider %s: %v", provider.Name, err)
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "failed to verify identity with provider")
+		return
+	}
+
+	if loginState.LinkUID != "" {
+		linkIdentityResponse(ctx, w, loginState.LinkUID, provider.Name, identity)
+		return
+	}
+
+	uid, err := AuthService.LoginIdentity(ctx, provider.Name, identity.Subject, identity.Email)
+	if err == nil {
+		// Same second step as a password login
+		twoFactorEnabled, err := AuthService.TwoFactorEnabled(ctx, uid)
+		if err != nil {
+			log.Printf("OIDCCallback: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+			return
+		}
+		staff, err := AuthService.IsStaff(ctx, uid)
+		if err != nil {
+			log.Printf("OIDCCallback: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+			return
+		}
+		if twoFactorEnabled || staff {
+			loginChallengeResponse(w, uid, twoFactorEnabled)
+			return
+		}
+
+		sendLoginResponse(ctx, w, r, uid, map[string]interface{}{"created": false})
+		return
+	}
+	if !errors.Is(err, AuthService.ErrIdentityNotFound) {
+		log.Printf("OIDCCallback: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+		return
+	}
+
+	uid, err = provisionOIDCUser(ctx, provider.Name, identity)
+	if err != nil {
+		log.Printf("OIDCCallback: failed to provision user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to create user")
+		return
+	}
+
+	sendLoginResponse(ctx, w, r, uid, map[string]interface{}{"created": true})
+}
+
+// linkIdentityResponse links a verified identity to a signed in user
+func linkIdentityResponse(ctx context.Context, w http.ResponseWriter, uid, provider string, identity *Oidc.IDTokenClaims) {
+	err := AuthService.LinkIdentity(ctx, Mdb.DB, uid, provider, identity.Subject, identity.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrIdentityLinked):
+			Utils.SendErrorResponse(w, http.StatusConflict, "identity is already linked to another account")
+		case errors.Is(err, AuthService.ErrProviderAlreadyLinked):
+			Utils.SendErrorResponse(w, http.StatusConflict, "an identity from this provider is already linked")
+		default:
+			log.Printf("OIDCCallback: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to link identity")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":  "Identity linked successfully",
+		"provider": provider,
+		"linked":   true,
+	})
+}
+
+// provisionOIDCUser creates a user for an identity that is not linked yet
+// The username is derived from the identity and validated like a registered one; the account has no password
+// The email is only copied when the provider verified it and no other user has it
+func provisionOIDCUser(ctx context.Context, provider string, identity *Oidc.IDTokenClaims) (string, error) {
+	username, err := availableUsername(ctx, identity)
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(identity.Name)
+	if Users.ValidateName(name) != nil {
+		name = username
+	}
+
+	var emailNull sql.NullString
+	var emailVerifiedAt sql.NullTime
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	if identity.EmailVerified && email != "" && Users.ValidateEmail(email) == nil {
+		var taken bool
+		err := Mdb.DB.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $1)",
+			email,
+		).Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("failed to check email: %w", err)
+		}
+		if !taken {
+			emailNull = sql.NullString{String: email, Valid: true}
+			emailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+
+	uid := Users.GenerateUID(username)
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO users (uid, username, name, role, password_hash, profile_picture, email, email_verified_at,
+			followers, following, total_streams, total_videos, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULL, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		uid, username, name, Users.DefaultRole, "", emailNull, emailVerifiedAt, 0, 0, 0, 0, now, now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	if err := AuthService.LinkIdentity(ctx, tx, uid, provider, identity.Subject, identity.Email); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit user: %w", err)
+	}
+
+	// Index user in Elasticsearch (non-blocking, log errors but don't fail the login)
+	go func() {
+		esCtx := context.Background()
+		if err := Search.IndexUser(esCtx, uid, username, ""); err != nil {
+			log.Printf("OIDCCallback: failed to index user in Elasticsearch: %v", err)
+		}
+	}()
+
+	return uid, nil
+}
+
+// availableUsername picks a free username from the identity's preferred username, email or name
+// A random suffix is appended when the candidate is taken
+func availableUsername(ctx context.Context, identity *Oidc.IDTokenClaims) (string, error) {
+	emailLocal, _, _ := strings.Cut(identity.Email, "@")
+
+	base := "user"
+	for _, candidate := range []string{identity.PreferredUsername, emailLocal, identity.Name} {
+		candidate = sanitizeUsername(candidate)
+		if Users.ValidateUsername(candidate) == nil {
+			base = candidate
+			break
+		}
+	}
+
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		candidate := base
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return "", fmt.Errorf("failed to generate username: %w", err)
+			}
+			candidate = fmt.Sprintf("%s_%04d", base, suffix.Int64())
+		}
+		if err := Users.ValidateUsername(candidate); err != nil {
+			continue
+		}
+
+		exists, err := Users.CheckUsernameExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("no available username")
+}
+
+// sanitizeUsername maps a display value to the username alphabet, leaving room for a suffix
+func sanitizeUsername(value string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(value)) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_':
+			b.WriteRune(c)
+		case c == '.', c == '-', c == ' ':
+			b.WriteRune('_')
+		}
+	}
+	username := strings.Trim(b.String(), "_")
+	if len(username) > Users.MaxUsernameLength-5 {
+		username = username[:Users.MaxUsernameLength-5]
+	}
+	return username
+}
+
+// ListIdentities lists the external identities linked to the authenticated user
+func ListIdentities(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	identities, err := AuthService.ListIdentities(ctx, claims.UID)
+	if err != nil {
+		log.Printf("ListIdentities: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to list identities")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"identities": identities,
+		"count":      len(identities),
+	})
+}
+
+// UnlinkIdentity removes the identity of a provider from the authenticated user
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	provider := strings.ToLower(chi.URLParam(r, "provider"))
+	if provider == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "provider is required")
+		return
+	}
+
+	removed, err := AuthService.UnlinkIdentity(ctx, claims.UID, provider)
+	if err != nil {
+		if errors.Is(err, AuthService.ErrLastLoginMethod) {
+			Utils.SendErrorResponse(w, http.StatusConflict, "set a password or link another identity before removing this one")
+			return
+		}
+		log.Printf("UnlinkIdentity: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to unlink identity")
+		return
+	}
+	if !removed {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "identity not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Identity unlinked successfully"})
+}
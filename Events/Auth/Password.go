@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	AuthService "hifi/Services/Auth"
+	Mail "hifi/Services/Mail"
+	Mdb "hifi/Services/Mdb"
+	Ratelimit "hifi/Services/Ratelimit"
+	Utils "hifi/Utils"
+)
+
+// MinPasswordLength is the minimum number of characters in a password
+const MinPasswordLength = 6
+
+// validatePassword checks the password rules shared by register, change and reset
+func validatePassword(password string) error {
+	if password == "" || len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
+
+// ChangePasswordRequest represents the change password request payload
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword changes the password of the authenticated user
+// All sessions and API keys are revoked and a fresh session is returned for the current device
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ChangePassword: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input ChangePasswordRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if input.CurrentPassword == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "current_password is required")
+		return
+	}
+	if err := validatePassword(input.NewPassword); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var username, passwordHash string
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT username, COALESCE(password_hash, '') FROM users WHERE uid = $1",
+		claims.UID,
+	).Scan(&username, &passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "user not found")
+			return
+		}
+		log.Printf("ChangePassword: failed to fetch user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+
+	// A stolen session must not give unlimited password guesses, so wrong passwords count as failed logins
+	limits := []limit{{Ratelimit.LoginIP, Utils.ClientIP(r)}, {Ratelimit.LoginUser, username}}
+	if wait := attemptLimits(ctx, limits...); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed login attempts, try again later")
+		return
+	}
+	if !AuthService.CheckPasswordHash(input.CurrentPassword, passwordHash) {
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "current password is incorrect")
+		return
+	}
+	forgiveLimits(ctx, limits[0])
+	resetLimits(ctx, limits[1])
+
+	if err := AuthService.ChangePassword(ctx, claims.UID, input.NewPassword); err != nil {
+		log.Printf("ChangePassword: failed to change password: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to change password")
+		return
+	}
+
+	// Every earlier session is gone, keep the current device signed in with a new one
+	tokens, err := AuthService.CreateSession(ctx, claims.UID, sessionMeta(r))
+	if err != nil {
+		log.Printf("ChangePassword: failed to create session: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to generate authentication token")
+		return
+	}
+
+	response := tokens.Response()
+	response["message"] = "Password changed successfully"
+	Utils.SendSuccessResponse(w, response)
+}
+
+// ForgotPasswordRequest represents the forgot password request payload
+// Either username or email identifies the account
+type ForgotPasswordRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// ForgotPassword emails a password reset link to the account owner
+// Links are only sent to verified email addresses
+// The response is the same whether or not the account exists, so it cannot be used to probe accounts
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ForgotPassword: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input ForgotPasswordRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(input.Username))
+	email := strings.TrimSpace(input.Email)
+	if username == "" && email == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "username or email is required")
+		return
+	}
+
+	// Every request may send an email, so requests are limited per IP
+	forgotLimit := limit{Ratelimit.PasswordResetIP, Utils.ClientIP(r)}
+	if wait := attemptLimits(ctx, forgotLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many password reset requests, try again later")
+		return
+	}
+
+	response := map[string]string{
+		"message": "If an account with a verified email exists, a password reset link has been sent",
+	}
+
+	query := "SELECT uid, username, email FROM users WHERE username = $1 AND email_verified_at IS NOT NULL"
+	arg := username
+	if username == "" {
+		query = "SELECT uid, username, email FROM users WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL"
+		arg = email
+	}
+
+	var uid, accountUsername string
+	var emailNull sql.NullString
+	err = Mdb.DB.QueryRowContext(ctx, query, arg).Scan(&uid, &accountUsername, &emailNull)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("ForgotPassword: failed to fetch user: %v", err)
+		}
+		Utils.SendSuccessResponse(w, response)
+		return
+	}
+	if !emailNull.Valid || emailNull.String == "" {
+		Utils.SendSuccessResponse(w, response)
+		return
+	}
+
+	// A throttled account gets the same response, otherwise the 429 would reveal that it exists
+	token, err := AuthService.CreatePasswordResetToken(ctx, uid)
+	if err != nil {
+		if errors.Is(err, AuthService.ErrPasswordResetThrottled) {
+			Utils.SendSuccessResponse(w, response)
+			return
+		}
+		log.Printf("ForgotPassword: failed to create reset token: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to process password reset")
+		return
+	}
+
+	// Send the email in the background (non-blocking, the response must not reveal delivery failures)
+	go func() {
+		msg := Mail.Message{
+			To:      emailNull.String,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and can only be used once.\n\n%s\n\nIf you did not request this, you can ignore this email.\n",
+				accountUsername, int(AuthService.PasswordResetTokenValidity.Minutes()), passwordResetLink(token),
+			),
+		}
+		if err := Mail.Send(context.Background(), msg); err != nil {
+			log.Printf("ForgotPassword: failed to send reset email: %v", err)
+		}
+	}()
+
+	Utils.SendSuccessResponse(w, response)
+}
+
+// passwordResetLink builds the frontend link that carries a reset token
+func passwordResetLink(token string) string {
+	return Utils.AppBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+}
+
+// ResetPasswordRequest represents the reset password request payload
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ResetPassword sets a new password using a token from a reset email
+// The token is consumed and all sessions and API keys of the user are revoked
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ResetPassword: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var input ResetPasswordRequest
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	input.Token = strings.TrimSpace(input.Token)
+	if input.Token == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "token is required")
+		return
+	}
+	if err := validatePassword(input.NewPassword); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := AuthService.ResetPassword(ctx, input.Token, input.NewPassword); err != nil {
+		if errors.Is(err, AuthService.ErrInvalidResetToken) {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid or expired reset token")
+			return
+		}
+		log.Printf("ResetPassword: failed to reset password: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Password reset successfully, please log in again"})
+}
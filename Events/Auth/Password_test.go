@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	AuthService "hifi/Services/Auth"
+	Mail "hifi/Services/Mail"
+	Mdb "hifi/Services/Mdb"
+	Ratelimit "hifi/Services/Ratelimit"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// testClientIP is the client IP of requests made with httptest.NewRequest
+const testClientIP = "192.0.2.1"
+
+func passwordRouter(t *testing.T) http.Handler {
+	t.Helper()
+	// Earlier tests (or runs with -count) must not have throttled the test client
+	ctx := context.Background()
+	resetLimits(ctx, limit{Ratelimit.LoginIP, testClientIP}, limit{Ratelimit.PasswordResetIP, testClientIP})
+	router := chi.NewRouter()
+	router.Route("/auth", Handle)
+	return router
+}
+
+// setCredentials gives the user a password and, if verified, a verified email address, and returns the email
+func setCredentials(t *testing.T, user Testdb.User, password string, verified bool) string {
+	t.Helper()
+	passwordHash, err := AuthService.HashPassword(password)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	email := user.Username + "@example.com"
+	var verifiedAt *time.Time
+	if verified {
+		now := time.Now()
+		verifiedAt = &now
+	}
+	if _, err := Mdb.DB.Exec(
+		"UPDATE users SET password_hash = $1, email = $2, email_verified_at = $3 WHERE uid = $4",
+		passwordHash, email, verifiedAt, user.UID,
+	); err != nil {
+		t.Fatalf("failed to set credentials: %v", err)
+	}
+	return email
+}
+
+func checkPassword(t *testing.T, uid, password string) bool {
+	t.Helper()
+	var passwordHash string
+	if err := Mdb.DB.QueryRow("SELECT password_hash FROM users WHERE uid = $1", uid).Scan(&passwordHash); err != nil {
+		t.Fatalf("failed to fetch password hash: %v", err)
+	}
+	return AuthService.CheckPasswordHash(password, passwordHash)
+}
+
+// createCredentials opens n sessions and an API key for the user
+func createCredentials(t *testing.T, uid string, n int) []*AuthService.TokenPair {
+	t.Helper()
+	ctx := context.Background()
+	sessions := make([]*AuthService.TokenPair, n)
+	for i := range sessions {
+		pair, err := AuthService.CreateSession(ctx, uid, AuthService.SessionMeta{})
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		sessions[i] = pair
+	}
+	if _, _, err := AuthService.CreateAPIKey(ctx, uid, "test", []string{AuthService.ScopeVideosRead}, time.Hour); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return sessions
+}
+
+func activeAPIKeys(t *testing.T, uid string) int {
+	t.Helper()
+	return Testdb.QueryInt(t, "SELECT COUNT(*) FROM api_keys WHERE user_uid = $1 AND revoked_at IS NULL", uid)
+}
+
+// useMailLog sends the emails of the test to a log file and returns its path
+func useMailLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mail.log")
+	sender := Mail.DefaultSender
+	Mail.DefaultSender = &Mail.LogSender{Path: path}
+	t.Cleanup(func() { Mail.DefaultSender = sender })
+	return path
+}
+
+// waitForMail waits until n emails were written to the mail log and returns them
+// Emails are sent in the background, after the response
+func waitForMail(t *testing.T, path string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("failed to read mail log: %v", err)
+		}
+		messages := strings.SplitAfter(string(data), "\n---\n")
+		messages = messages[:len(messages)-1]
+		if len(messages) >= n || time.Now().After(deadline) {
+			if len(messages) != n {
+				t.Fatalf("%d emails sent, want %d", len(messages), n)
+			}
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var resetLinkToken = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+// mailedResetToken returns the reset token in the link of an email
+func mailedResetToken(t *testing.T, message string) string {
+	t.Helper()
+	match := resetLinkToken.FindStringSubmatch(message)
+	if match == nil {
+		t.Fatalf("no reset link in email:\n%s", message)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("failed to decode reset token: %v", err)
+	}
+	return token
+}
+
+func resetTokens(t *testing.T, uid string) int {
+	t.Helper()
+	return Testdb.QueryInt(t, "SELECT COUNT(*) FROM password_reset_tokens WHERE user_uid = $1", uid)
+}
+
+// ageResetTokens moves the reset tokens of the user past the cooldown
+func ageResetTokens(t *testing.T, uid string) {
+	t.Helper()
+	if _, err := Mdb.DB.Exec(
+		"UPDATE password_reset_tokens SET created_at = $1 WHERE user_uid = $2",
+		time.Now().Add(-2*AuthService.PasswordResetCooldown), uid,
+	); err != nil {
+		t.Fatalf("failed to age reset tokens: %v", err)
+	}
+}
+
+// createResetToken inserts a reset token for the user and returns the token
+func createResetToken(t *testing.T, uid string, expiresAt time.Time) string {
+	t.Helper()
+	token, tokenHash, err := AuthService.GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+	if _, err := Mdb.DB.Exec(
+		`INSERT INTO password_reset_tokens (token_hash, user_uid, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		tokenHash, uid, time.Now(), expiresAt,
+	); err != nil {
+		t.Fatalf("failed to create reset token: %v", err)
+	}
+	return token
+}
+
+func TestChangePassword(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+	router := passwordRouter(t)
+	setCredentials(t, alice, "oldpassword", true)
+	sessions := createCredentials(t, alice.UID, 2)
+	current, other := sessions[0], sessions[1]
+
+	// Wrong current passwords count as failed logins, so a stolen session cannot guess for long
+	wrong := `{"current_password": "wrongpassword", "new_password": "newpassword"}`
+	for i := 0; i <= Ratelimit.LoginUser.Policy.FreeAttempts; i++ {
+		if code := serveAuth(t, router, http.MethodPut, "/auth/password", current.AccessToken, wrong).Code; code != http.StatusUnauthorized {
+			t.Fatalf("wrong current password: status %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+	if code := serveAuth(t, router, http.MethodPut, "/auth/password", current.AccessToken, wrong).Code; code != http.StatusTooManyRequests {
+		t.Errorf("wrong current password after the free attempts: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if !checkPassword(t, alice.UID, "oldpassword") {
+		t.Fatal("password changed with a wrong current password")
+	}
+	if _, err := AuthService.VerifyToken(other.AccessToken); err != nil {
+		t.Fatalf("other session after a wrong current password: %v", err)
+	}
+
+	resetLimits(context.Background(), limit{Ratelimit.LoginUser, alice.Username})
+	right := `{"current_password": "oldpassword", "new_password": "newpassword"}`
+	if code := serveAuth(t, router, http.MethodPut, "/auth/password", current.AccessToken, right).Code; code != http.StatusOK {
+		t.Fatalf("change password: status %d", code)
+	}
+	if !checkPassword(t, alice.UID, "newpassword") {
+		t.Error("password not changed")
+	}
+
+	// Every earlier session and API key is revoked, only the session returned to the device is left
+	for name, session := range map[string]*AuthService.TokenPair{"current": current, "other": other} {
+		if _, err := AuthService.VerifyToken(session.AccessToken); !errors.Is(err, AuthService.ErrSessionRevoked) {
+			t.Errorf("%s session after the change: %v, want %v", name, err, AuthService.ErrSessionRevoked)
+		}
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM auth_sessions WHERE user_uid = $1 AND revoked_at IS NULL", alice.UID); got != 1 {
+		t.Errorf("%d active sessions after the change, want 1", got)
+	}
+	if got := activeAPIKeys(t, alice.UID); got != 0 {
+		t.Errorf("%d active API keys after the change, want 0", got)
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	alice, bob := users[0], users[1]
+	router := passwordRouter(t)
+	mailLog := useMailLog(t)
+	email := setCredentials(t, alice, "password", true)
+	setCredentials(t, bob, "password", false)
+
+	forgot := func(body string) string {
+		t.Helper()
+		rec := serveAuth(t, router, http.MethodPost, "/auth/password/forgot", "", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("forgot password with %s: status %d", body, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// Unknown and unverified accounts get the response of a sent email, and nothing is sent
+	sent := forgot(`{"email": "` + strings.ToUpper(email) + `"}`)
+	if got := forgot(`{"username": "unknown_` + alice.Username + `"}`); got != sent {
+		t.Errorf("unknown account: response %s, want %s", got, sent)
+	}
+	if got := forgot(`{"username": "` + bob.Username + `"}`); got != sent {
+		t.Errorf("unverified account: response %s, want %s", got, sent)
+	}
+	if got := resetTokens(t, bob.UID); got != 0 {
+		t.Errorf("%d reset tokens issued for an unverified account, want 0", got)
+	}
+	messages := waitForMail(t, mailLog, 1)
+	if !strings.Contains(messages[0], "To: "+email) {
+		t.Errorf("email sent to the wrong address:\n%s", messages[0])
+	}
+
+	// Within the cooldown the response is the same, but no token or email is issued
+	if got := forgot(`{"username": "` + alice.Username + `"}`); got != sent {
+		t.Errorf("within the cooldown: response %s, want %s", got, sent)
+	}
+	if got := resetTokens(t, alice.UID); got != 1 {
+		t.Errorf("%d reset tokens within the cooldown, want 1", got)
+	}
+
+	ageResetTokens(t, alice.UID)
+	forgot(`{"username": "` + alice.Username + `"}`)
+	if got := resetTokens(t, alice.UID); got != 2 {
+		t.Errorf("%d reset tokens after the cooldown, want 2", got)
+	}
+
+	// The hourly limit holds even after the cooldown
+	for i := 2; i < AuthService.PasswordResetHourlyLimit; i++ {
+		createResetToken(t, alice.UID, time.Now().Add(time.Hour))
+	}
+	ageResetTokens(t, alice.UID)
+	if got := forgot(`{"username": "` + alice.Username + `"}`); got != sent {
+		t.Errorf("over the hourly limit: response %s, want %s", got, sent)
+	}
+	if got := resetTokens(t, alice.UID); got != AuthService.PasswordResetHourlyLimit {
+		t.Errorf("%d reset tokens over the hourly limit, want %d", got, AuthService.PasswordResetHourlyLimit)
+	}
+	waitForMail(t, mailLog, 2)
+}
+
+func TestResetPassword(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+	router := passwordRouter(t)
+	mailLog := useMailLog(t)
+	setCredentials(t, alice, "oldpassword", true)
+	sessions := createCredentials(t, alice.UID, 2)
+
+	reset := func(token string) int {
+		t.Helper()
+		return serveAuth(t, router, http.MethodPost, "/auth/password/reset", "",
+			`{"token": "`+token+`", "new_password": "newpassword"}`).Code
+	}
+
+	expired := createResetToken(t, alice.UID, time.Now().Add(-time.Minute))
+	if code := reset(expired); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if !checkPassword(t, alice.UID, "oldpassword") {
+		t.Fatal("password reset with an expired token")
+	}
+
+	// Requesting a link invalidates the tokens issued earlier
+	earlier := createResetToken(t, alice.UID, time.Now().Add(time.Hour))
+	ageResetTokens(t, alice.UID)
+	if code := serveAuth(t, router, http.MethodPost, "/auth/password/forgot", "", `{"username": "`+alice.Username+`"}`).Code; code != http.StatusOK {
+		t.Fatalf("forgot password: status %d", code)
+	}
+	token := mailedResetToken(t, waitForMail(t, mailLog, 1)[0])
+	if code := reset(earlier); code != http.StatusBadRequest {
+		t.Errorf("token replaced by a newer one: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code := reset(token); code != http.StatusOK {
+		t.Fatalf("reset password: status %d", code)
+	}
+	if !checkPassword(t, alice.UID, "newpassword") {
+		t.Error("password not reset")
+	}
+	for i, session := range sessions {
+		if _, err := AuthService.VerifyToken(session.AccessToken); !errors.Is(err, AuthService.ErrSessionRevoked) {
+			t.Errorf("session %d after the reset: %v, want %v", i, err, AuthService.ErrSessionRevoked)
+		}
+	}
+	if got := activeAPIKeys(t, alice.UID); got != 0 {
+		t.Errorf("%d active API keys after the reset, want 0", got)
+	}
+
+	// Tokens are single use
+	if code := reset(token); code != http.StatusBadRequest {
+		t.Errorf("used token: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	Ratelimit "hifi/Services/Ratelimit"
+)
+
+// limit pairs a limiter with the id (IP, username or UID) it is applied to
+type limit struct {
+	limiter *Ratelimit.Limiter
+	id      string
+}
+
+// attemptLimits records an attempt on each of the limits and returns the wait imposed by the first limit
+// that throttles it (0 means the attempt is allowed and counted as a failure until forgiven or reset)
+// A throttled attempt is not counted on any limit.
+// Limiter errors are logged and ignored, so an unavailable store does not lock everybody out
+func attemptLimits(ctx context.Context, limits ...limit) time.Duration {
+	for i, l := range limits {
+		wait, err := l.limiter.Attempt(ctx, l.id)
+		if err != nil {
+			log.Printf("attemptLimits: %v", err)
+			continue
+		}
+		if wait > 0 {
+			forgiveLimits(ctx, limits[:i]...)
+			return wait
+		}
+	}
+	return 0
+}
+
+// forgiveLimits takes back the attempt recorded on each of the limits, for attempts that did not fail
+func forgiveLimits(ctx context.Context, limits ...limit) {
+	for _, l := range limits {
+		if err := l.limiter.Forgive(ctx, l.id); err != nil {
+			log.Printf("forgiveLimits: %v", err)
+		}
+	}
+}
+
+// resetLimits clears the failures recorded on each of the limits
+func resetLimits(ctx context.Context, limits ...limit) {
+	for _, l := range limits {
+		if err := l.limiter.Reset(ctx, l.id); err != nil {
+			log.Printf("resetLimits: %v", err)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	AuthService "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Ratelimit "hifi/Services/Ratelimit"
+	Utils "hifi/Utils"
+)
+
+// TwoFactorRequest is the payload shared by the two-factor endpoints
+// ChallengeToken is only used during login (second step or forced enrollment)
+type TwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	Password       string `json:"password"`
+}
+
+// readTwoFactorRequest decodes the request body; an empty body is allowed
+func readTwoFactorRequest(r *http.Request) (TwoFactorRequest, error) {
+	var input TwoFactorRequest
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return input, err
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return input, nil
+	}
+	err = json.Unmarshal(body, &input)
+	return input, err
+}
+
+// enrollmentSubject returns the user enrolling in two-factor authentication
+// It is either the authenticated user or, for accounts that must enroll before they can log in,
+// the user of an enrollment challenge token
+func enrollmentSubject(r *http.Request, challengeToken string) (string, bool, bool) {
+	if challengeToken != "" {
+		uid, err := AuthService.VerifyChallengeToken(challengeToken, AuthService.ChallengeEnrollment)
+		if err != nil {
+			return "", false, false
+		}
+		return uid, true, true
+	}
+	claims, ok := AuthService.GetSessionClaims(r)
+	if !ok {
+		return "", false, false
+	}
+	return claims.UID, false, true
+}
+
+// loginChallengeResponse answers the password step of a login when a second step is needed
+func loginChallengeResponse(w http.ResponseWriter, uid string, twoFactorEnabled bool) {
+	purpose := AuthService.ChallengeTwoFactor
+	if !twoFactorEnabled {
+		purpose = AuthService.ChallengeEnrollment
+	}
+
+	challenge, err := AuthService.GenerateChallengeToken(uid, purpose)
+	if err != nil {
+		log.Printf("Login: failed to generate challenge token: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to generate authentication token")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"two_factor_required":  twoFactorEnabled,
+		"enrollment_required":  !twoFactorEnabled,
+		"challenge_token":      challenge,
+		"challenge_expires_in": int(AuthService.ChallengeTokenValidity.Seconds()),
+	})
+}
+
+// sendLoginResponse creates a session for the user and responds with its tokens and the user summary
+func sendLoginResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, uid string, extra map[string]interface{}) {
+	var username, name string
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT username, name FROM users WHERE uid = $1",
+		uid,
+	).Scan(&username, &name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid challenge token")
+			return
+		}
+		log.Printf("sendLoginResponse: failed to fetch user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+		return
+	}
+
+	tokens, err := AuthService.CreateSession(ctx, uid, sessionMeta(r))
+	if err != nil {
+		if errors.Is(err, AuthService.ErrAccountSuspended) {
+			Utils.SendErrorResponse(w, http.StatusForbidden, err.Error())
+			return
+		}
+		log.Printf("sendLoginResponse: failed to create session: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to generate authentication token")
+		return
+	}
+
+	response := tokens.Response()
+	for k, v := range extra {
+		response[k] = v
+	}
+	response["user"] = map[string]interface{}{
+		"uid":      uid,
+		"username": username,
+		"name":     name,
+	}
+	Utils.SendSuccessResponse(w, response)
+}
+
+// LoginTwoFactor completes a login by exchanging a challenge token and a TOTP or recovery code for tokens
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	input, err := readTwoFactorRequest(r)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if input.ChallengeToken == "" || input.Code == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "challenge_token and code are required")
+		return
+	}
+
+	uid, err := AuthService.VerifyChallengeToken(input.ChallengeToken, AuthService.ChallengeTwoFactor)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid or expired challenge token")
+		return
+	}
+
+	// Codes are short, so failed guesses are limited per user
+	codeLimit := limit{Ratelimit.LoginTwoFactor, uid}
+	if wait := attemptLimits(ctx, codeLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed two-factor attempts, try again later")
+		return
+	}
+
+	if err := AuthService.VerifySecondFactor(ctx, uid, input.Code); err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrInvalidTwoFactorCode):
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid two-factor code")
+		case errors.Is(err, AuthService.ErrTwoFactorNotEnabled):
+			forgiveLimits(ctx, codeLimit)
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "invalid or expired challenge token")
+		default:
+			forgiveLimits(ctx, codeLimit)
+			log.Printf("LoginTwoFactor: failed to verify code: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to authenticate")
+		}
+		return
+	}
+
+	resetLimits(ctx, codeLimit)
+
+	remaining, err := AuthService.RecoveryCodesRemaining(ctx, uid)
+	if err != nil {
+		log.Printf("LoginTwoFactor: %v", err)
+	}
+
+	sendLoginResponse(ctx, w, r, uid, map[string]interface{}{"recovery_codes_remaining": remaining})
+}
+
+// TwoFactorStatus reports whether the authenticated user has two-factor authentication enabled
+func TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	enabled, err := AuthService.TwoFactorEnabled(ctx, claims.UID)
+	if err != nil {
+		log.Printf("TwoFactorStatus: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to load two-factor status")
+		return
+	}
+
+	remaining := 0
+	if enabled {
+		if remaining, err = AuthService.RecoveryCodesRemaining(ctx, claims.UID); err != nil {
+			log.Printf("TwoFactorStatus: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to load two-factor status")
+			return
+		}
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTwoFactor starts TOTP enrollment and returns the secret and otpauth URI for the authenticator app
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	input, err := readTwoFactorRequest(r)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	uid, _, ok := enrollmentSubject(r, input.ChallengeToken)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var username string
+	err = Mdb.DB.QueryRowContext(ctx, "SELECT username FROM users WHERE uid = $1", uid).Scan(&username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "user not found")
+			return
+		}
+		log.Printf("EnrollTwoFactor: failed to fetch user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to start enrollment")
+		return
+	}
+
+	secret, uri, err := AuthService.BeginTOTPEnrollment(ctx, uid, username)
+	if err != nil {
+		if errors.Is(err, AuthService.ErrTwoFactorEnabled) {
+			Utils.SendErrorResponse(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("EnrollTwoFactor: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to start enrollment")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// ConfirmTwoFactor finishes TOTP enrollment with a code from the authenticator app
+// When enrolling through a login challenge, the login is completed as well
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	input, err := readTwoFactorRequest(r)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if input.Code == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	uid, viaChallenge, ok := enrollmentSubject(r, input.ChallengeToken)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Codes are guessed against the same per user limit as the login second step
+	codeLimit := limit{Ratelimit.LoginTwoFactor, uid}
+	if wait := attemptLimits(ctx, codeLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed two-factor attempts, try again later")
+		return
+	}
+
+	codes, err := AuthService.ConfirmTOTPEnrollment(ctx, uid, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrInvalidTwoFactorCode):
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid two-factor code")
+		case errors.Is(err, AuthService.ErrNoPendingEnrollment):
+			forgiveLimits(ctx, codeLimit)
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "no pending two-factor enrollment")
+		case errors.Is(err, AuthService.ErrTwoFactorEnabled):
+			forgiveLimits(ctx, codeLimit)
+			Utils.SendErrorResponse(w, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			forgiveLimits(ctx, codeLimit)
+			log.Printf("ConfirmTwoFactor: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to enable two-factor authentication")
+		}
+		return
+	}
+	resetLimits(ctx, codeLimit)
+
+	if viaChallenge {
+		sendLoginResponse(ctx, w, r, uid, map[string]interface{}{"recovery_codes": codes})
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the authenticated user
+// A current TOTP code (or an unused recovery code) is required
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	input, err := readTwoFactorRequest(r)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if input.Code == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "code is required")
+		return
+	}
+
+	codeLimit := limit{Ratelimit.LoginTwoFactor, claims.UID}
+	if wait := attemptLimits(ctx, codeLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed two-factor attempts, try again later")
+		return
+	}
+
+	if err := AuthService.VerifySecondFactor(ctx, claims.UID, input.Code); err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrInvalidTwoFactorCode):
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid two-factor code")
+		case errors.Is(err, AuthService.ErrTwoFactorNotEnabled):
+			forgiveLimits(ctx, codeLimit)
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		default:
+			forgiveLimits(ctx, codeLimit)
+			log.Printf("RegenerateRecoveryCodes: failed to verify code: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to regenerate recovery codes")
+		}
+		return
+	}
+	resetLimits(ctx, codeLimit)
+
+	codes, err := AuthService.RegenerateRecoveryCodes(ctx, claims.UID)
+	if err != nil {
+		log.Printf("RegenerateRecoveryCodes: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to regenerate recovery codes")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableTwoFactor turns off two-factor authentication for the authenticated user
+// Both the password and a current code are required; staff cannot disable it
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := AuthService.PrincipalFrom(r)
+
+	input, err := readTwoFactorRequest(r)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if input.Password == "" || input.Code == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "password and code are required")
+		return
+	}
+
+	var passwordHash string
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT COALESCE(password_hash, '') FROM users WHERE uid = $1",
+		claims.UID,
+	).Scan(&passwordHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "user not found")
+			return
+		}
+		log.Printf("DisableTwoFactor: failed to fetch user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		return
+	}
+
+	staff, err := AuthService.IsStaff(ctx, claims.UID)
+	if err != nil {
+		log.Printf("DisableTwoFactor: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		return
+	}
+	if staff {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "two-factor authentication is required for staff accounts")
+		return
+	}
+
+	// A wrong password or code counts as a failed second factor attempt
+	codeLimit := limit{Ratelimit.LoginTwoFactor, claims.UID}
+	if wait := attemptLimits(ctx, codeLimit); wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "too many failed two-factor attempts, try again later")
+		return
+	}
+	if !AuthService.CheckPasswordHash(input.Password, passwordHash) {
+		Utils.SendErrorResponse(w, http.StatusUnauthorized, "password is incorrect")
+		return
+	}
+
+	if err := AuthService.VerifySecondFactor(ctx, claims.UID, input.Code); err != nil {
+		switch {
+		case errors.Is(err, AuthService.ErrInvalidTwoFactorCode):
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "invalid two-factor code")
+		case errors.Is(err, AuthService.ErrTwoFactorNotEnabled):
+			forgiveLimits(ctx, codeLimit)
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		default:
+			forgiveLimits(ctx, codeLimit)
+			log.Printf("DisableTwoFactor: failed to verify code: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		}
+		return
+	}
+	resetLimits(ctx, codeLimit)
+
+	if err := AuthService.DisableTOTP(ctx, claims.UID); err != nil {
+		log.Printf("DisableTwoFactor: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "failed to disable two-factor authentication")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,803 @@
+package messages
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
+	Utils "hifi/Utils"
+)
+
+// Who may message a user (users.messages_from)
+const (
+	AllowFromFollowers = "followers" // Users who follow them
+	AllowFromMutual    = "mutual"    // Users who follow them and whom they follow
+)
+
+// MaxMessageLength is the most characters a message can have
+const MaxMessageLength = 2000
+
+var (
+	errConversationNotFound = errors.New("conversation not found")
+	errCannotMessage        = errors.New("cannot message user")
+)
+
+// Handle sets up the routes for direct message endpoints (mounted behind RequireAuth)
+func Handle(req chi.Router) {
+	read := req.With(Auth.RequireScope(Auth.ScopeMessagesRead))
+	write := req.With(Auth.RequireScope(Auth.ScopeMessagesWrite))
+	read.Get("/conversations", ListConversations)
+	write.Post("/conversations", StartConversation)
+	read.Get("/conversations/{conversationID}/messages", ListMessages)
+	write.Post("/conversations/{conversationID}/messages", SendMessage)
+	write.Post("/conversations/{conversationID}/read", MarkRead)
+	write.Delete("/conversations/{conversationID}/messages/{messageID}", DeleteMessage)
+	read.Get("/settings", GetSettings)
+	write.Put("/settings", UpdateSettings)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// canMessage reports whether the sender may message the recipient
+// Neither may have blocked the other, and the sender must follow the recipient (and be followed
+// back when the recipient only accepts mutual follows), unless the recipient already messaged
+// the sender in the conversation (0 for a new one)
+func canMessage(ctx context.Context, db queryRower, senderUID, recipientUID string, conversationID int) (bool, error) {
+	var allowed bool
+	err := db.QueryRowContext(ctx,
+		`SELECT NOT EXISTS (
+				SELECT 1 FROM blocklists
+				WHERE (blocked_by = $1 AND blocked_to = $2) OR (blocked_by = $2 AND blocked_to = $1)
+			)
+			AND (
+				EXISTS (SELECT 1 FROM messages WHERE conversation_id = $3 AND sender_uid = $2)
+				OR (
+					EXISTS (SELECT 1 FROM followers WHERE followed_by = $1 AND followed_to = $2)
+					AND (u.messages_from = 'followers'
+						OR EXISTS (SELECT 1 FROM followers WHERE followed_by = $2 AND followed_to = $1))
+				)
+			)
+		FROM users u WHERE u.uid = $2`,
+		senderUID, recipientUID, conversationID,
+	).Scan(&allowed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil // The recipient deleted their account
+		}
+		return false, fmt.Errorf("failed to check messaging permission: %w", err)
+	}
+	return allowed, nil
+}
+
+// lockConversation locks a conversation of the user and returns the other participant
+// Messages of a conversation are sent one at a time, so message IDs become visible in order
+// and a read position never skips a message committed later
+func lockConversation(ctx context.Context, tx *sql.Tx, uid string, conversationID int) (string, error) {
+	var userA, userB string
+	err := tx.QueryRowContext(ctx,
+		"SELECT user_a, user_b FROM conversations WHERE id = $1 AND $2 IN (user_a, user_b) FOR UPDATE",
+		conversationID, uid,
+	).Scan(&userA, &userB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errConversationNotFound
+		}
+		return "", fmt.Errorf("failed to lock conversation: %w", err)
+	}
+	if userA == uid {
+		return userB, nil
+	}
+	return userA, nil
+}
+
+// memberState returns the read positions of the user and the other participant of a conversation
+func memberState(ctx context.Context, uid string, conversationID int) (own, other int64, otherUID string, err error) {
+	err = Mdb.DB.QueryRowContext(ctx,
+		`SELECT me.last_read_message_id, other.last_read_message_id, other.user_uid
+		FROM conversation_members me
+		JOIN conversation_members other ON other.conversation_id = me.conversation_id AND other.user_uid <> me.user_uid
+		WHERE me.conversation_id = $1 AND me.user_uid = $2`,
+		conversationID, uid,
+	).Scan(&own, &other, &otherUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, "", errConversationNotFound
+		}
+		return 0, 0, "", fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+	return own, other, otherUID, nil
+}
+
+// queryConversations returns the user's conversations, most recently active first, and their total
+// conversationID limits the result to one conversation (0 for all)
+func queryConversations(ctx context.Context, uid string, conversationID, limit, offset int) ([]Conversations, int, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT c.id, u.uid, u.username, u.profile_picture, other.last_read_message_id, c.created_at, c.last_message_at,
+			lm.id, lm.sender_uid, lm.body, lm.sent_at,
+			(SELECT COUNT(*) FROM messages m
+			WHERE m.conversation_id = c.id AND m.sender_uid <> $1 AND m.id > me.last_read_message_id
+				AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_uid = $1)
+			) as unread_count,
+			COUNT(*) OVER() as total_count
+		FROM conversation_members me
+		JOIN conversations c ON c.id = me.conversation_id
+		JOIN conversation_members other ON other.conversation_id = c.id AND other.user_uid <> me.user_uid
+		JOIN users u ON u.uid = other.user_uid
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.sender_uid, m.body, m.sent_at FROM messages m
+			WHERE m.conversation_id = c.id
+				AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_uid = $1)
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON TRUE
+		WHERE me.user_uid = $1 AND ($4 = 0 OR c.id = $4)
+		ORDER BY COALESCE(c.last_message_at, c.created_at) DESC, c.id DESC
+		LIMIT $2 OFFSET $3`,
+		uid, limit, offset, conversationID,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []Conversations{}
+	var count int
+	for rows.Next() {
+		var c Conversations
+		var lastID sql.NullInt64
+		var lastSender, lastBody sql.NullString
+		var lastSentAt sql.NullTime
+		if err := rows.Scan(
+			&c.ID, &c.OtherUID, &c.OtherUsername, &c.OtherPicture, &c.OtherLastReadMessage, &c.CreatedAt, &c.LastMessageAt,
+			&lastID, &lastSender, &lastBody, &lastSentAt,
+			&c.UnreadCount,
+			&count, // total_count from window function
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		if lastID.Valid {
+			c.LastMessage = &Messages{
+				ID:             lastID.Int64,
+				ConversationID: c.ID,
+				SenderUID:      lastSender.String,
+				Body:           lastBody.String,
+				SentAt:         lastSentAt.Time,
+				Read:           lastSender.String == uid && lastID.Int64 <= c.OtherLastReadMessage,
+			}
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate conversations: %w", err)
+	}
+	return conversations, count, nil
+}
+
+// conversationParam parses the conversationID URL parameter
+func conversationParam(r *http.Request) (int, bool) {
+	conversationID, err := strconv.Atoi(chi.URLParam(r, "conversationID"))
+	return conversationID, err == nil && conversationID > 0
+}
+
+// ListConversations lists the authenticated user's conversations, most recently active first
+// Query params: ?limit=20&offset=0
+func ListConversations(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	// Get pagination parameters from query string
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+			if limit > 100 {
+				limit = 100
+			}
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	conversations, count, err := queryConversations(r.Context(), claims.UID, 0, limit, offset)
+	if err != nil {
+		log.Printf("ListConversations: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch conversations")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"conversations": conversations,
+		"limit":         limit,
+		"offset":        offset,
+		"count":         count,
+	})
+}
+
+// StartConversation returns the authenticated user's conversation with a user, creating it if needed
+// Body: {"username": "alice"}
+func StartConversation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("StartConversation: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	if input.Username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	var recipientUID string
+	err = Mdb.DB.QueryRowContext(ctx, "SELECT uid FROM users WHERE username = $1", input.Username).Scan(&recipientUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("StartConversation: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+	if recipientUID == claims.UID {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You cannot message yourself")
+		return
+	}
+
+	// A pair of users has one conversation, stored with the smaller UID first
+	userA, userB := claims.UID, recipientUID
+	if userB < userA {
+		userA, userB = userB, userA
+	}
+
+	var conversationID int
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT id FROM conversations WHERE user_a = $1 AND user_b = $2",
+		userA, userB,
+	).Scan(&conversationID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("StartConversation: failed to fetch conversation: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+		return
+	}
+
+	allowed, err := canMessage(ctx, Mdb.DB, claims.UID, recipientUID, conversationID)
+	if err != nil {
+		log.Printf("StartConversation: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+		return
+	}
+	if !allowed {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot message this user")
+		return
+	}
+
+	created := false
+	if conversationID == 0 {
+		tx, err := Mdb.DB.BeginTx(ctx, nil)
+		if err != nil {
+			log.Printf("StartConversation: failed to begin transaction: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+			return
+		}
+		defer tx.Rollback()
+
+		// A concurrent start of the same conversation inserts nothing; the existing one is used
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO conversations (user_a, user_b, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_a, user_b) DO NOTHING
+			RETURNING id`,
+			userA, userB, time.Now(),
+		).Scan(&conversationID)
+		switch {
+		case err == nil:
+			created = true
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO conversation_members (conversation_id, user_uid) VALUES ($1, $2), ($1, $3)",
+				conversationID, userA, userB,
+			); err != nil {
+				log.Printf("StartConversation: failed to insert members: %v", err)
+				Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+				return
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			err = tx.QueryRowContext(ctx,
+				"SELECT id FROM conversations WHERE user_a = $1 AND user_b = $2",
+				userA, userB,
+			).Scan(&conversationID)
+			if err != nil {
+				log.Printf("StartConversation: failed to fetch conversation: %v", err)
+				Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+				return
+			}
+		default:
+			log.Printf("StartConversation: failed to insert conversation: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("StartConversation: failed to commit: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to start conversation")
+			return
+		}
+	}
+
+	conversations, _, err := queryConversations(ctx, claims.UID, conversationID, 1, 0)
+	if err != nil || len(conversations) == 0 {
+		log.Printf("StartConversation: failed to load conversation %d: %v", conversationID, err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch conversation")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"conversation": conversations[0],
+		"created":      created,
+	})
+}
+
+// ListMessages lists the messages of a conversation, newest first, with cursor pagination
+// Query params: ?limit=50&before=<message_id> (next_cursor of the previous page)
+// Messages the caller deleted are left out
+func ListMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	conversationID, ok := conversationParam(r)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+		if limit > 100 {
+			limit = 100
+		}
+	}
+
+	var before int64
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		b, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || b <= 0 {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		before = b
+	}
+
+	_, otherLastRead, _, err := memberState(ctx, claims.UID, conversationID)
+	if err != nil {
+		if errors.Is(err, errConversationNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		} else {
+			log.Printf("ListMessages: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch conversation")
+		}
+		return
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT m.id, m.conversation_id, m.sender_uid, m.body, m.sent_at
+		FROM messages m
+		WHERE m.conversation_id = $1 AND ($2::bigint = 0 OR m.id < $2)
+			AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_uid = $3)
+		ORDER BY m.id DESC
+		LIMIT $4`,
+		conversationID, before, claims.UID, limit+1,
+	)
+	if err != nil {
+		log.Printf("ListMessages: failed to query messages: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch messages")
+		return
+	}
+	defer rows.Close()
+
+	messages := []Messages{}
+	for rows.Next() {
+		var m Messages
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderUID, &m.Body, &m.SentAt); err != nil {
+			log.Printf("ListMessages: failed to scan message: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode message")
+			return
+		}
+		m.Read = m.SenderUID == claims.UID && m.ID <= otherLastRead
+		messages = append(messages, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("ListMessages: failed to iterate messages: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate messages")
+		return
+	}
+
+	var nextCursor *int64
+	if len(messages) > limit {
+		messages = messages[:limit]
+		nextCursor = &messages[limit-1].ID
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"messages":                   messages,
+		"limit":                      limit,
+		"next_cursor":                nextCursor,
+		"other_last_read_message_id": otherLastRead,
+	})
+}
+
+// SendMessage sends a message in a conversation
+// Body: {"body": "hi"}; the messaging rules are checked on every message (see canMessage)
+func SendMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	conversationID, ok := conversationParam(r)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("SendMessage: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	text := strings.TrimSpace(input.Body)
+	if text == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Message body is required")
+		return
+	}
+	if utf8.RuneCountInString(text) > MaxMessageLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Message must be at most %d characters", MaxMessageLength))
+		return
+	}
+
+	message, err := sendMessage(ctx, claims.UID, conversationID, text)
+	if err != nil {
+		switch {
+		case errors.Is(err, errConversationNotFound):
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		case errors.Is(err, errCannotMessage):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot message this user")
+		default:
+			log.Printf("SendMessage: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to send message")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":         "Message sent",
+		"message_id":      message.ID,
+		"conversation_id": message.ConversationID,
+		"sent_at":         message.SentAt,
+	})
+}
+
+// sendMessage stores a message and pushes it to both participants' streams
+// The sender has read the conversation up to their own message
+func sendMessage(ctx context.Context, uid string, conversationID int, text string) (Messages, error) {
+	message := Messages{ConversationID: conversationID, SenderUID: uid, Body: text}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return message, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	recipientUID, err := lockConversation(ctx, tx, uid, conversationID)
+	if err != nil {
+		return message, err
+	}
+
+	allowed, err := canMessage(ctx, tx, uid, recipientUID, conversationID)
+	if err != nil {
+		return message, err
+	}
+	if !allowed {
+		return message, errCannotMessage
+	}
+
+	now := time.Now()
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO messages (conversation_id, sender_uid, body, sent_at) VALUES ($1, $2, $3, $4) RETURNING id, sent_at",
+		conversationID, uid, text, now,
+	).Scan(&message.ID, &message.SentAt)
+	if err != nil {
+		return message, fmt.Errorf("failed to insert message: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE conversations SET last_message_at = $1 WHERE id = $2", now, conversationID); err != nil {
+		return message, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE conversation_members SET last_read_message_id = $1, read_at = $2 WHERE conversation_id = $3 AND user_uid = $4",
+		message.ID, now, conversationID, uid,
+	); err != nil {
+		return message, fmt.Errorf("failed to update read position: %w", err)
+	}
+
+	// Delivered to the recipient and the sender's other devices once the transaction commits
+	for _, topicUID := range []string{recipientUID, uid} {
+		if err := Realtime.Publish(ctx, tx, Realtime.UserTopic(topicUID), "message", uid, message); err != nil {
+			return message, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return message, fmt.Errorf("failed to commit message: %w", err)
+	}
+	return message, nil
+}
+
+// MarkRead marks a conversation as read up to a message, or up to its latest message
+// Body (optional): {"message_id": 123}; a read position never moves back
+func MarkRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	conversationID, ok := conversationParam(r)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("MarkRead: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+			return
+		}
+	}
+
+	lastRead, _, otherUID, err := memberState(ctx, claims.UID, conversationID)
+	if err != nil {
+		if errors.Is(err, errConversationNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		} else {
+			log.Printf("MarkRead: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch conversation")
+		}
+		return
+	}
+
+	var latest int64
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = $1",
+		conversationID,
+	).Scan(&latest)
+	if err != nil {
+		log.Printf("MarkRead: failed to fetch latest message: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mark conversation as read")
+		return
+	}
+
+	target := latest
+	if input.MessageID > 0 && input.MessageID < latest {
+		target = input.MessageID
+	}
+
+	// Only moves forward, so concurrent reads from several devices keep the furthest position
+	result, err := Mdb.DB.ExecContext(ctx,
+		`UPDATE conversation_members SET last_read_message_id = $1, read_at = $2
+		WHERE conversation_id = $3 AND user_uid = $4 AND last_read_message_id < $1`,
+		target, time.Now(), conversationID, claims.UID,
+	)
+	if err != nil {
+		log.Printf("MarkRead: failed to update read position: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mark conversation as read")
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		lastRead = target
+
+		// Read receipt for the other participant, and the new position for the reader's other devices
+		receipt := map[string]interface{}{
+			"conversation_id":      conversationID,
+			"user_uid":             claims.UID,
+			"last_read_message_id": target,
+		}
+		for _, topicUID := range []string{otherUID, claims.UID} {
+			if err := Realtime.Publish(ctx, Mdb.DB, Realtime.UserTopic(topicUID), "read", claims.UID, receipt); err != nil {
+				log.Printf("MarkRead: %v", err)
+			}
+		}
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":              "Conversation marked as read",
+		"last_read_message_id": lastRead,
+	})
+}
+
+// DeleteMessage deletes a message for the authenticated user only; the other participant still sees it
+// A message deleted by both participants is removed
+func DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	conversationID, ok := conversationParam(r)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageID"), 10, 64)
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid message ID")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("DeleteMessage: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the message, so two participants deleting it at once cannot both miss the other's deletion
+	var locked int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT m.id FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1 AND m.conversation_id = $2 AND $3 IN (c.user_a, c.user_b)
+			AND NOT EXISTS (SELECT 1 FROM message_deletions d WHERE d.message_id = m.id AND d.user_uid = $3)
+		FOR UPDATE OF m`,
+		messageID, conversationID, claims.UID,
+	).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Message not found")
+		} else {
+			log.Printf("DeleteMessage: failed to lock message: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete message")
+		}
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO message_deletions (message_id, user_uid, deleted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		messageID, claims.UID, time.Now(),
+	); err != nil {
+		log.Printf("DeleteMessage: failed to insert deletion: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM messages WHERE id = $1 AND (SELECT COUNT(*) FROM message_deletions WHERE message_id = $1) >= 2",
+		messageID,
+	); err != nil {
+		log.Printf("DeleteMessage: failed to remove message: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("DeleteMessage: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete message")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":    "Message deleted",
+		"message_id": messageID,
+	})
+}
+
+// GetSettings returns who may message the authenticated user
+func GetSettings(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	var allowFrom string
+	err := Mdb.DB.QueryRowContext(r.Context(), "SELECT messages_from FROM users WHERE uid = $1", claims.UID).Scan(&allowFrom)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("GetSettings: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch message settings")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"allow_from": allowFrom})
+}
+
+// UpdateSettings sets who may message the authenticated user
+// Body: {"allow_from": "mutual"}; existing conversations are checked against it from the next message
+func UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("UpdateSettings: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		AllowFrom string `json:"allow_from"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	allowFrom := strings.ToLower(strings.TrimSpace(input.AllowFrom))
+	if allowFrom != AllowFromFollowers && allowFrom != AllowFromMutual {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Allow from must be followers or mutual")
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(r.Context(),
+		"UPDATE users SET messages_from = $1, updated_at = $2 WHERE uid = $3",
+		allowFrom, time.Now(), claims.UID,
+	)
+	if err != nil {
+		log.Printf("UpdateSettings: failed to update user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update message settings")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"allow_from": allowFrom})
+}
@@ -0,0 +1,128 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	Social "hifi/Events/Social"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// Messaging depends on follows and blocks, so the tests drive the social endpoints as well.
+// They run against the Postgres database of Utils/Testdb and are skipped when HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Route("/users", Social.HandleUsers)
+		r.Route("/messages", Handle)
+	})
+}
+
+func TestDirectMessages(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	alice, bob := users[0], users[1]
+	router := testRouter()
+
+	start := `{"username": "` + bob.Username + `"}`
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/messages/conversations", start); code != http.StatusForbidden {
+		t.Fatalf("start without following: status %d, want %d", code, http.StatusForbidden)
+	}
+	Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow/"+bob.Username, "")
+
+	var started struct {
+		Conversation Conversations `json:"conversation"`
+	}
+	if code := Testdb.RequestData(t, router, alice.UID, http.MethodPost, "/messages/conversations", start, &started); code != http.StatusOK {
+		t.Fatalf("start: status %d", code)
+	}
+	messagesPath := fmt.Sprintf("/messages/conversations/%d/messages", started.Conversation.ID)
+
+	// Mutual only: alice needs bob to follow her back
+	Testdb.Request(t, router, bob.UID, http.MethodPut, "/messages/settings", `{"allow_from": "mutual"}`)
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, messagesPath, `{"body": "hi"}`); code != http.StatusForbidden {
+		t.Fatalf("send to mutual only: status %d, want %d", code, http.StatusForbidden)
+	}
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Testdb.Request(t, router, alice.UID, http.MethodPost, messagesPath, fmt.Sprintf(`{"body": "message %d"}`, i))
+		}(i)
+	}
+	wg.Wait()
+
+	// Cursor pagination returns every message once, newest first
+	var ids []int64
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		var resp struct {
+			Messages   []Messages `json:"messages"`
+			NextCursor *int64     `json:"next_cursor"`
+		}
+		if code := Testdb.RequestData(t, router, bob.UID, http.MethodGet, messagesPath+"?limit=2"+cursor, "", &resp); code != http.StatusOK {
+			t.Fatalf("list messages: status %d", code)
+		}
+		for _, m := range resp.Messages {
+			if len(ids) > 0 && m.ID >= ids[len(ids)-1] {
+				t.Errorf("message %d after %d", m.ID, ids[len(ids)-1])
+			}
+			ids = append(ids, m.ID)
+		}
+		if resp.NextCursor == nil {
+			break
+		}
+		cursor = fmt.Sprintf("&before=%d", *resp.NextCursor)
+	}
+	if len(ids) != 5 {
+		t.Fatalf("paged through %d messages, want 5", len(ids))
+	}
+
+	// Read receipts
+	Testdb.Request(t, router, bob.UID, http.MethodPost, fmt.Sprintf("/messages/conversations/%d/read", started.Conversation.ID), "")
+	var listed struct {
+		Messages []Messages `json:"messages"`
+	}
+	Testdb.RequestData(t, router, alice.UID, http.MethodGet, messagesPath, "", &listed)
+	for _, m := range listed.Messages {
+		if !m.Read {
+			t.Errorf("message %d not read after bob read the conversation", m.ID)
+		}
+	}
+
+	// Delete for me hides a message from its deleter only; deleted by both, it is removed
+	deletePath := fmt.Sprintf("%s/%d", messagesPath, ids[0])
+	Testdb.Request(t, router, alice.UID, http.MethodDelete, deletePath, "")
+	Testdb.RequestData(t, router, alice.UID, http.MethodGet, messagesPath, "", &listed)
+	if len(listed.Messages) != 4 {
+		t.Errorf("alice sees %d messages after deleting one, want 4", len(listed.Messages))
+	}
+	Testdb.RequestData(t, router, bob.UID, http.MethodGet, messagesPath, "", &listed)
+	if len(listed.Messages) != 5 {
+		t.Errorf("bob sees %d messages, want 5", len(listed.Messages))
+	}
+	Testdb.Request(t, router, bob.UID, http.MethodDelete, deletePath, "")
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM messages WHERE id = $1", ids[0]); got != 0 {
+		t.Errorf("message deleted by both still stored")
+	}
+
+	// Blocks stop messages in both directions
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/block/"+alice.Username, "")
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, messagesPath, `{"body": "still there?"}`); code != http.StatusForbidden {
+		t.Errorf("send after block: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, messagesPath, `{"body": "bye"}`); code != http.StatusForbidden {
+		t.Errorf("send to blocked user: status %d, want %d", code, http.StatusForbidden)
+	}
+}
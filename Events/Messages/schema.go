@@ -0,0 +1,24 @@
+package messages
+
+import "time"
+
+type Conversations struct {
+	ID                   int        `db:"id" json:"id"`
+	OtherUID             string     `db:"other_uid" json:"other_uid"`                                   // The other participant
+	OtherUsername        string     `db:"other_username" json:"other_username"`                         // Joined from users (for responses)
+	OtherPicture         *string    `db:"other_profile_picture" json:"other_profile_picture"`           // Joined from users (for responses)
+	OtherLastReadMessage int64      `db:"other_last_read_message_id" json:"other_last_read_message_id"` // Messages up to this ID were read by the other participant
+	LastMessage          *Messages  `db:"-" json:"last_message"`                                        // Latest message not deleted by the caller (nil if none)
+	UnreadCount          int        `db:"-" json:"unread_count"`                                        // Messages from the other participant the caller has not read
+	CreatedAt            time.Time  `db:"created_at" json:"created_at"`
+	LastMessageAt        *time.Time `db:"last_message_at" json:"last_message_at"` // nil until the first message
+}
+
+type Messages struct {
+	ID             int64     `db:"id" json:"id"`
+	ConversationID int       `db:"conversation_id" json:"conversation_id"`
+	SenderUID      string    `db:"sender_uid" json:"sender_uid"`
+	Body           string    `db:"body" json:"body"`
+	SentAt         time.Time `db:"sent_at" json:"sent_at"`
+	Read           bool      `db:"-" json:"read"` // Whether the recipient has read it
+}
@@ -0,0 +1,512 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
+	Utils "hifi/Utils"
+)
+
+// Notification types
+const (
+	TypeFollow  = "follow"  // Someone followed you
+	TypeComment = "comment" // Someone commented on your video
+	TypeReply   = "reply"   // Someone replied to your comment
+	TypeUpvote  = "upvote"  // Someone upvoted your video
+	TypeMention = "mention" // Someone mentioned you in a comment, reply or video description
+)
+
+// Types lists every notification type, in the order preferences are reported
+var Types = []string{TypeFollow, TypeComment, TypeReply, TypeUpvote, TypeMention}
+
+// GroupWindow is how long an unread notification keeps aggregating the same kind of event
+const GroupWindow = 24 * time.Hour
+
+// Event is something a user did that may notify another user
+// The IDs say what it is about; events of the same type about the same IDs are aggregated
+type Event struct {
+	Type      string
+	UserUID   string // User to notify
+	ActorUID  string // User who acted
+	VideoID   string // Optional
+	CommentID string // Optional
+	ReplyID   string // Optional
+}
+
+// Handle sets up the routes for notification endpoints (mounted behind RequireAuth)
+func Handle(req chi.Router) {
+	read := req.With(Auth.RequireScope(Auth.ScopeSocialRead))
+	write := req.With(Auth.RequireScope(Auth.ScopeSocialWrite))
+	read.Get("/", ListNotifications)
+	read.Get("/unread-count", UnreadCount)
+	write.Post("/{notificationID}/read", MarkRead)
+	write.Post("/read-all", MarkAllRead)
+	read.Get("/preferences", GetPreferences)
+	write.Put("/preferences", UpdatePreferences)
+}
+
+// Notify records an event for the user it is about, in the caller's transaction
+// Nothing is recorded for the actor's own content, when the user switched the type off,
+// when either user blocked the other or when the user muted the actor
+// An unread notification of the same type about the same IDs created within GroupWindow
+// is aggregated instead, counting each actor once
+func Notify(ctx context.Context, tx *sql.Tx, event Event) error {
+	if event.UserUID == event.ActorUID {
+		return nil
+	}
+
+	var wanted bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT NOT EXISTS (SELECT 1 FROM notification_preferences WHERE user_uid = $1 AND type = $2 AND NOT enabled)
+			AND NOT EXISTS (
+				SELECT 1 FROM blocklists
+				WHERE (blocked_by = $1 AND blocked_to = $3) OR (blocked_by = $3 AND blocked_to = $1)
+			)
+			AND NOT EXISTS (SELECT 1 FROM mutes WHERE muted_by = $1 AND muted_to = $3)`,
+		event.UserUID, event.Type, event.ActorUID,
+	).Scan(&wanted)
+	if err != nil {
+		return fmt.Errorf("failed to check notification preferences: %w", err)
+	}
+	if !wanted {
+		return nil
+	}
+
+	// Serialize the events aggregated into one notification, so concurrent ones cannot
+	// both start a new notification
+	key := event.UserUID + ":" + event.Type + ":" + event.VideoID + ":" + event.CommentID + ":" + event.ReplyID
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('notify:' || $1))", key); err != nil {
+		return fmt.Errorf("failed to lock notification: %w", err)
+	}
+
+	now := time.Now()
+	var notificationID int
+	err = tx.QueryRowContext(ctx,
+		`SELECT id FROM notifications
+		WHERE user_uid = $1 AND type = $2
+			AND video_id IS NOT DISTINCT FROM NULLIF($3, '')
+			AND comment_id IS NOT DISTINCT FROM NULLIF($4, '')
+			AND reply_id IS NOT DISTINCT FROM NULLIF($5, '')
+			AND read_at IS NULL AND created_at > $6
+		ORDER BY created_at DESC
+		LIMIT 1`,
+		event.UserUID, event.Type, event.VideoID, event.CommentID, event.ReplyID, now.Add(-GroupWindow),
+	).Scan(&notificationID)
+	switch {
+	case err == nil:
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO notification_actors (notification_id, actor_uid, acted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			notificationID, event.ActorUID, now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert notification actor: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return nil // The actor is already counted
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE notifications SET actor_uid = $1, actor_count = actor_count + 1, updated_at = $2 WHERE id = $3",
+			event.ActorUID, now, notificationID,
+		); err != nil {
+			return fmt.Errorf("failed to update notification: %w", err)
+		}
+		return publish(ctx, tx, notificationID)
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("failed to find notification: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO notifications (user_uid, type, actor_uid, actor_count, video_id, comment_id, reply_id, created_at, updated_at)
+		VALUES ($1, $2, $3, 1, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $7)
+		RETURNING id`,
+		event.UserUID, event.Type, event.ActorUID, event.VideoID, event.CommentID, event.ReplyID, now,
+	).Scan(&notificationID)
+	if err != nil {
+		return fmt.Errorf("failed to insert notification: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO notification_actors (notification_id, actor_uid, acted_at) VALUES ($1, $2, $3)",
+		notificationID, event.ActorUID, now,
+	); err != nil {
+		return fmt.Errorf("failed to insert notification actor: %w", err)
+	}
+	return publish(ctx, tx, notificationID)
+}
+
+// publish pushes a new or updated notification and the unread count to the user's streams
+// It is delivered when the caller's transaction commits
+func publish(ctx context.Context, tx *sql.Tx, notificationID int) error {
+	var n Notifications
+	var unread int
+	err := tx.QueryRowContext(ctx,
+		`SELECT n.id, n.user_uid, n.type, n.actor_uid, u.username, u.profile_picture, n.actor_count,
+			n.video_id, n.comment_id, n.reply_id, n.created_at, n.updated_at, n.read_at,
+			(SELECT COUNT(*) FROM notifications WHERE user_uid = n.user_uid AND read_at IS NULL)
+		FROM notifications n
+		LEFT JOIN users u ON u.uid = n.actor_uid
+		WHERE n.id = $1`,
+		notificationID,
+	).Scan(
+		&n.ID, &n.UserUID, &n.Type, &n.ActorUID, &n.ActorUsername, &n.ActorPicture, &n.ActorCount,
+		&n.VideoID, &n.CommentID, &n.ReplyID, &n.CreatedAt, &n.UpdatedAt, &n.ReadAt,
+		&unread,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch notification: %w", err)
+	}
+	n.Message = message(n)
+
+	return Realtime.Publish(ctx, tx, Realtime.UserTopic(n.UserUID), "notification", "", map[string]interface{}{
+		"notification": n,
+		"unread_count": unread,
+	})
+}
+
+// publishUnreadCount pushes the unread count to the user's streams, so other devices update their badge
+// Failures are only logged: the notifications were already marked
+func publishUnreadCount(ctx context.Context, uid string, unread int) {
+	err := Realtime.Publish(ctx, Mdb.DB, Realtime.UserTopic(uid), "unread_count", "", map[string]int{"unread_count": unread})
+	if err != nil {
+		log.Printf("publishUnreadCount: %v", err)
+	}
+}
+
+// NotifyUsers records an event for each of the users, as Notify
+func NotifyUsers(ctx context.Context, tx *sql.Tx, event Event, uids []string) error {
+	for _, uid := range uids {
+		event.UserUID = uid
+		if err := Notify(ctx, tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// message describes a notification, e.g. "alice upvoted your video" or "12 people upvoted your video"
+func message(n Notifications) string {
+	var action string
+	switch n.Type {
+	case TypeFollow:
+		action = "followed you"
+	case TypeComment:
+		action = "commented on your video"
+	case TypeReply:
+		action = "replied to your comment"
+	case TypeUpvote:
+		action = "upvoted your video"
+	case TypeMention:
+		switch {
+		case n.ReplyID != nil:
+			action = "mentioned you in a reply"
+		case n.CommentID != nil:
+			action = "mentioned you in a comment"
+		default:
+			action = "mentioned you in a video description"
+		}
+	}
+
+	if n.ActorCount > 1 {
+		return fmt.Sprintf("%d people %s", n.ActorCount, action)
+	}
+	if n.ActorUsername != nil {
+		return *n.ActorUsername + " " + action
+	}
+	return "Someone " + action // The actor deleted their account
+}
+
+// unreadCount returns the number of unread notifications of a user
+func unreadCount(ctx context.Context, uid string) (int, error) {
+	var count int
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE user_uid = $1 AND read_at IS NULL",
+		uid,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// ListNotifications lists the authenticated user's notifications, most recently active first
+// Query params: ?limit=20&offset=0&unread=true (only unread ones)
+func ListNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	// Get pagination parameters from query string
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+			if limit > 100 {
+				limit = 100
+			}
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT n.id, n.user_uid, n.type, n.actor_uid, u.username, u.profile_picture, n.actor_count,
+			n.video_id, n.comment_id, n.reply_id, n.created_at, n.updated_at, n.read_at,
+			COUNT(*) OVER() as total_count
+		FROM notifications n
+		LEFT JOIN users u ON u.uid = n.actor_uid
+		WHERE n.user_uid = $1 AND (NOT $4 OR n.read_at IS NULL)
+		ORDER BY n.updated_at DESC, n.id DESC
+		LIMIT $2 OFFSET $3`,
+		claims.UID, limit, offset, unreadOnly,
+	)
+	if err != nil {
+		log.Printf("ListNotifications: failed to query notifications: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch notifications")
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notifications{}
+	var count int
+	for rows.Next() {
+		var n Notifications
+		if err := rows.Scan(
+			&n.ID, &n.UserUID, &n.Type, &n.ActorUID, &n.ActorUsername, &n.ActorPicture, &n.ActorCount,
+			&n.VideoID, &n.CommentID, &n.ReplyID, &n.CreatedAt, &n.UpdatedAt, &n.ReadAt,
+			&count, // total_count from window function
+		); err != nil {
+			log.Printf("ListNotifications: failed to scan notification: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode notification")
+			return
+		}
+		n.Message = message(n)
+		notifications = append(notifications, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("ListNotifications: failed to iterate notifications: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate notifications")
+		return
+	}
+
+	unread, err := unreadCount(ctx, claims.UID)
+	if err != nil {
+		log.Printf("ListNotifications: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to count notifications")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"notifications": notifications,
+		"limit":         limit,
+		"offset":        offset,
+		"count":         count,
+		"unread_count":  unread,
+	})
+}
+
+// UnreadCount returns the number of unread notifications of the authenticated user
+func UnreadCount(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	unread, err := unreadCount(r.Context(), claims.UID)
+	if err != nil {
+		log.Printf("UnreadCount: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to count notifications")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]int{"unread_count": unread})
+}
+
+// MarkRead marks one of the authenticated user's notifications as read
+// Marking a read notification again is a no-op
+func MarkRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	notificationID, err := strconv.Atoi(chi.URLParam(r, "notificationID"))
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"UPDATE notifications SET read_at = COALESCE(read_at, $1) WHERE id = $2 AND user_uid = $3",
+		time.Now(), notificationID, claims.UID,
+	)
+	if err != nil {
+		log.Printf("MarkRead: failed to update notification: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mark notification as read")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	unread, err := unreadCount(ctx, claims.UID)
+	if err != nil {
+		log.Printf("MarkRead: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to count notifications")
+		return
+	}
+	publishUnreadCount(ctx, claims.UID, unread)
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":      "Notification marked as read",
+		"unread_count": unread,
+	})
+}
+
+// MarkAllRead marks every notification of the authenticated user as read
+func MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	result, err := Mdb.DB.ExecContext(r.Context(),
+		"UPDATE notifications SET read_at = $1 WHERE user_uid = $2 AND read_at IS NULL",
+		time.Now(), claims.UID,
+	)
+	if err != nil {
+		log.Printf("MarkAllRead: failed to update notifications: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mark notifications as read")
+		return
+	}
+	marked, _ := result.RowsAffected()
+	if marked > 0 {
+		// Counted again rather than assumed 0: a notification may have arrived meanwhile
+		if unread, err := unreadCount(r.Context(), claims.UID); err != nil {
+			log.Printf("MarkAllRead: %v", err)
+		} else {
+			publishUnreadCount(r.Context(), claims.UID, unread)
+		}
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Notifications marked as read",
+		"marked":  marked,
+	})
+}
+
+// preferences returns whether each notification type is on for a user
+func preferences(ctx context.Context, uid string) (map[string]bool, error) {
+	prefs := make(map[string]bool, len(Types))
+	for _, t := range Types {
+		prefs[t] = true
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		"SELECT type, enabled FROM notification_preferences WHERE user_uid = $1",
+		uid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		var enabled bool
+		if err := rows.Scan(&t, &enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan notification preference: %w", err)
+		}
+		prefs[t] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate notification preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+// GetPreferences returns which notification types the authenticated user receives
+func GetPreferences(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	prefs, err := preferences(r.Context(), claims.UID)
+	if err != nil {
+		log.Printf("GetPreferences: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch notification preferences")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, prefs)
+}
+
+// UpdatePreferences switches notification types on or off for the authenticated user
+// Body: {"upvote": false, "mention": true}; types left out are unchanged
+func UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("UpdatePreferences: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input map[string]bool
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+
+	// Validate every type before changing any
+	for t := range input {
+		known := false
+		for _, k := range Types {
+			if t == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Unknown notification type: "+t)
+			return
+		}
+	}
+
+	now := time.Now()
+	for t, enabled := range input {
+		if _, err := Mdb.DB.ExecContext(ctx,
+			`INSERT INTO notification_preferences (user_uid, type, enabled, updated_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_uid, type) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = EXCLUDED.updated_at`,
+			claims.UID, t, enabled, now,
+		); err != nil {
+			log.Printf("UpdatePreferences: failed to upsert preference: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update notification preferences")
+			return
+		}
+	}
+
+	prefs, err := preferences(ctx, claims.UID)
+	if err != nil {
+		log.Printf("UpdatePreferences: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch notification preferences")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, prefs)
+}
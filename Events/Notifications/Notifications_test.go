@@ -0,0 +1,107 @@
+package notifications_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	Notifications "hifi/Events/Notifications"
+	Social "hifi/Events/Social"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The notifications are created by the social endpoints, so the tests drive those (the test
+// package is external because Social imports Notifications). They run against the Postgres
+// database of Utils/Testdb and are skipped when HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Route("/users", Social.HandleUsers)
+		r.Post("/videos/upvote/{videoID}", Social.Upvote)
+		r.Post("/videos/comment/{videoID}", Social.Comment)
+		r.Route("/notifications", Notifications.Handle)
+	})
+}
+
+func TestNotifications(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 15)
+	owner, commenter, follower := users[0], users[1], users[2]
+	upvoters := users[3:]
+	videoID := Testdb.CreateVideo(t, owner)
+	router := testRouter()
+
+	// A burst of upvotes, some twice at once, is one notification counting each upvoter once
+	var wg sync.WaitGroup
+	for _, user := range upvoters {
+		for k := 0; k < 2; k++ {
+			wg.Add(1)
+			go func(user Testdb.User) {
+				defer wg.Done()
+				Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/upvote/"+videoID, "")
+			}(user)
+		}
+	}
+	wg.Wait()
+
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM notifications WHERE user_uid = $1 AND type = 'upvote'", owner.UID); got != 1 {
+		t.Fatalf("%d upvote notifications, want 1", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT actor_count FROM notifications WHERE user_uid = $1 AND type = 'upvote'", owner.UID); got != len(upvoters) {
+		t.Errorf("actor_count = %d, want %d", got, len(upvoters))
+	}
+
+	// Switched off types are not recorded; the mention in the comment still is
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPut, "/notifications/preferences", `{"comment": false}`); code != http.StatusOK {
+		t.Fatalf("preferences: status %d", code)
+	}
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPut, "/notifications/preferences", `{"likes": false}`); code != http.StatusBadRequest {
+		t.Errorf("unknown type: status %d, want %d", code, http.StatusBadRequest)
+	}
+	Testdb.Request(t, router, commenter.UID, http.MethodPost, "/videos/comment/"+videoID, fmt.Sprintf(`{"comment": "hey @%s"}`, owner.Username))
+	Testdb.Request(t, router, follower.UID, http.MethodPost, "/users/follow/"+owner.Username, "")
+	for typ, want := range map[string]int{"comment": 0, "mention": 1, "follow": 1} {
+		if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM notifications WHERE user_uid = $1 AND type = $2", owner.UID, typ); got != want {
+			t.Errorf("%d %s notifications, want %d", got, typ, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+	req.Header.Set("X-Test-UID", owner.UID)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp struct {
+		Data struct {
+			Notifications []Notifications.Notifications `json:"notifications"`
+			UnreadCount   int                           `json:"unread_count"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Notifications) != 3 {
+		t.Fatalf("list notifications: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Data.UnreadCount != 3 {
+		t.Errorf("unread_count = %d, want 3", resp.Data.UnreadCount)
+	}
+	want := fmt.Sprintf("%d people upvoted your video", len(upvoters))
+	if got := resp.Data.Notifications[2].Message; got != want {
+		t.Errorf("oldest notification %q, want %q", got, want)
+	}
+
+	// Once read, a notification stops aggregating
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPost, "/notifications/read-all", ""); code != http.StatusOK {
+		t.Fatalf("read-all: status %d", code)
+	}
+	Testdb.Request(t, router, commenter.UID, http.MethodPost, "/videos/upvote/"+videoID, "")
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM notifications WHERE user_uid = $1 AND read_at IS NULL", owner.UID); got != 1 {
+		t.Errorf("%d unread notifications, want 1", got)
+	}
+}
@@ -0,0 +1,20 @@
+package notifications
+
+import "time"
+
+type Notifications struct {
+	ID            int        `db:"id" json:"id"`
+	UserUID       string     `db:"user_uid" json:"-"`                                  // User who is notified
+	Type          string     `db:"type" json:"type"`                                   // follow, comment, reply, upvote or mention
+	ActorUID      *string    `db:"actor_uid" json:"actor_uid"`                         // Latest user who acted (nil if deleted)
+	ActorUsername *string    `db:"actor_username" json:"actor_username"`               // Joined from users (for responses)
+	ActorPicture  *string    `db:"actor_profile_picture" json:"actor_profile_picture"` // Joined from users (for responses)
+	ActorCount    int        `db:"actor_count" json:"actor_count"`                     // Distinct users who acted
+	VideoID       *string    `db:"video_id" json:"video_id"`
+	CommentID     *string    `db:"comment_id" json:"comment_id"`
+	ReplyID       *string    `db:"reply_id" json:"reply_id"`
+	Message       string     `db:"-" json:"message"` // e.g. "12 people upvoted your video"
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"` // Last time a user acted
+	ReadAt        *time.Time `db:"read_at" json:"read_at"`
+}
@@ -0,0 +1,354 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// What can be reported (reports.target_type)
+const (
+	TargetVideo   = "video"
+	TargetComment = "comment"
+	TargetReply   = "reply"
+	TargetUser    = "user"
+)
+
+// Report statuses (reports.status)
+const (
+	StatusOpen      = "open"
+	StatusDismissed = "dismissed" // No action was taken
+	StatusActioned  = "actioned"  // The content was taken down or its author warned or suspended
+)
+
+// Moderation actions (moderation_actions.action)
+const (
+	ActionDismiss   = "dismiss"
+	ActionTakeDown  = "take_down"
+	ActionWarn      = "warn"
+	ActionSuspend   = "suspend"
+	ActionUnsuspend = "unsuspend"
+)
+
+// Reasons is the taxonomy a report picks its reason from (reports.reason)
+var Reasons = []string{
+	"spam",
+	"harassment",
+	"hate_speech",
+	"violence",
+	"sexual_content",
+	"self_harm",
+	"misinformation",
+	"impersonation",
+	"copyright",
+	"other", // Requires details
+}
+
+// MaxDetailsLength is the most characters the free text of a report can have
+const MaxDetailsLength = 1000
+
+// Handle sets up the routes for report endpoints (mounted behind RequireAuth)
+// The moderation queue is in Events/Admin
+func Handle(req chi.Router) {
+	read := req.With(Auth.RequireScope(Auth.ScopeSocialRead))
+	write := req.With(Auth.RequireScope(Auth.ScopeSocialWrite))
+	write.Post("/", CreateReport)
+	read.Get("/", ListReports)
+	read.Get("/moderation", ListModerationActions)
+}
+
+// IsTargetType reports whether t is something that can be reported
+func IsTargetType(t string) bool {
+	switch t {
+	case TargetVideo, TargetComment, TargetReply, TargetUser:
+		return true
+	}
+	return false
+}
+
+func isReason(reason string) bool {
+	for _, r := range Reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// targetAuthor returns the UID of the author of a video, comment or reply, or the UID of a user
+// Returns sql.ErrNoRows if the target does not exist
+func targetAuthor(ctx context.Context, targetType, targetID string) (string, error) {
+	var query string
+	switch targetType {
+	case TargetVideo:
+		query = "SELECT user_uid FROM videos WHERE video_id = $1"
+	case TargetComment:
+		query = "SELECT commented_by FROM comments WHERE comment_id = $1"
+	case TargetReply:
+		query = "SELECT replied_by FROM replies WHERE reply_id = $1"
+	case TargetUser:
+		query = "SELECT uid FROM users WHERE uid = $1"
+	default:
+		return "", sql.ErrNoRows
+	}
+
+	var uid string
+	if err := Mdb.DB.QueryRowContext(ctx, query, targetID).Scan(&uid); err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", targetType, err)
+	}
+	return uid, nil
+}
+
+// paginate parses ?limit= (default 20, at most 100) and ?offset=
+func paginate(r *http.Request) (limit, offset int) {
+	limit = 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+		if limit > 100 {
+			limit = 100
+		}
+	}
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o >= 0 {
+		offset = o
+	}
+	return limit, offset
+}
+
+// CreateReport reports a video, comment, reply or user to the moderators
+// A user has at most one open report per target: reporting it again returns the open report
+// Body: {"target_type": "comment", "target_id": "...", "reason": "harassment", "details": "..."}
+func CreateReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("CreateReport: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		TargetType string `json:"target_type"`
+		TargetID   string `json:"target_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	input.TargetType = strings.ToLower(strings.TrimSpace(input.TargetType))
+	input.TargetID = strings.TrimSpace(input.TargetID)
+	input.Reason = strings.ToLower(strings.TrimSpace(input.Reason))
+	input.Details = strings.TrimSpace(input.Details)
+
+	if !IsTargetType(input.TargetType) {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Target type must be video, comment, reply or user")
+		return
+	}
+	if input.TargetID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Target ID is required")
+		return
+	}
+	if !isReason(input.Reason) {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Reason must be one of "+strings.Join(Reasons, ", "))
+		return
+	}
+	if input.Reason == "other" && input.Details == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Details are required when the reason is other")
+		return
+	}
+	if utf8.RuneCountInString(input.Details) > MaxDetailsLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Details must be at most %d characters", MaxDetailsLength))
+		return
+	}
+
+	targetUID, err := targetAuthor(ctx, input.TargetType, input.TargetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Report target not found")
+		} else {
+			log.Printf("CreateReport: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch report target")
+		}
+		return
+	}
+	if targetUID == claims.UID {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You cannot report yourself or your own content")
+		return
+	}
+
+	var details interface{}
+	if input.Details != "" {
+		details = input.Details
+	}
+
+	report := Reports{
+		ReporterUID: claims.UID,
+		TargetType:  input.TargetType,
+		TargetID:    input.TargetID,
+		TargetUID:   targetUID,
+		Status:      StatusOpen,
+	}
+
+	// A second report of the same target while the first is open inserts nothing; the open one is returned
+	created := true
+	err = Mdb.DB.QueryRowContext(ctx,
+		`INSERT INTO reports (reporter_uid, target_type, target_id, target_uid, reason, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (reporter_uid, target_type, target_id) WHERE status = 'open' DO NOTHING
+		RETURNING id, reason, details, created_at`,
+		claims.UID, input.TargetType, input.TargetID, targetUID, input.Reason, details, time.Now(),
+	).Scan(&report.ID, &report.Reason, &report.Details, &report.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		created = false
+		err = Mdb.DB.QueryRowContext(ctx,
+			`SELECT id, reason, details, created_at FROM reports
+			WHERE reporter_uid = $1 AND target_type = $2 AND target_id = $3 AND status = 'open'`,
+			claims.UID, input.TargetType, input.TargetID,
+		).Scan(&report.ID, &report.Reason, &report.Details, &report.CreatedAt)
+	}
+	if err != nil {
+		log.Printf("CreateReport: failed to save report: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to save report")
+		return
+	}
+
+	message := "Report submitted successfully"
+	if !created {
+		message = "You have already reported this"
+	}
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": message,
+		"created": created,
+		"report":  report,
+	})
+}
+
+// ListReports lists the reports made by the authenticated user, newest first, with their status
+// Query params: ?status=open|dismissed|actioned&limit=20&offset=0
+func ListReports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	limit, offset := paginate(r)
+	status := r.URL.Query().Get("status")
+	if status != "" && status != StatusOpen && status != StatusDismissed && status != StatusActioned {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Status must be open, dismissed or actioned")
+		return
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, reporter_uid, target_type, target_id, target_uid, reason, details, status, created_at, resolved_at,
+			COUNT(*) OVER() as total_count
+		FROM reports
+		WHERE reporter_uid = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4`,
+		claims.UID, status, limit, offset,
+	)
+	if err != nil {
+		log.Printf("ListReports: failed to query reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+	defer rows.Close()
+
+	reports := []Reports{}
+	var count int
+	for rows.Next() {
+		var report Reports
+		if err := rows.Scan(
+			&report.ID, &report.ReporterUID, &report.TargetType, &report.TargetID, &report.TargetUID,
+			&report.Reason, &report.Details, &report.Status, &report.CreatedAt, &report.ResolvedAt,
+			&count, // total_count from window function
+		); err != nil {
+			log.Printf("ListReports: failed to scan report: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+			return
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ListReports: failed to iterate reports: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reports")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"reports": reports,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   count,
+	})
+}
+
+// ListModerationActions lists the moderation actions taken against the authenticated user and their
+// content (take downs, warnings and suspensions), newest first; moderators are not disclosed
+// Query params: ?limit=20&offset=0
+func ListModerationActions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	limit, offset := paginate(r)
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, action, target_type, target_id, target_uid, reason, suspended_until, reports_resolved, created_at,
+			COUNT(*) OVER() as total_count
+		FROM moderation_actions
+		WHERE target_uid = $1 AND action IN ($2, $3, $4)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5 OFFSET $6`,
+		claims.UID, ActionTakeDown, ActionWarn, ActionSuspend, limit, offset,
+	)
+	if err != nil {
+		log.Printf("ListModerationActions: failed to query moderation actions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch moderation actions")
+		return
+	}
+	defer rows.Close()
+
+	actions := []ModerationActions{}
+	var count int
+	for rows.Next() {
+		var action ModerationActions
+		if err := rows.Scan(
+			&action.ID, &action.Action, &action.TargetType, &action.TargetID, &action.TargetUID,
+			&action.Reason, &action.SuspendedUntil, &action.ReportsResolved, &action.CreatedAt,
+			&count, // total_count from window function
+		); err != nil {
+			log.Printf("ListModerationActions: failed to scan moderation action: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch moderation actions")
+			return
+		}
+		actions = append(actions, action)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("ListModerationActions: failed to iterate moderation actions: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch moderation actions")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"actions": actions,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   count,
+	})
+}
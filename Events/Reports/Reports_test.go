@@ -0,0 +1,96 @@
+package reports
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests run against the Postgres database of Utils/Testdb and are skipped when
+// HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Route("/reports", Handle)
+	})
+}
+
+func TestReports(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	owner, alice, bob := users[0], users[1], users[2]
+	router := testRouter()
+	videoID := Testdb.CreateVideo(t, owner)
+
+	report := `{"target_type": "video", "target_id": "` + videoID + `", "reason": "spam"}`
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPost, "/reports", report); code != http.StatusBadRequest {
+		t.Errorf("report own video: status %d, want %d", code, http.StatusBadRequest)
+	}
+	other := `{"target_type": "video", "target_id": "` + videoID + `", "reason": "other"}`
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/reports", other); code != http.StatusBadRequest {
+		t.Errorf("report with reason other and no details: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// A reporter has one open report per target, however often they report it
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Testdb.Request(t, router, alice.UID, http.MethodPost, "/reports", report)
+		}()
+	}
+	wg.Wait()
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/reports", report)
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM reports WHERE target_type = 'video' AND target_id = $1 AND status = 'open'", videoID); got != 2 {
+		t.Errorf("open reports = %d, want 2", got)
+	}
+
+	// Suspension revokes the user's sessions and API keys and refuses new sessions
+	if _, err := Mdb.DB.Exec(
+		`INSERT INTO auth_sessions (session_id, user_uid, refresh_token_hash, created_at, refreshed_at, expires_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $4, $5, $4)`,
+		"test_"+Testdb.RandomHex(t, 16), owner.UID, Testdb.RandomHex(t, 32), time.Now(), time.Now().Add(time.Hour),
+	); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if _, err := Mdb.DB.Exec(
+		"INSERT INTO api_keys (key_id, user_uid, name, key_hash, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		Testdb.RandomHex(t, 8), owner.UID, "test", Testdb.RandomHex(t, 32), time.Now().Add(time.Hour),
+	); err != nil {
+		t.Fatalf("failed to create api key: %v", err)
+	}
+	tx, err := Mdb.DB.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := Auth.SuspendUser(context.Background(), tx, owner.UID, time.Now().Add(time.Hour)); err != nil {
+		tx.Rollback()
+		t.Fatalf("SuspendUser: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit suspension: %v", err)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM auth_sessions WHERE user_uid = $1 AND revoked_at IS NULL", owner.UID); got != 0 {
+		t.Errorf("%d sessions left after suspension, want 0", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM api_keys WHERE user_uid = $1 AND revoked_at IS NULL", owner.UID); got != 0 {
+		t.Errorf("%d api keys left after suspension, want 0", got)
+	}
+	if _, err := Auth.CreateSession(context.Background(), owner.UID, Auth.SessionMeta{}); !errors.Is(err, Auth.ErrAccountSuspended) {
+		t.Errorf("CreateSession for suspended user: %v, want %v", err, Auth.ErrAccountSuspended)
+	}
+}
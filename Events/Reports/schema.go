@@ -0,0 +1,45 @@
+package reports
+
+import "time"
+
+type Reports struct {
+	ID               int        `db:"id" json:"id"`
+	ReporterUID      string     `db:"reporter_uid" json:"reporter_uid"`
+	ReporterUsername *string    `db:"reporter_username" json:"reporter_username,omitempty"` // Joined from users (admin responses)
+	TargetType       string     `db:"target_type" json:"target_type"`                       // video, comment, reply or user
+	TargetID         string     `db:"target_id" json:"target_id"`
+	TargetUID        string     `db:"target_uid" json:"target_uid"` // Author of the reported content
+	Reason           string     `db:"reason" json:"reason"`
+	Details          *string    `db:"details" json:"details"`
+	Status           string     `db:"status" json:"status"` // open, dismissed or actioned
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	ResolvedAt       *time.Time `db:"resolved_at" json:"resolved_at"`
+	ResolvedBy       *string    `db:"resolved_by" json:"resolved_by,omitempty"` // Moderator UID (admin responses only)
+}
+
+// ReportTargets is a reported target in the moderation queue with its reports counted
+type ReportTargets struct {
+	TargetType      string         `db:"target_type" json:"target_type"`
+	TargetID        string         `db:"target_id" json:"target_id"`
+	TargetUID       string         `db:"target_uid" json:"target_uid"`
+	TargetUsername  *string        `db:"target_username" json:"target_username"` // Joined from users (for responses)
+	TargetExists    bool           `db:"target_exists" json:"target_exists"`     // False once the content was deleted
+	Preview         *string        `db:"preview" json:"preview"`                 // Video title, comment or reply text, or username
+	ReportCount     int            `db:"report_count" json:"report_count"`
+	Reasons         map[string]int `db:"reasons" json:"reasons"` // Number of reports per reason
+	FirstReportedAt time.Time      `db:"first_reported_at" json:"first_reported_at"`
+	LastReportedAt  time.Time      `db:"last_reported_at" json:"last_reported_at"`
+}
+
+type ModerationActions struct {
+	ID              int        `db:"id" json:"id"`
+	ModeratorUID    string     `db:"moderator_uid" json:"moderator_uid,omitempty"` // Left out of the responses to the affected user
+	Action          string     `db:"action" json:"action"`                         // dismiss, take_down, warn, suspend or unsuspend
+	TargetType      string     `db:"target_type" json:"target_type"`
+	TargetID        string     `db:"target_id" json:"target_id"`
+	TargetUID       string     `db:"target_uid" json:"target_uid"`
+	Reason          *string    `db:"reason" json:"reason"`
+	SuspendedUntil  *time.Time `db:"suspended_until" json:"suspended_until,omitempty"` // Set for suspend
+	ReportsResolved int        `db:"reports_resolved" json:"reports_resolved"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+}
@@ -13,16 +13,87 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	Auth "hifi/Services/Auth"
 	ES "hifi/Services/Elasticsearch"
+	Mdb "hifi/Services/Mdb"
 	Utils "hifi/Utils"
 )
 
 // Handle sets up the routes for search endpoints
+// Authentication is optional: authenticated callers do not see users they blocked or their videos
+// Videos of private accounts are only found by the account and its followers
 func Handle(r chi.Router) {
+	r.Use(Auth.OptionalAuth)
 	r.Get("/users/{query}", SearchUsersHandler)
 	r.Get("/videos/{query}", SearchVideosHandler)
 }
 
+// blockedUsers returns the UIDs and usernames of the users the caller blocked
+// Anonymous callers have blocked nobody
+func blockedUsers(r *http.Request) (uids, usernames []string, err error) {
+	claims := Auth.PrincipalFrom(r)
+	if claims == nil {
+		return nil, nil, nil
+	}
+
+	rows, err := Mdb.DB.QueryContext(r.Context(),
+		`SELECT u.uid, u.username FROM blocklists b
+		JOIN users u ON b.blocked_to = u.uid
+		WHERE b.blocked_by = $1`,
+		claims.UID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid, username string
+		if err := rows.Scan(&uid, &username); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan blocked user: %w", err)
+		}
+		uids = append(uids, uid)
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to iterate blocked users: %w", err)
+	}
+	return uids, usernames, nil
+}
+
+// hiddenPrivateUsers returns the usernames of the private accounts whose videos the caller cannot see
+// The caller sees the videos of their own account and of the private accounts they follow
+func hiddenPrivateUsers(r *http.Request) ([]string, error) {
+	viewerUID := ""
+	if claims := Auth.PrincipalFrom(r); claims != nil {
+		viewerUID = claims.UID
+	}
+
+	rows, err := Mdb.DB.QueryContext(r.Context(),
+		`SELECT u.username FROM users u
+		WHERE u.is_private AND u.uid != $1
+			AND NOT EXISTS (SELECT 1 FROM followers f WHERE f.followed_by = $1 AND f.followed_to = u.uid)`,
+		viewerUID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list private users: %w", err)
+	}
+	defer rows.Close()
+
+	var usernames []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to scan private user: %w", err)
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate private users: %w", err)
+	}
+	return usernames, nil
+}
+
 // SearchUsersHandler handles HTTP requests for searching users
 func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -46,8 +117,15 @@ func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	excludeUIDs, _, err := blockedUsers(r)
+	if err != nil {
+		log.Printf("SearchUsersHandler: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
 	// Perform search
-	results, err := SearchUsers(ctx, query, limit)
+	results, err := SearchUsers(ctx, query, limit, excludeUIDs...)
 	if err != nil {
 		log.Printf("SearchUsersHandler: failed to search users: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to search users")
@@ -85,8 +163,22 @@ func SearchVideosHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	_, excludeUsernames, err := blockedUsers(r)
+	if err != nil {
+		log.Printf("SearchVideosHandler: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to search videos")
+		return
+	}
+	privateUsernames, err := hiddenPrivateUsers(r)
+	if err != nil {
+		log.Printf("SearchVideosHandler: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to search videos")
+		return
+	}
+	excludeUsernames = append(excludeUsernames, privateUsernames...)
+
 	// Perform search
-	results, err := SearchVideos(ctx, query, limit)
+	results, err := SearchVideos(ctx, query, limit, excludeUsernames...)
 	if err != nil {
 		log.Printf("SearchVideosHandler: failed to search videos: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to search videos")
@@ -234,7 +326,8 @@ func DeleteVideo(ctx context.Context, videoID string) error {
 }
 
 // SearchUsers searches for users by username
-func SearchUsers(ctx context.Context, query string, limit int) ([]map[string]interface{}, error) {
+// Users whose UID is in excludeUIDs are left out
+func SearchUsers(ctx context.Context, query string, limit int, excludeUIDs ...string) ([]map[string]interface{}, error) {
 	if !ES.IsESEnabled() {
 		return nil, fmt.Errorf("elasticsearch is not enabled")
 	}
@@ -248,9 +341,18 @@ func SearchUsers(ctx context.Context, query string, limit int) ([]map[string]int
 
 	searchQuery := map[string]interface{}{
 		"query": map[string]interface{}{
-			"multi_match": map[string]interface{}{
-				"query":  query,
-				"fields": []string{"username^2", "profile_picture"},
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":  query,
+						"fields": []string{"username^2", "profile_picture"},
+					},
+				},
+				"must_not": map[string]interface{}{
+					"terms": map[string]interface{}{
+						"uid": nonNil(excludeUIDs),
+					},
+				},
 			},
 		},
 		"size": limit,
@@ -329,7 +431,8 @@ func SearchUsers(ctx context.Context, query string, limit int) ([]map[string]int
 }
 
 // SearchVideos searches for videos by title, tags, or description
-func SearchVideos(ctx context.Context, query string, limit int) ([]map[string]interface{}, error) {
+// Videos of users whose username is in excludeUsernames are left out
+func SearchVideos(ctx context.Context, query string, limit int, excludeUsernames ...string) ([]map[string]interface{}, error) {
 	if !ES.IsESEnabled() {
 		return nil, fmt.Errorf("elasticsearch is not enabled")
 	}
@@ -361,6 +464,12 @@ func SearchVideos(ctx context.Context, query string, limit int) ([]map[string]in
 					},
 				},
 				"minimum_should_match": 1,
+				"must_not": map[string]interface{}{
+					"terms": map[string]interface{}{
+						// user_username is dynamically mapped, its keyword sub-field holds the exact value
+						"user_username.keyword": nonNil(excludeUsernames),
+					},
+				},
 			},
 		},
 		"size": limit,
@@ -437,3 +546,11 @@ func SearchVideos(ctx context.Context, query string, limit int) ([]map[string]in
 
 	return results, nil
 }
+
+// nonNil returns an empty slice for nil, so that it is encoded as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,331 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// Blocked reports whether either user has blocked the other
+// A block in either direction stops follows, comments and replies between the two users
+func Blocked(ctx context.Context, uid, otherUID string) (bool, error) {
+	var blocked bool
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM blocklists
+			WHERE (blocked_by = $1 AND blocked_to = $2) OR (blocked_by = $2 AND blocked_to = $1)
+		)`,
+		uid, otherUID,
+	).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check blocklists: %w", err)
+	}
+	return blocked, nil
+}
+
+// fetchUserUID returns the UID of the user with the username
+func fetchUserUID(ctx context.Context, username string) (string, error) {
+	var uid string
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT uid FROM users WHERE username = $1",
+		username,
+	).Scan(&uid)
+	return uid, err
+}
+
+// Block blocks a user and removes the follows and follow requests between the two users in both directions
+func Block(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Block: failed to fetch user to block: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	// Prevent self-block
+	if claims.UID == userUID {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You cannot block yourself")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Block: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockUsers(ctx, tx, claims.UID, userUID); err != nil {
+		log.Printf("Block: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO blocklists (blocked_by, blocked_to, blocked_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		claims.UID, userUID, time.Now(),
+	)
+	if err != nil {
+		log.Printf("Block: failed to insert block: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You have already blocked this user")
+		return
+	}
+
+	// Remove follows in both directions and keep the follower counts in step
+	rows, err := tx.QueryContext(ctx,
+		`DELETE FROM followers
+		WHERE (followed_by = $1 AND followed_to = $2) OR (followed_by = $2 AND followed_to = $1)
+		RETURNING followed_by, followed_to`,
+		claims.UID, userUID,
+	)
+	if err != nil {
+		log.Printf("Block: failed to remove follows: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+	var removed []Followers
+	for rows.Next() {
+		var follow Followers
+		if err := rows.Scan(&follow.FollowedBy, &follow.FollowedTo); err != nil {
+			rows.Close()
+			log.Printf("Block: failed to scan removed follow: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+			return
+		}
+		removed = append(removed, follow)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Block: failed to iterate removed follows: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM follow_requests
+		WHERE (requested_by = $1 AND requested_to = $2) OR (requested_by = $2 AND requested_to = $1)`,
+		claims.UID, userUID,
+	); err != nil {
+		log.Printf("Block: failed to remove follow requests: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+
+	for _, follow := range removed {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET followers = followers - 1 WHERE uid = $1", follow.FollowedTo); err != nil {
+			log.Printf("Block: failed to update followers: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update followers")
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET following = following - 1 WHERE uid = $1", follow.FollowedBy); err != nil {
+			log.Printf("Block: failed to update following: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update following")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Block: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":         "Blocked successfully",
+		"removed_follows": len(removed),
+	})
+}
+
+// Unblock removes a block; follows removed by the block are not restored
+func Unblock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Unblock: failed to fetch user to unblock: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"DELETE FROM blocklists WHERE blocked_by = $1 AND blocked_to = $2",
+		claims.UID, userUID,
+	)
+	if err != nil {
+		log.Printf("Unblock: failed to delete block: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unblock user")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Unblock: failed to check delete result: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check delete result")
+		return
+	}
+	if rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You have not blocked this user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Unblocked successfully"})
+}
+
+// ListBlocked lists the users the authenticated user has blocked, newest first
+func ListBlocked(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	// Get pagination parameters from query string
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+			if limit > 100 {
+				limit = 100
+			}
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT b.id, b.blocked_by, b.blocked_to, u.username as blocked_to_username, b.blocked_at,
+			COUNT(*) OVER() as total_count
+		FROM blocklists b
+		JOIN users u ON b.blocked_to = u.uid
+		WHERE b.blocked_by = $1
+		ORDER BY b.blocked_at DESC
+		LIMIT $2 OFFSET $3`,
+		claims.UID, limit, offset,
+	)
+	if err != nil {
+		log.Printf("ListBlocked: failed to list blocked users: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list blocked users")
+		return
+	}
+	defer rows.Close()
+
+	blocked := []Blocklists{}
+	var count int
+	for rows.Next() {
+		var block Blocklists
+		if err := rows.Scan(
+			&block.ID,
+			&block.BlockedBy,
+			&block.BlockedTo,
+			&block.BlockedToUsername,
+			&block.BlockedAt,
+			&count, // total_count from window function (same value for all rows)
+		); err != nil {
+			log.Printf("ListBlocked: failed to scan block: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode blocked user")
+			return
+		}
+		blocked = append(blocked, block)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("ListBlocked: failed to iterate blocked users: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate blocked users")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"blocked": blocked,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   count,
+	})
+}
+
+// IsBlocked reports whether the authenticated user has blocked a user and whether that user has blocked them
+func IsBlocked(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("IsBlocked: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	var blocked, blockedBy bool
+	err = Mdb.DB.QueryRowContext(ctx,
+		`SELECT
+			EXISTS(SELECT 1 FROM blocklists WHERE blocked_by = $1 AND blocked_to = $2) as blocked,
+			EXISTS(SELECT 1 FROM blocklists WHERE blocked_by = $2 AND blocked_to = $1) as blocked_by`,
+		claims.UID, userUID,
+	).Scan(&blocked, &blockedBy)
+	if err != nil {
+		log.Printf("IsBlocked: failed to check blocklists: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check block")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"username":   username,
+		"blocked":    blocked,
+		"blocked_by": blockedBy,
+	})
+}
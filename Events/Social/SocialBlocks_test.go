@@ -0,0 +1,115 @@
+package social
+
+import (
+	"net/http"
+	"testing"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// commentAuthors returns the UIDs of the authors of the comments uid sees on a video
+func commentAuthors(t *testing.T, router http.Handler, uid, videoID string) map[string]bool {
+	t.Helper()
+	var listed struct {
+		Comments []Comments `json:"comments"`
+	}
+	if code := Testdb.RequestData(t, router, uid, http.MethodGet, "/videos/comments/"+videoID, "", &listed); code != http.StatusOK {
+		t.Fatalf("list comments: status %d", code)
+	}
+	authors := map[string]bool{}
+	for _, comment := range listed.Comments {
+		authors[comment.CommentedBy] = true
+	}
+	return authors
+}
+
+func TestBlock(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	router := testRouter()
+
+	// Follows in both directions are removed by the block
+	Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow/"+bob.Username, "")
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, "")
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/block/"+bob.Username, ""); code != http.StatusOK {
+		t.Fatalf("block: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/block/"+bob.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("block twice: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/block/"+alice.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("block oneself: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_by IN ($1, $2)", alice.UID, bob.UID); got != 0 {
+		t.Errorf("%d follows left after the block, want 0", got)
+	}
+	for _, user := range []Testdb.User{alice, bob} {
+		if got := Testdb.QueryInt(t, "SELECT followers + following FROM users WHERE uid = $1", user.UID); got != 0 {
+			t.Errorf("%s: followers + following = %d after the block, want 0", user.Username, got)
+		}
+	}
+
+	// Neither side can follow the other
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, ""); code != http.StatusForbidden {
+		t.Errorf("blocked user following: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow/"+bob.Username, ""); code != http.StatusForbidden {
+		t.Errorf("following a blocked user: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// The blocked user cannot comment on the blocker's videos or reply to the blocker's comments
+	aliceVideo := Testdb.CreateVideo(t, alice)
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/videos/comment/"+aliceVideo, `{"comment": "hi"}`); code != http.StatusForbidden {
+		t.Errorf("blocked user commenting: status %d, want %d", code, http.StatusForbidden)
+	}
+	carolVideo := Testdb.CreateVideo(t, carol)
+	Testdb.Request(t, router, alice.UID, http.MethodPost, "/videos/comment/"+carolVideo, `{"comment": "alice"}`)
+	var aliceComment string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1 AND commented_by = $2", carolVideo, alice.UID).Scan(&aliceComment); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/videos/reply/"+aliceComment, `{"reply": "hi"}`); code != http.StatusForbidden {
+		t.Errorf("blocked user replying: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Comments of the blocked user disappear for the blocker only
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/videos/comment/"+carolVideo, `{"comment": "bob"}`)
+	if authors := commentAuthors(t, router, alice.UID, carolVideo); authors[bob.UID] || !authors[alice.UID] {
+		t.Errorf("alice sees comments by %v, want hers but not bob's", authors)
+	}
+	if authors := commentAuthors(t, router, carol.UID, carolVideo); !authors[bob.UID] {
+		t.Error("carol does not see bob's comment")
+	}
+
+	var status struct {
+		Blocked   bool `json:"blocked"`
+		BlockedBy bool `json:"blocked_by"`
+	}
+	Testdb.RequestData(t, router, alice.UID, http.MethodGet, "/users/blocked/"+bob.Username, "", &status)
+	if !status.Blocked || status.BlockedBy {
+		t.Errorf("alice's block status of bob = %+v, want blocked", status)
+	}
+	var listed struct {
+		Count int `json:"count"`
+	}
+	Testdb.RequestData(t, router, alice.UID, http.MethodGet, "/users/blocked", "", &listed)
+	if listed.Count != 1 {
+		t.Errorf("%d blocked users listed, want 1", listed.Count)
+	}
+
+	// Unblocking does not restore the follows
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/unblock/"+bob.Username, ""); code != http.StatusOK {
+		t.Fatalf("unblock: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/unblock/"+bob.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("unblock twice: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_by IN ($1, $2)", alice.UID, bob.UID); got != 0 {
+		t.Errorf("%d follows after unblocking, want 0", got)
+	}
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, ""); code != http.StatusOK {
+		t.Errorf("follow after unblocking: status %d", code)
+	}
+}
@@ -0,0 +1,495 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Notifications "hifi/Events/Notifications"
+	Users "hifi/Events/Users"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrReplyNotFound   = errors.New("reply not found")
+)
+
+// RemoveComment deletes a comment in tx (and with it its replies and edit history) and
+// decrements the video's comment count
+// Used by the author, the video owner and the admin endpoints, including moderation take-downs
+func RemoveComment(ctx context.Context, tx *sql.Tx, commentID string) error {
+	// Deleting the row first means only one of two concurrent deletes decrements the count
+	var commentedTo string
+	err := tx.QueryRowContext(ctx,
+		"DELETE FROM comments WHERE comment_id = $1 RETURNING commented_to",
+		commentID,
+	).Scan(&commentedTo)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrCommentNotFound
+		}
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE videos SET video_comments = video_comments - 1 WHERE video_id = $1",
+		commentedTo,
+	); err != nil {
+		return fmt.Errorf("failed to update video comment count: %w", err)
+	}
+	return nil
+}
+
+// RemoveReply deletes a reply in tx (and its edit history) and decrements the comment's reply count
+// Used by the author, the video owner and the admin endpoints, including moderation take-downs
+func RemoveReply(ctx context.Context, tx *sql.Tx, replyID string) error {
+	var repliedTo string
+	err := tx.QueryRowContext(ctx,
+		"SELECT replied_to FROM replies WHERE reply_id = $1",
+		replyID,
+	).Scan(&repliedTo)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReplyNotFound
+		}
+		return fmt.Errorf("failed to fetch reply: %w", err)
+	}
+
+	// Lock the comment before the reply, in the same order as Reply, so the two cannot deadlock
+	var locked int
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM comments WHERE comment_id = $1 FOR UPDATE",
+		repliedTo,
+	).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReplyNotFound // Deleted with its comment in the meantime
+		}
+		return fmt.Errorf("failed to lock comment: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM replies WHERE reply_id = $1 AND replied_to = $2",
+		replyID, repliedTo,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete reply: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrReplyNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE comments SET total_replies = total_replies - 1 WHERE comment_id = $1",
+		repliedTo,
+	); err != nil {
+		return fmt.Errorf("failed to update comment reply count: %w", err)
+	}
+	return nil
+}
+
+// updateReply replaces the text of a reply locked by tx, keeping the previous text in comment_edits,
+// and its mentions, notifying the users it newly mentions
+func updateReply(ctx context.Context, tx *sql.Tx, replyID, editedBy, previous, text string) (Replies, error) {
+	var reply Replies
+	now := time.Now()
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO comment_edits (reply_id, edited_by, previous_text, edited_at) VALUES ($1, $2, $3, $4)",
+		replyID, editedBy, previous, now,
+	); err != nil {
+		return reply, fmt.Errorf("failed to insert reply edit: %w", err)
+	}
+
+	var videoID string
+	err := tx.QueryRowContext(ctx,
+		`UPDATE replies SET reply = $1, edited_at = $2 WHERE reply_id = $3
+		RETURNING id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username, edited_at, total_likes,
+			(SELECT commented_to FROM comments WHERE comment_id = replies.replied_to)`,
+		text, now, replyID,
+	).Scan(
+		&reply.ID, &reply.ReplyID, &reply.RepliedBy, &reply.RepliedTo,
+		&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername, &reply.EditedAt, &reply.TotalLikes,
+		&videoID,
+	)
+	if err != nil {
+		return reply, fmt.Errorf("failed to update reply: %w", err)
+	}
+
+	var mentioned []string
+	reply.Mentions, mentioned, err = Users.SaveMentions(ctx, tx, Users.MentionInReply, replyID, reply.RepliedBy, text)
+	if err != nil {
+		return reply, err
+	}
+	err = Notifications.NotifyUsers(ctx, tx, Notifications.Event{
+		Type:      Notifications.TypeMention,
+		ActorUID:  reply.RepliedBy,
+		VideoID:   videoID,
+		CommentID: reply.RepliedTo,
+		ReplyID:   replyID,
+	}, mentioned)
+	return reply, err
+}
+
+// EditComment replaces the text of the authenticated user's comment
+// Body: {"comment": "..."}; the previous text is kept for moderators
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	commentID := chi.URLParam(r, "commentID")
+	if commentID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Comment ID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("EditComment: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Comment *string `json:"comment"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	if input.Comment == nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Comment text is required")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("EditComment: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit comment")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the comment so concurrent edits are recorded one after the other
+	var comment Comments
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, comment_id, commented_by, commented_to, commented_at, comment,
+			comment_by_username, total_replies, edited_at, total_likes, pinned_at, hearted_at
+		FROM comments WHERE comment_id = $1 FOR UPDATE`,
+		commentID,
+	).Scan(
+		&comment.ID, &comment.CommentID, &comment.CommentedBy, &comment.CommentedTo,
+		&comment.CommentedAt, &comment.Comment, &comment.CommentByUsername,
+		&comment.TotalReplies, &comment.EditedAt, &comment.TotalLikes,
+		&comment.PinnedAt, &comment.HeartedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		} else {
+			log.Printf("EditComment: failed to fetch comment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch comment")
+		}
+		return
+	}
+	if comment.CommentedBy != claims.UID {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You can only edit your own comments")
+		return
+	}
+
+	// Nothing to record if the text is unchanged
+	if comment.Comment == *input.Comment {
+		mentions, err := Users.LoadMentions(ctx, tx, Users.MentionInComment, []string{commentID})
+		if err != nil {
+			log.Printf("EditComment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+			return
+		}
+		comment.Mentions = mentions[commentID]
+		Utils.SendSuccessResponse(w, map[string]interface{}{
+			"message": "Comment unchanged",
+			"comment": comment,
+		})
+		return
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO comment_edits (comment_id, edited_by, previous_text, edited_at) VALUES ($1, $2, $3, $4)",
+		commentID, claims.UID, comment.Comment, now,
+	); err != nil {
+		log.Printf("EditComment: failed to insert comment edit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit comment")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE comments SET comment = $1, edited_at = $2 WHERE comment_id = $3",
+		*input.Comment, now, commentID,
+	); err != nil {
+		log.Printf("EditComment: failed to update comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit comment")
+		return
+	}
+
+	var mentioned []string
+	comment.Mentions, mentioned, err = Users.SaveMentions(ctx, tx, Users.MentionInComment, commentID, claims.UID, *input.Comment)
+	if err == nil {
+		err = Notifications.NotifyUsers(ctx, tx, Notifications.Event{
+			Type:      Notifications.TypeMention,
+			ActorUID:  claims.UID,
+			VideoID:   comment.CommentedTo,
+			CommentID: commentID,
+		}, mentioned)
+	}
+	if err != nil {
+		log.Printf("EditComment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit comment")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("EditComment: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit comment")
+		return
+	}
+
+	comment.Comment = *input.Comment
+	comment.EditedAt = &now
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Comment edited",
+		"comment": comment,
+	})
+}
+
+// EditReply replaces the text of the authenticated user's reply
+// Body: {"reply": "..."}; the previous text is kept for moderators
+func EditReply(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	replyID := chi.URLParam(r, "replyID")
+	if replyID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Reply ID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("EditReply: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Reply *string `json:"reply"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	if input.Reply == nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Reply text is required")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("EditReply: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit reply")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the reply so concurrent edits are recorded one after the other
+	var reply Replies
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username, edited_at, total_likes
+		FROM replies WHERE reply_id = $1 FOR UPDATE`,
+		replyID,
+	).Scan(
+		&reply.ID, &reply.ReplyID, &reply.RepliedBy, &reply.RepliedTo,
+		&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername, &reply.EditedAt, &reply.TotalLikes,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Reply not found")
+		} else {
+			log.Printf("EditReply: failed to fetch reply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reply")
+		}
+		return
+	}
+	if reply.RepliedBy != claims.UID {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You can only edit your own replies")
+		return
+	}
+
+	// Nothing to record if the text is unchanged
+	if reply.Reply == *input.Reply {
+		mentions, err := Users.LoadMentions(ctx, tx, Users.MentionInReply, []string{replyID})
+		if err != nil {
+			log.Printf("EditReply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+			return
+		}
+		reply.Mentions = mentions[replyID]
+		Utils.SendSuccessResponse(w, map[string]interface{}{
+			"message": "Reply unchanged",
+			"reply":   reply,
+		})
+		return
+	}
+
+	reply, err = updateReply(ctx, tx, replyID, claims.UID, reply.Reply, *input.Reply)
+	if err != nil {
+		log.Printf("EditReply: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit reply")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("EditReply: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to edit reply")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Reply edited",
+		"reply":   reply,
+	})
+}
+
+// DeleteComment deletes a comment and its replies
+// Allowed for the comment's author and the owner of the video it is on
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	commentID := chi.URLParam(r, "commentID")
+	if commentID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Comment ID is required")
+		return
+	}
+
+	var commentedBy, videoOwner string
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT c.commented_by, v.user_uid
+		FROM comments c
+		JOIN videos v ON v.video_id = c.commented_to
+		WHERE c.comment_id = $1`,
+		commentID,
+	).Scan(&commentedBy, &videoOwner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		} else {
+			log.Printf("DeleteComment: failed to fetch comment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch comment")
+		}
+		return
+	}
+	if claims.UID != commentedBy && claims.UID != videoOwner {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot delete this comment")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("DeleteComment: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := RemoveComment(ctx, tx, commentID); err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		} else {
+			log.Printf("DeleteComment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("DeleteComment: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Comment deleted"})
+}
+
+// DeleteReply deletes a reply
+// Allowed for the reply's author and the owner of the video the comment is on
+func DeleteReply(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	replyID := chi.URLParam(r, "replyID")
+	if replyID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Reply ID is required")
+		return
+	}
+
+	var repliedBy, videoOwner string
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT r.replied_by, v.user_uid
+		FROM replies r
+		JOIN comments c ON c.comment_id = r.replied_to
+		JOIN videos v ON v.video_id = c.commented_to
+		WHERE r.reply_id = $1`,
+		replyID,
+	).Scan(&repliedBy, &videoOwner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Reply not found")
+		} else {
+			log.Printf("DeleteReply: failed to fetch reply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch reply")
+		}
+		return
+	}
+	if claims.UID != repliedBy && claims.UID != videoOwner {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot delete this reply")
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("DeleteReply: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := RemoveReply(ctx, tx, replyID); err != nil {
+		if errors.Is(err, ErrReplyNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Reply not found")
+		} else {
+			log.Printf("DeleteReply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("DeleteReply: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to delete reply")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Reply deleted"})
+}
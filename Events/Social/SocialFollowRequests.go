@@ -0,0 +1,272 @@
+package social
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// ListIncomingFollowRequests lists the pending requests to follow the authenticated user, newest first
+func ListIncomingFollowRequests(w http.ResponseWriter, r *http.Request) {
+	listFollowRequests(w, r, "ListIncomingFollowRequests", true)
+}
+
+// ListOutgoingFollowRequests lists the authenticated user's pending requests to follow private accounts, newest first
+func ListOutgoingFollowRequests(w http.ResponseWriter, r *http.Request) {
+	listFollowRequests(w, r, "ListOutgoingFollowRequests", false)
+}
+
+func listFollowRequests(w http.ResponseWriter, r *http.Request, handler string, incoming bool) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	// Get pagination parameters from query string
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+			if limit > 100 {
+				limit = 100
+			}
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	where := "fr.requested_by = $1"
+	if incoming {
+		where = "fr.requested_to = $1"
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT fr.id, fr.requested_by, u1.username as requested_by_username,
+			fr.requested_to, u2.username as requested_to_username, fr.requested_at,
+			COUNT(*) OVER() as total_count
+		FROM follow_requests fr
+		JOIN users u1 ON fr.requested_by = u1.uid
+		JOIN users u2 ON fr.requested_to = u2.uid
+		WHERE `+where+`
+		ORDER BY fr.requested_at DESC
+		LIMIT $2 OFFSET $3`,
+		claims.UID, limit, offset,
+	)
+	if err != nil {
+		log.Printf("%s: failed to list follow requests: %v", handler, err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list follow requests")
+		return
+	}
+	defer rows.Close()
+
+	requests := []FollowRequests{}
+	var count int
+	for rows.Next() {
+		var request FollowRequests
+		if err := rows.Scan(
+			&request.ID,
+			&request.RequestedBy,
+			&request.RequestedByUsername,
+			&request.RequestedTo,
+			&request.RequestedToUsername,
+			&request.RequestedAt,
+			&count, // total_count from window function (same value for all rows)
+		); err != nil {
+			log.Printf("%s: failed to scan follow request: %v", handler, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode follow request")
+			return
+		}
+		requests = append(requests, request)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("%s: failed to iterate follow requests: %v", handler, err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate follow requests")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"requests": requests,
+		"limit":    limit,
+		"offset":   offset,
+		"count":    count,
+	})
+}
+
+// ApproveFollowRequest accepts a pending request from {username} to follow the authenticated user
+func ApproveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("ApproveFollowRequest: failed to fetch requester: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("ApproveFollowRequest: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to approve follow request")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockUsers(ctx, tx, claims.UID, userUID); err != nil {
+		log.Printf("ApproveFollowRequest: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to approve follow request")
+		return
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM follow_requests WHERE requested_by = $1 AND requested_to = $2",
+		userUID, claims.UID,
+	)
+	if err != nil {
+		log.Printf("ApproveFollowRequest: failed to delete follow request: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to approve follow request")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+
+	result, err = tx.ExecContext(ctx,
+		"INSERT INTO followers (followed_by, followed_to, followed_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		userUID, claims.UID, time.Now(),
+	)
+	if err != nil {
+		log.Printf("ApproveFollowRequest: failed to insert follower: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to insert follower")
+		return
+	}
+
+	// Update follower counts (unless the follow already existed)
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET followers = followers + 1 WHERE uid = $1", claims.UID); err != nil {
+			log.Printf("ApproveFollowRequest: failed to update followers: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update followers")
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET following = following + 1 WHERE uid = $1", userUID); err != nil {
+			log.Printf("ApproveFollowRequest: failed to update following: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update following")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("ApproveFollowRequest: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to approve follow request")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Follow request approved"})
+}
+
+// RejectFollowRequest declines a pending request from {username} to follow the authenticated user
+// The requester is not told and may request again
+func RejectFollowRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("RejectFollowRequest: failed to fetch requester: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"DELETE FROM follow_requests WHERE requested_by = $1 AND requested_to = $2",
+		userUID, claims.UID,
+	)
+	if err != nil {
+		log.Printf("RejectFollowRequest: failed to delete follow request: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reject follow request")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Follow request rejected"})
+}
+
+// CancelFollowRequest withdraws the authenticated user's pending request to follow {username}
+func CancelFollowRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("CancelFollowRequest: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"DELETE FROM follow_requests WHERE requested_by = $1 AND requested_to = $2",
+		claims.UID, userUID,
+	)
+	if err != nil {
+		log.Printf("CancelFollowRequest: failed to delete follow request: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to cancel follow request")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Follow request not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Follow request cancelled"})
+}
@@ -0,0 +1,93 @@
+package social
+
+import (
+	"net/http"
+	"testing"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// followRequests returns the usernames on the other side of uid's incoming or outgoing follow requests
+func followRequests(t *testing.T, router http.Handler, uid, direction string) map[string]bool {
+	t.Helper()
+	var listed struct {
+		Requests []FollowRequests `json:"requests"`
+	}
+	if code := Testdb.RequestData(t, router, uid, http.MethodGet, "/users/follow-requests/"+direction, "", &listed); code != http.StatusOK {
+		t.Fatalf("list %s follow requests: status %d", direction, code)
+	}
+	usernames := map[string]bool{}
+	for _, request := range listed.Requests {
+		if direction == "incoming" {
+			usernames[request.RequestedByUsername] = true
+		} else {
+			usernames[request.RequestedToUsername] = true
+		}
+	}
+	return usernames
+}
+
+func TestFollowRequests(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 4)
+	alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+	router := testRouter()
+
+	if _, err := Mdb.DB.Exec("UPDATE users SET is_private = TRUE WHERE uid = $1", alice.UID); err != nil {
+		t.Fatalf("failed to make account private: %v", err)
+	}
+
+	// Following a private account creates a pending request, not a follow
+	for _, user := range []Testdb.User{bob, carol, dave} {
+		if code := Testdb.Request(t, router, user.UID, http.MethodPost, "/users/follow/"+alice.Username, ""); code != http.StatusOK {
+			t.Fatalf("%s requesting to follow: status %d", user.Username, code)
+		}
+	}
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("requesting twice: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_to = $1", alice.UID); got != 0 {
+		t.Errorf("%d followers before approval, want 0", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT followers FROM users WHERE uid = $1", alice.UID); got != 0 {
+		t.Errorf("followers count = %d before approval, want 0", got)
+	}
+
+	if incoming := followRequests(t, router, alice.UID, "incoming"); len(incoming) != 3 || !incoming[bob.Username] {
+		t.Errorf("alice's incoming requests = %v, want bob, carol and dave", incoming)
+	}
+	if outgoing := followRequests(t, router, bob.UID, "outgoing"); len(outgoing) != 1 || !outgoing[alice.Username] {
+		t.Errorf("bob's outgoing requests = %v, want alice", outgoing)
+	}
+
+	// Approve, reject and cancel
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow-requests/"+bob.Username+"/approve", ""); code != http.StatusOK {
+		t.Fatalf("approve: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow-requests/"+bob.Username+"/approve", ""); code != http.StatusNotFound {
+		t.Errorf("approve twice: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow-requests/"+carol.Username+"/reject", ""); code != http.StatusOK {
+		t.Fatalf("reject: status %d", code)
+	}
+	if code := Testdb.Request(t, router, dave.UID, http.MethodDelete, "/users/follow-requests/"+alice.Username, ""); code != http.StatusOK {
+		t.Fatalf("cancel: status %d", code)
+	}
+	if incoming := followRequests(t, router, alice.UID, "incoming"); len(incoming) != 0 {
+		t.Errorf("alice's incoming requests after handling them = %v, want none", incoming)
+	}
+
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_to = $1 AND followed_by = $2", alice.UID, bob.UID); got != 1 {
+		t.Error("approved request did not create the follow")
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_to = $1", alice.UID); got != 1 {
+		t.Errorf("%d followers, want only bob", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT followers FROM users WHERE uid = $1", alice.UID); got != 1 {
+		t.Errorf("alice's followers = %d, want 1", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT following FROM users WHERE uid = $1", bob.UID); got != 1 {
+		t.Errorf("bob's following = %d, want 1", got)
+	}
+}
@@ -0,0 +1,173 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// Comment list orders (ListComments ?sort=)
+const (
+	CommentSortNewest = "newest"
+	CommentSortOldest = "oldest"
+	CommentSortTop    = "top" // Likes decayed by age
+)
+
+// TopCommentsGravity is how fast a comment's likes lose weight with age in the top order
+const TopCommentsGravity = 1.5
+
+var errLikeBlocked = errors.New("blocked by the author")
+
+// likeTarget describes the table a like goes to: comments or replies
+type likeTarget struct {
+	name         string // comment or reply, for messages
+	table        string // comments or replies
+	idColumn     string // comment_id or reply_id
+	authorColumn string // commented_by or replied_by
+	likesTable   string // comment_likes or reply_likes
+	notFound     error
+}
+
+var (
+	commentLikes = likeTarget{"comment", "comments", "comment_id", "commented_by", "comment_likes", ErrCommentNotFound}
+	replyLikes   = likeTarget{"reply", "replies", "reply_id", "replied_by", "reply_likes", ErrReplyNotFound}
+)
+
+// setLike likes or unlikes a comment or reply and returns its new like count
+// changed is false when the caller already (or never) liked it
+// The liked row is locked, so likes of it are applied one at a time and the count
+// changes in the same transaction as the likes
+func setLike(ctx context.Context, target likeTarget, uid, id string, like bool) (int, bool, error) {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var author string
+	var likes int
+	err = tx.QueryRowContext(ctx,
+		"SELECT "+target.authorColumn+", total_likes FROM "+target.table+" WHERE "+target.idColumn+" = $1 FOR UPDATE",
+		id,
+	).Scan(&author, &likes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, target.notFound
+		}
+		return 0, false, fmt.Errorf("failed to fetch %s: %w", target.table, err)
+	}
+
+	var result sql.Result
+	if like {
+		// Users blocked by (or blocking) the author cannot like
+		var blocked bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS(
+				SELECT 1 FROM blocklists
+				WHERE (blocked_by = $1 AND blocked_to = $2) OR (blocked_by = $2 AND blocked_to = $1)
+			)`,
+			uid, author,
+		).Scan(&blocked)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to check blocklists: %w", err)
+		}
+		if blocked {
+			return 0, false, errLikeBlocked
+		}
+
+		result, err = tx.ExecContext(ctx,
+			"INSERT INTO "+target.likesTable+" (liked_by, "+target.idColumn+", liked_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			uid, id, time.Now(),
+		)
+	} else {
+		result, err = tx.ExecContext(ctx,
+			"DELETE FROM "+target.likesTable+" WHERE liked_by = $1 AND "+target.idColumn+" = $2",
+			uid, id,
+		)
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to update %s: %w", target.likesTable, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return likes, false, nil
+	}
+
+	if like {
+		likes++
+	} else {
+		likes--
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE "+target.table+" SET total_likes = $1 WHERE "+target.idColumn+" = $2",
+		likes, id,
+	); err != nil {
+		return 0, false, fmt.Errorf("failed to update %s likes: %w", target.table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit like: %w", err)
+	}
+	return likes, true, nil
+}
+
+// LikeComment likes a comment; liking it twice is a no-op
+func LikeComment(w http.ResponseWriter, r *http.Request) {
+	sendLike(w, r, "LikeComment", commentLikes, chi.URLParam(r, "commentID"), true)
+}
+
+// UnlikeComment removes the caller's like from a comment; removing a like that does not exist is a no-op
+func UnlikeComment(w http.ResponseWriter, r *http.Request) {
+	sendLike(w, r, "UnlikeComment", commentLikes, chi.URLParam(r, "commentID"), false)
+}
+
+// LikeReply likes a reply; liking it twice is a no-op
+func LikeReply(w http.ResponseWriter, r *http.Request) {
+	sendLike(w, r, "LikeReply", replyLikes, chi.URLParam(r, "replyID"), true)
+}
+
+// UnlikeReply removes the caller's like from a reply; removing a like that does not exist is a no-op
+func UnlikeReply(w http.ResponseWriter, r *http.Request) {
+	sendLike(w, r, "UnlikeReply", replyLikes, chi.URLParam(r, "replyID"), false)
+}
+
+func sendLike(w http.ResponseWriter, r *http.Request, handler string, target likeTarget, id string, like bool) {
+	claims := Auth.PrincipalFrom(r)
+
+	if id == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "ID is required")
+		return
+	}
+
+	likes, changed, err := setLike(r.Context(), target, claims.UID, id, like)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCommentNotFound):
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		case errors.Is(err, ErrReplyNotFound):
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Reply not found")
+		case errors.Is(err, errLikeBlocked):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot like this "+target.name)
+		default:
+			log.Printf("%s: %v", handler, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update like")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		target.idColumn: id,
+		"liked":         like,
+		"total_likes":   likes,
+		"changed":       changed,
+	})
+}
@@ -0,0 +1,356 @@
+package social
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// Muted word kinds
+const (
+	MutedKeyword = "keyword" // Matches the title or description of a video
+	MutedTag     = "tag"     // Matches a tag of a video
+)
+
+const (
+	MaxMutedWords      = 200
+	MaxMutedWordLength = 100
+)
+
+// Mute hides a user's videos from the feeds and their comments from the muter
+// Mutes are private: the muted user is not told and follows are untouched
+func Mute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Mute: failed to fetch user to mute: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	// Prevent self-mute
+	if claims.UID == userUID {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You cannot mute yourself")
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"INSERT INTO mutes (muted_by, muted_to, muted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		claims.UID, userUID, time.Now(),
+	)
+	if err != nil {
+		log.Printf("Mute: failed to insert mute: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mute user")
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You have already muted this user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Muted successfully"})
+}
+
+// Unmute removes a mute
+func Unmute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	username := chi.URLParam(r, "username")
+	if username == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Username is required")
+		return
+	}
+
+	userUID, err := fetchUserUID(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Unmute: failed to fetch user to unmute: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"DELETE FROM mutes WHERE muted_by = $1 AND muted_to = $2",
+		claims.UID, userUID,
+	)
+	if err != nil {
+		log.Printf("Unmute: failed to delete mute: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unmute user")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("Unmute: failed to check delete result: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check delete result")
+		return
+	}
+	if rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You have not muted this user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Unmuted successfully"})
+}
+
+// ListMuted lists the users the authenticated user has muted, newest first
+func ListMuted(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	// Get pagination parameters from query string
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+			if limit > 100 {
+				limit = 100
+			}
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT m.id, m.muted_by, m.muted_to, u.username as muted_to_username, m.muted_at,
+			COUNT(*) OVER() as total_count
+		FROM mutes m
+		JOIN users u ON m.muted_to = u.uid
+		WHERE m.muted_by = $1
+		ORDER BY m.muted_at DESC
+		LIMIT $2 OFFSET $3`,
+		claims.UID, limit, offset,
+	)
+	if err != nil {
+		log.Printf("ListMuted: failed to list muted users: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list muted users")
+		return
+	}
+	defer rows.Close()
+
+	muted := []Mutes{}
+	var count int
+	for rows.Next() {
+		var mute Mutes
+		if err := rows.Scan(
+			&mute.ID,
+			&mute.MutedBy,
+			&mute.MutedTo,
+			&mute.MutedToUsername,
+			&mute.MutedAt,
+			&count, // total_count from window function (same value for all rows)
+		); err != nil {
+			log.Printf("ListMuted: failed to scan mute: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode muted user")
+			return
+		}
+		muted = append(muted, mute)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("ListMuted: failed to iterate muted users: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate muted users")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"muted":  muted,
+		"limit":  limit,
+		"offset": offset,
+		"count":  count,
+	})
+}
+
+// ListMutedWords lists the keywords and tags the authenticated user has muted
+func ListMutedWords(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, user_uid, word, kind, created_at
+		FROM muted_words
+		WHERE user_uid = $1
+		ORDER BY kind, word`,
+		claims.UID,
+	)
+	if err != nil {
+		log.Printf("ListMutedWords: failed to list muted words: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to list muted words")
+		return
+	}
+	defer rows.Close()
+
+	words := []MutedWords{}
+	for rows.Next() {
+		var word MutedWords
+		if err := rows.Scan(&word.ID, &word.UserUID, &word.Word, &word.Kind, &word.CreatedAt); err != nil {
+			log.Printf("ListMutedWords: failed to scan muted word: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to decode muted word")
+			return
+		}
+		words = append(words, word)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Printf("ListMutedWords: failed to iterate muted words: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to iterate muted words")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"muted_words": words,
+		"count":       len(words),
+	})
+}
+
+// MuteWord mutes a keyword or tag for the authenticated user
+// Body: {"word": "spoilers", "kind": "keyword"}; kind defaults to keyword
+func MuteWord(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("MuteWord: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Word string `json:"word"`
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+
+	word := strings.ToLower(strings.TrimSpace(input.Word))
+	kind := strings.ToLower(strings.TrimSpace(input.Kind))
+	if kind == "" {
+		kind = MutedKeyword
+	}
+	if kind == MutedTag {
+		word = strings.TrimPrefix(word, "#")
+	}
+	if kind != MutedKeyword && kind != MutedTag {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Kind must be keyword or tag")
+		return
+	}
+	if word == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Word is required")
+		return
+	}
+	if utf8.RuneCountInString(word) > MaxMutedWordLength {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Word must be at most %d characters", MaxMutedWordLength))
+		return
+	}
+
+	var count int
+	err = Mdb.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM muted_words WHERE user_uid = $1", claims.UID).Scan(&count)
+	if err != nil {
+		log.Printf("MuteWord: failed to count muted words: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mute word")
+		return
+	}
+	if count >= MaxMutedWords {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("You can mute at most %d words", MaxMutedWords))
+		return
+	}
+
+	var mutedWord MutedWords
+	err = Mdb.DB.QueryRowContext(ctx,
+		`INSERT INTO muted_words (user_uid, word, kind, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING id, user_uid, word, kind, created_at`,
+		claims.UID, word, kind, time.Now(),
+	).Scan(&mutedWord.ID, &mutedWord.UserUID, &mutedWord.Word, &mutedWord.Kind, &mutedWord.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "You have already muted this word")
+			return
+		}
+		log.Printf("MuteWord: failed to insert muted word: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to mute word")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":    "Word muted successfully",
+		"muted_word": mutedWord,
+	})
+}
+
+// UnmuteWord removes a muted keyword or tag by its ID
+func UnmuteWord(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	wordID, err := strconv.Atoi(chi.URLParam(r, "wordID"))
+	if err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid word ID")
+		return
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		"DELETE FROM muted_words WHERE id = $1 AND user_uid = $2",
+		wordID, claims.UID,
+	)
+	if err != nil {
+		log.Printf("UnmuteWord: failed to delete muted word: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unmute word")
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Printf("UnmuteWord: failed to check delete result: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check delete result")
+		return
+	}
+	if rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Muted word not found")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Word unmuted successfully"})
+}
@@ -0,0 +1,104 @@
+package social
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+func TestMute(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	router := testRouter()
+
+	Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/follow/"+bob.Username, "")
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/mute/"+bob.Username, ""); code != http.StatusOK {
+		t.Fatalf("mute: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/mute/"+bob.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("mute twice: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/mute/"+alice.Username, ""); code != http.StatusBadRequest {
+		t.Errorf("mute oneself: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Mutes leave follows and their counters alone
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_by = $1 AND followed_to = $2", alice.UID, bob.UID); got != 1 {
+		t.Error("mute removed the follow")
+	}
+	if got := Testdb.QueryInt(t, "SELECT followers FROM users WHERE uid = $1", bob.UID); got != 1 {
+		t.Errorf("bob's followers = %d after the mute, want 1", got)
+	}
+
+	// Comments of the muted user are hidden from the muter only
+	videoID := Testdb.CreateVideo(t, carol)
+	Testdb.Request(t, router, bob.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "bob"}`)
+	Testdb.Request(t, router, carol.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "carol"}`)
+	if authors := commentAuthors(t, router, alice.UID, videoID); authors[bob.UID] || !authors[carol.UID] {
+		t.Errorf("alice sees comments by %v, want carol's but not bob's", authors)
+	}
+	for _, user := range []Testdb.User{bob, carol} {
+		if authors := commentAuthors(t, router, user.UID, videoID); !authors[bob.UID] {
+			t.Errorf("%s does not see bob's comment", user.Username)
+		}
+	}
+
+	// The muted user can still follow, comment and reply as before
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/users/follow/"+alice.Username, ""); code != http.StatusOK {
+		t.Errorf("muted user following the muter: status %d", code)
+	}
+
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/unmute/"+bob.Username, ""); code != http.StatusOK {
+		t.Fatalf("unmute: status %d", code)
+	}
+	if authors := commentAuthors(t, router, alice.UID, videoID); !authors[bob.UID] {
+		t.Error("bob's comment still hidden after unmuting")
+	}
+}
+
+func TestMuteWords(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+	router := testRouter()
+
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/muted-words", `{"word": " Spoilers "}`); code != http.StatusOK {
+		t.Fatalf("mute keyword: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/muted-words", `{"word": "#Leak", "kind": "tag"}`); code != http.StatusOK {
+		t.Fatalf("mute tag: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/muted-words", `{"word": "spoilers"}`); code != http.StatusBadRequest {
+		t.Errorf("mute a keyword twice: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/muted-words", `{"word": "x", "kind": "user"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown kind: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/users/muted-words", `{"word": " "}`); code != http.StatusBadRequest {
+		t.Errorf("empty word: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Words are stored lowercased, tags without their #
+	if got := Testdb.QueryInt(t,
+		`SELECT COUNT(*) FROM muted_words WHERE user_uid = $1
+			AND ((word = 'spoilers' AND kind = $2) OR (word = 'leak' AND kind = $3))`,
+		alice.UID, MutedKeyword, MutedTag,
+	); got != 2 {
+		t.Errorf("%d normalized muted words stored, want 2", got)
+	}
+
+	var id int
+	if err := Mdb.DB.QueryRow("SELECT id FROM muted_words WHERE user_uid = $1 AND word = 'leak'", alice.UID).Scan(&id); err != nil {
+		t.Fatalf("failed to fetch muted word: %v", err)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodDelete, fmt.Sprintf("/users/muted-words/%d", id), ""); code != http.StatusOK {
+		t.Errorf("unmute word: status %d", code)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM muted_words WHERE user_uid = $1", alice.UID); got != 1 {
+		t.Errorf("%d muted words left, want 1", got)
+	}
+}
@@ -0,0 +1,161 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+var errNotVideoOwner = errors.New("not the video owner")
+
+// commentBadge is a mark the video owner puts on a comment of their video
+type commentBadge struct {
+	name   string // pin or heart, for messages
+	column string // pinned_at or hearted_at
+	field  string // pinned or hearted, for responses
+}
+
+var (
+	pinBadge   = commentBadge{"pin", "pinned_at", "pinned"}
+	heartBadge = commentBadge{"heart", "hearted_at", "hearted"}
+)
+
+// setCommentBadge pins or hearts (or unpins or unhearts) a comment as the owner of its video
+// changed is false when the comment already had (or did not have) the badge
+// Pinning a comment unpins the video's previous pinned comment
+func setCommentBadge(ctx context.Context, badge commentBadge, uid, commentID string, set bool) (bool, error) {
+	var videoID, videoOwner string
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT c.commented_to, v.user_uid
+		FROM comments c
+		JOIN videos v ON v.video_id = c.commented_to
+		WHERE c.comment_id = $1`,
+		commentID,
+	).Scan(&videoID, &videoOwner)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrCommentNotFound
+		}
+		return false, fmt.Errorf("failed to fetch comment: %w", err)
+	}
+	if uid != videoOwner {
+		return false, errNotVideoOwner
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize pins of a video before locking any comment, so two pins cannot both
+	// keep their comment pinned nor wait on each other's comments
+	if badge == pinBadge {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('pin:' || $1))", videoID); err != nil {
+			return false, fmt.Errorf("failed to lock video pins: %w", err)
+		}
+	}
+
+	var current *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT "+badge.column+" FROM comments WHERE comment_id = $1 FOR UPDATE",
+		commentID,
+	).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrCommentNotFound // Deleted in the meantime
+		}
+		return false, fmt.Errorf("failed to lock comment: %w", err)
+	}
+	if (current != nil) == set {
+		return false, nil
+	}
+
+	var value *time.Time
+	if set {
+		now := time.Now()
+		value = &now
+
+		if badge == pinBadge {
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE comments SET pinned_at = NULL WHERE commented_to = $1 AND pinned_at IS NOT NULL",
+				videoID,
+			); err != nil {
+				return false, fmt.Errorf("failed to unpin comment: %w", err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE comments SET "+badge.column+" = $1 WHERE comment_id = $2",
+		value, commentID,
+	); err != nil {
+		return false, fmt.Errorf("failed to update comment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit %s: %w", badge.name, err)
+	}
+	return true, nil
+}
+
+// PinComment pins a comment to the top of its video's comments, replacing the pinned one
+// Only the video owner can pin
+func PinComment(w http.ResponseWriter, r *http.Request) {
+	sendCommentBadge(w, r, "PinComment", pinBadge, true)
+}
+
+// UnpinComment unpins a comment; unpinning a comment that is not pinned is a no-op
+func UnpinComment(w http.ResponseWriter, r *http.Request) {
+	sendCommentBadge(w, r, "UnpinComment", pinBadge, false)
+}
+
+// HeartComment hearts a comment; only the video owner can heart
+func HeartComment(w http.ResponseWriter, r *http.Request) {
+	sendCommentBadge(w, r, "HeartComment", heartBadge, true)
+}
+
+// UnheartComment removes the video owner's heart from a comment
+func UnheartComment(w http.ResponseWriter, r *http.Request) {
+	sendCommentBadge(w, r, "UnheartComment", heartBadge, false)
+}
+
+func sendCommentBadge(w http.ResponseWriter, r *http.Request, handler string, badge commentBadge, set bool) {
+	claims := Auth.PrincipalFrom(r)
+
+	commentID := chi.URLParam(r, "commentID")
+	if commentID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Comment ID is required")
+		return
+	}
+
+	changed, err := setCommentBadge(r.Context(), badge, claims.UID, commentID, set)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCommentNotFound):
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		case errors.Is(err, errNotVideoOwner):
+			Utils.SendErrorResponse(w, http.StatusForbidden, "Only the video owner can "+badge.name+" comments")
+		default:
+			log.Printf("%s: %v", handler, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update comment")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"comment_id": commentID,
+		badge.field:  set,
+		"changed":    changed,
+	})
+}
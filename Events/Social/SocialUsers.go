@@ -1,34 +1,71 @@
 package social
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
 
+	Notifications "hifi/Events/Notifications"
 	Auth "hifi/Services/Auth"
 	Mdb "hifi/Services/Mdb"
 	Utils "hifi/Utils"
 )
 
+// HandleUsers sets up the routes for following, blocking and muting users
+// Events.Handler mounts them behind RequireAuth
 func HandleUsers(req chi.Router) {
-	req.Post("/follow/{username}", Follow)
-	req.Post("/unfollow/{username}", Unfollow)
-	req.Get("/followers", ListFollowers)
-	req.Get("/following", ListFollowing)
+	read := req.With(Auth.RequireScope(Auth.ScopeSocialRead))
+	write := req.With(Auth.RequireScope(Auth.ScopeSocialWrite))
+
+	write.Post("/follow/{username}", Follow)
+	write.Post("/unfollow/{username}", Unfollow)
+	read.Get("/followers", ListFollowers)
+	read.Get("/following", ListFollowing)
+
+	read.Get("/follow-requests/incoming", ListIncomingFollowRequests)
+	read.Get("/follow-requests/outgoing", ListOutgoingFollowRequests)
+	write.Post("/follow-requests/{username}/approve", ApproveFollowRequest)
+	write.Post("/follow-requests/{username}/reject", RejectFollowRequest)
+	write.Delete("/follow-requests/{username}", CancelFollowRequest)
+
+	write.Post("/block/{username}", Block)
+	write.Post("/unblock/{username}", Unblock)
+	read.Get("/blocked", ListBlocked)
+	read.Get("/blocked/{username}", IsBlocked)
+
+	write.Post("/mute/{username}", Mute)
+	write.Post("/unmute/{username}", Unmute)
+	read.Get("/muted", ListMuted)
+	read.Get("/muted-words", ListMutedWords)
+	write.Post("/muted-words", MuteWord)
+	write.Delete("/muted-words/{wordID}", UnmuteWord)
 }
 
+// lockUsers locks the users' rows for the rest of the transaction, in uid order so that
+// two transactions locking the same users (e.g. mutual follows) cannot deadlock
+func lockUsers(ctx context.Context, tx *sql.Tx, uids ...string) error {
+	_, err := tx.ExecContext(ctx,
+		"SELECT 1 FROM users WHERE uid = ANY($1) ORDER BY uid FOR UPDATE",
+		pq.Array(uids),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to lock users: %w", err)
+	}
+	return nil
+}
+
+// Follow follows a user; following a private account sends a follow request instead
 func Follow(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
@@ -38,10 +75,11 @@ func Follow(w http.ResponseWriter, r *http.Request) {
 
 	// Get the UID of the user to follow
 	var userUID string
+	var isPrivate bool
 	err := Mdb.DB.QueryRowContext(ctx,
-		"SELECT uid FROM users WHERE username = $1",
+		"SELECT uid, is_private FROM users WHERE username = $1",
 		username,
-	).Scan(&userUID)
+	).Scan(&userUID, &isPrivate)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
@@ -58,6 +96,18 @@ func Follow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Blocked users cannot follow each other
+	blocked, err := Blocked(ctx, claims.UID, userUID)
+	if err != nil {
+		log.Printf("Follow: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check block")
+		return
+	}
+	if blocked {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot follow this user")
+		return
+	}
+
 	// Check if already following
 	var existingID int
 	err = Mdb.DB.QueryRowContext(ctx,
@@ -74,9 +124,47 @@ func Follow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert follower relationship (using UIDs)
-	_, err = Mdb.DB.ExecContext(ctx,
-		"INSERT INTO followers (followed_by, followed_to, followed_at) VALUES ($1, $2, $3)",
+	// A private account approves its followers: record a pending request
+	if isPrivate {
+		result, err := Mdb.DB.ExecContext(ctx,
+			"INSERT INTO follow_requests (requested_by, requested_to, requested_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			claims.UID, userUID, time.Now(),
+		)
+		if err != nil {
+			log.Printf("Follow: failed to insert follow request: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to send follow request")
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "You have already requested to follow this user")
+			return
+		}
+
+		Utils.SendSuccessResponse(w, map[string]interface{}{
+			"message": "Follow request sent",
+			"pending": true,
+		})
+		return
+	}
+
+	// The follow and both counts change in one transaction so the counts cannot drift
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Follow: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockUsers(ctx, tx, claims.UID, userUID); err != nil {
+		log.Printf("Follow: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+
+	// Insert follower relationship (using UIDs); a concurrent duplicate follow inserts nothing
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO followers (followed_by, followed_to, followed_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
 		claims.UID, userUID, time.Now(),
 	)
 	if err != nil {
@@ -84,32 +172,52 @@ func Follow(w http.ResponseWriter, r *http.Request) {
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to insert follower")
 		return
 	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "You are already following this user")
+		return
+	}
 
 	// Update follower counts
-	_, err = Mdb.DB.ExecContext(ctx, "UPDATE users SET followers = followers + 1 WHERE uid = $1", userUID)
+	_, err = tx.ExecContext(ctx, "UPDATE users SET followers = followers + 1 WHERE uid = $1", userUID)
 	if err != nil {
 		log.Printf("Follow: failed to update followers: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update followers")
 		return
 	}
 
-	_, err = Mdb.DB.ExecContext(ctx, "UPDATE users SET following = following + 1 WHERE uid = $1", claims.UID)
+	_, err = tx.ExecContext(ctx, "UPDATE users SET following = following + 1 WHERE uid = $1", claims.UID)
 	if err != nil {
 		log.Printf("Follow: failed to update following: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update following")
 		return
 	}
 
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Followed successfully"})
+	err = Notifications.Notify(ctx, tx, Notifications.Event{
+		Type:     Notifications.TypeFollow,
+		UserUID:  userUID,
+		ActorUID: claims.UID,
+	})
+	if err != nil {
+		log.Printf("Follow: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Follow: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to follow user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Followed successfully",
+		"pending": false,
+	})
 }
 
 func Unfollow(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
@@ -133,8 +241,23 @@ func Unfollow(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The follow and both counts change in one transaction so the counts cannot drift
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Unfollow: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unfollow user")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockUsers(ctx, tx, claims.UID, userUID); err != nil {
+		log.Printf("Unfollow: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unfollow user")
+		return
+	}
+
 	// Delete follower relationship (using UIDs)
-	result, err := Mdb.DB.ExecContext(ctx,
+	result, err := tx.ExecContext(ctx,
 		"DELETE FROM followers WHERE followed_by = $1 AND followed_to = $2",
 		claims.UID, userUID,
 	)
@@ -156,30 +279,32 @@ func Unfollow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update follower counts
-	_, err = Mdb.DB.ExecContext(ctx, "UPDATE users SET followers = followers - 1 WHERE uid = $1", userUID)
+	_, err = tx.ExecContext(ctx, "UPDATE users SET followers = followers - 1 WHERE uid = $1", userUID)
 	if err != nil {
 		log.Printf("Unfollow: failed to update followers: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update followers")
 		return
 	}
 
-	_, err = Mdb.DB.ExecContext(ctx, "UPDATE users SET following = following - 1 WHERE uid = $1", claims.UID)
+	_, err = tx.ExecContext(ctx, "UPDATE users SET following = following - 1 WHERE uid = $1", claims.UID)
 	if err != nil {
 		log.Printf("Unfollow: failed to update following: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update following")
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		log.Printf("Unfollow: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to unfollow user")
+		return
+	}
+
 	Utils.SendSuccessResponse(w, map[string]string{"message": "Unfollowed successfully"})
 }
 
 func ListFollowers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Get pagination parameters from query string
 	limitStr := r.URL.Query().Get("limit")
@@ -261,11 +386,7 @@ func ListFollowers(w http.ResponseWriter, r *http.Request) {
 
 func ListFollowing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Get pagination parameters from query string
 	limitStr := r.URL.Query().Get("limit")
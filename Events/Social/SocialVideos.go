@@ -1,7 +1,6 @@
 package social
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -16,10 +15,12 @@ import (
 	"github.com/google/uuid"
 	blake3 "lukechampine.com/blake3"
 
+	Notifications "hifi/Events/Notifications"
 	Users "hifi/Events/Users"
 	Videos "hifi/Events/Videos"
 	Auth "hifi/Services/Auth"
 	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
 	Utils "hifi/Utils"
 )
 
@@ -32,22 +33,47 @@ func nullStringToPtr(ns sql.NullString) *string {
 }
 
 func HandleVideos(req chi.Router) {
-	req.Post("/upvote/{videoID}", Upvote)
-	req.Post("/downvote/{videoID}", Downvote)
-	req.Post("/comment/{videoID}", Comment)
-	req.Post("/reply/{commentID}", Reply)
-
-	req.Get("/comments/{videoID}", ListComments)
-	req.Get("/replies/{commentID}", ListReplies)
+	write := req.With(Auth.RequireAuth, Auth.RequireScope(Auth.ScopeSocialWrite))
+	write.Post("/upvote/{videoID}", Upvote)
+	write.Post("/downvote/{videoID}", Downvote)
+	write.Put("/vote/{videoID}", SetVote)
+	write.Delete("/vote/{videoID}", RemoveVote)
+	write.Post("/comment/{videoID}", Comment)
+	write.Post("/reply/{commentID}", Reply)
+
+	// Authors edit their comments and replies; authors and the video owner delete them
+	write.Patch("/comments/{commentID}", EditComment)
+	write.Delete("/comments/{commentID}", DeleteComment)
+	write.Patch("/replies/{replyID}", EditReply)
+	write.Delete("/replies/{replyID}", DeleteReply)
+
+	// Likes are idempotent: PUT likes, DELETE takes the like back
+	write.Put("/comments/{commentID}/like", LikeComment)
+	write.Delete("/comments/{commentID}/like", UnlikeComment)
+	write.Put("/replies/{replyID}/like", LikeReply)
+	write.Delete("/replies/{replyID}/like", UnlikeReply)
+
+	// The video owner pins one comment to the top and hearts comments
+	write.Put("/comments/{commentID}/pin", PinComment)
+	write.Delete("/comments/{commentID}/pin", UnpinComment)
+	write.Put("/comments/{commentID}/heart", HeartComment)
+	write.Delete("/comments/{commentID}/heart", UnheartComment)
+
+	// Public; authenticated callers do not see comments and replies of users they blocked,
+	// nor comments of users they muted
+	optional := req.With(Auth.OptionalAuth, Auth.RequireScope(Auth.ScopeSocialRead))
+	optional.Get("/comments/{videoID}", ListComments)
+	optional.Get("/replies/{commentID}", ListReplies)
+
+	// Public; anonymous views are deduplicated by a hash of the IP address and user agent
+	optional.Post("/view/{videoID}", RecordView)
 }
 
+// Upvote upvotes a video, replacing a downvote by the caller
+// Upvoting twice is a no-op; PUT or DELETE /vote/{videoID} takes the vote back
 func Upvote(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -55,121 +81,35 @@ func Upvote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if video exists
-	var video Videos.Videos
-	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, video_id, video_url, video_thumbnail, video_title, video_description, 
-			video_tags, video_views, video_upvotes, video_downvotes, video_comments, 
-			user_uid, user_username, created_at, updated_at
-		FROM videos WHERE video_id = $1`,
-		videoID,
-	).Scan(
-		&video.ID, &video.VideoID, &video.VideoURL, &video.VideoThumbnail,
-		&video.VideoTitle, &video.VideoDescription, &video.VideoTags,
-		&video.VideoViews, &video.VideoUpvotes, &video.VideoDownvotes,
-		&video.VideoComments, &video.UserUID, &video.UserUsername,
-		&video.CreatedAt, &video.UpdatedAt,
-	)
+	state, changed, err := setVote(ctx, claims.UID, videoID, VoteUp)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, errVideoNotFound) {
 			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
 		} else {
-			log.Printf("Upvote: failed to fetch video: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch video")
+			log.Printf("Upvote: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to upvote video")
 		}
 		return
 	}
 
-	// Check if already upvoted
-	var upvoteID int
-	err = Mdb.DB.QueryRowContext(ctx,
-		"SELECT id FROM upvotes WHERE upvoted_by = $1 AND upvoted_to = $2",
-		claims.UID, videoID,
-	).Scan(&upvoteID)
-	if err == nil {
-		// Already upvoted, remove downvote if exists
-		result, delErr := Mdb.DB.ExecContext(ctx,
-			"DELETE FROM downvotes WHERE downvoted_by = $1 AND downvoted_to = $2",
-			claims.UID, videoID,
-		)
-		if delErr == nil {
-			rowsAffected, _ := result.RowsAffected()
-			if rowsAffected > 0 {
-				_, updErr := Mdb.DB.ExecContext(ctx,
-					"UPDATE videos SET video_downvotes = video_downvotes - 1 WHERE video_id = $1",
-					videoID,
-				)
-				if updErr != nil {
-					log.Printf("Upvote: failed to update video downvotes: %v", updErr)
-					Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video downvotes")
-					return
-				}
-			}
-		}
-		Utils.SendSuccessResponse(w, map[string]string{"message": "Already upvoted, downvote removed if existed"})
-		return
-	}
-	if !errors.Is(err, sql.ErrNoRows) {
-		log.Printf("Upvote: failed to check existing upvote: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check existing upvote")
-		return
+	message := "Video upvoted"
+	if !changed {
+		message = "Already upvoted, downvote removed if existed"
 	}
-
-	// Remove any existing downvote first
-	result, delErr := Mdb.DB.ExecContext(ctx,
-		"DELETE FROM downvotes WHERE downvoted_by = $1 AND downvoted_to = $2",
-		claims.UID, videoID,
-	)
-	if delErr != nil {
-		log.Printf("Upvote: failed to remove previous downvote: %v", delErr)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to remove previous downvote")
-		return
-	}
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected > 0 {
-		_, updErr := Mdb.DB.ExecContext(ctx,
-			"UPDATE videos SET video_downvotes = video_downvotes - 1 WHERE video_id = $1",
-			videoID,
-		)
-		if updErr != nil {
-			log.Printf("Upvote: failed to update video downvotes: %v", updErr)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video downvotes")
-			return
-		}
-	}
-
-	// Insert upvote (ignore if already exists due to UNIQUE constraint)
-	_, err = Mdb.DB.ExecContext(ctx,
-		"INSERT INTO upvotes (upvoted_by, upvoted_to, upvoted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
-		claims.UID, videoID, time.Now(),
-	)
-	if err != nil {
-		log.Printf("Upvote: failed to upvote video: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to upvote video")
-		return
-	}
-
-	// Update video upvotes
-	_, err = Mdb.DB.ExecContext(ctx,
-		"UPDATE videos SET video_upvotes = video_upvotes + 1 WHERE video_id = $1",
-		videoID,
-	)
-	if err != nil {
-		log.Printf("Upvote: failed to update video: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video")
-		return
-	}
-
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Video upvoted"})
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":   message,
+		"video_id":  state.VideoID,
+		"vote":      state.Vote,
+		"upvotes":   state.Upvotes,
+		"downvotes": state.Downvotes,
+	})
 }
 
+// Downvote downvotes a video, replacing an upvote by the caller
+// Downvoting twice is a no-op; PUT or DELETE /vote/{videoID} takes the vote back
 func Downvote(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -177,121 +117,33 @@ func Downvote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if video exists
-	var video Videos.Videos
-	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, video_id, video_url, video_thumbnail, video_title, video_description, 
-			video_tags, video_views, video_upvotes, video_downvotes, video_comments, 
-			user_uid, user_username, created_at, updated_at
-		FROM videos WHERE video_id = $1`,
-		videoID,
-	).Scan(
-		&video.ID, &video.VideoID, &video.VideoURL, &video.VideoThumbnail,
-		&video.VideoTitle, &video.VideoDescription, &video.VideoTags,
-		&video.VideoViews, &video.VideoUpvotes, &video.VideoDownvotes,
-		&video.VideoComments, &video.UserUID, &video.UserUsername,
-		&video.CreatedAt, &video.UpdatedAt,
-	)
+	state, changed, err := setVote(ctx, claims.UID, videoID, VoteDown)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, errVideoNotFound) {
 			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
 		} else {
-			log.Printf("Downvote: failed to fetch video: %v", err)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch video")
-		}
-		return
-	}
-
-	// Check if already downvoted
-	var downvoteID int
-	err = Mdb.DB.QueryRowContext(ctx,
-		"SELECT id FROM downvotes WHERE downvoted_by = $1 AND downvoted_to = $2",
-		claims.UID, videoID,
-	).Scan(&downvoteID)
-	if err == nil {
-		// Already downvoted, remove upvote if exists
-		result, delErr := Mdb.DB.ExecContext(ctx,
-			"DELETE FROM upvotes WHERE upvoted_by = $1 AND upvoted_to = $2",
-			claims.UID, videoID,
-		)
-		if delErr == nil {
-			rowsAffected, _ := result.RowsAffected()
-			if rowsAffected > 0 {
-				_, updErr := Mdb.DB.ExecContext(ctx,
-					"UPDATE videos SET video_upvotes = video_upvotes - 1 WHERE video_id = $1",
-					videoID,
-				)
-				if updErr != nil {
-					log.Printf("Downvote: failed to update video upvotes: %v", updErr)
-					Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video upvotes")
-					return
-				}
-			}
+			log.Printf("Downvote: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to downvote video")
 		}
-		Utils.SendSuccessResponse(w, map[string]string{"message": "Already downvoted, upvote removed if existed"})
-		return
-	}
-	if !errors.Is(err, sql.ErrNoRows) {
-		log.Printf("Downvote: failed to check existing downvote: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check existing downvote")
-		return
-	}
-
-	// Remove any existing upvote first
-	result, delErr := Mdb.DB.ExecContext(ctx,
-		"DELETE FROM upvotes WHERE upvoted_by = $1 AND upvoted_to = $2",
-		claims.UID, videoID,
-	)
-	if delErr != nil {
-		log.Printf("Downvote: failed to remove previous upvote: %v", delErr)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to remove previous upvote")
-		return
-	}
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected > 0 {
-		_, updErr := Mdb.DB.ExecContext(ctx,
-			"UPDATE videos SET video_upvotes = video_upvotes - 1 WHERE video_id = $1",
-			videoID,
-		)
-		if updErr != nil {
-			log.Printf("Downvote: failed to update video upvotes: %v", updErr)
-			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video upvotes")
-			return
-		}
-	}
-
-	// Insert downvote
-	_, err = Mdb.DB.ExecContext(ctx,
-		"INSERT INTO downvotes (downvoted_by, downvoted_to, downvoted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
-		claims.UID, videoID, time.Now(),
-	)
-	if err != nil {
-		log.Printf("Downvote: failed to downvote video: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to downvote video")
 		return
 	}
 
-	// Update video downvotes
-	_, err = Mdb.DB.ExecContext(ctx,
-		"UPDATE videos SET video_downvotes = video_downvotes + 1 WHERE video_id = $1",
-		videoID,
-	)
-	if err != nil {
-		log.Printf("Downvote: failed to update video: %v", err)
-		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video")
-		return
+	message := "Video downvoted"
+	if !changed {
+		message = "Already downvoted, upvote removed if existed"
 	}
-
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Video downvoted"})
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":   message,
+		"video_id":  state.VideoID,
+		"vote":      state.Vote,
+		"upvotes":   state.Upvotes,
+		"downvotes": state.Downvotes,
+	})
 }
 
 func Comment(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -338,17 +190,41 @@ func Comment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Videos of private accounts the caller cannot see do not exist for them
+	visible, err := Videos.CanView(ctx, Mdb.DB, videoID, claims.UID)
+	if err != nil {
+		log.Printf("Comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch video")
+		return
+	}
+	if !visible {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	// Users blocked by (or blocking) the video owner cannot comment
+	blocked, err := Blocked(ctx, claims.UID, video.UserUID)
+	if err != nil {
+		log.Printf("Comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check block")
+		return
+	}
+	if blocked {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot comment on this video")
+		return
+	}
+
 	// Get user
 	var user Users.User
 	var bioNull, emailNull sql.NullString
 	err = Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		claims.UID,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -371,19 +247,17 @@ func Comment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = Mdb.DB.ExecContext(ctx,
-		`INSERT INTO comments (comment_id, commented_by, commented_to, commented_at, comment, comment_by_username, total_replies)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		commentID, claims.UID, videoID, time.Now(), commentText, user.Username, 0,
-	)
+	// The comment and the video's comment count change in one transaction so the count cannot drift
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Comment: failed to comment on video: %v", err)
+		log.Printf("Comment: failed to begin transaction: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
 		return
 	}
+	defer tx.Rollback()
 
-	// Update video comment count
-	_, err = Mdb.DB.ExecContext(ctx,
+	// Update video comment count first: it locks the video row and finds a video deleted in the meantime
+	result, err := tx.ExecContext(ctx,
 		"UPDATE videos SET video_comments = video_comments + 1 WHERE video_id = $1",
 		videoID,
 	)
@@ -392,17 +266,83 @@ func Comment(w http.ResponseWriter, r *http.Request) {
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update video")
 		return
 	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		return
+	}
 
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Video commented"})
+	commentedAt := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO comments (comment_id, commented_by, commented_to, commented_at, comment, comment_by_username, total_replies)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		commentID, claims.UID, videoID, commentedAt, commentText, user.Username, 0,
+	)
+	if err != nil {
+		log.Printf("Comment: failed to comment on video: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
+		return
+	}
+
+	mentions, mentioned, err := Users.SaveMentions(ctx, tx, Users.MentionInComment, commentID, claims.UID, commentText)
+	if err != nil {
+		log.Printf("Comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
+		return
+	}
+
+	// Comments on a video are aggregated per video, mentions per comment
+	err = Notifications.Notify(ctx, tx, Notifications.Event{
+		Type:     Notifications.TypeComment,
+		UserUID:  video.UserUID,
+		ActorUID: claims.UID,
+		VideoID:  videoID,
+	})
+	if err == nil {
+		err = Notifications.NotifyUsers(ctx, tx, Notifications.Event{
+			Type:      Notifications.TypeMention,
+			ActorUID:  claims.UID,
+			VideoID:   videoID,
+			CommentID: commentID,
+		}, mentioned)
+	}
+	if err != nil {
+		log.Printf("Comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
+		return
+	}
+
+	// Delivered to the streams watching the video once the transaction commits
+	err = Realtime.Publish(ctx, tx, Realtime.VideoTopic(videoID), "comment", claims.UID, Comments{
+		CommentID:         commentID,
+		CommentedBy:       claims.UID,
+		CommentedTo:       videoID,
+		CommentedAt:       commentedAt,
+		Comment:           commentText,
+		CommentByUsername: user.Username,
+		Mentions:          mentions,
+	})
+	if err != nil {
+		log.Printf("Comment: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Comment: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to comment on video")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":    "Video commented",
+		"comment_id": commentID,
+		"mentions":   mentions,
+	})
 }
 
 func Reply(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	commentID := chi.URLParam(r, "commentID")
 	if commentID == "" {
@@ -446,17 +386,50 @@ func Reply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Comments on videos the caller cannot see do not exist for them
+	visible, err := Videos.CanView(ctx, Mdb.DB, comment.CommentedTo, claims.UID)
+	if err != nil {
+		log.Printf("Reply: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch comment")
+		return
+	}
+	if !visible {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	// Users blocked by (or blocking) the comment author or the video owner cannot reply
+	var blocked bool
+	err = Mdb.DB.QueryRowContext(ctx,
+		`SELECT EXISTS(
+			SELECT 1 FROM blocklists b
+			JOIN videos v ON v.video_id = $3
+			WHERE (b.blocked_by = $1 AND b.blocked_to IN ($2, v.user_uid))
+				OR (b.blocked_to = $1 AND b.blocked_by IN ($2, v.user_uid))
+		)`,
+		claims.UID, comment.CommentedBy, comment.CommentedTo,
+	).Scan(&blocked)
+	if err != nil {
+		log.Printf("Reply: failed to check blocklists: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to check block")
+		return
+	}
+	if blocked {
+		Utils.SendErrorResponse(w, http.StatusForbidden, "You cannot reply to this comment")
+		return
+	}
+
 	// Get user
 	var user Users.User
 	var bioNull, emailNull sql.NullString
 	err = Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		claims.UID,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -475,24 +448,67 @@ func Reply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The reply and the comment's reply count change in one transaction so the count cannot drift
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Reply: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reply to comment")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the comment: replies to the same comment are applied one at a time, so two
+	// concurrent replies by the same user cannot both be inserted
+	var locked int
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM comments WHERE comment_id = $1 FOR UPDATE",
+		commentID,
+	).Scan(&locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Comment not found")
+		} else {
+			log.Printf("Reply: failed to lock comment: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch comment")
+		}
+		return
+	}
+
 	// Check if reply already exists
-	var existingReplyID int
-	err = Mdb.DB.QueryRowContext(ctx,
-		"SELECT id FROM replies WHERE replied_to = $1 AND replied_by = $2",
+	var existingReplyID, existingReply string
+	err = tx.QueryRowContext(ctx,
+		"SELECT reply_id, reply FROM replies WHERE replied_to = $1 AND replied_by = $2 FOR UPDATE",
 		commentID, claims.UID,
-	).Scan(&existingReplyID)
+	).Scan(&existingReplyID, &existingReply)
 	if err == nil {
-		// Reply exists, update it
-		_, err = Mdb.DB.ExecContext(ctx,
-			"UPDATE replies SET reply = $1 WHERE replied_to = $2 AND replied_by = $3",
-			replyText, commentID, claims.UID,
-		)
-		if err != nil {
-			log.Printf("Reply: failed to update reply: %v", err)
+		// Reply exists, update it (an edit, so the previous text is kept)
+		if existingReply != replyText {
+			if _, err := updateReply(ctx, tx, existingReplyID, claims.UID, existingReply, replyText); err != nil {
+				log.Printf("Reply: %v", err)
+				Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update reply to comment")
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Reply: failed to commit: %v", err)
 			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update reply to comment")
 			return
 		}
-		Utils.SendSuccessResponse(w, map[string]string{"message": "Reply updated"})
+		loaded, err := Users.LoadMentions(ctx, Mdb.DB, Users.MentionInReply, []string{existingReplyID})
+		if err != nil {
+			log.Printf("Reply: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+			return
+		}
+		mentions := loaded[existingReplyID]
+		if mentions == nil {
+			mentions = []Users.Mention{}
+		}
+		Utils.SendSuccessResponse(w, map[string]interface{}{
+			"message":  "Reply updated",
+			"reply_id": existingReplyID,
+			"mentions": mentions,
+		})
 		return
 	}
 	if !errors.Is(err, sql.ErrNoRows) {
@@ -503,10 +519,11 @@ func Reply(w http.ResponseWriter, r *http.Request) {
 
 	// Insert new reply
 	replyID := fmt.Sprintf("%x", blake3.Sum256([]byte(claims.UID+time.Now().Format(time.RFC3339)+uuid.New().String()+commentID)))
-	_, err = Mdb.DB.ExecContext(ctx,
+	repliedAt := time.Now()
+	_, err = tx.ExecContext(ctx,
 		`INSERT INTO replies (reply_id, replied_by, replied_to, replied_at, reply, reply_by_username)
 		VALUES ($1, $2, $3, $4, $5, $6)`,
-		replyID, claims.UID, commentID, time.Now(), replyText, user.Username,
+		replyID, claims.UID, commentID, repliedAt, replyText, user.Username,
 	)
 	if err != nil {
 		log.Printf("Reply: failed to insert reply: %v", err)
@@ -514,8 +531,38 @@ func Reply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mentions, mentioned, err := Users.SaveMentions(ctx, tx, Users.MentionInReply, replyID, claims.UID, replyText)
+	if err != nil {
+		log.Printf("Reply: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to insert reply")
+		return
+	}
+
+	// Replies to a comment are aggregated per comment, mentions per reply
+	err = Notifications.Notify(ctx, tx, Notifications.Event{
+		Type:      Notifications.TypeReply,
+		UserUID:   comment.CommentedBy,
+		ActorUID:  claims.UID,
+		VideoID:   comment.CommentedTo,
+		CommentID: commentID,
+	})
+	if err == nil {
+		err = Notifications.NotifyUsers(ctx, tx, Notifications.Event{
+			Type:      Notifications.TypeMention,
+			ActorUID:  claims.UID,
+			VideoID:   comment.CommentedTo,
+			CommentID: commentID,
+			ReplyID:   replyID,
+		}, mentioned)
+	}
+	if err != nil {
+		log.Printf("Reply: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to insert reply")
+		return
+	}
+
 	// Update comment reply count
-	_, err = Mdb.DB.ExecContext(ctx,
+	_, err = tx.ExecContext(ctx,
 		"UPDATE comments SET total_replies = total_replies + 1 WHERE comment_id = $1",
 		commentID,
 	)
@@ -525,7 +572,33 @@ func Reply(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	Utils.SendSuccessResponse(w, map[string]string{"message": "Reply added"})
+	// Delivered to the streams watching the video once the transaction commits
+	err = Realtime.Publish(ctx, tx, Realtime.VideoTopic(comment.CommentedTo), "reply", claims.UID, Replies{
+		ReplyID:         replyID,
+		RepliedBy:       claims.UID,
+		RepliedTo:       commentID,
+		RepliedAt:       repliedAt,
+		Reply:           replyText,
+		ReplyByUsername: user.Username,
+		Mentions:        mentions,
+	})
+	if err != nil {
+		log.Printf("Reply: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reply to comment")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Reply: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to reply to comment")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message":  "Reply added",
+		"reply_id": replyID,
+		"mentions": mentions,
+	})
 }
 
 func ListComments(w http.ResponseWriter, r *http.Request) {
@@ -558,17 +631,50 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get comments ordered by timestamp (newest first) with total count in single query
+	// Comments of users the caller blocked or muted are left out ('' matches nobody for anonymous callers)
+	viewerUID := ""
+	if claims := Auth.PrincipalFrom(r); claims != nil {
+		viewerUID = claims.UID
+	}
+	args := []interface{}{videoID, limit, offset, viewerUID}
+
+	// Sort order: newest (default), oldest or top; the pinned comment comes first in every order
+	// top ranks by likes decayed by age: (likes + 1) / (age in hours + 2)^TopCommentsGravity
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = CommentSortNewest
+	}
+	var orderBy string
+	switch sort {
+	case CommentSortNewest:
+		orderBy = "commented_at DESC, id DESC"
+	case CommentSortOldest:
+		orderBy = "commented_at ASC, id ASC"
+	case CommentSortTop:
+		orderBy = fmt.Sprintf(
+			"(total_likes + 1) / POWER(GREATEST(EXTRACT(EPOCH FROM ($5::timestamp - commented_at)) / 3600, 0) + 2, %g) DESC, commented_at DESC, id DESC",
+			TopCommentsGravity,
+		)
+		args = append(args, time.Now())
+	default:
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Sort must be top, newest or oldest")
+		return
+	}
+
+	// Get comments in the requested order with total count in single query
 	// Use window function COUNT(*) OVER() to get total count without separate query
 	rows, err := Mdb.DB.QueryContext(ctx,
 		`SELECT id, comment_id, commented_by, commented_to, commented_at, comment, 
-			comment_by_username, total_replies,
+			comment_by_username, total_replies, edited_at, total_likes, pinned_at, hearted_at,
+			EXISTS(SELECT 1 FROM comment_likes l WHERE l.comment_id = comments.comment_id AND l.liked_by = $4) as liked,
 			COUNT(*) OVER() as total_count
 		FROM comments 
 		WHERE commented_to = $1 
-		ORDER BY commented_at DESC
+			AND NOT EXISTS (SELECT 1 FROM blocklists WHERE blocked_by = $4 AND blocked_to = commented_by)
+			AND NOT EXISTS (SELECT 1 FROM mutes WHERE muted_by = $4 AND muted_to = commented_by)
+		ORDER BY pinned_at IS NOT NULL DESC, `+orderBy+`
 		LIMIT $2 OFFSET $3`,
-		videoID, limit, offset,
+		args...,
 	)
 	if err != nil {
 		log.Printf("ListComments: failed to find comments: %v", err)
@@ -584,7 +690,9 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 		err := rows.Scan(
 			&comment.ID, &comment.CommentID, &comment.CommentedBy, &comment.CommentedTo,
 			&comment.CommentedAt, &comment.Comment, &comment.CommentByUsername,
-			&comment.TotalReplies, &count, // total_count from window function
+			&comment.TotalReplies, &comment.EditedAt, &comment.TotalLikes,
+			&comment.PinnedAt, &comment.HeartedAt, &comment.Liked,
+			&count, // total_count from window function
 		)
 		if err != nil {
 			log.Printf("ListComments: failed to scan comment: %v", err)
@@ -600,6 +708,20 @@ func ListComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	commentIDs := make([]string, len(comments))
+	for i, comment := range comments {
+		commentIDs[i] = comment.CommentID
+	}
+	mentions, err := Users.LoadMentions(ctx, Mdb.DB, Users.MentionInComment, commentIDs)
+	if err != nil {
+		log.Printf("ListComments: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].CommentID]
+	}
+
 	Utils.SendSuccessResponse(w, map[string]interface{}{
 		"comments": comments,
 		"limit":    limit,
@@ -638,16 +760,24 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Replies of users the caller blocked are left out ('' matches nobody for anonymous callers)
+	viewerUID := ""
+	if claims := Auth.PrincipalFrom(r); claims != nil {
+		viewerUID = claims.UID
+	}
+
 	// Get replies ordered by timestamp (newest first) with total count in single query
 	// Use window function COUNT(*) OVER() to get total count without separate query
 	rows, err := Mdb.DB.QueryContext(ctx,
-		`SELECT id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username,
+		`SELECT id, reply_id, replied_by, replied_to, replied_at, reply, reply_by_username, edited_at, total_likes,
+			EXISTS(SELECT 1 FROM reply_likes l WHERE l.reply_id = replies.reply_id AND l.liked_by = $4) as liked,
 			COUNT(*) OVER() as total_count
 		FROM replies 
 		WHERE replied_to = $1 
+			AND NOT EXISTS (SELECT 1 FROM blocklists WHERE blocked_by = $4 AND blocked_to = replied_by)
 		ORDER BY replied_at DESC
 		LIMIT $2 OFFSET $3`,
-		commentID, limit, offset,
+		commentID, limit, offset, viewerUID,
 	)
 	if err != nil {
 		log.Printf("ListReplies: failed to find replies: %v", err)
@@ -662,7 +792,8 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 		var reply Replies
 		err := rows.Scan(
 			&reply.ID, &reply.ReplyID, &reply.RepliedBy, &reply.RepliedTo,
-			&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername, &count, // total_count from window function
+			&reply.RepliedAt, &reply.Reply, &reply.ReplyByUsername, &reply.EditedAt, &reply.TotalLikes, &reply.Liked,
+			&count, // total_count from window function
 		)
 		if err != nil {
 			log.Printf("ListReplies: failed to scan reply: %v", err)
@@ -678,6 +809,20 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	replyIDs := make([]string, len(replies))
+	for i, reply := range replies {
+		replyIDs[i] = reply.ReplyID
+	}
+	mentions, err := Users.LoadMentions(ctx, Mdb.DB, Users.MentionInReply, replyIDs)
+	if err != nil {
+		log.Printf("ListReplies: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+	for i := range replies {
+		replies[i].Mentions = mentions[replies[i].ReplyID]
+	}
+
 	Utils.SendSuccessResponse(w, map[string]interface{}{
 		"replies": replies,
 		"limit":   limit,
@@ -685,16 +830,3 @@ func ListReplies(w http.ResponseWriter, r *http.Request) {
 		"count":   count,
 	})
 }
-
-func View(ctx context.Context, auth bool, claims *Auth.Token, videoID string) error {
-	// Increment video views counter (simple count, no authentication-based tracking)
-	_, err := Mdb.DB.ExecContext(ctx,
-		"UPDATE videos SET video_views = video_views + 1 WHERE video_id = $1",
-		videoID,
-	)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,60 @@
+package social
+
+import (
+	"net/http"
+	"testing"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+func TestPrivateAccountInteractions(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	videoID := Testdb.CreateVideo(t, alice)
+	router := testRouter()
+
+	if _, err := Mdb.DB.Exec("UPDATE users SET is_private = TRUE WHERE uid = $1", alice.UID); err != nil {
+		t.Fatalf("failed to make account private: %v", err)
+	}
+	if _, err := Mdb.DB.Exec("INSERT INTO followers (followed_by, followed_to) VALUES ($1, $2)", bob.UID, alice.UID); err != nil {
+		t.Fatalf("failed to follow: %v", err)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "alice"}`); code != http.StatusOK {
+		t.Fatalf("owner commenting: status %d", code)
+	}
+	var commentID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1", videoID).Scan(&commentID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+
+	// Followers can interact with the video; for anyone else it does not exist
+	requests := []struct {
+		name, method, path, body string
+	}{
+		{name: "comment", method: http.MethodPost, path: "/videos/comment/" + videoID, body: `{"comment": "hi"}`},
+		{name: "reply", method: http.MethodPost, path: "/videos/reply/" + commentID, body: `{"reply": "hi"}`},
+		{name: "upvote", method: http.MethodPost, path: "/videos/upvote/" + videoID},
+		{name: "downvote", method: http.MethodPost, path: "/videos/downvote/" + videoID},
+		{name: "vote", method: http.MethodPut, path: "/videos/vote/" + videoID, body: `{"vote": "up"}`},
+	}
+	for _, req := range requests {
+		if code := Testdb.Request(t, router, carol.UID, req.method, req.path, req.body); code != http.StatusNotFound {
+			t.Errorf("non-follower %s: status %d, want %d", req.name, code, http.StatusNotFound)
+		}
+		if code := Testdb.Request(t, router, bob.UID, req.method, req.path, req.body); code != http.StatusOK {
+			t.Errorf("follower %s: status %d, want %d", req.name, code, http.StatusOK)
+		}
+	}
+
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE commented_to = $1 AND commented_by = $2", videoID, carol.UID); got != 0 {
+		t.Errorf("%d comments by the non-follower, want 0", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM replies WHERE replied_by = $1", carol.UID); got != 0 {
+		t.Errorf("%d replies by the non-follower, want 0", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_upvotes + video_downvotes FROM videos WHERE video_id = $1", videoID); got != 1 {
+		t.Errorf("video has %d votes, want the follower's only", got)
+	}
+}
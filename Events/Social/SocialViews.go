@@ -0,0 +1,162 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	blake3 "lukechampine.com/blake3"
+
+	Videos "hifi/Events/Videos"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+const (
+	MinViewWatchSeconds = 3              // Watch time a client must report before a view counts
+	AnonymousViewWindow = 24 * time.Hour // An anonymous viewer counts once per video within this window
+)
+
+// viewerHash identifies an anonymous viewer by their IP address and user agent
+// Only the salted hash is stored (VIEW_HASH_SALT)
+func viewerHash(r *http.Request) string {
+	sum := blake3.Sum256([]byte(Utils.ViewHashSalt + "\n" + Utils.ClientIP(r) + "\n" + r.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordView records a view of a video and reports whether it counted
+// An authenticated user counts once per video; an anonymous viewer once per video within AnonymousViewWindow
+// videos.video_views is kept in step with the views by triggers (migration 025)
+func recordView(ctx context.Context, viewerUID, hash, videoID string) (bool, error) {
+	if viewerUID != "" {
+		result, err := Mdb.DB.ExecContext(ctx,
+			"INSERT INTO views (viewed_by, viewed_to, viewed_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			viewerUID, videoID, time.Now(),
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to insert view: %w", err)
+		}
+		rowsAffected, _ := result.RowsAffected()
+		return rowsAffected > 0, nil
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize views of a video by the same hash, so concurrent requests cannot both pass the window check
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))", hash, videoID); err != nil {
+		return false, fmt.Errorf("failed to lock viewer: %w", err)
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO anonymous_views (viewer_hash, viewed_to, viewed_at)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (
+			SELECT 1 FROM anonymous_views
+			WHERE viewer_hash = $1 AND viewed_to = $2 AND viewed_at > $4
+		)`,
+		hash, videoID, now, now.Add(-AnonymousViewWindow),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert anonymous view: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit view: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// RecordView counts a view of a video once the client has watched it for MinViewWatchSeconds
+// Body: {"watch_seconds": 12.5}; reporting again for the same viewer does not count twice
+func RecordView(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	videoID := chi.URLParam(r, "videoID")
+	if videoID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Video ID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("RecordView: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		WatchSeconds float64 `json:"watch_seconds"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+	if input.WatchSeconds < 0 {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Watch seconds must not be negative")
+		return
+	}
+
+	viewerUID := ""
+	if claims != nil {
+		viewerUID = claims.UID
+	}
+
+	visible, err := Videos.CanView(ctx, Mdb.DB, videoID, viewerUID)
+	if err != nil {
+		log.Printf("RecordView: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch video")
+		return
+	}
+	if !visible {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		return
+	}
+
+	counted := false
+	if input.WatchSeconds >= MinViewWatchSeconds {
+		hash := ""
+		if viewerUID == "" {
+			hash = viewerHash(r)
+		}
+		counted, err = recordView(ctx, viewerUID, hash, videoID)
+		if err != nil {
+			log.Printf("RecordView: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to record view")
+			return
+		}
+	}
+
+	var views int
+	err = Mdb.DB.QueryRowContext(ctx, "SELECT video_views FROM videos WHERE video_id = $1", videoID).Scan(&views)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		} else {
+			log.Printf("RecordView: failed to fetch views: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch views")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"video_id":    videoID,
+		"counted":     counted,
+		"video_views": views,
+	})
+}
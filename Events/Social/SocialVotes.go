@@ -0,0 +1,221 @@
+package social
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Notifications "hifi/Events/Notifications"
+	Videos "hifi/Events/Videos"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// Vote states of a user on a video
+const (
+	VoteUp   = "up"
+	VoteDown = "down"
+	VoteNone = "none"
+)
+
+var errVideoNotFound = errors.New("video not found")
+
+// VoteState is a video's vote counts and the caller's vote on it
+type VoteState struct {
+	VideoID   string `json:"video_id"`
+	Vote      string `json:"vote"` // up, down or none
+	Upvotes   int    `json:"upvotes"`
+	Downvotes int    `json:"downvotes"`
+}
+
+// setVote sets the user's vote on a video to up, down or none and returns the new state
+// changed is false when the vote already was the requested one
+// A video the user cannot see (of a private account they do not follow) is errVideoNotFound
+// The video row is locked, so votes on a video are applied one at a time and the counts
+// change in the same transaction as the votes
+func setVote(ctx context.Context, uid, videoID, vote string) (VoteState, bool, error) {
+	state := VoteState{VideoID: videoID, Vote: vote}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return state, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_uid, video_upvotes, video_downvotes FROM videos WHERE video_id = $1 FOR UPDATE",
+		videoID,
+	).Scan(&owner, &state.Upvotes, &state.Downvotes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return state, false, errVideoNotFound
+		}
+		return state, false, fmt.Errorf("failed to fetch video: %w", err)
+	}
+	visible, err := Videos.CanView(ctx, tx, videoID, uid)
+	if err != nil {
+		return state, false, err
+	}
+	if !visible {
+		return state, false, errVideoNotFound
+	}
+
+	changed := false
+
+	// Remove the votes that differ from the requested one
+	if vote != VoteUp {
+		result, err := tx.ExecContext(ctx, "DELETE FROM upvotes WHERE upvoted_by = $1 AND upvoted_to = $2", uid, videoID)
+		if err != nil {
+			return state, false, fmt.Errorf("failed to remove upvote: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			state.Upvotes--
+			changed = true
+		}
+	}
+	if vote != VoteDown {
+		result, err := tx.ExecContext(ctx, "DELETE FROM downvotes WHERE downvoted_by = $1 AND downvoted_to = $2", uid, videoID)
+		if err != nil {
+			return state, false, fmt.Errorf("failed to remove downvote: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			state.Downvotes--
+			changed = true
+		}
+	}
+
+	// Insert the requested vote; nothing is inserted if it already exists
+	switch vote {
+	case VoteUp:
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO upvotes (upvoted_by, upvoted_to, upvoted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			uid, videoID, time.Now(),
+		)
+		if err != nil {
+			return state, false, fmt.Errorf("failed to insert upvote: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			state.Upvotes++
+			changed = true
+
+			err = Notifications.Notify(ctx, tx, Notifications.Event{
+				Type:     Notifications.TypeUpvote,
+				UserUID:  owner,
+				ActorUID: uid,
+				VideoID:  videoID,
+			})
+			if err != nil {
+				return state, false, err
+			}
+		}
+	case VoteDown:
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO downvotes (downvoted_by, downvoted_to, downvoted_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			uid, videoID, time.Now(),
+		)
+		if err != nil {
+			return state, false, fmt.Errorf("failed to insert downvote: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			state.Downvotes++
+			changed = true
+		}
+	}
+
+	if !changed {
+		return state, false, nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE videos SET video_upvotes = $1, video_downvotes = $2 WHERE video_id = $3",
+		state.Upvotes, state.Downvotes, videoID,
+	)
+	if err != nil {
+		return state, false, fmt.Errorf("failed to update video votes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return state, false, fmt.Errorf("failed to commit vote: %w", err)
+	}
+	return state, true, nil
+}
+
+// SetVote sets the caller's vote on a video to up, down or none
+// Body: {"vote": "up"}; repeating a request changes nothing
+func SetVote(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	videoID := chi.URLParam(r, "videoID")
+	if videoID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Video ID is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("SetVote: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to read body")
+		return
+	}
+
+	var input struct {
+		Vote string `json:"vote"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Failed to unmarshal body")
+		return
+	}
+
+	vote := strings.ToLower(strings.TrimSpace(input.Vote))
+	if vote != VoteUp && vote != VoteDown && vote != VoteNone {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Vote must be up, down or none")
+		return
+	}
+
+	sendVote(w, r, "SetVote", claims.UID, videoID, vote)
+}
+
+// RemoveVote removes the caller's upvote or downvote from a video
+// Removing a vote that does not exist is not an error
+func RemoveVote(w http.ResponseWriter, r *http.Request) {
+	claims := Auth.PrincipalFrom(r)
+
+	videoID := chi.URLParam(r, "videoID")
+	if videoID == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Video ID is required")
+		return
+	}
+
+	sendVote(w, r, "RemoveVote", claims.UID, videoID, VoteNone)
+}
+
+func sendVote(w http.ResponseWriter, r *http.Request, handler, uid, videoID, vote string) {
+	state, changed, err := setVote(r.Context(), uid, videoID, vote)
+	if err != nil {
+		if errors.Is(err, errVideoNotFound) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		} else {
+			log.Printf("%s: %v", handler, err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update vote")
+		}
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"video_id":  state.VideoID,
+		"vote":      state.Vote,
+		"upvotes":   state.Upvotes,
+		"downvotes": state.Downvotes,
+		"changed":   changed,
+	})
+}
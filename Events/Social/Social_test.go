@@ -0,0 +1,628 @@
+package social
+
+import (
+	"encoding/json"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests in this file hammer the social endpoints concurrently and then check that the
+// denormalized counters match the rows they count. They run against the Postgres database
+// of Utils/Testdb and are skipped when HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+// testRouter mounts the social routes behind the fake auth middleware of Testdb.Router
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Route("/users", HandleUsers)
+		r.Post("/videos/upvote/{videoID}", Upvote)
+		r.Post("/videos/downvote/{videoID}", Downvote)
+		r.Put("/videos/vote/{videoID}", SetVote)
+		r.Delete("/videos/vote/{videoID}", RemoveVote)
+		r.Post("/videos/comment/{videoID}", Comment)
+		r.Post("/videos/reply/{commentID}", Reply)
+		r.Patch("/videos/comments/{commentID}", EditComment)
+		r.Delete("/videos/comments/{commentID}", DeleteComment)
+		r.Patch("/videos/replies/{replyID}", EditReply)
+		r.Delete("/videos/replies/{replyID}", DeleteReply)
+		r.Put("/videos/comments/{commentID}/like", LikeComment)
+		r.Delete("/videos/comments/{commentID}/like", UnlikeComment)
+		r.Put("/videos/comments/{commentID}/pin", PinComment)
+		r.Put("/videos/comments/{commentID}/heart", HeartComment)
+		r.Get("/videos/comments/{videoID}", ListComments)
+		r.Post("/videos/view/{videoID}", RecordView)
+	})
+}
+
+func TestConcurrentVotes(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 20)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(user Testdb.User, seed int64) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(seed))
+			for j := 0; j < 15; j++ {
+				path := "/videos/upvote/" + videoID
+				if rng.Intn(2) == 0 {
+					path = "/videos/downvote/" + videoID
+				}
+				// Fire some votes twice at once to race the duplicate checks
+				if rng.Intn(3) == 0 {
+					var dup sync.WaitGroup
+					dup.Add(2)
+					for k := 0; k < 2; k++ {
+						go func() {
+							defer dup.Done()
+							Testdb.Request(t, router, user.UID, http.MethodPost, path, "")
+						}()
+					}
+					dup.Wait()
+					continue
+				}
+				Testdb.Request(t, router, user.UID, http.MethodPost, path, "")
+			}
+		}(user, int64(i))
+	}
+	wg.Wait()
+
+	upvotes := Testdb.QueryInt(t, "SELECT COUNT(*) FROM upvotes WHERE upvoted_to = $1", videoID)
+	downvotes := Testdb.QueryInt(t, "SELECT COUNT(*) FROM downvotes WHERE downvoted_to = $1", videoID)
+	if got := Testdb.QueryInt(t, "SELECT video_upvotes FROM videos WHERE video_id = $1", videoID); got != upvotes {
+		t.Errorf("video_upvotes = %d, want %d", got, upvotes)
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_downvotes FROM videos WHERE video_id = $1", videoID); got != downvotes {
+		t.Errorf("video_downvotes = %d, want %d", got, downvotes)
+	}
+	if upvotes+downvotes != len(users) {
+		t.Errorf("%d votes for %d voters, want one vote each", upvotes+downvotes, len(users))
+	}
+	if both := Testdb.QueryInt(t,
+		`SELECT COUNT(*) FROM upvotes u JOIN downvotes d ON d.downvoted_by = u.upvoted_by AND d.downvoted_to = u.upvoted_to
+		WHERE u.upvoted_to = $1`, videoID); both != 0 {
+		t.Errorf("%d users both upvoted and downvoted", both)
+	}
+}
+
+func TestConcurrentVoteChanges(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 20)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(user Testdb.User, seed int64) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(seed))
+			for j := 0; j < 15; j++ {
+				switch rng.Intn(4) {
+				case 0:
+					Testdb.Request(t, router, user.UID, http.MethodDelete, "/videos/vote/"+videoID, "")
+				case 1:
+					Testdb.Request(t, router, user.UID, http.MethodPut, "/videos/vote/"+videoID, `{"vote": "none"}`)
+				case 2:
+					Testdb.Request(t, router, user.UID, http.MethodPut, "/videos/vote/"+videoID, `{"vote": "up"}`)
+				default:
+					Testdb.Request(t, router, user.UID, http.MethodPut, "/videos/vote/"+videoID, `{"vote": "down"}`)
+				}
+			}
+		}(user, int64(i))
+	}
+	wg.Wait()
+
+	upvotes := Testdb.QueryInt(t, "SELECT COUNT(*) FROM upvotes WHERE upvoted_to = $1", videoID)
+	downvotes := Testdb.QueryInt(t, "SELECT COUNT(*) FROM downvotes WHERE downvoted_to = $1", videoID)
+	if got := Testdb.QueryInt(t, "SELECT video_upvotes FROM videos WHERE video_id = $1", videoID); got != upvotes {
+		t.Errorf("video_upvotes = %d, want %d", got, upvotes)
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_downvotes FROM videos WHERE video_id = $1", videoID); got != downvotes {
+		t.Errorf("video_downvotes = %d, want %d", got, downvotes)
+	}
+
+	// Removing every vote brings both counts back to zero
+	for _, user := range users {
+		Testdb.Request(t, router, user.UID, http.MethodDelete, "/videos/vote/"+videoID, "")
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_upvotes + video_downvotes FROM videos WHERE video_id = $1", videoID); got != 0 {
+		t.Errorf("%d votes left after removing every vote", got)
+	}
+}
+
+func TestConcurrentFollows(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 8)
+	router := testRouter()
+
+	// Every ordered pair follows and unfollows at random, so mutual follows race each other
+	var wg sync.WaitGroup
+	for i, follower := range users {
+		for j, followed := range users {
+			if i == j {
+				continue
+			}
+			wg.Add(1)
+			go func(follower, followed Testdb.User, seed int64) {
+				defer wg.Done()
+				rng := mrand.New(mrand.NewSource(seed))
+				for k := 0; k < 6; k++ {
+					path := "/users/follow/" + followed.Username
+					if rng.Intn(2) == 0 {
+						path = "/users/unfollow/" + followed.Username
+					}
+					Testdb.Request(t, router, follower.UID, http.MethodPost, path, "")
+				}
+			}(follower, followed, int64(i*len(users)+j))
+		}
+	}
+	wg.Wait()
+
+	for _, user := range users {
+		followers := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_to = $1", user.UID)
+		following := Testdb.QueryInt(t, "SELECT COUNT(*) FROM followers WHERE followed_by = $1", user.UID)
+		if got := Testdb.QueryInt(t, "SELECT followers FROM users WHERE uid = $1", user.UID); got != followers {
+			t.Errorf("%s: followers = %d, want %d", user.Username, got, followers)
+		}
+		if got := Testdb.QueryInt(t, "SELECT following FROM users WHERE uid = $1", user.UID); got != following {
+			t.Errorf("%s: following = %d, want %d", user.Username, got, following)
+		}
+	}
+}
+
+func TestConcurrentComments(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 20)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	var wg sync.WaitGroup
+	for _, user := range users {
+		for k := 0; k < 5; k++ {
+			wg.Add(1)
+			go func(user Testdb.User) {
+				defer wg.Done()
+				Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "first"}`)
+			}(user)
+		}
+	}
+	wg.Wait()
+
+	comments := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE commented_to = $1", videoID)
+	if comments != len(users)*5 {
+		t.Errorf("%d comments, want %d", comments, len(users)*5)
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_comments FROM videos WHERE video_id = $1", videoID); got != comments {
+		t.Errorf("video_comments = %d, want %d", got, comments)
+	}
+}
+
+func TestConcurrentReplies(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 10)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	if code := Testdb.Request(t, router, users[0].UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "hello"}`); code != http.StatusOK {
+		t.Fatalf("comment: status %d", code)
+	}
+	var commentID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1", videoID).Scan(&commentID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+
+	// A user has one reply per comment, so concurrent replies by the same user must insert it once
+	var wg sync.WaitGroup
+	for _, user := range users {
+		for k := 0; k < 5; k++ {
+			wg.Add(1)
+			go func(user Testdb.User, k int) {
+				defer wg.Done()
+				Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/reply/"+commentID, fmt.Sprintf(`{"reply": "reply %d"}`, k))
+			}(user, k)
+		}
+	}
+	wg.Wait()
+
+	replies := Testdb.QueryInt(t, "SELECT COUNT(*) FROM replies WHERE replied_to = $1", commentID)
+	if replies != len(users) {
+		t.Errorf("%d replies, want %d", replies, len(users))
+	}
+	if got := Testdb.QueryInt(t, "SELECT total_replies FROM comments WHERE comment_id = $1", commentID); got != replies {
+		t.Errorf("total_replies = %d, want %d", got, replies)
+	}
+}
+
+func TestConcurrentViews(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 10)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+	path := "/videos/view/" + videoID
+
+	// Too short a watch does not count
+	Testdb.Request(t, router, users[1].UID, http.MethodPost, path, `{"watch_seconds": 1}`)
+	if got := Testdb.QueryInt(t, "SELECT video_views FROM videos WHERE video_id = $1", videoID); got != 0 {
+		t.Fatalf("video_views = %d after a short watch, want 0", got)
+	}
+
+	// Every user refreshes at once, and so does one anonymous client (same IP address and user agent)
+	var wg sync.WaitGroup
+	for k := 0; k < 5; k++ {
+		for _, user := range append(users, Testdb.User{}) {
+			wg.Add(1)
+			go func(uid string) {
+				defer wg.Done()
+				Testdb.Request(t, router, uid, http.MethodPost, path, `{"watch_seconds": 30}`)
+			}(user.UID)
+		}
+	}
+	wg.Wait()
+
+	views := Testdb.QueryInt(t, "SELECT COUNT(*) FROM views WHERE viewed_to = $1", videoID)
+	anonymous := Testdb.QueryInt(t, "SELECT COUNT(*) FROM anonymous_views WHERE viewed_to = $1", videoID)
+	if views != len(users) || anonymous != 1 {
+		t.Errorf("%d views and %d anonymous views, want %d and 1", views, anonymous, len(users))
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_views FROM videos WHERE video_id = $1", videoID); got != views+anonymous {
+		t.Errorf("video_views = %d, want %d", got, views+anonymous)
+	}
+}
+
+func TestConcurrentCommentDeletes(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 10)
+	owner := users[0]
+	videoID := Testdb.CreateVideo(t, owner)
+	router := testRouter()
+
+	for _, user := range users {
+		if code := Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "hello"}`); code != http.StatusOK {
+			t.Fatalf("comment: status %d", code)
+		}
+	}
+	var commentID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1 AND commented_by = $2", videoID, owner.UID).Scan(&commentID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+
+	// Everyone replies to the owner's comment while their replies are deleted again
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user Testdb.User) {
+			defer wg.Done()
+			for k := 0; k < 5; k++ {
+				Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/reply/"+commentID, fmt.Sprintf(`{"reply": "reply %d"}`, k))
+				var replyID string
+				if err := Mdb.DB.QueryRow("SELECT reply_id FROM replies WHERE replied_to = $1 AND replied_by = $2", commentID, user.UID).Scan(&replyID); err == nil && k%2 == 0 {
+					Testdb.Request(t, router, user.UID, http.MethodDelete, "/videos/replies/"+replyID, "")
+				}
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	replies := Testdb.QueryInt(t, "SELECT COUNT(*) FROM replies WHERE replied_to = $1", commentID)
+	if got := Testdb.QueryInt(t, "SELECT total_replies FROM comments WHERE comment_id = $1", commentID); got != replies {
+		t.Errorf("total_replies = %d, want %d", got, replies)
+	}
+
+	// The author and the video owner delete each comment at the same time; only one delete counts
+	rows, err := Mdb.DB.Query("SELECT comment_id, commented_by FROM comments WHERE commented_to = $1", videoID)
+	if err != nil {
+		t.Fatalf("failed to list comments: %v", err)
+	}
+	type comment struct{ id, author string }
+	var comments []comment
+	for rows.Next() {
+		var c comment
+		if err := rows.Scan(&c.id, &c.author); err != nil {
+			t.Fatalf("failed to scan comment: %v", err)
+		}
+		comments = append(comments, c)
+	}
+	rows.Close()
+
+	for i, c := range comments {
+		if i%2 == 1 {
+			continue // Leave some comments
+		}
+		for _, uid := range []string{c.author, owner.UID} {
+			wg.Add(1)
+			go func(uid, commentID string) {
+				defer wg.Done()
+				Testdb.Request(t, router, uid, http.MethodDelete, "/videos/comments/"+commentID, "")
+			}(uid, c.id)
+		}
+	}
+	wg.Wait()
+
+	remaining := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE commented_to = $1", videoID)
+	if remaining != len(comments)/2 {
+		t.Errorf("%d comments left, want %d", remaining, len(comments)/2)
+	}
+	if got := Testdb.QueryInt(t, "SELECT video_comments FROM videos WHERE video_id = $1", videoID); got != remaining {
+		t.Errorf("video_comments = %d, want %d", got, remaining)
+	}
+}
+
+func TestCommentEdits(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	Testdb.Request(t, router, users[1].UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "first"}`)
+	var commentID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1", videoID).Scan(&commentID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+
+	// Only the author can edit, not even the video owner
+	if code := Testdb.Request(t, router, users[0].UID, http.MethodPatch, "/videos/comments/"+commentID, `{"comment": "owner"}`); code != http.StatusForbidden {
+		t.Errorf("edit by video owner: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	var wg sync.WaitGroup
+	for k := 0; k < 5; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			Testdb.Request(t, router, users[1].UID, http.MethodPatch, "/videos/comments/"+commentID, fmt.Sprintf(`{"comment": "edit %d"}`, k))
+		}(k)
+	}
+	wg.Wait()
+
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comment_edits WHERE comment_id = $1", commentID); got != 5 {
+		t.Errorf("%d edits recorded, want 5", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comment_edits WHERE comment_id = $1 AND previous_text = 'first'", commentID); got != 1 {
+		t.Errorf("original text recorded %d times, want once", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE comment_id = $1 AND edited_at IS NOT NULL", commentID); got != 1 {
+		t.Error("edited_at not set")
+	}
+}
+
+func TestConcurrentLikes(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 20)
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	for _, body := range []string{`{"comment": "old"}`, `{"comment": "new"}`} {
+		if code := Testdb.Request(t, router, users[0].UID, http.MethodPost, "/videos/comment/"+videoID, body); code != http.StatusOK {
+			t.Fatalf("comment: status %d", code)
+		}
+	}
+	var oldID, newID string
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1 AND comment = 'old'", videoID).Scan(&oldID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+	if err := Mdb.DB.QueryRow("SELECT comment_id FROM comments WHERE commented_to = $1 AND comment = 'new'", videoID).Scan(&newID); err != nil {
+		t.Fatalf("failed to fetch comment: %v", err)
+	}
+
+	// Everyone likes and unlikes the old comment at random, some likes twice at once
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func(user Testdb.User, seed int64) {
+			defer wg.Done()
+			rng := mrand.New(mrand.NewSource(seed))
+			for j := 0; j < 10; j++ {
+				method := http.MethodPut
+				if rng.Intn(3) == 0 {
+					method = http.MethodDelete
+				}
+				var dup sync.WaitGroup
+				for k := 0; k < 1+rng.Intn(2); k++ {
+					dup.Add(1)
+					go func() {
+						defer dup.Done()
+						Testdb.Request(t, router, user.UID, method, "/videos/comments/"+oldID+"/like", "")
+					}()
+				}
+				dup.Wait()
+			}
+			Testdb.Request(t, router, user.UID, http.MethodPut, "/videos/comments/"+oldID+"/like", "")
+		}(user, int64(i))
+	}
+	wg.Wait()
+
+	likes := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comment_likes WHERE comment_id = $1", oldID)
+	if likes != len(users) {
+		t.Errorf("%d likes, want %d", likes, len(users))
+	}
+	if got := Testdb.QueryInt(t, "SELECT total_likes FROM comments WHERE comment_id = $1", oldID); got != likes {
+		t.Errorf("total_likes = %d, want %d", got, likes)
+	}
+
+	// The liked comment ranks first in the top order, the newest in the default order
+	for sort, want := range map[string]string{"top": oldID, "newest": newID, "": newID} {
+		req := httptest.NewRequest(http.MethodGet, "/videos/comments/"+videoID+"?sort="+sort, nil)
+		req.Header.Set("X-Test-UID", users[1].UID)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp struct {
+			Data struct {
+				Comments []Comments `json:"comments"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Comments) != 2 {
+			t.Fatalf("sort %q: status %d: %s", sort, rec.Code, rec.Body.String())
+		}
+		if got := resp.Data.Comments[0].CommentID; got != want {
+			t.Errorf("sort %q: first comment %s, want %s", sort, got, want)
+		}
+		for _, comment := range resp.Data.Comments {
+			if comment.Liked != (comment.CommentID == oldID) {
+				t.Errorf("sort %q: comment %s liked = %v", sort, comment.CommentID, comment.Liked)
+			}
+		}
+	}
+}
+
+func TestConcurrentPins(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 6)
+	owner := users[0]
+	videoID := Testdb.CreateVideo(t, owner)
+	router := testRouter()
+
+	var commentIDs []string
+	for _, user := range users[1:] {
+		if code := Testdb.Request(t, router, user.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "hi"}`); code != http.StatusOK {
+			t.Fatalf("comment: status %d", code)
+		}
+	}
+	rows, err := Mdb.DB.Query("SELECT comment_id FROM comments WHERE commented_to = $1", videoID)
+	if err != nil {
+		t.Fatalf("failed to fetch comments: %v", err)
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("failed to scan comment: %v", err)
+		}
+		commentIDs = append(commentIDs, id)
+	}
+	rows.Close()
+
+	// Only the video owner pins and hearts
+	if code := Testdb.Request(t, router, users[1].UID, http.MethodPut, "/videos/comments/"+commentIDs[0]+"/pin", ""); code != http.StatusForbidden {
+		t.Errorf("pin by non-owner: status %d, want %d", code, http.StatusForbidden)
+	}
+	if code := Testdb.Request(t, router, users[1].UID, http.MethodPut, "/videos/comments/"+commentIDs[0]+"/heart", ""); code != http.StatusForbidden {
+		t.Errorf("heart by non-owner: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	// The owner pins every comment at once, several times over, while one comment is deleted
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for _, id := range commentIDs {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				Testdb.Request(t, router, owner.UID, http.MethodPut, "/videos/comments/"+id+"/pin", "")
+			}(id)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		Testdb.Request(t, router, owner.UID, http.MethodDelete, "/videos/comments/"+commentIDs[0], "")
+	}()
+	wg.Wait()
+
+	if n := Testdb.QueryInt(t, "SELECT COUNT(*) FROM comments WHERE commented_to = $1 AND pinned_at IS NOT NULL", videoID); n > 1 {
+		t.Fatalf("%d pinned comments, want at most 1", n)
+	}
+
+	// The last pin wins and comes first in every order
+	pinned := commentIDs[len(commentIDs)-1]
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPut, "/videos/comments/"+pinned+"/pin", ""); code != http.StatusOK {
+		t.Fatalf("pin: status %d", code)
+	}
+	if code := Testdb.Request(t, router, owner.UID, http.MethodPut, "/videos/comments/"+commentIDs[1]+"/heart", ""); code != http.StatusOK {
+		t.Fatalf("heart: status %d", code)
+	}
+	for _, sort := range []string{"top", "newest", "oldest"} {
+		req := httptest.NewRequest(http.MethodGet, "/videos/comments/"+videoID+"?sort="+sort, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var resp struct {
+			Data struct {
+				Comments []Comments `json:"comments"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Comments) != len(commentIDs)-1 {
+			t.Fatalf("sort %q: status %d: %s", sort, rec.Code, rec.Body.String())
+		}
+		if got := resp.Data.Comments[0]; got.CommentID != pinned || got.PinnedAt == nil {
+			t.Errorf("sort %q: first comment %s, want pinned %s", sort, got.CommentID, pinned)
+		}
+		for _, comment := range resp.Data.Comments[1:] {
+			if comment.PinnedAt != nil {
+				t.Errorf("sort %q: comment %s also pinned", sort, comment.CommentID)
+			}
+			if (comment.HeartedAt != nil) != (comment.CommentID == commentIDs[1]) {
+				t.Errorf("sort %q: comment %s hearted_at = %v", sort, comment.CommentID, comment.HeartedAt)
+			}
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 4)
+	author := users[1]
+	videoID := Testdb.CreateVideo(t, users[0])
+	router := testRouter()
+
+	// users[3] blocked the author, so mentioning them does nothing
+	if _, err := Mdb.DB.Exec("INSERT INTO blocklists (blocked_by, blocked_to) VALUES ($1, $2)", users[3].UID, author.UID); err != nil {
+		t.Fatalf("failed to block: %v", err)
+	}
+
+	text := fmt.Sprintf("hé @%s, @%s @%s @nobody_%s mail x@%s",
+		users[0].Username, strings.ToUpper(users[2].Username), users[3].Username, Testdb.RandomHex(t, 4), users[0].Username)
+	if code := Testdb.Request(t, router, author.UID, http.MethodPost, "/videos/comment/"+videoID, fmt.Sprintf(`{"comment": %q}`, text)); code != http.StatusOK {
+		t.Fatalf("comment: status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/videos/comments/"+videoID, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp struct {
+		Data struct {
+			Comments []Comments `json:"comments"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Comments) != 1 {
+		t.Fatalf("list comments: status %d: %s", rec.Code, rec.Body.String())
+	}
+	comment := resp.Data.Comments[0]
+	if len(comment.Mentions) != 2 {
+		t.Fatalf("mentions = %+v, want users[0] and users[2]", comment.Mentions)
+	}
+	runes := []rune(text)
+	for i, want := range []Testdb.User{users[0], users[2]} {
+		mention := comment.Mentions[i]
+		if mention.UID != want.UID || mention.Username != want.Username {
+			t.Errorf("mention %d = %+v, want %s", i, mention, want.Username)
+		}
+		if got := strings.ToLower(string(runes[mention.Start:mention.End])); got != "@"+want.Username {
+			t.Errorf("mention %d covers %q, want %q", i, got, "@"+want.Username)
+		}
+	}
+
+	// Editing the comment replaces its mentions
+	if code := Testdb.Request(t, router, author.UID, http.MethodPatch, "/videos/comments/"+comment.CommentID, fmt.Sprintf(`{"comment": "@%s"}`, users[2].Username)); code != http.StatusOK {
+		t.Fatalf("edit: status %d", code)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM mentions WHERE comment_id = $1", comment.CommentID); got != 1 {
+		t.Errorf("%d mentions after edit, want 1", got)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM mentions WHERE comment_id = $1 AND mentioned_uid = $2 AND start_offset = 0", comment.CommentID, users[2].UID); got != 1 {
+		t.Error("edited mention not recorded")
+	}
+}
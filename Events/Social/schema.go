@@ -1,6 +1,10 @@
 package social
 
-import "time"
+import (
+	"time"
+
+	Users "hifi/Events/Users"
+)
 
 type Followers struct {
 	ID               int       `db:"id" json:"-"`
@@ -15,9 +19,35 @@ type Blocklists struct {
 	ID        int       `db:"id" json:"-"`
 	BlockedBy string    `db:"blocked_by" json:"blocked_by"` // User who blocked
 	BlockedTo string    `db:"blocked_to" json:"blocked_to"` // User who is blocked
+	BlockedToUsername string `db:"blocked_to_username" json:"blocked_to_username,omitempty"` // Username of user who is blocked (for responses)
 	BlockedAt time.Time `db:"blocked_at" json:"blocked_at"`
 }
 
+type Mutes struct {
+	ID              int       `db:"id" json:"-"`
+	MutedBy         string    `db:"muted_by" json:"muted_by"`                               // User who muted
+	MutedTo         string    `db:"muted_to" json:"muted_to"`                               // User who is muted
+	MutedToUsername string    `db:"muted_to_username" json:"muted_to_username,omitempty"` // Username of user who is muted (for responses)
+	MutedAt         time.Time `db:"muted_at" json:"muted_at"`
+}
+
+type FollowRequests struct {
+	ID                  int       `db:"id" json:"-"`
+	RequestedBy         string    `db:"requested_by" json:"requested_by"`                                   // User who wants to follow
+	RequestedByUsername string    `db:"requested_by_username" json:"requested_by_username,omitempty"` // Username of user who wants to follow (for responses)
+	RequestedTo         string    `db:"requested_to" json:"requested_to"`                                   // Private account to follow
+	RequestedToUsername string    `db:"requested_to_username" json:"requested_to_username,omitempty"` // Username of private account to follow (for responses)
+	RequestedAt         time.Time `db:"requested_at" json:"requested_at"`
+}
+
+type MutedWords struct {
+	ID        int       `db:"id" json:"id"`
+	UserUID   string    `db:"user_uid" json:"-"`
+	Word      string    `db:"word" json:"word"` // Lowercased keyword or tag
+	Kind      string    `db:"kind" json:"kind"` // keyword or tag
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
 type Upvotes struct {
 	ID        int       `db:"id" json:"-"`
 	UpvotedBy string    `db:"upvoted_by" json:"upvoted_by"` // User who upvoted
@@ -41,6 +71,12 @@ type Comments struct {
 	Comment          string    `db:"comment" json:"comment"`
 	CommentByUsername string   `db:"comment_by_username" json:"comment_by_username"`
 	TotalReplies     int       `db:"total_replies" json:"total_replies"`
+	EditedAt         *time.Time `db:"edited_at" json:"edited_at"` // nil if never edited
+	TotalLikes       int       `db:"total_likes" json:"total_likes"`
+	Liked            bool      `db:"-" json:"liked"` // Whether the caller liked it (set by the social list endpoints only)
+	PinnedAt         *time.Time `db:"pinned_at" json:"pinned_at"`   // nil unless pinned by the video owner
+	HeartedAt        *time.Time `db:"hearted_at" json:"hearted_at"` // nil unless hearted by the video owner
+	Mentions         []Users.Mention `db:"-" json:"mentions,omitempty"` // @mentions in the comment (from the mentions table)
 }
 
 type Replies struct {
@@ -51,6 +87,19 @@ type Replies struct {
 	RepliedAt      time.Time `db:"replied_at" json:"replied_at"`
 	Reply          string    `db:"reply" json:"reply"`
 	ReplyByUsername string   `db:"reply_by_username" json:"reply_by_username"`
+	EditedAt       *time.Time `db:"edited_at" json:"edited_at"` // nil if never edited
+	TotalLikes     int       `db:"total_likes" json:"total_likes"`
+	Liked          bool      `db:"-" json:"liked"` // Whether the caller liked it (set by the social list endpoints only)
+	Mentions       []Users.Mention `db:"-" json:"mentions,omitempty"` // @mentions in the reply (from the mentions table)
+}
+
+type CommentEdits struct {
+	ID           int       `db:"id" json:"id"`
+	CommentID    *string   `db:"comment_id" json:"comment_id,omitempty"` // Edited comment (nil for a reply)
+	ReplyID      *string   `db:"reply_id" json:"reply_id,omitempty"`     // Edited reply (nil for a comment)
+	EditedBy     string    `db:"edited_by" json:"edited_by"`             // Author who edited
+	PreviousText string    `db:"previous_text" json:"previous_text"`     // Text before the edit
+	EditedAt     time.Time `db:"edited_at" json:"edited_at"`
 }
 
 type Views struct {
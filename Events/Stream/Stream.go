@@ -0,0 +1,217 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
+	Utils "hifi/Utils"
+)
+
+const (
+	HeartbeatInterval = 25 * time.Second // Keeps proxies from closing an idle stream
+	CountsInterval    = time.Second      // Count updates of a video are sent at most this often
+	RetryMillis       = 3000             // How long EventSource waits before reconnecting
+)
+
+// Handle sets up the routes of the event stream
+func Handle(req chi.Router) {
+	req.With(Auth.RequireAuth, Auth.RequireScope(Auth.ScopeSocialRead)).Post("/ticket", CreateTicket)
+	req.With(ticketAuth, Auth.RequireScope(Auth.ScopeSocialRead)).Get("/", Stream)
+}
+
+// ticketAuth authenticates the stream with a ticket from POST /stream/ticket in ?ticket=, as
+// EventSource cannot set headers; other requests need the Authorization header (RequireAuth)
+// Tickets are short-lived and single use, unlike the access token they stand in for, so a URL
+// that leaks into logs or browser history cannot be used to open another stream
+func ticketAuth(next http.Handler) http.Handler {
+	requireAuth := Auth.RequireAuth(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticket := r.URL.Query().Get("ticket")
+		if ticket == "" || r.Header.Get("Authorization") != "" {
+			requireAuth.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := Auth.RedeemStreamTicket(r.Context(), ticket)
+		if err != nil {
+			if !errors.Is(err, Auth.ErrInvalidStreamTicket) {
+				log.Printf("ticketAuth: %v", err)
+			}
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "Invalid or expired stream ticket")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(Auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// CreateTicket issues a ticket that opens one stream as the caller, for clients that cannot set
+// the Authorization header on GET /stream
+func CreateTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, err := Auth.CreateStreamTicket(r.Context(), Auth.PrincipalFrom(r))
+	if err != nil {
+		log.Printf("CreateTicket: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to create stream ticket")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(Auth.StreamTicketValidity.Seconds()),
+	})
+}
+
+// hiddenUsers returns the UIDs whose comments and replies the user does not see: the users they blocked or muted
+func hiddenUsers(ctx context.Context, uid string) (map[string]bool, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT blocked_to FROM blocklists WHERE blocked_by = $1
+		UNION
+		SELECT muted_to FROM mutes WHERE muted_by = $1`,
+		uid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blocked and muted users: %w", err)
+	}
+	defer rows.Close()
+
+	hidden := make(map[string]bool)
+	for rows.Next() {
+		var hiddenUID string
+		if err := rows.Scan(&hiddenUID); err != nil {
+			return nil, fmt.Errorf("failed to scan hidden user: %w", err)
+		}
+		hidden[hiddenUID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate hidden users: %w", err)
+	}
+	return hidden, nil
+}
+
+// writeEvent writes a server-sent event; data must not contain newlines (compact JSON)
+func writeEvent(w http.ResponseWriter, event string, data []byte) error {
+	if len(data) == 0 {
+		data = []byte("{}")
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// Stream sends the authenticated user's notifications and, with ?video_id=, the new comments,
+// replies and counts of the video being watched as server-sent events until the client disconnects
+// Events are fanned out across instances through Postgres LISTEN/NOTIFY (see Services/Realtime)
+func Stream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	topics := []string{Realtime.UserTopic(claims.UID)}
+
+	videoID := r.URL.Query().Get("video_id")
+	if videoID != "" {
+		// Same visibility as GET /videos/{videoID}: a private account's videos are only
+		// visible to the account itself and its followers; users the owner blocked cannot watch
+		var visible bool
+		err := Mdb.DB.QueryRowContext(ctx,
+			`SELECT (u.is_private IS NOT TRUE OR v.user_uid = $2
+					OR EXISTS (SELECT 1 FROM followers f WHERE f.followed_by = $2 AND f.followed_to = v.user_uid))
+				AND NOT EXISTS (SELECT 1 FROM blocklists b WHERE b.blocked_by = v.user_uid AND b.blocked_to = $2)
+			FROM videos v
+			LEFT JOIN users u ON v.user_uid = u.uid
+			WHERE v.video_id = $1`,
+			videoID, claims.UID,
+		).Scan(&visible)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Stream: failed to fetch video: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch video")
+			return
+		}
+		if !visible {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+			return
+		}
+		topics = append(topics, Realtime.VideoTopic(videoID))
+	}
+
+	// Loaded once per connection: blocks and mutes made later apply when the client reconnects
+	hidden, err := hiddenUsers(ctx, claims.UID)
+	if err != nil {
+		log.Printf("Stream: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch blocked users")
+		return
+	}
+
+	subscription := Realtime.Subscribe(topics...)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+
+	ready, _ := json.Marshal(map[string]interface{}{"topics": topics})
+	fmt.Fprintf(w, "retry: %d\n\n", RetryMillis)
+	if err := writeEvent(w, "ready", ready); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	countsTicker := time.NewTicker(CountsInterval)
+	defer countsTicker.Stop()
+
+	// Only the latest counts are sent, so a burst of views or votes costs one event per CountsInterval
+	var pendingCounts json.RawMessage
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg, ok := <-subscription.C:
+			if !ok {
+				return // Too far behind; the client reconnects
+			}
+			if msg.Actor != "" && hidden[msg.Actor] {
+				continue
+			}
+			if msg.Event == "counts" {
+				pendingCounts = msg.Data
+				continue
+			}
+			err = writeEvent(w, msg.Event, msg.Data)
+
+		case <-countsTicker.C:
+			if pendingCounts == nil {
+				continue
+			}
+			err = writeEvent(w, "counts", pendingCounts)
+			pendingCounts = nil
+
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,186 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Social "hifi/Events/Social"
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The events are published by the social endpoints, so the tests drive those. They run against
+// the Postgres database of Utils/Testdb and are skipped when HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Route("/users", Social.HandleUsers)
+		r.Post("/videos/upvote/{videoID}", Social.Upvote)
+		r.Post("/videos/comment/{videoID}", Social.Comment)
+		r.Get("/stream", Stream)
+	})
+}
+
+func TestStreamEvents(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	owner, commenter, voter := users[0], users[1], users[2]
+	videoID := Testdb.CreateVideo(t, owner)
+	router := testRouter()
+
+	subscription := Realtime.Subscribe(Realtime.VideoTopic(videoID), Realtime.UserTopic(owner.UID))
+	defer subscription.Close()
+
+	Testdb.Request(t, router, commenter.UID, http.MethodPost, "/videos/comment/"+videoID, `{"comment": "live"}`)
+	Testdb.Request(t, router, voter.UID, http.MethodPost, "/videos/upvote/"+videoID, "")
+
+	// Each commit is delivered through LISTEN/NOTIFY; a rolled back one would publish nothing
+	want := map[string]bool{"comment": true, "counts": true, "notification": true}
+	timeout := time.After(10 * time.Second)
+	for len(want) > 0 {
+		select {
+		case msg, ok := <-subscription.C:
+			if !ok {
+				t.Fatal("subscription closed")
+			}
+			switch msg.Event {
+			case "comment":
+				var comment Social.Comments
+				if err := json.Unmarshal(msg.Data, &comment); err != nil {
+					t.Fatalf("failed to decode comment: %v", err)
+				}
+				if comment.Comment != "live" || msg.Actor != commenter.UID {
+					t.Errorf("comment event = %+v by %s", comment, msg.Actor)
+				}
+			case "counts":
+				var counts struct {
+					Upvotes  int `json:"upvotes"`
+					Comments int `json:"comments"`
+				}
+				if err := json.Unmarshal(msg.Data, &counts); err != nil {
+					t.Fatalf("failed to decode counts: %v", err)
+				}
+				if counts.Upvotes != 1 || counts.Comments != 1 {
+					continue // An earlier update
+				}
+			}
+			delete(want, msg.Event)
+		case <-timeout:
+			t.Fatalf("missing events: %v", want)
+		}
+	}
+}
+
+func TestStreamVideoVisibility(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	owner, viewer := users[0], users[1]
+	videoID := Testdb.CreateVideo(t, owner)
+	router := testRouter()
+
+	// Subscriptions are refused like GET /videos/{videoID} (404) before the stream starts
+	if code := Testdb.Request(t, router, viewer.UID, http.MethodGet, "/stream?video_id=test_missing", ""); code != http.StatusNotFound {
+		t.Errorf("unknown video: status %d, want %d", code, http.StatusNotFound)
+	}
+	Testdb.Request(t, router, owner.UID, http.MethodPost, "/users/block/"+viewer.Username, "")
+	if code := Testdb.Request(t, router, viewer.UID, http.MethodGet, "/stream?video_id="+videoID, ""); code != http.StatusNotFound {
+		t.Errorf("video of a user who blocked the caller: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// openStream opens the stream through the real middleware for a moment and returns the response
+// A stream that opened has sent the ready event before the request context ran out
+func openStream(t *testing.T, router http.Handler, query, accessToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/stream?"+query, nil).WithContext(ctx)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStreamTickets(t *testing.T) {
+	Testdb.Require(t)
+	alice := Testdb.CreateUsers(t, 1)[0]
+	ctx := context.Background()
+	router := chi.NewRouter()
+	router.Route("/stream", Handle)
+
+	session, err := Auth.CreateSession(ctx, alice.UID, Auth.SessionMeta{})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	createTicket := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/stream/ticket", nil)
+		req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var res struct {
+			Data struct {
+				Ticket    string `json:"ticket"`
+				ExpiresIn int    `json:"expires_in"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); rec.Code != http.StatusOK || err != nil || res.Data.Ticket == "" {
+			t.Fatalf("creating ticket: status %d, body %s", rec.Code, rec.Body)
+		}
+		if res.Data.ExpiresIn != int(Auth.StreamTicketValidity.Seconds()) {
+			t.Errorf("ticket expires in %d seconds, want %v", res.Data.ExpiresIn, Auth.StreamTicketValidity)
+		}
+		return res.Data.Ticket
+	}
+
+	if rec := openStream(t, router, "", session.AccessToken); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "event: ready") {
+		t.Errorf("stream with Authorization header: status %d, body %q", rec.Code, rec.Body)
+	}
+	// Access tokens are not accepted in the query string
+	if rec := openStream(t, router, "access_token="+url.QueryEscape(session.AccessToken), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stream with access_token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := openStream(t, router, "ticket=not-a-ticket", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stream with unknown ticket: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	ticket := createTicket()
+	if rec := openStream(t, router, "ticket="+url.QueryEscape(ticket), ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "event: ready") {
+		t.Errorf("stream with ticket: status %d, body %q", rec.Code, rec.Body)
+	}
+	if rec := openStream(t, router, "ticket="+url.QueryEscape(ticket), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused ticket: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// Tickets are refused once they expire or the session they stand in for is revoked
+	expired := createTicket()
+	if _, err := Mdb.DB.Exec("UPDATE stream_tickets SET expires_at = NOW() - INTERVAL '1 second' WHERE user_uid = $1", alice.UID); err != nil {
+		t.Fatalf("failed to expire ticket: %v", err)
+	}
+	if rec := openStream(t, router, "ticket="+url.QueryEscape(expired), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expired ticket: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	revoked := createTicket()
+	if err := Auth.RevokeSession(ctx, session.SessionID); err != nil {
+		t.Fatalf("failed to revoke session: %v", err)
+	}
+	if rec := openStream(t, router, "ticket="+url.QueryEscape(revoked), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("ticket of a revoked session: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
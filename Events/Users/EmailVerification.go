@@ -0,0 +1,253 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	Auth "hifi/Services/Auth"
+	Mail "hifi/Services/Mail"
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// Email verification settings
+const (
+	EmailVerificationTokenValidity  = 24 * time.Hour
+	EmailVerificationResendCooldown = time.Minute // Minimum time between two verification emails
+	EmailVerificationHourlyLimit    = 5           // Maximum verification emails per user per hour
+)
+
+var errInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+// sendVerificationEmail issues a verification token for the user's email and mails the link
+// Any token sent earlier to the user stops working
+func sendVerificationEmail(ctx context.Context, uid, username, email string) error {
+	token, tokenHash, err := Auth.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE email_verification_tokens SET used_at = $1 WHERE user_uid = $2 AND used_at IS NULL",
+		now, uid,
+	); err != nil {
+		return fmt.Errorf("failed to invalidate verification tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO email_verification_tokens (token_hash, user_uid, email, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		tokenHash, uid, email, now, now.Add(EmailVerificationTokenValidity),
+	); err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit verification token: %w", err)
+	}
+
+	link := Utils.AppBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return Mail.Send(ctx, Mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm that %s is your email address by opening the link below. It expires in %d hours.\n\n%s\n\nIf you did not add this address to your account, you can ignore this email.\n",
+			username, email, int(EmailVerificationTokenValidity.Hours()), link,
+		),
+	})
+}
+
+// verificationRetryAfter returns how long the user has to wait before another verification email can be sent
+// Zero means a new email can be sent now
+func verificationRetryAfter(ctx context.Context, uid string) (time.Duration, error) {
+	now := time.Now()
+	var lastSent sql.NullTime
+	var sentLastHour int
+	var oldestLastHour sql.NullTime
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT MAX(created_at), COUNT(*) FILTER (WHERE created_at > $2), MIN(created_at) FILTER (WHERE created_at > $2)
+		FROM email_verification_tokens WHERE user_uid = $1`,
+		uid, now.Add(-time.Hour),
+	).Scan(&lastSent, &sentLastHour, &oldestLastHour)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check verification throttle: %w", err)
+	}
+
+	var wait time.Duration
+	if lastSent.Valid {
+		if d := lastSent.Time.Add(EmailVerificationResendCooldown).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if sentLastHour >= EmailVerificationHourlyLimit && oldestLastHour.Valid {
+		if d := oldestLastHour.Time.Add(time.Hour).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// ResendVerificationEmail sends a new verification link to the authenticated user's email
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims := Auth.PrincipalFrom(r)
+
+	user, err := fetchUserByUID(ctx, claims.UID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			Utils.SendErrorResponse(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("ResendVerificationEmail: failed to fetch user: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to load user")
+		}
+		return
+	}
+
+	if user.Email == nil || *user.Email == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "No email address set")
+		return
+	}
+	if user.EmailVerified {
+		Utils.SendErrorResponse(w, http.StatusConflict, "Email already verified")
+		return
+	}
+
+	wait, err := verificationRetryAfter(ctx, user.UID)
+	if err != nil {
+		log.Printf("ResendVerificationEmail: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+	if wait > 0 {
+		Utils.SendTooManyRequests(w, wait, "Please wait before requesting another verification email")
+		return
+	}
+
+	if err := sendVerificationEmail(ctx, user.UID, user.Username, *user.Email); err != nil {
+		log.Printf("ResendVerificationEmail: failed to send verification email: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]string{"message": "Verification email sent"})
+}
+
+// VerifyEmail marks the email address of a user as verified using a token from a verification email
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("VerifyEmail: failed to read body: %v", err)
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	token := strings.TrimSpace(payload.Token)
+	if token == "" {
+		Utils.SendErrorResponse(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	uid, err := redeemVerificationToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, errInvalidVerificationToken) {
+			Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid or expired verification token")
+			return
+		}
+		log.Printf("VerifyEmail: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	user, err := fetchUserByUID(ctx, uid)
+	if err != nil {
+		log.Printf("VerifyEmail: failed to fetch user: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+
+	Utils.SendSuccessResponse(w, map[string]interface{}{
+		"message": "Email verified successfully",
+		"user":    user,
+	})
+}
+
+// redeemVerificationToken consumes a verification token and marks the address it was sent to as verified
+// The token is rejected if the user's email changed after it was sent
+func redeemVerificationToken(ctx context.Context, token string) (string, error) {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var id int
+	var uid, email string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_uid, email FROM email_verification_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		FOR UPDATE`,
+		Auth.HashOpaqueToken(token), now,
+	).Scan(&id, &uid, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errInvalidVerificationToken
+		}
+		return "", fmt.Errorf("failed to fetch verification token: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1), updated_at = $1
+		WHERE uid = $2 AND email = $3`,
+		now, uid, email,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("failed to check verification result: %w", err)
+	}
+	if rowsAffected == 0 {
+		return "", errInvalidVerificationToken
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE email_verification_tokens SET used_at = $1 WHERE id = $2",
+		now, id,
+	); err != nil {
+		return "", fmt.Errorf("failed to consume verification token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit email verification: %w", err)
+	}
+	return uid, nil
+}
@@ -0,0 +1,147 @@
+package users
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests run against the Postgres database of Utils/Testdb and are skipped when
+// HIFI_TEST_POSTGRES is not set. Verification emails go to the log.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Post("/email/verify", VerifyEmail)
+		r.Post("/self/email/verify/resend", ResendVerificationEmail)
+	})
+}
+
+// setEmail gives the user an unverified email address and returns it
+func setEmail(t *testing.T, user Testdb.User) string {
+	t.Helper()
+	email := user.Username + "@example.com"
+	if _, err := Mdb.DB.Exec("UPDATE users SET email = $1, email_verified_at = NULL WHERE uid = $2", email, user.UID); err != nil {
+		t.Fatalf("failed to set email: %v", err)
+	}
+	return email
+}
+
+// createVerificationToken inserts a verification token for email and returns the token
+func createVerificationToken(t *testing.T, uid, email string, expiresAt time.Time) string {
+	t.Helper()
+	token, tokenHash, err := Auth.GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+	if _, err := Mdb.DB.Exec(
+		`INSERT INTO email_verification_tokens (token_hash, user_uid, email, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		tokenHash, uid, email, time.Now(), expiresAt,
+	); err != nil {
+		t.Fatalf("failed to create verification token: %v", err)
+	}
+	return token
+}
+
+func isVerified(t *testing.T, uid string) bool {
+	t.Helper()
+	return Testdb.QueryInt(t, "SELECT COUNT(*) FROM users WHERE uid = $1 AND email_verified_at IS NOT NULL", uid) == 1
+}
+
+func TestResendVerificationEmailThrottle(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 2)
+	alice, bob := users[0], users[1]
+	router := testRouter()
+
+	if code := Testdb.Request(t, router, bob.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusBadRequest {
+		t.Errorf("resend without an email: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	setEmail(t, alice)
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusOK {
+		t.Fatalf("resend: status %d", code)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusTooManyRequests {
+		t.Errorf("resend within the cooldown: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if got := Testdb.QueryInt(t, "SELECT COUNT(*) FROM email_verification_tokens WHERE user_uid = $1", alice.UID); got != 1 {
+		t.Errorf("%d verification tokens issued, want 1", got)
+	}
+
+	// The hourly limit holds even after the cooldown
+	if _, err := Mdb.DB.Exec(
+		"UPDATE email_verification_tokens SET created_at = $1 WHERE user_uid = $2",
+		time.Now().Add(-2*EmailVerificationResendCooldown), alice.UID,
+	); err != nil {
+		t.Fatalf("failed to age verification tokens: %v", err)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusOK {
+		t.Fatalf("resend after the cooldown: status %d", code)
+	}
+	for i := 0; i < EmailVerificationHourlyLimit-2; i++ {
+		createVerificationToken(t, alice.UID, alice.Username+"@example.com", time.Now().Add(time.Hour))
+	}
+	if _, err := Mdb.DB.Exec(
+		"UPDATE email_verification_tokens SET created_at = $1 WHERE user_uid = $2",
+		time.Now().Add(-2*EmailVerificationResendCooldown), alice.UID,
+	); err != nil {
+		t.Fatalf("failed to age verification tokens: %v", err)
+	}
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusTooManyRequests {
+		t.Errorf("resend over the hourly limit: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 1)
+	alice := users[0]
+	router := testRouter()
+	email := setEmail(t, alice)
+
+	if code := Testdb.Request(t, router, "", http.MethodPost, "/email/verify", `{"token":"unknown"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown token: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	expired := createVerificationToken(t, alice.UID, email, time.Now().Add(-time.Minute))
+	if code := Testdb.Request(t, router, "", http.MethodPost, "/email/verify", `{"token":"`+expired+`"}`); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// A token sent to a previous address does not verify the current one
+	stale := createVerificationToken(t, alice.UID, "old-"+email, time.Now().Add(time.Hour))
+	if code := Testdb.Request(t, router, "", http.MethodPost, "/email/verify", `{"token":"`+stale+`"}`); code != http.StatusBadRequest {
+		t.Errorf("token for a previous address: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if isVerified(t, alice.UID) {
+		t.Fatal("email verified by an invalid token")
+	}
+
+	token := createVerificationToken(t, alice.UID, email, time.Now().Add(time.Hour))
+	if code := Testdb.Request(t, router, "", http.MethodPost, "/email/verify", `{"token":"`+token+`"}`); code != http.StatusOK {
+		t.Fatalf("verify: status %d", code)
+	}
+	if !isVerified(t, alice.UID) {
+		t.Error("email not verified")
+	}
+
+	// Tokens are single use
+	if code := Testdb.Request(t, router, "", http.MethodPost, "/email/verify", `{"token":"`+token+`"}`); code != http.StatusBadRequest {
+		t.Errorf("reused token: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if code := Testdb.Request(t, router, alice.UID, http.MethodPost, "/self/email/verify/resend", ""); code != http.StatusConflict {
+		t.Errorf("resend after verifying: status %d, want %d", code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,172 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// MaxMentions is the most mentions a text can have; later ones are ignored
+const MaxMentions = 20
+
+// MentionSource is the kind of text a mention is in, named after the mentions column holding the text's ID
+type MentionSource string
+
+const (
+	MentionInComment MentionSource = "comment_id"
+	MentionInReply   MentionSource = "reply_id"
+	MentionInVideo   MentionSource = "video_id" // The video description
+)
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx
+type queryExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func isUsernameRune(r rune) bool {
+	return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// parseMentions finds the @usernames in a text, lowercased, with their character offsets
+// An @ that follows a letter, digit or underscore (as in an email address) does not start a mention
+func parseMentions(text string) []Mention {
+	var mentions []Mention
+	runes := []rune(text)
+	for i := 0; i < len(runes) && len(mentions) < MaxMentions; i++ {
+		if runes[i] != '@' || (i > 0 && (isUsernameRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		if username := strings.ToLower(string(runes[i+1 : end])); usernameRegex.MatchString(username) {
+			mentions = append(mentions, Mention{Username: username, Start: i, End: end})
+		}
+		i = end - 1
+	}
+	return mentions
+}
+
+// SaveMentions replaces the mention rows of a comment, reply or video description with the
+// mentions in its text and returns them, and the UIDs of the users it did not mention before
+// (for notifications; the author is left out)
+// Mentions of nonexistent users and of users who blocked (or are blocked by) the author are left out
+func SaveMentions(ctx context.Context, db queryExecer, source MentionSource, id, authorUID, text string) ([]Mention, []string, error) {
+	rows, err := db.QueryContext(ctx, "DELETE FROM mentions WHERE "+string(source)+" = $1 RETURNING mentioned_uid", id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to delete mentions: %w", err)
+	}
+	previous := map[string]bool{authorUID: true}
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan previous mention: %w", err)
+		}
+		previous[uid] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to iterate previous mentions: %w", err)
+	}
+
+	mentions := []Mention{}
+	var added []string
+	parsed := parseMentions(text)
+	if len(parsed) == 0 {
+		return mentions, added, nil
+	}
+
+	usernames := make([]string, len(parsed))
+	for i, mention := range parsed {
+		usernames[i] = mention.Username
+	}
+	rows, err = db.QueryContext(ctx,
+		`SELECT uid, username FROM users u
+		WHERE username = ANY($1)
+			AND NOT EXISTS (
+				SELECT 1 FROM blocklists b
+				WHERE (b.blocked_by = $2 AND b.blocked_to = u.uid) OR (b.blocked_by = u.uid AND b.blocked_to = $2)
+			)`,
+		pq.Array(usernames), authorUID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find mentioned users: %w", err)
+	}
+	uids := make(map[string]string)
+	for rows.Next() {
+		var uid, username string
+		if err := rows.Scan(&uid, &username); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan mentioned user: %w", err)
+		}
+		uids[username] = uid
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to iterate mentioned users: %w", err)
+	}
+
+	now := time.Now()
+	for _, mention := range parsed {
+		uid, ok := uids[mention.Username]
+		if !ok {
+			continue
+		}
+		mention.UID = uid
+		if _, err := db.ExecContext(ctx,
+			`INSERT INTO mentions (mentioned_uid, mentioned_by, `+string(source)+`, start_offset, end_offset, mentioned_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			uid, authorUID, id, mention.Start, mention.End, now,
+		); err != nil {
+			return nil, nil, fmt.Errorf("failed to insert mention: %w", err)
+		}
+		mentions = append(mentions, mention)
+		if !previous[uid] {
+			previous[uid] = true
+			added = append(added, uid)
+		}
+	}
+	return mentions, added, nil
+}
+
+// LoadMentions returns the mentions of the given comments, replies or videos by ID, in text order
+func LoadMentions(ctx context.Context, db queryExecer, source MentionSource, ids []string) (map[string][]Mention, error) {
+	mentions := make(map[string][]Mention)
+	if len(ids) == 0 {
+		return mentions, nil
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT m.`+string(source)+`, m.mentioned_uid, u.username, m.start_offset, m.end_offset
+		FROM mentions m
+		JOIN users u ON u.uid = m.mentioned_uid
+		WHERE m.`+string(source)+` = ANY($1)
+		ORDER BY m.start_offset`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mentions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var mention Mention
+		if err := rows.Scan(&id, &mention.UID, &mention.Username, &mention.Start, &mention.End); err != nil {
+			return nil, fmt.Errorf("failed to scan mention: %w", err)
+		}
+		mentions[id] = append(mentions[id], mention)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate mentions: %w", err)
+	}
+	return mentions, nil
+}
@@ -23,27 +23,34 @@ import (
 	Utils "hifi/Utils"
 )
 
-var GetClaims func(r *http.Request) (*Auth.Token, bool) = Auth.GetClaims
-
 // Handle sets up the routes for user endpoints
 func Handle(r chi.Router) {
-	r.Get("/{username}", GetUser)
-	r.Get("/self", GetSelf)
-	r.Delete("/{username}", DeleteUser)
-	r.Put("/self", UpdateUser)
+	// Public
 	r.Get("/availability/{username}", UsernameAvailability)
-	r.Get("/list", ListUser) // Added route for ListUser
-	r.Post("/profile-photo/upload", UploadProfilePhoto)
+	r.Post("/email/verify", VerifyEmail)
+
+	r.Group(func(r chi.Router) {
+		r.Use(Auth.RequireAuth)
+
+		read := r.With(Auth.RequireScope(Auth.ScopeUsersRead))
+		write := r.With(Auth.RequireScope(Auth.ScopeUsersWrite))
+
+		read.Get("/{username}", GetUser)
+		read.Get("/self", GetSelf)
+		write.Put("/self", UpdateUser)
+		read.Get("/list", ListUser) // Added route for ListUser
+		write.Post("/profile-photo/upload", UploadProfilePhoto)
+		write.Post("/self/email/verify/resend", ResendVerificationEmail)
+	})
+
+	// Deleting the account needs a session, API keys cannot do it
+	r.With(Auth.RequireSession).Delete("/{username}", DeleteUser)
 }
 
 // GetUser retrieves a user by username
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
@@ -58,13 +65,13 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	var user User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE username = $1 AND role != 'admin'`,
 		username,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -79,20 +86,25 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	user.Bio = nullStringToPtr(bioNull)
 	user.Email = nullStringToPtr(emailNull)
 
-	// Check if authenticated user follows this user
+	// Check if authenticated user follows this user or has requested to follow them
 	following := false
-	var hasFollow bool
+	requested := false
+	var hasFollow, hasRequest bool
 	err = Mdb.DB.QueryRowContext(ctx,
-		`SELECT EXISTS(SELECT 1 FROM followers WHERE followed_by = $1 AND followed_to = $2)`,
+		`SELECT
+			EXISTS(SELECT 1 FROM followers WHERE followed_by = $1 AND followed_to = $2) as following,
+			EXISTS(SELECT 1 FROM follow_requests WHERE requested_by = $1 AND requested_to = $2) as requested`,
 		claims.UID, user.UID,
-	).Scan(&hasFollow)
+	).Scan(&hasFollow, &hasRequest)
 	if err == nil {
 		following = hasFollow
+		requested = hasRequest
 	}
 
 	Utils.SendSuccessResponse(w, map[string]interface{}{
 		"user":      user,
 		"following": following,
+		"requested": requested,
 	})
 }
 
@@ -100,11 +112,7 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 // DEPRECATED: This endpoint is deprecated. Use GET /users/{username} with your own username instead.
 func GetSelf(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	user, err := fetchUserByUID(ctx, claims.UID)
 	if err != nil {
@@ -124,11 +132,7 @@ func GetSelf(w http.ResponseWriter, r *http.Request) {
 // Uses transaction to ensure atomicity
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	username := chi.URLParam(r, "username")
 	if username == "" {
@@ -226,11 +230,7 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 // Users can only update their own account
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Fetch existing user from token (like GetSelf)
 	existing, err := fetchUserByUID(ctx, claims.UID)
@@ -258,6 +258,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Role           *string `json:"role"`
 		Bio            *string `json:"bio"`
 		Email          *string `json:"email"`
+		IsPrivate      *bool   `json:"is_private"`
 	}
 	if err := json.Unmarshal(body, &payload); err != nil {
 		Utils.SendErrorResponse(w, http.StatusBadRequest, "Invalid request body")
@@ -319,11 +320,13 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate and process email update
+	// A new address starts unverified and gets a verification link
+	sendVerification := false
 	if payload.Email != nil {
 		email := strings.TrimSpace(*payload.Email)
 		if email == "" {
 			// Set to NULL if empty string
-			updates = append(updates, "email = NULL")
+			updates = append(updates, "email = NULL", "email_verified_at = NULL")
 		} else {
 			if err := ValidateEmail(email); err != nil {
 				Utils.SendErrorResponse(w, http.StatusBadRequest, err.Error())
@@ -346,9 +349,23 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 			updates = append(updates, fmt.Sprintf("email = $%d", argPos))
 			args = append(args, email)
 			argPos++
+			if existing.Email == nil || !strings.EqualFold(*existing.Email, email) {
+				updates = append(updates, "email_verified_at = NULL")
+				sendVerification = true
+			}
 		}
 	}
 
+	// Process private account update
+	// Making a private account public approves its pending follow requests
+	approvePending := false
+	if payload.IsPrivate != nil {
+		updates = append(updates, fmt.Sprintf("is_private = $%d", argPos))
+		args = append(args, *payload.IsPrivate)
+		argPos++
+		approvePending = existing.IsPrivate && !*payload.IsPrivate
+	}
+
 	// If no updates, return current user
 	if len(updates) == 0 {
 		Utils.SendSuccessResponse(w, map[string]interface{}{"user": existing})
@@ -362,14 +379,36 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	args = append(args, existing.UID)
 
 	// Execute update
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("UpdateUser: failed to begin transaction: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	defer tx.Rollback()
+
 	query := "UPDATE users SET " + strings.Join(updates, ", ") + fmt.Sprintf(" WHERE uid = $%d", argPos)
-	_, err = Mdb.DB.ExecContext(ctx, query, args...)
+	_, err = tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		log.Printf("UpdateUser: failed to update user: %v", err)
 		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
+	if approvePending {
+		if err := approveFollowRequests(ctx, tx, existing.UID); err != nil {
+			log.Printf("UpdateUser: failed to approve follow requests: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("UpdateUser: failed to commit: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
 	// Fetch updated user
 	updatedUser, err := fetchUserByUID(ctx, existing.UID)
 	if err != nil {
@@ -388,6 +427,15 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		}()
 	}
 
+	// Send a verification link to the new address (non-blocking, the user can resend it later)
+	if sendVerification && updatedUser.Email != nil {
+		go func() {
+			if err := sendVerificationEmail(context.Background(), updatedUser.UID, updatedUser.Username, *updatedUser.Email); err != nil {
+				log.Printf("UpdateUser: failed to send verification email: %v", err)
+			}
+		}()
+	}
+
 	Utils.SendSuccessResponse(w, map[string]interface{}{"user": updatedUser})
 }
 
@@ -425,11 +473,7 @@ func UsernameAvailability(w http.ResponseWriter, r *http.Request) {
 // Query params: ?limit=20&offset=0
 func ListUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Parse pagination parameters
 	limitStr := r.URL.Query().Get("limit")
@@ -461,7 +505,7 @@ func ListUser(w http.ResponseWriter, r *http.Request) {
 		seed = "hifi_users_shuffle_2024" // Default seed for stable shuffle
 	}
 	rows, err := Mdb.DB.QueryContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users 
 		WHERE role != 'admin'
@@ -483,7 +527,7 @@ func ListUser(w http.ResponseWriter, r *http.Request) {
 		var bioNull, emailNull sql.NullString
 		if err := rows.Scan(
 			&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-			&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+			&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 			&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 		); err != nil {
 			log.Printf("ListUser: failed to scan user: %v", err)
@@ -550,11 +594,7 @@ func ListUser(w http.ResponseWriter, r *http.Request) {
 
 // UploadProfilePhoto generates a presigned URL for uploading a profile photo
 func UploadProfilePhoto(w http.ResponseWriter, r *http.Request) {
-	claims, ok := GetClaims(r)
-	if !ok {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Generate the profile photo path
 	profilePhotoPath := fmt.Sprintf("ProfileProto/users/%s.jpg", claims.UID)
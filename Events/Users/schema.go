@@ -13,6 +13,8 @@ type User struct {
 	ProfilePicture string     `db:"profile_picture" json:"profile_picture"`
 	Bio            *string    `db:"bio" json:"bio,omitempty"`           // Optional biography (nullable)
 	Email          *string    `db:"email" json:"email,omitempty"`       // Optional email address (nullable)
+	EmailVerified  bool       `db:"email_verified" json:"email_verified"` // Derived from email_verified_at IS NOT NULL
+	IsPrivate      bool       `db:"is_private" json:"is_private"`         // Follows need approval and videos are hidden from non-followers
 	Followers      int        `db:"followers" json:"followers"`
 	Following      int        `db:"following" json:"following"`
 	TotalStreams   int        `db:"total_streams" json:"total_streams"`
@@ -20,3 +22,12 @@ type User struct {
 	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt      time.Time  `db:"updated_at" json:"updated_at"`
 }
+
+// Mention is an @username in a comment, reply or video description, resolved to the user
+// Start and End are character offsets of "@username" in the text (End exclusive)
+type Mention struct {
+	UID      string `db:"mentioned_uid" json:"uid"`
+	Username string `db:"username" json:"username"`
+	Start    int    `db:"start_offset" json:"start"`
+	End      int    `db:"end_offset" json:"end"`
+}
@@ -45,13 +45,13 @@ func fetchUserByUID(ctx context.Context, uid string) (*User, error) {
 	var user User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		uid,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -68,13 +68,13 @@ func fetchUserByUsername(ctx context.Context, username string) (*User, error) {
 	var user User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE username = $1`,
 		username,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -85,6 +85,30 @@ func fetchUserByUsername(ctx context.Context, username string) (*User, error) {
 	return &user, nil
 }
 
+// approveFollowRequests turns every pending request to follow uid into a follow and updates the follower counts
+// Used when a private account is made public
+func approveFollowRequests(ctx context.Context, tx *sql.Tx, uid string) error {
+	_, err := tx.ExecContext(ctx,
+		`WITH approved AS (
+			DELETE FROM follow_requests WHERE requested_to = $1
+			RETURNING requested_by, requested_to
+		), inserted AS (
+			INSERT INTO followers (followed_by, followed_to)
+			SELECT requested_by, requested_to FROM approved
+			ON CONFLICT DO NOTHING
+			RETURNING followed_by
+		), following AS (
+			UPDATE users SET following = following + 1 WHERE uid IN (SELECT followed_by FROM inserted)
+		)
+		UPDATE users SET followers = followers + (SELECT COUNT(*) FROM inserted) WHERE uid = $1`,
+		uid,
+	)
+	if err != nil {
+		return fmt.Errorf("approveFollowRequests: %w", err)
+	}
+	return nil
+}
+
 // ValidateUsername checks if username meets requirements (exported for use in Auth package)
 func ValidateUsername(username string) error {
 	username = strings.TrimSpace(strings.ToLower(username))
@@ -17,6 +17,7 @@ import (
 	"github.com/google/uuid"
 	blake3 "lukechampine.com/blake3"
 
+	Notifications "hifi/Events/Notifications"
 	Search "hifi/Events/Search"
 	Auth "hifi/Services/Auth"
 	Mdb "hifi/Services/Mdb"
@@ -24,8 +25,6 @@ import (
 	Utils "hifi/Utils"
 )
 
-var View func(ctx context.Context, auth bool, claims *Auth.Token, videoID string) error
-
 // nullStringToPtr converts sql.NullString to *string (nil if NULL, pointer to value if not)
 func nullStringToPtr(ns sql.NullString) *string {
 	if ns.Valid {
@@ -35,35 +34,37 @@ func nullStringToPtr(ns sql.NullString) *string {
 }
 
 func Handle(req chi.Router) {
-	req.Post("/upload", Upload)
-	req.Delete("/{videoID}", Delete)
-	req.Get("/{videoID}", GetVideo)
-	req.Get("/list", ListVideo)
-	req.Post("/upload/ack/{videoID}", UploadACK)
-	req.Get("/list/self", ListVideoSelf)
-	req.Get("/list/following", ListVideoFollowing)
-	req.Get("/list/{username}", ListVideoByUsername)
+	// Authentication optional: anonymous callers get the public view
+	optional := req.With(Auth.OptionalAuth, Auth.RequireScope(Auth.ScopeVideosRead))
+	optional.Get("/{videoID}", GetVideo)
+	optional.Get("/list", ListVideo)
+	optional.Get("/list/{username}", ListVideoByUsername)
+
+	// Authentication required
+	read := req.With(Auth.RequireAuth, Auth.RequireScope(Auth.ScopeVideosRead))
+	write := req.With(Auth.RequireAuth, Auth.RequireScope(Auth.ScopeVideosWrite))
+	write.Post("/upload", Upload)
+	write.Delete("/{videoID}", Delete)
+	write.Post("/upload/ack/{videoID}", UploadACK)
+	read.Get("/list/self", ListVideoSelf)
+	read.Get("/list/following", ListVideoFollowing)
 }
 
 func Upload(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Get user
 	var user Users.User
 	var bioNull, emailNull sql.NullString
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, uid, username, name, role, profile_picture, bio, email, 
+		`SELECT id, uid, username, name, role, profile_picture, bio, email, email_verified_at IS NOT NULL, is_private,
 			followers, following, total_streams, total_videos, created_at, updated_at
 		FROM users WHERE uid = $1`,
 		claims.UID,
 	).Scan(
 		&user.ID, &user.UID, &user.Username, &user.Name, &user.Role,
-		&user.ProfilePicture, &bioNull, &emailNull, &user.Followers, &user.Following,
+		&user.ProfilePicture, &bioNull, &emailNull, &user.EmailVerified, &user.IsPrivate, &user.Followers, &user.Following,
 		&user.TotalStreams, &user.TotalVideos, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
@@ -134,11 +135,7 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 
 func UploadACK(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -228,6 +225,11 @@ func UploadACK(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the mentions in the description (log errors but don't fail upload: the video is already published)
+	if err := recordMentions(ctx, temp_video); err != nil {
+		log.Printf("UploadACK: %v", err)
+	}
+
 	// Index video in Elasticsearch (non-blocking, log errors but don't fail upload)
 	go func() {
 		esCtx := context.Background()
@@ -244,13 +246,36 @@ func UploadACK(w http.ResponseWriter, r *http.Request) {
 	Utils.SendSuccessResponse(w, map[string]interface{}{"message": "video uploaded"})
 }
 
+// recordMentions records the mentions in a video's description and notifies the mentioned users
+func recordMentions(ctx context.Context, video Videos) error {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, mentioned, err := Users.SaveMentions(ctx, tx, Users.MentionInVideo, video.VideoID, video.UserUID, video.VideoDescription)
+	if err != nil {
+		return err
+	}
+	err = Notifications.NotifyUsers(ctx, tx, Notifications.Event{
+		Type:     Notifications.TypeMention,
+		ActorUID: video.UserUID,
+		VideoID:  video.VideoID,
+	}, mentioned)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mentions: %w", err)
+	}
+	return nil
+}
+
 func Delete(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	videoID := chi.URLParam(r, "videoID")
 	if videoID == "" {
@@ -368,6 +393,37 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 	Utils.SendSuccessResponse(w, map[string]interface{}{"message": "video deleted"})
 }
 
+// visibleToViewer is the SQL condition under which the video v of the user u is visible to the viewer $2
+// A private account's videos are only visible to the account itself and its followers
+const visibleToViewer = `(u.is_private IS NOT TRUE OR v.user_uid = $2
+	OR EXISTS (SELECT 1 FROM followers f WHERE f.followed_by = $2 AND f.followed_to = v.user_uid))`
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CanView reports whether a video exists and is visible to viewerUID (empty for anonymous callers),
+// with the same visibility as GET /videos/{videoID}
+// Callers answer 404 when it is not, so the existence of a private account's videos is not revealed
+func CanView(ctx context.Context, db queryRower, videoID, viewerUID string) (bool, error) {
+	var visible bool
+	err := db.QueryRowContext(ctx,
+		`SELECT `+visibleToViewer+`
+		FROM videos v
+		LEFT JOIN users u ON v.user_uid = u.uid
+		WHERE v.video_id = $1`,
+		videoID, viewerUID,
+	).Scan(&visible)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check video visibility: %w", err)
+	}
+	return visible, nil
+}
+
 func GetVideo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	videoID := chi.URLParam(r, "videoID")
@@ -380,23 +436,32 @@ func GetVideo(w http.ResponseWriter, r *http.Request) {
 	downvoted := false
 	following := false
 
-	claims, auth := Auth.GetClaims(r)
-	putViewErr := View(ctx, auth, claims, videoID)
+	claims := Auth.PrincipalFrom(r)
+	auth := claims != nil
+	viewerUID := ""
+	if auth {
+		viewerUID = claims.UID
+	}
 
 	// Get video
+	// A private account's videos are only visible to the account itself and its followers;
+	// anyone else gets a 404 so the video's existence is not revealed
 	var video Videos
+	var visible bool
 	err := Mdb.DB.QueryRowContext(ctx,
-		`SELECT id, video_id, video_url, video_thumbnail, video_title, video_description, 
-			video_tags, video_views, video_upvotes, video_downvotes, video_comments, 
-			user_uid, user_username, created_at, updated_at
-		FROM videos WHERE video_id = $1`,
-		videoID,
+		`SELECT v.id, v.video_id, v.video_url, v.video_thumbnail, v.video_title, v.video_description, 
+			v.video_tags, v.video_views, v.video_upvotes, v.video_downvotes, v.video_comments, 
+			v.user_uid, v.user_username, v.created_at, v.updated_at, `+visibleToViewer+`
+		FROM videos v
+		LEFT JOIN users u ON v.user_uid = u.uid
+		WHERE v.video_id = $1`,
+		videoID, viewerUID,
 	).Scan(
 		&video.ID, &video.VideoID, &video.VideoURL, &video.VideoThumbnail,
 		&video.VideoTitle, &video.VideoDescription, &video.VideoTags,
 		&video.VideoViews, &video.VideoUpvotes, &video.VideoDownvotes,
 		&video.VideoComments, &video.UserUID, &video.UserUsername,
-		&video.CreatedAt, &video.UpdatedAt,
+		&video.CreatedAt, &video.UpdatedAt, &visible,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -407,6 +472,10 @@ func GetVideo(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !visible {
+		Utils.SendErrorResponse(w, http.StatusNotFound, "Video not found")
+		return
+	}
 
 	// Optimized: Check upvoted, downvoted, and following in a single query
 	if auth {
@@ -437,26 +506,51 @@ func GetVideo(w http.ResponseWriter, r *http.Request) {
 		"following":     following,
 	}
 
-	if putViewErr != nil {
-		response["put_view_error"] = putViewErr.Error()
-	}
-
 	Utils.SendSuccessResponse(w, response)
 }
 
+// attachMentions sets the mentions in the descriptions of listed videos
+func attachMentions(ctx context.Context, videos []Videos) error {
+	videoIDs := make([]string, len(videos))
+	for i, video := range videos {
+		videoIDs[i] = video.VideoID
+	}
+	mentions, err := Users.LoadMentions(ctx, Mdb.DB, Users.MentionInVideo, videoIDs)
+	if err != nil {
+		return err
+	}
+	for i := range videos {
+		videos[i].Mentions = mentions[videos[i].VideoID]
+	}
+	return nil
+}
+
 // Constants for pagination
 const (
 	DefaultVideoPageLimit = 20
 	MaxVideoPageLimit     = 100
 )
 
+// mutedVideosFilter leaves out the videos the caller ($1) muted in the feeds: videos of
+// muted users and videos matching a muted keyword (title or description) or tag
+const mutedVideosFilter = `NOT EXISTS (SELECT 1 FROM mutes m WHERE m.muted_by = $1 AND m.muted_to = v.user_uid)
+		AND NOT EXISTS (
+			SELECT 1 FROM muted_words mw
+			WHERE mw.user_uid = $1 AND (
+				(mw.kind = 'keyword' AND (strpos(lower(COALESCE(v.video_title, '')), mw.word) > 0
+					OR strpos(lower(COALESCE(v.video_description, '')), mw.word) > 0))
+				OR (mw.kind = 'tag' AND EXISTS (SELECT 1 FROM unnest(v.video_tags) t WHERE lower(t) = mw.word))
+			)
+		)`
+
 // ListVideo lists all videos with deterministic random pagination (stable shuffle)
 // Query params: ?limit=20&offset=0&seed=optional_seed
 func ListVideo(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Check if user is authenticated (optional for this endpoint)
-	claims, auth := Auth.GetClaims(r)
+	claims := Auth.PrincipalFrom(r)
+	auth := claims != nil
 
 	// Parse pagination parameters from query string
 	limitStr := r.URL.Query().Get("limit")
@@ -490,6 +584,8 @@ func ListVideo(w http.ResponseWriter, r *http.Request) {
 
 	// Optimized: Use LEFT JOIN to get following status and user profile_picture in a single query
 	// This eliminates the need for a separate query and array collection
+	// Videos of users the caller blocked or muted, and videos matching their muted words, are left out
+	// Videos of private accounts are left out unless the caller owns or follows the account
 	var query string
 	var args []interface{}
 	if auth {
@@ -502,6 +598,9 @@ func ListVideo(w http.ResponseWriter, r *http.Request) {
 		FROM videos v
 		LEFT JOIN users u ON v.user_uid = u.uid
 		LEFT JOIN followers f ON f.followed_by = $1 AND f.followed_to = v.user_uid
+		WHERE NOT EXISTS (SELECT 1 FROM blocklists b WHERE b.blocked_by = $1 AND b.blocked_to = v.user_uid)
+			AND (u.is_private IS NOT TRUE OR v.user_uid = $1 OR f.followed_by IS NOT NULL)
+			AND ` + mutedVideosFilter + `
 		ORDER BY hashtext(v.id::text || $2)
 		LIMIT $3 OFFSET $4`
 		args = []interface{}{claims.UID, seed, limit, offset}
@@ -514,6 +613,7 @@ func ListVideo(w http.ResponseWriter, r *http.Request) {
 			false as following
 		FROM videos v
 		LEFT JOIN users u ON v.user_uid = u.uid
+		WHERE u.is_private IS NOT TRUE
 		ORDER BY hashtext(v.id::text || $1)
 		LIMIT $2 OFFSET $3`
 		args = []interface{}{seed, limit, offset}
@@ -563,6 +663,12 @@ func ListVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := attachMentions(ctx, videos); err != nil {
+		log.Printf("ListVideo: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+
 	// Build response with following status and profile picture for each video
 	type VideoWithFollowing struct {
 		Video          Videos `json:"video"`
@@ -592,11 +698,7 @@ func ListVideo(w http.ResponseWriter, r *http.Request) {
 // Query params: ?limit=20&offset=0&seed=optional_seed
 func ListVideoSelf(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Parse pagination parameters from query string
 	limitStr := r.URL.Query().Get("limit")
@@ -668,6 +770,12 @@ func ListVideoSelf(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := attachMentions(ctx, videos); err != nil {
+		log.Printf("ListVideoSelf: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+
 	Utils.SendSuccessResponse(w, map[string]interface{}{
 		"videos": videos,
 		"limit":  limit,
@@ -682,11 +790,7 @@ func ListVideoSelf(w http.ResponseWriter, r *http.Request) {
 // Ordered by created_at DESC (newest first)
 func ListVideoFollowing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, auth := Auth.GetClaims(r)
-	if !auth {
-		Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	claims := Auth.PrincipalFrom(r)
 
 	// Parse pagination parameters from query string
 	limitStr := r.URL.Query().Get("limit")
@@ -711,6 +815,7 @@ func ListVideoFollowing(w http.ResponseWriter, r *http.Request) {
 
 	// Query videos from users that the authenticated user follows
 	// Uses INNER JOIN for efficiency - only returns videos from followed users
+	// Muted users and videos matching muted words are left out
 	// Ordered by created_at DESC (newest first)
 	rows, err := Mdb.DB.QueryContext(ctx,
 		`SELECT v.id, v.video_id, v.video_url, v.video_thumbnail, v.video_title, v.video_description, 
@@ -719,6 +824,7 @@ func ListVideoFollowing(w http.ResponseWriter, r *http.Request) {
 		FROM videos v
 		INNER JOIN followers f ON v.user_uid = f.followed_to
 		WHERE f.followed_by = $1
+			AND `+mutedVideosFilter+`
 		ORDER BY v.created_at DESC
 		LIMIT $2 OFFSET $3`,
 		claims.UID, limit, offset,
@@ -754,6 +860,12 @@ func ListVideoFollowing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := attachMentions(ctx, videos); err != nil {
+		log.Printf("ListVideoFollowing: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+
 	// Build response with following status (always true since we filtered by followed users)
 	type VideoWithFollowing struct {
 		Video     Videos `json:"video"`
@@ -782,7 +894,8 @@ func ListVideoByUsername(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Check if user is authenticated (optional for this endpoint)
-	claims, auth := Auth.GetClaims(r)
+	claims := Auth.PrincipalFrom(r)
+	auth := claims != nil
 
 	// Get username from URL parameter
 	username := chi.URLParam(r, "username")
@@ -815,6 +928,12 @@ func ListVideoByUsername(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// A private account's videos are only listed for the account itself and its followers
+	viewerUID := ""
+	if auth {
+		viewerUID = claims.UID
+	}
+
 	rows, err := Mdb.DB.QueryContext(ctx,
 		`SELECT v.id, v.video_id, v.video_url, v.video_thumbnail, v.video_title, v.video_description, 
 			v.video_tags, v.video_views, v.video_upvotes, v.video_downvotes, v.video_comments, 
@@ -822,9 +941,11 @@ func ListVideoByUsername(w http.ResponseWriter, r *http.Request) {
 		FROM videos v
 		INNER JOIN users u ON v.user_uid = u.uid
 		WHERE u.username = $1
+			AND (NOT u.is_private OR u.uid = $4
+				OR EXISTS (SELECT 1 FROM followers f WHERE f.followed_by = $4 AND f.followed_to = u.uid))
 		ORDER BY v.created_at DESC
 		LIMIT $2 OFFSET $3`,
-		username, limit, offset,
+		username, limit, offset, viewerUID,
 	)
 	if err != nil {
 		log.Printf("ListVideoByUsername: failed to query videos: %v", err)
@@ -861,6 +982,12 @@ func ListVideoByUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := attachMentions(ctx, videos); err != nil {
+		log.Printf("ListVideoByUsername: %v", err)
+		Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch mentions")
+		return
+	}
+
 	// Check if authenticated user follows the video owner
 	following := false
 	if auth && videoOwnerUID != "" {
@@ -0,0 +1,173 @@
+package videos
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	Mdb "hifi/Services/Mdb"
+	Testdb "hifi/Utils/Testdb"
+)
+
+// The tests check which videos the feeds show to whom. They run against the Postgres database
+// of Utils/Testdb and are skipped when HIFI_TEST_POSTGRES is not set.
+
+func TestMain(m *testing.M) {
+	Testdb.Main(m)
+}
+
+func testRouter() http.Handler {
+	return Testdb.Router(func(r chi.Router) {
+		r.Get("/videos/list", ListVideo)
+		r.Get("/videos/list/following", ListVideoFollowing)
+		r.Get("/videos/list/{username}", ListVideoByUsername)
+		r.Get("/videos/{videoID}", GetVideo)
+	})
+}
+
+// listedVideos pages through a feed as uid (anonymous if empty) and returns the IDs of the videos listed
+func listedVideos(t *testing.T, router http.Handler, uid, path string) map[string]bool {
+	t.Helper()
+	listed := map[string]bool{}
+	for offset := 0; ; offset += MaxVideoPageLimit {
+		var page struct {
+			Videos []struct {
+				Video Videos `json:"video"`
+			} `json:"videos"`
+		}
+		if code := Testdb.RequestData(t, router, uid, http.MethodGet, fmt.Sprintf("%s?limit=%d&offset=%d", path, MaxVideoPageLimit, offset), "", &page); code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, code)
+		}
+		for _, v := range page.Videos {
+			listed[v.Video.VideoID] = true
+		}
+		if len(page.Videos) < MaxVideoPageLimit {
+			return listed
+		}
+	}
+}
+
+func TestListVideoBlocked(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	aliceVideo := Testdb.CreateVideo(t, alice)
+	bobVideo := Testdb.CreateVideo(t, bob)
+	carolVideo := Testdb.CreateVideo(t, carol)
+	router := testRouter()
+
+	if _, err := Mdb.DB.Exec("INSERT INTO blocklists (blocked_by, blocked_to, blocked_at) VALUES ($1, $2, $3)", alice.UID, bob.UID, time.Now()); err != nil {
+		t.Fatalf("failed to block: %v", err)
+	}
+
+	// The blocked user's videos disappear from the blocker's feed only
+	listed := listedVideos(t, router, alice.UID, "/videos/list")
+	if listed[bobVideo] || !listed[carolVideo] {
+		t.Errorf("alice's feed: bob's video listed = %v, carol's = %v, want only carol's", listed[bobVideo], listed[carolVideo])
+	}
+	if listed := listedVideos(t, router, carol.UID, "/videos/list"); !listed[bobVideo] || !listed[aliceVideo] {
+		t.Error("carol's feed is missing videos of alice or bob")
+	}
+	if listed := listedVideos(t, router, "", "/videos/list"); !listed[bobVideo] {
+		t.Error("anonymous feed is missing bob's video")
+	}
+}
+
+func TestListVideoMuted(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	aliceVideo := Testdb.CreateVideo(t, alice)
+	bobVideo := Testdb.CreateVideo(t, bob)
+	plainVideo := Testdb.CreateVideo(t, carol)
+	keywordVideo := Testdb.CreateVideo(t, carol)
+	tagVideo := Testdb.CreateVideo(t, carol)
+	router := testRouter()
+
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE videos SET video_title = 'The Big SPOILERS' WHERE video_id = $1", []interface{}{keywordVideo}},
+		{"UPDATE videos SET video_tags = ARRAY['music', 'Leak'] WHERE video_id = $1", []interface{}{tagVideo}},
+		{"INSERT INTO followers (followed_by, followed_to) VALUES ($1, $2), ($1, $3)", []interface{}{alice.UID, bob.UID, carol.UID}},
+		{"INSERT INTO mutes (muted_by, muted_to) VALUES ($1, $2)", []interface{}{alice.UID, bob.UID}},
+		{"INSERT INTO muted_words (user_uid, word, kind) VALUES ($1, 'spoilers', 'keyword'), ($1, 'leak', 'tag')", []interface{}{alice.UID}},
+	} {
+		if _, err := Mdb.DB.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatalf("%s: %v", stmt.query, err)
+		}
+	}
+
+	// The muter's feeds leave out the muted user and the videos matching muted words
+	for _, path := range []string{"/videos/list", "/videos/list/following"} {
+		listed := listedVideos(t, router, alice.UID, path)
+		if !listed[plainVideo] {
+			t.Errorf("%s for alice is missing carol's video", path)
+		}
+		for name, videoID := range map[string]string{"muted user": bobVideo, "muted keyword": keywordVideo, "muted tag": tagVideo} {
+			if listed[videoID] {
+				t.Errorf("%s for alice lists the video of a %s", path, name)
+			}
+		}
+	}
+
+	// Nothing changes for the muted user or anyone else
+	if listed := listedVideos(t, router, bob.UID, "/videos/list"); !listed[aliceVideo] {
+		t.Error("bob's feed is missing alice's video")
+	}
+	listed := listedVideos(t, router, carol.UID, "/videos/list")
+	for _, videoID := range []string{bobVideo, keywordVideo, tagVideo} {
+		if !listed[videoID] {
+			t.Errorf("carol's feed is missing video %s", videoID)
+		}
+	}
+}
+
+func TestPrivateAccountVideos(t *testing.T) {
+	Testdb.Require(t)
+	users := Testdb.CreateUsers(t, 3)
+	alice, bob, carol := users[0], users[1], users[2]
+	videoID := Testdb.CreateVideo(t, alice)
+	router := testRouter()
+
+	if _, err := Mdb.DB.Exec("UPDATE users SET is_private = TRUE WHERE uid = $1", alice.UID); err != nil {
+		t.Fatalf("failed to make account private: %v", err)
+	}
+	if _, err := Mdb.DB.Exec("INSERT INTO followers (followed_by, followed_to) VALUES ($1, $2)", bob.UID, alice.UID); err != nil {
+		t.Fatalf("failed to follow: %v", err)
+	}
+
+	// The account itself and its followers see the videos; anyone else gets a 404 or an empty list
+	tests := []struct {
+		name    string
+		uid     string
+		visible bool
+	}{
+		{name: "owner", uid: alice.UID, visible: true},
+		{name: "follower", uid: bob.UID, visible: true},
+		{name: "non-follower", uid: carol.UID, visible: false},
+		{name: "anonymous", uid: "", visible: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := http.StatusNotFound
+			if tt.visible {
+				want = http.StatusOK
+			}
+			if code := Testdb.Request(t, router, tt.uid, http.MethodGet, "/videos/"+videoID, ""); code != want {
+				t.Errorf("GetVideo: status %d, want %d", code, want)
+			}
+			if listed := listedVideos(t, router, tt.uid, "/videos/list/"+alice.Username); listed[videoID] != tt.visible {
+				t.Errorf("ListVideoByUsername lists the video: %v, want %v", listed[videoID], tt.visible)
+			}
+			if listed := listedVideos(t, router, tt.uid, "/videos/list"); listed[videoID] != tt.visible {
+				t.Errorf("ListVideo lists the video: %v, want %v", listed[videoID], tt.visible)
+			}
+		})
+	}
+}
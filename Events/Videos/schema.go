@@ -6,6 +6,8 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	Users "hifi/Events/Users"
 )
 
 // StringArray is a custom type for PostgreSQL text arrays
@@ -92,4 +94,5 @@ type Videos struct {
 	UserUsername    string     `db:"user_username" json:"user_username"`
 	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+	Mentions        []Users.Mention `db:"-" json:"mentions,omitempty"` // @mentions in the description (from the mentions table)
 }
\ No newline at end of file
@@ -4,28 +4,57 @@ import (
 	_ "fmt"
 	Admin "hifi/Events/Admin"
 	Auth "hifi/Events/Auth"
+	Messages "hifi/Events/Messages"
+	Notifications "hifi/Events/Notifications"
+	Reports "hifi/Events/Reports"
 	Search "hifi/Events/Search"
 	Social "hifi/Events/Social"
+	Stream "hifi/Events/Stream"
 	User "hifi/Events/Users"
 	Videos "hifi/Events/Videos"
+	AuthService "hifi/Services/Auth"
 
 	"github.com/go-chi/chi/v5"
 )
 
-func Init() {
-	Videos.View = Social.View
-}
-
+// Handler mounts the routes of every package
+// Auth policies are declared with the route: package wide ones here, per route ones in each Handle
+// (RequireAuth, RequireSession, OptionalAuth, RequireScope, RequireRole, RequirePermission from Services/Auth)
 func Handler(req chi.Router) {
 	req.Route("/auth", Auth.Handle)
 	req.Route("/users", User.Handle)
 	req.Route("/videos", Videos.Handle)
 
-	req.Route("/social/users", Social.HandleUsers)
+	req.Route("/social/users", func(r chi.Router) {
+		r.Use(AuthService.RequireAuth)
+		Social.HandleUsers(r)
+	})
 	req.Route("/social/videos", Social.HandleVideos)
 
-	req.Route("/admin", Admin.Handle)
+	req.Route("/notifications", func(r chi.Router) {
+		r.Use(AuthService.RequireAuth)
+		Notifications.Handle(r)
+	})
+
+	req.Route("/messages", func(r chi.Router) {
+		r.Use(AuthService.RequireAuth)
+		Messages.Handle(r)
+	})
+
+	req.Route("/reports", func(r chi.Router) {
+		r.Use(AuthService.RequireAuth)
+		Reports.Handle(r)
+	})
+
+	req.Route("/stream", Stream.Handle)
+
+	req.Route("/admin", func(r chi.Router) {
+		r.Use(AuthService.RequireAuth)
+		Admin.Handle(r)
+	})
 
 	req.Route("/search", Search.Handle)
 
+	req.Get("/.well-known/jwks.json", Auth.JWKS)
+
 }
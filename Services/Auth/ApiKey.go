@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrInvalidAPIKey   = errors.New("invalid api key")
+	ErrUnknownScope    = errors.New("unknown scope")
+	ErrTooManyAPIKeys  = errors.New("too many api keys")
+	ErrAPIKeyForbidden = errors.New("api key scope requires a staff role")
+)
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT
+const APIKeyPrefix = "hifi_"
+
+// apiKeyIDLength is the length of the hex key ID that follows the prefix
+const apiKeyIDLength = 16
+
+// Scopes an API key can be granted. A write scope also grants the read scope of the same resource
+const (
+	ScopeVideosRead  = "videos:read"
+	ScopeVideosWrite = "videos:write"
+	ScopeSocialRead  = "social:read"
+	ScopeSocialWrite = "social:write"
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopeAdminRead   = "admin:read"
+	ScopeAdminWrite  = "admin:write"
+
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+// APIKeyScopes lists every valid scope
+var APIKeyScopes = []string{
+	ScopeVideosRead, ScopeVideosWrite,
+	ScopeSocialRead, ScopeSocialWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeAdminRead, ScopeAdminWrite,
+	ScopeMessagesRead, ScopeMessagesWrite,
+}
+
+var (
+	DefaultAPIKeyValidity = 90 * 24 * time.Hour
+	MaxAPIKeyValidity     = 365 * 24 * time.Hour
+	MaxAPIKeysPerUser     = 25
+)
+
+// APIKey is a personal API key as shown to its owner and admins; the secret is never stored
+type APIKey struct {
+	KeyID      string     `json:"key_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, to recognise it
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope reports whether the principal may use a scope
+// Session tokens carry the full rights of their user, API keys only their granted scopes
+func (t *Principal) HasScope(scope string) bool {
+	if t.APIKeyID == "" {
+		return true
+	}
+	resource, access, _ := strings.Cut(scope, ":")
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+		if access == "read" && granted == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKey reports whether the principal authenticated with an API key
+func (t *Principal) IsAPIKey() bool {
+	return t.APIKeyID != ""
+}
+
+// NormalizeScopes validates scopes and returns them sorted by APIKeyScopes, without duplicates
+func NormalizeScopes(scopes []string) ([]string, error) {
+	requested := map[string]bool{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		valid := false
+		for _, known := range APIKeyScopes {
+			if scope == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, scope)
+		}
+		requested[scope] = true
+	}
+
+	normalized := []string{}
+	for _, known := range APIKeyScopes {
+		if requested[known] {
+			normalized = append(normalized, known)
+		}
+	}
+	return normalized, nil
+}
+
+// CreateAPIKey mints an API key for a user and returns the key, which is only shown once
+// Admin scopes are only granted to staff (see IsStaff)
+func CreateAPIKey(ctx context.Context, uid, name string, scopes []string, validity time.Duration) (string, *APIKey, error) {
+	for _, scope := range scopes {
+		if !strings.HasPrefix(scope, "admin:") {
+			continue
+		}
+		staff, err := IsStaff(ctx, uid)
+		if err != nil {
+			return "", nil, err
+		}
+		if !staff {
+			return "", nil, ErrAPIKeyForbidden
+		}
+		break
+	}
+
+	keyID, err := generateID(apiKeyIDLength / 2)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, secretHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	key := APIKeyPrefix + keyID + "_" + secret
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent requests cannot exceed the limit
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE uid = $1 FOR UPDATE", uid); err != nil {
+		return "", nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	now := time.Now()
+	var active int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM api_keys WHERE user_uid = $1 AND revoked_at IS NULL AND expires_at > $2",
+		uid, now,
+	).Scan(&active)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to count api keys: %w", err)
+	}
+	if active >= MaxAPIKeysPerUser {
+		return "", nil, ErrTooManyAPIKeys
+	}
+
+	apiKey := &APIKey{
+		KeyID:     keyID,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+apiKeyIDLength],
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(validity),
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO api_keys (key_id, user_uid, name, key_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		keyID, uid, name, secretHash, pq.Array(scopes), now, apiKey.ExpiresAt,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to commit api key: %w", err)
+	}
+	return key, apiKey, nil
+}
+
+// ListAPIKeys returns the active (not revoked, not expired) API keys of a user, newest first
+func ListAPIKeys(ctx context.Context, uid string) ([]APIKey, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT key_id, name, scopes, created_at, expires_at, last_used_at
+		FROM api_keys
+		WHERE user_uid = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY created_at DESC`,
+		uid, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&key.KeyID, &key.Name, pq.Array(&key.Scopes), &key.CreatedAt, &key.ExpiresAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		key.Prefix = APIKeyPrefix + key.KeyID
+		if lastUsed.Valid {
+			key.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one API key of a user
+// Returns false if the key does not exist, belongs to another user or was already revoked
+func RevokeAPIKey(ctx context.Context, uid, keyID string) (bool, error) {
+	result, err := Mdb.DB.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = $1 WHERE key_id = $2 AND user_uid = $3 AND revoked_at IS NULL",
+		time.Now(), keyID, uid,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// RevokeUserAPIKeys revokes every API key of a user and returns how many were revoked
+// db is Mdb.DB, or a transaction the revocation is part of
+func RevokeUserAPIKeys(ctx context.Context, db execer, uid string) (int64, error) {
+	result, err := db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = $1 WHERE user_uid = $2 AND revoked_at IS NULL",
+		time.Now(), uid,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke api keys: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// verifyAPIKey checks an API key and returns the principal it authenticates
+func verifyAPIKey(ctx context.Context, key string) (*Principal, error) {
+	rest := strings.TrimPrefix(key, APIKeyPrefix)
+	if len(rest) <= apiKeyIDLength+1 || rest[apiKeyIDLength] != '_' {
+		return nil, ErrInvalidAPIKey
+	}
+	keyID, secret := rest[:apiKeyIDLength], rest[apiKeyIDLength+1:]
+
+	var uid, storedHash string
+	var scopes []string
+	var expiresAt time.Time
+	var revokedAt, lastUsed sql.NullTime
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT user_uid, key_hash, scopes, expires_at, revoked_at, last_used_at
+		FROM api_keys WHERE key_id = $1`,
+		keyID,
+	).Scan(&uid, &storedHash, pq.Array(&scopes), &expiresAt, &revokedAt, &lastUsed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, fmt.Errorf("failed to fetch api key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashOpaqueToken(secret)), []byte(storedHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > lastSeenResolution {
+		go func() {
+			if _, err := Mdb.DB.ExecContext(context.Background(),
+				"UPDATE api_keys SET last_used_at = $1 WHERE key_id = $2",
+				time.Now(), keyID,
+			); err != nil {
+				log.Printf("verifyAPIKey: failed to update last_used_at: %v", err)
+			}
+		}()
+	}
+
+	return &Principal{UID: uid, APIKeyID: keyID, Scopes: scopes}, nil
+}
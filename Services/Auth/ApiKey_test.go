@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHasScope(t *testing.T) {
+	session := &Principal{UID: "alice", SessionID: "session"}
+	readOnly := &Principal{UID: "alice", APIKeyID: "key", Scopes: []string{ScopeVideosRead}}
+	writer := &Principal{UID: "alice", APIKeyID: "key", Scopes: []string{ScopeSocialWrite}}
+	unscoped := &Principal{UID: "alice", APIKeyID: "key"}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		scope     string
+		want      bool
+	}{
+		{name: "session has every scope", principal: session, scope: ScopeAdminWrite, want: true},
+		{name: "granted scope", principal: readOnly, scope: ScopeVideosRead, want: true},
+		{name: "read does not imply write", principal: readOnly, scope: ScopeVideosWrite, want: false},
+		{name: "other resource", principal: readOnly, scope: ScopeSocialRead, want: false},
+		{name: "write implies read", principal: writer, scope: ScopeSocialRead, want: true},
+		{name: "write scope", principal: writer, scope: ScopeSocialWrite, want: true},
+		{name: "write of another resource", principal: writer, scope: ScopeVideosRead, want: false},
+		{name: "no scopes", principal: unscoped, scope: ScopeUsersRead, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%q) with scopes %v = %v, want %v", tt.scope, tt.principal.Scopes, got, tt.want)
+			}
+		})
+	}
+
+	if session.IsAPIKey() || !readOnly.IsAPIKey() {
+		t.Error("IsAPIKey does not tell API keys from sessions")
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		want   []string
+	}{
+		{name: "none", scopes: nil, want: []string{}},
+		{name: "sorted by APIKeyScopes", scopes: []string{ScopeAdminRead, ScopeVideosWrite, ScopeSocialRead},
+			want: []string{ScopeVideosWrite, ScopeSocialRead, ScopeAdminRead}},
+		{name: "duplicates", scopes: []string{ScopeUsersRead, ScopeUsersRead}, want: []string{ScopeUsersRead}},
+		{name: "case and space", scopes: []string{" Videos:Read ", "VIDEOS:READ"}, want: []string{ScopeVideosRead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeScopes(tt.scopes)
+			if err != nil {
+				t.Fatalf("NormalizeScopes(%q): %v", tt.scopes, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeScopes(%q) = %q, want %q", tt.scopes, got, tt.want)
+			}
+		})
+	}
+
+	for _, scopes := range [][]string{{"videos:delete"}, {ScopeVideosRead, "videos"}, {""}, {"*"}} {
+		if _, err := NormalizeScopes(scopes); !errors.Is(err, ErrUnknownScope) {
+			t.Errorf("NormalizeScopes(%q) = %v, want %v", scopes, err, ErrUnknownScope)
+		}
+	}
+
+	all, err := NormalizeScopes(APIKeyScopes)
+	if err != nil || !reflect.DeepEqual(all, APIKeyScopes) {
+		t.Errorf("NormalizeScopes(APIKeyScopes) = %q, %v", all, err)
+	}
+}
+
+func TestVerifyAPIKeyMalformed(t *testing.T) {
+	// Keys without a key ID and secret are rejected before the database is consulted
+	for _, key := range []string{"", APIKeyPrefix, APIKeyPrefix + "0123", APIKeyPrefix + "0123456789abcdef", "not-a-key"} {
+		if _, err := verifyAPIKey(context.Background(), key); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("verifyAPIKey(%q) = %v, want %v", key, err, ErrInvalidAPIKey)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package auth
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -18,44 +17,82 @@ import (
 
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
-	UID string `json:"uid"`
+	UID       string `json:"uid"`
+	SessionID string `json:"sid"` // Session the token belongs to (see auth_sessions)
 	jwt.RegisteredClaims
 }
 
 var (
-	JWTSecret     []byte
-	TokenValidity = 24 * time.Hour // Token expires in 24 hours
+	TokenValidity        = 15 * time.Minute    // Access tokens expire after 15 minutes
+	RefreshTokenValidity = 30 * 24 * time.Hour // Refresh tokens (sessions) expire after 30 days
 )
 
 // InitAuth initializes the JWT authentication system
+// Tokens are signed with asymmetric keys from the jwt_signing_keys table (see Keys.go)
 func Initauth() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// Generate a random secret if not provided (for development only)
-		// In production, JWT_SECRET should be set in environment
-		log.Println("Warning: JWT_SECRET not set, generating random secret (not recommended for production)")
-		secretBytes := make([]byte, 32)
-		if _, err := rand.Read(secretBytes); err != nil {
-			log.Fatalf("Failed to generate JWT secret: %v", err)
-		}
-		secret = base64.URLEncoding.EncodeToString(secretBytes)
-		log.Printf("Generated JWT_SECRET: %s (save this for production)", secret)
-	}
-	JWTSecret = []byte(secret)
-
 	// Set token validity from env if provided
+	// JWT_TOKEN_VALIDITY_HOURS is still honoured for older deployments
 	if validityStr := os.Getenv("JWT_TOKEN_VALIDITY_HOURS"); validityStr != "" {
 		if hours, err := time.ParseDuration(validityStr + "h"); err == nil {
 			TokenValidity = hours
 		}
 	}
+	if validityStr := os.Getenv("JWT_ACCESS_TOKEN_VALIDITY_MINUTES"); validityStr != "" {
+		if minutes, err := time.ParseDuration(validityStr + "m"); err == nil {
+			TokenValidity = minutes
+		}
+	}
+	if validityStr := os.Getenv("JWT_REFRESH_TOKEN_VALIDITY_HOURS"); validityStr != "" {
+		if hours, err := time.ParseDuration(validityStr + "h"); err == nil {
+			RefreshTokenValidity = hours
+		}
+	}
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		TOTPIssuer = issuer
+	}
+
+	// Signing key ring
+	if alg := os.Getenv("JWT_SIGNING_ALG"); alg != "" {
+		switch strings.ToUpper(alg) {
+		case strings.ToUpper(AlgRS256):
+			SigningAlgorithm = AlgRS256
+		case strings.ToUpper(AlgEdDSA):
+			SigningAlgorithm = AlgEdDSA
+		default:
+			log.Fatalf("Unsupported JWT_SIGNING_ALG %q (expected RS256 or EdDSA)", alg)
+		}
+	}
+	if validityStr := os.Getenv("JWT_KEY_ROTATION_HOURS"); validityStr != "" {
+		if hours, err := time.ParseDuration(validityStr + "h"); err == nil {
+			KeyRotationPeriod = hours
+		}
+	}
+	if KeyRotationPeriod < 2*keyPrepublish {
+		KeyRotationPeriod = 2 * keyPrepublish
+	}
+	if encoded := os.Getenv("JWT_KEY_ENCRYPTION_KEY"); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			log.Fatal("JWT_KEY_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+		}
+		keyEncryptionKey = key
+	}
+	if os.Getenv("JWT_SECRET") != "" {
+		log.Println("Warning: JWT_SECRET is no longer used, tokens are signed with the keys in jwt_signing_keys")
+	}
+
+	if err := initKeyRing(context.Background()); err != nil {
+		log.Fatalf("Failed to initialize JWT signing keys: %v", err)
+	}
+	fmt.Printf("Auth initialized! Signing algorithm: %s, key rotation: %s\n", SigningAlgorithm, KeyRotationPeriod)
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(uid string) (string, error) {
+// GenerateToken creates a new JWT access token for a user session
+func GenerateToken(uid, sessionID string) (string, error) {
 	now := time.Now()
 	claims := JWTClaims{
-		UID: uid,
+		UID:       uid,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(TokenValidity)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -64,24 +101,14 @@ func GenerateToken(uid string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(JWTSecret)
-	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
-	}
-
-	return tokenString, nil
+	return signToken(claims)
 }
 
 // VerifyToken verifies and parses a JWT token
+// Tokens whose session has been revoked or has expired are rejected
 func VerifyToken(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return JWTSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, verificationKeyFunc,
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -92,12 +119,20 @@ func VerifyToken(tokenString string) (*JWTClaims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	// Tokens issued before sessions existed carry no sid and are no longer accepted
+	if claims.SessionID == "" {
+		return nil, errors.New("token has no session")
+	}
+	if err := checkSession(context.Background(), claims.SessionID); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
 
-// GetClaims extracts and verifies JWT token from request Authorization header
-// Returns a Token-like struct for compatibility with existing code
-func GetClaims(r *http.Request) (*Token, bool) {
+// GetClaims extracts and verifies the JWT or API key from the request Authorization header
+// Returns the authenticated user of the request and the session or API key it authenticated with
+func GetClaims(r *http.Request) (*Principal, bool) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return nil, false
@@ -116,18 +151,42 @@ func GetClaims(r *http.Request) (*Token, bool) {
 		return nil, false
 	}
 
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		token, err := verifyAPIKey(r.Context(), tokenString)
+		if err != nil {
+			return nil, false
+		}
+		return token, true
+	}
+
 	claims, err := VerifyToken(tokenString)
 	if err != nil {
 		return nil, false
 	}
 
-	// Return a Token struct compatible with the old Firebase auth.Token interface
-	return &Token{UID: claims.UID}, true
+	return &Principal{UID: claims.UID, SessionID: claims.SessionID}, true
 }
 
-// Token represents a user token (compatible with Firebase auth.Token interface)
-type Token struct {
-	UID string
+// GetSessionClaims is GetClaims for endpoints that manage the account's credentials
+// (sessions, passwords, two-factor, API keys); API keys are not accepted there
+func GetSessionClaims(r *http.Request) (*Principal, bool) {
+	claims, ok := GetClaims(r)
+	if !ok || claims.IsAPIKey() {
+		return nil, false
+	}
+	return claims, true
+}
+
+// Principal identifies the caller of an authenticated request
+// The auth middleware (see Middleware.go) stores it in the request context
+type Principal struct {
+	UID       string
+	SessionID string   // Set for session (JWT) tokens
+	APIKeyID  string   // Set for API keys
+	Scopes    []string // Scopes granted to the API key
+	Role      string   // Loaded by RequireRole, empty otherwise
+
+	Permissions map[string]bool // Loaded by RequirePermission, nil otherwise
 }
 
 // HashPassword hashes a password using bcrypt
@@ -144,11 +203,3 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
-
-// DeleteUser is a no-op for custom JWT auth (user deletion is handled in Users package)
-// Kept for compatibility with existing code
-func DeleteUser(ctx context.Context, uid string) error {
-	// User deletion is handled in the Users package by removing from database
-	// No separate auth service deletion needed for JWT
-	return nil
-}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrInvalidOIDCState      = errors.New("invalid or expired login state")
+	ErrIdentityNotFound      = errors.New("identity is not linked to a user")
+	ErrIdentityLinked        = errors.New("identity is linked to another user")
+	ErrProviderAlreadyLinked = errors.New("an identity from this provider is already linked")
+	ErrLastLoginMethod       = errors.New("cannot remove the only way to sign in")
+)
+
+// OIDCLoginStateValidity is how long a user has to complete the provider login
+var OIDCLoginStateValidity = 10 * time.Minute
+
+// OIDCLoginState is the server side half of an authorization code login
+type OIDCLoginState struct {
+	Provider     string
+	CodeVerifier string // PKCE code verifier
+	Nonce        string
+	LinkUID      string // Set when the identity is linked to an existing, signed in user
+}
+
+// Identity is an external identity linked to a user
+type Identity struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       *string    `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// CreateOIDCLoginState stores a new login state and returns the opaque state parameter for it
+// linkUID is empty for a login and the signed in user for linking an identity
+func CreateOIDCLoginState(ctx context.Context, provider, linkUID string) (string, *OIDCLoginState, error) {
+	state, stateHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, _, err := GenerateOpaqueToken(48)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, _, err := GenerateOpaqueToken(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	if _, err := Mdb.DB.ExecContext(ctx, "DELETE FROM oidc_login_states WHERE expires_at <= $1", now); err != nil {
+		return "", nil, fmt.Errorf("failed to delete expired login states: %w", err)
+	}
+
+	var linkUIDNull sql.NullString
+	if linkUID != "" {
+		linkUIDNull = sql.NullString{String: linkUID, Valid: true}
+	}
+	_, err = Mdb.DB.ExecContext(ctx,
+		`INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, user_uid, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		stateHash, provider, verifier, nonce, linkUIDNull, now, now.Add(OIDCLoginStateValidity),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create login state: %w", err)
+	}
+
+	return state, &OIDCLoginState{Provider: provider, CodeVerifier: verifier, Nonce: nonce, LinkUID: linkUID}, nil
+}
+
+// RedeemOIDCLoginState consumes a login state, it cannot be used again
+func RedeemOIDCLoginState(ctx context.Context, state, provider string) (*OIDCLoginState, error) {
+	if state == "" {
+		return nil, ErrInvalidOIDCState
+	}
+
+	loginState := OIDCLoginState{Provider: provider}
+	var linkUIDNull sql.NullString
+	err := Mdb.DB.QueryRowContext(ctx,
+		`DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > $3
+		RETURNING code_verifier, nonce, user_uid`,
+		HashOpaqueToken(state), provider, time.Now(),
+	).Scan(&loginState.CodeVerifier, &loginState.Nonce, &linkUIDNull)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, fmt.Errorf("failed to redeem login state: %w", err)
+	}
+	loginState.LinkUID = linkUIDNull.String
+	return &loginState, nil
+}
+
+// LoginIdentity returns the user linked to an identity and records the login
+func LoginIdentity(ctx context.Context, provider, subject, email string) (string, error) {
+	var uid string
+	err := Mdb.DB.QueryRowContext(ctx,
+		`UPDATE user_identities SET last_login_at = $3, email = NULLIF($4, '')
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_uid`,
+		provider, subject, time.Now(), email,
+	).Scan(&uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrIdentityNotFound
+		}
+		return "", fmt.Errorf("failed to look up identity: %w", err)
+	}
+	return uid, nil
+}
+
+// LinkIdentity links an identity to a user; db may be a transaction that also creates the user
+// Linking an identity that is already linked to the same user is a no-op
+func LinkIdentity(ctx context.Context, db queryExecer, uid, provider, subject, email string) error {
+	now := time.Now()
+	result, err := db.ExecContext(ctx,
+		`INSERT INTO user_identities (user_uid, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $5)
+		ON CONFLICT DO NOTHING`,
+		uid, provider, subject, email, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		return nil
+	}
+
+	// Nothing was inserted: either the identity or the user's slot for this provider is taken
+	var owner string
+	err = db.QueryRowContext(ctx,
+		"SELECT user_uid FROM user_identities WHERE provider = $1 AND subject = $2",
+		provider, subject,
+	).Scan(&owner)
+	switch {
+	case err == nil && owner == uid:
+		return nil
+	case err == nil:
+		return ErrIdentityLinked
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrProviderAlreadyLinked
+	default:
+		return fmt.Errorf("failed to check identity: %w", err)
+	}
+}
+
+// ListIdentities returns the identities linked to a user
+func ListIdentities(ctx context.Context, uid string) ([]Identity, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT provider, subject, email, created_at, last_login_at
+		FROM user_identities WHERE user_uid = $1
+		ORDER BY created_at`,
+		uid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		var emailNull sql.NullString
+		var lastLoginNull sql.NullTime
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &emailNull, &identity.CreatedAt, &lastLoginNull); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		if emailNull.Valid {
+			identity.Email = &emailNull.String
+		}
+		if lastLoginNull.Valid {
+			identity.LastLoginAt = &lastLoginNull.Time
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity removes the identity of a provider from a user
+// The last identity of a user without a password cannot be removed, they could no longer sign in
+func UnlinkIdentity(ctx context.Context, uid, provider string) (bool, error) {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent unlinks cannot both pass the check
+	var hasPassword bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT password_hash IS NOT NULL AND password_hash != '' FROM users WHERE uid = $1 FOR UPDATE",
+		uid,
+	).Scan(&hasPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	if !hasPassword {
+		var others int
+		err = tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM user_identities WHERE user_uid = $1 AND provider != $2",
+			uid, provider,
+		).Scan(&others)
+		if err != nil {
+			return false, fmt.Errorf("failed to count identities: %w", err)
+		}
+		if others == 0 {
+			return false, ErrLastLoginMethod
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM user_identities WHERE user_uid = $1 AND provider = $2",
+		uid, provider,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit unlink: %w", err)
+	}
+	return true, nil
+}
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx
+type queryExecer interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
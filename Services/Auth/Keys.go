@@ -0,0 +1,461 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits            = 2048
+	keyRingRefresh        = 5 * time.Minute // How often keys are reloaded and rotation is checked
+	keyPrepublish         = time.Hour       // Next key is published this long before it starts signing
+	unknownKidReloadDelay = 10 * time.Second
+	encryptedKeyPrefix    = "enc:v1:"
+	keyRingLockID         = 7212001 // pg_advisory_xact_lock id used while creating keys
+)
+
+// Key ring settings, configured by Initauth
+var (
+	SigningAlgorithm   = AlgRS256
+	KeyRotationPeriod  = 30 * 24 * time.Hour // How long a key signs new tokens
+	keyEncryptionKey   []byte                // Optional AES-256 key for private keys and TOTP secrets at rest (JWT_KEY_ENCRYPTION_KEY)
+	ErrUnknownKeyID    = errors.New("unknown signing key")
+	ErrNoActiveKey     = errors.New("no active signing key")
+	keyRing            = &signingKeyRing{keys: map[string]*signingKey{}}
+	keyRingRefreshOnce sync.Once
+)
+
+// signingKey is one key of the ring
+// A key signs tokens between notBefore and retiresAt and verifies them until expiresAt
+type signingKey struct {
+	kid       string
+	alg       string
+	private   crypto.Signer
+	public    crypto.PublicKey
+	notBefore time.Time
+	retiresAt time.Time
+	expiresAt time.Time
+}
+
+func (k *signingKey) method() jwt.SigningMethod {
+	if k.alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+type signingKeyRing struct {
+	mu         sync.RWMutex
+	keys       map[string]*signingKey
+	lastReload time.Time
+}
+
+// signingKeyFor returns the key that signs new tokens at time t
+func (r *signingKeyRing) signingKeyFor(t time.Time) *signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var active *signingKey
+	for _, k := range r.keys {
+		if k.alg != SigningAlgorithm || t.Before(k.notBefore) || !t.Before(k.retiresAt) {
+			continue
+		}
+		if active == nil || k.notBefore.After(active.notBefore) {
+			active = k
+		}
+	}
+	return active
+}
+
+// verificationKey returns the key with the given kid if it may still verify tokens
+func (r *signingKeyRing) verificationKey(kid string) (*signingKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	k, ok := r.keys[kid]
+	if !ok || time.Now().After(k.expiresAt) {
+		return nil, false
+	}
+	return k, true
+}
+
+// tokenLifetime is the longest time a signed token stays valid, keys verify for this long after retiring
+func tokenLifetime() time.Duration {
+	lifetime := TokenValidity
+	if ChallengeTokenValidity > lifetime {
+		lifetime = ChallengeTokenValidity
+	}
+	return lifetime + time.Minute // Allow for clock skew
+}
+
+// initKeyRing loads the signing keys, creating the first ones if needed, and starts scheduled rotation
+func initKeyRing(ctx context.Context) error {
+	if err := rotateKeys(ctx); err != nil {
+		return err
+	}
+	if err := loadKeys(ctx); err != nil {
+		return err
+	}
+	if keyRing.signingKeyFor(time.Now()) == nil {
+		return ErrNoActiveKey
+	}
+
+	keyRingRefreshOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(keyRingRefresh)
+			defer ticker.Stop()
+			for range ticker.C {
+				ctx := context.Background()
+				if err := rotateKeys(ctx); err != nil {
+					log.Printf("keyRing: rotation failed: %v", err)
+				}
+				if err := loadKeys(ctx); err != nil {
+					log.Printf("keyRing: reload failed: %v", err)
+				}
+			}
+		}()
+	})
+	return nil
+}
+
+// rotateKeys makes sure a key signs now and that its successor is published before it takes over
+// Runs under an advisory lock so replicas starting together do not create duplicate keys
+func rotateKeys(ctx context.Context) error {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", keyRingLockID); err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+
+	now := time.Now()
+	var latestRetire sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT MAX(retires_at) FROM jwt_signing_keys WHERE algorithm = $1 AND retires_at > $2",
+		SigningAlgorithm, now,
+	).Scan(&latestRetire)
+	if err != nil {
+		return fmt.Errorf("failed to check signing keys: %w", err)
+	}
+
+	// Chain keys until the ring covers now plus the prepublish period
+	notBefore := now
+	if latestRetire.Valid {
+		notBefore = latestRetire.Time
+	}
+	for !notBefore.After(now.Add(keyPrepublish)) {
+		retiresAt := notBefore.Add(KeyRotationPeriod)
+		if err := createKey(ctx, tx, notBefore, retiresAt); err != nil {
+			return err
+		}
+		notBefore = retiresAt
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM jwt_signing_keys WHERE expires_at < $1", now.Add(-24*time.Hour),
+	); err != nil {
+		return fmt.Errorf("failed to delete expired signing keys: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit signing keys: %w", err)
+	}
+	return nil
+}
+
+// createKey generates a key pair for SigningAlgorithm and stores it
+func createKey(ctx context.Context, tx *sql.Tx, notBefore, retiresAt time.Time) error {
+	var private crypto.Signer
+	switch SigningAlgorithm {
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate ed25519 key: %w", err)
+		}
+		private = key
+	default:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return fmt.Errorf("failed to generate rsa key: %w", err)
+		}
+		private = key
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	privatePEM, err := sealSecret(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	if err != nil {
+		return err
+	}
+	publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	kid, err := generateID(8)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO jwt_signing_keys (kid, algorithm, private_key, public_key, created_at, not_before, retires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		kid, SigningAlgorithm, privatePEM, publicPEM, time.Now(), notBefore, retiresAt, retiresAt.Add(tokenLifetime()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+	log.Printf("keyRing: created %s signing key %s (signs from %s until %s)", SigningAlgorithm, kid,
+		notBefore.Format(time.RFC3339), retiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// loadKeys replaces the in-memory ring with the keys in the database that can still verify tokens
+func loadKeys(ctx context.Context) error {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT kid, algorithm, private_key, public_key, not_before, retires_at, expires_at
+		FROM jwt_signing_keys WHERE expires_at > $1`,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := map[string]*signingKey{}
+	for rows.Next() {
+		var k signingKey
+		var privatePEM, publicPEM string
+		if err := rows.Scan(&k.kid, &k.alg, &privatePEM, &publicPEM, &k.notBefore, &k.retiresAt, &k.expiresAt); err != nil {
+			return fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		if err := k.decode(privatePEM, publicPEM); err != nil {
+			log.Printf("keyRing: skipping key %s: %v", k.kid, err)
+			continue
+		}
+		keys[k.kid] = &k
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate signing keys: %w", err)
+	}
+
+	keyRing.mu.Lock()
+	keyRing.keys = keys
+	keyRing.lastReload = time.Now()
+	keyRing.mu.Unlock()
+	return nil
+}
+
+// decode parses the stored PEM keys
+func (k *signingKey) decode(privatePEM, publicPEM string) error {
+	block, _ := pem.Decode([]byte(publicPEM))
+	if block == nil {
+		return errors.New("invalid public key")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	k.public = public
+
+	privateBytes, err := openSecret(privatePEM)
+	if err != nil {
+		return err
+	}
+	block, _ = pem.Decode(privateBytes)
+	if block == nil {
+		return errors.New("invalid private key")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return errors.New("unsupported private key type")
+	}
+	k.private = signer
+	return nil
+}
+
+// sealSecret encrypts a secret (a PEM private key or a TOTP secret) with JWT_KEY_ENCRYPTION_KEY if it is configured
+func sealSecret(plain []byte) (string, error) {
+	if len(keyEncryptionKey) == 0 {
+		return string(plain), nil
+	}
+	gcm, err := newKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// isSealed reports whether a stored secret was encrypted by sealSecret
+func isSealed(stored string) bool {
+	return strings.HasPrefix(stored, encryptedKeyPrefix)
+}
+
+// openSecret reverses sealSecret; secrets stored before encryption was configured are returned as is
+func openSecret(stored string) ([]byte, error) {
+	if !isSealed(stored) {
+		return []byte(stored), nil
+	}
+	if len(keyEncryptionKey) == 0 {
+		return nil, errors.New("secret is encrypted but JWT_KEY_ENCRYPTION_KEY is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %w", err)
+	}
+	gcm, err := newKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted secret is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return plain, nil
+}
+
+func newKeyCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(keyEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_KEY_ENCRYPTION_KEY: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// signToken signs claims with the active key of the ring
+func signToken(claims jwt.Claims) (string, error) {
+	key := keyRing.signingKeyFor(time.Now())
+	if key == nil {
+		return "", ErrNoActiveKey
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.kid
+	tokenString, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// verificationKeyFunc resolves the public key of a token by its kid header
+// An unknown kid triggers a reload (rate limited), since another replica may have just rotated
+func verificationKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownKeyID
+	}
+
+	key, ok := keyRing.verificationKey(kid)
+	if !ok {
+		keyRing.mu.RLock()
+		stale := time.Since(keyRing.lastReload) > unknownKidReloadDelay
+		keyRing.mu.RUnlock()
+		if stale {
+			if err := loadKeys(context.Background()); err != nil {
+				log.Printf("keyRing: reload failed: %v", err)
+			}
+			key, ok = keyRing.verificationKey(kid)
+		}
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+	}
+
+	if token.Method.Alg() != key.alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS returns the public keys that can verify tokens, including the next key before it starts signing
+func JWKS() []JWK {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	now := time.Now()
+	keys := []JWK{}
+	for _, k := range keyRing.keys {
+		if now.After(k.expiresAt) {
+			continue
+		}
+		switch public := k.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: k.kid,
+				Use: "sig",
+				Alg: k.alg,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: k.kid,
+				Use: "sig",
+				Alg: k.alg,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return keys
+}
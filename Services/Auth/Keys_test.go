@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestKey generates an in-memory key signing from notBefore until retiresAt
+func newTestKey(t *testing.T, kid, alg string, notBefore, retiresAt time.Time) *signingKey {
+	t.Helper()
+	var private crypto.Signer
+	if alg == AlgEdDSA {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate ed25519 key: %v", err)
+		}
+		private = key
+	} else {
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			t.Fatalf("failed to generate rsa key: %v", err)
+		}
+		private = key
+	}
+	return &signingKey{
+		kid:       kid,
+		alg:       alg,
+		private:   private,
+		public:    private.Public(),
+		notBefore: notBefore,
+		retiresAt: retiresAt,
+		expiresAt: retiresAt.Add(tokenLifetime()),
+	}
+}
+
+// useKeyRing replaces the key ring with keys for the duration of the test
+// The ring counts as freshly loaded, so unknown kids never reach the database
+func useKeyRing(t *testing.T, alg string, keys ...*signingKey) {
+	t.Helper()
+	ring, algorithm := keyRing, SigningAlgorithm
+	t.Cleanup(func() { keyRing, SigningAlgorithm = ring, algorithm })
+
+	keyRing = &signingKeyRing{keys: map[string]*signingKey{}, lastReload: time.Now()}
+	for _, k := range keys {
+		keyRing.keys[k.kid] = k
+	}
+	SigningAlgorithm = alg
+}
+
+// parseTestToken verifies a token the way VerifyToken does, without the session check
+func parseTestToken(tokenString string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, verificationKeyFunc,
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+	return claims, err
+}
+
+// signWith signs claims with k regardless of whether k is active
+func signWith(t *testing.T, k *signingKey, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method(), claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(k.private)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return tokenString
+}
+
+func testClaims(uid string) JWTClaims {
+	now := time.Now()
+	return JWTClaims{
+		UID:       uid,
+		SessionID: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(TokenValidity)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key := newTestKey(t, "current", alg, now.Add(-time.Hour), now.Add(time.Hour))
+			useKeyRing(t, alg, key)
+
+			tokenString, err := signToken(testClaims("alice"))
+			if err != nil {
+				t.Fatalf("signToken: %v", err)
+			}
+			token, _, err := jwt.NewParser().ParseUnverified(tokenString, &JWTClaims{})
+			if err != nil {
+				t.Fatalf("failed to parse token: %v", err)
+			}
+			if token.Header["kid"] != "current" || token.Header["alg"] != alg {
+				t.Errorf("header = %v, want kid current and alg %s", token.Header, alg)
+			}
+
+			claims, err := parseTestToken(tokenString)
+			if err != nil {
+				t.Fatalf("failed to verify token: %v", err)
+			}
+			if claims.UID != "alice" {
+				t.Errorf("uid = %q, want alice", claims.UID)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now()
+	retired := newTestKey(t, "retired", AlgRS256, now.Add(-2*time.Hour), now.Add(-time.Minute))
+	current := newTestKey(t, "current", AlgRS256, now.Add(-time.Minute), now.Add(time.Hour))
+	next := newTestKey(t, "next", AlgRS256, now.Add(time.Hour), now.Add(2*time.Hour))
+	expired := newTestKey(t, "expired", AlgRS256, now.Add(-4*time.Hour), now.Add(-3*time.Hour))
+	expired.expiresAt = now.Add(-time.Hour)
+
+	useKeyRing(t, AlgRS256, retired, current, next, expired)
+	if key := keyRing.signingKeyFor(now); key == nil || key.kid != "current" {
+		t.Fatalf("signing key = %v, want current", key)
+	}
+
+	tokenString, err := signToken(testClaims("alice"))
+	if err != nil {
+		t.Fatalf("signToken: %v", err)
+	}
+	if _, err := parseTestToken(tokenString); err != nil {
+		t.Errorf("token of the current key: %v", err)
+	}
+
+	// A retired key verifies until it expires
+	if _, err := parseTestToken(signWith(t, retired, "retired", testClaims("alice"))); err != nil {
+		t.Errorf("token of the retired key: %v", err)
+	}
+	if _, err := parseTestToken(signWith(t, expired, "expired", testClaims("alice"))); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("token of an expired key: %v, want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Now()
+	key := newTestKey(t, "current", AlgRS256, now.Add(-time.Hour), now.Add(time.Hour))
+	useKeyRing(t, AlgRS256, key)
+
+	other := newTestKey(t, "other", AlgRS256, now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err := parseTestToken(signWith(t, other, "other", testClaims("alice"))); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("unknown kid: %v, want %v", err, ErrUnknownKeyID)
+	}
+	if _, err := parseTestToken(signWith(t, other, "current", testClaims("alice"))); err == nil {
+		t.Error("token signed by another key verified")
+	}
+	if _, err := parseTestToken(signWith(t, key, "", testClaims("alice"))); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("no kid: %v, want %v", err, ErrUnknownKeyID)
+	}
+
+	// An EdDSA token under the kid of an RSA key
+	ed := newTestKey(t, "current", AlgEdDSA, now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err := parseTestToken(signWith(t, ed, "current", testClaims("alice"))); err == nil {
+		t.Error("token with another algorithm than its key verified")
+	}
+
+	// Symmetric tokens signed with the public key are not accepted
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("alice"))
+	token.Header["kid"] = "current"
+	tokenString, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	if _, err := parseTestToken(tokenString); err == nil {
+		t.Error("HS256 token verified")
+	}
+
+	// No active key
+	useKeyRing(t, AlgEdDSA, key)
+	if _, err := signToken(testClaims("alice")); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("signToken without a key for the algorithm: %v, want %v", err, ErrNoActiveKey)
+	}
+}
+
+func TestSigningKeyDecode(t *testing.T) {
+	defer func(key []byte) { keyEncryptionKey = key }(keyEncryptionKey)
+
+	now := time.Now()
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		for _, encryptionKey := range [][]byte{nil, []byte("0123456789abcdef0123456789abcdef")} {
+			keyEncryptionKey = encryptionKey
+			key := newTestKey(t, "key", alg, now, now.Add(time.Hour))
+
+			privateDER, err := x509.MarshalPKCS8PrivateKey(key.private)
+			if err != nil {
+				t.Fatalf("failed to encode private key: %v", err)
+			}
+			publicDER, err := x509.MarshalPKIXPublicKey(key.public)
+			if err != nil {
+				t.Fatalf("failed to encode public key: %v", err)
+			}
+			privatePEM, err := sealSecret(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+			if err != nil {
+				t.Fatalf("sealSecret: %v", err)
+			}
+			if isSealed(privatePEM) != (encryptionKey != nil) {
+				t.Errorf("%s: private key sealed = %v with encryption key %q", alg, isSealed(privatePEM), encryptionKey)
+			}
+			publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+			var decoded signingKey
+			if err := decoded.decode(privatePEM, publicPEM); err != nil {
+				t.Fatalf("%s: decode: %v", alg, err)
+			}
+			type equaler interface{ Equal(crypto.PrivateKey) bool }
+			if !decoded.private.(equaler).Equal(key.private) {
+				t.Errorf("%s: decoded private key differs", alg)
+			}
+			if !decoded.private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.public) {
+				t.Errorf("%s: decoded public key differs", alg)
+			}
+
+			if encryptionKey != nil {
+				keyEncryptionKey = nil
+				if err := decoded.decode(privatePEM, publicPEM); err == nil {
+					t.Errorf("%s: decoding a sealed key without JWT_KEY_ENCRYPTION_KEY succeeded", alg)
+				}
+			}
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	rsaKey := newTestKey(t, "rsa", AlgRS256, now.Add(-time.Hour), now.Add(time.Hour))
+	edKey := newTestKey(t, "ed", AlgEdDSA, now.Add(time.Hour), now.Add(2*time.Hour))
+	expired := newTestKey(t, "expired", AlgEdDSA, now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+	expired.expiresAt = now.Add(-time.Hour)
+	useKeyRing(t, AlgRS256, rsaKey, edKey, expired)
+
+	jwks := map[string]JWK{}
+	for _, k := range JWKS() {
+		jwks[k.Kid] = k
+	}
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS has %d keys, want the rsa and ed keys: %+v", len(jwks), jwks)
+	}
+
+	// The next key is published before it starts signing
+	ed, ok := jwks["ed"]
+	if !ok {
+		t.Fatal("next key is not published")
+	}
+	if ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != AlgEdDSA || ed.Use != "sig" {
+		t.Errorf("ed25519 JWK = %+v", ed)
+	}
+	if x, err := base64.RawURLEncoding.DecodeString(ed.X); err != nil || !edKey.public.(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		t.Errorf("ed25519 JWK x does not match the key")
+	}
+
+	r := jwks["rsa"]
+	if r.Kty != "RSA" || r.Alg != AlgRS256 || r.Use != "sig" {
+		t.Errorf("rsa JWK = %+v", r)
+	}
+	n, errN := base64.RawURLEncoding.DecodeString(r.N)
+	e, errE := base64.RawURLEncoding.DecodeString(r.E)
+	if errN != nil || errE != nil {
+		t.Fatalf("rsa JWK is not base64url: %v, %v", errN, errE)
+	}
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if !rsaKey.public.(*rsa.PublicKey).Equal(public) {
+		t.Error("rsa JWK n and e do not match the key")
+	}
+	if r.E != "AQAB" {
+		t.Errorf("rsa JWK e = %q, want AQAB", r.E)
+	}
+}
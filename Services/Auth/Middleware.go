@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	Mdb "hifi/Services/Mdb"
+	Utils "hifi/Utils"
+)
+
+// principalKey is the request context key of the authenticated Principal
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal the auth middleware stored in the request context
+// It is never nil behind RequireAuth or RequireSession, and nil for anonymous requests behind OptionalAuth
+func PrincipalFrom(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// RequireAuth rejects requests without a valid JWT or API key and stores the principal in the context
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := GetClaims(r)
+		if !ok {
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// RequireSession is RequireAuth for endpoints that manage the account's credentials; API keys are rejected
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := GetSessionClaims(r)
+		if !ok {
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// OptionalAuth stores the principal in the context when the request has a valid JWT or API key
+// Requests without one (or with an invalid one) continue anonymously
+func OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := GetClaims(r); ok {
+			r = r.WithContext(WithPrincipal(r.Context(), principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects API keys without the scope; session tokens and anonymous requests pass
+// Use it after RequireAuth or OptionalAuth
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if principal := PrincipalFrom(r); principal != nil && !principal.HasScope(scope) {
+				Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: API key is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects principals whose user does not have one of the roles
+// The role is loaded once and kept on the principal; use it after RequireAuth
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFrom(r)
+			if principal == nil {
+				Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			if principal.Role == "" {
+				err := Mdb.DB.QueryRowContext(r.Context(),
+					"SELECT role FROM users WHERE uid = $1",
+					principal.UID,
+				).Scan(&principal.Role)
+				if err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+						return
+					}
+					log.Printf("RequireRole: failed to fetch user: %v", err)
+					Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+					return
+				}
+			}
+
+			for _, role := range roles {
+				if principal.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+strings.Join(roles, " or ")+" access required")
+		})
+	}
+}
+
+// RequirePermission rejects principals whose user does not hold the permission through a staff role
+// The permissions are loaded once and kept on the principal; use it after RequireAuth
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFrom(r)
+			if principal == nil {
+				Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			if principal.Permissions == nil {
+				permissions, err := UserPermissions(r.Context(), principal.UID)
+				if err != nil {
+					log.Printf("RequirePermission: %v", err)
+					Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+					return
+				}
+				principal.Permissions = permissions
+			}
+
+			if !principal.HasPermission(permission) {
+				Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: "+permission+" permission required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireTwoFactor rejects principals whose user has not enabled two-factor authentication
+// Admin routes use it so that sessions created before 2FA was enforced cannot be used; use it after RequireAuth
+func RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := PrincipalFrom(r)
+		if principal == nil {
+			Utils.SendErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		enabled, err := TwoFactorEnabled(r.Context(), principal.UID)
+		if err != nil {
+			log.Printf("RequireTwoFactor: %v", err)
+			Utils.SendErrorResponse(w, http.StatusInternalServerError, "Failed to fetch user")
+			return
+		}
+		if !enabled {
+			Utils.SendErrorResponse(w, http.StatusForbidden, "Forbidden: two-factor authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serve runs a request through middleware in front of a handler that records the principal it saw
+// principal, if not nil, is put into the request context first, as RequireAuth would
+func serve(middleware func(http.Handler) http.Handler, principal *Principal, authorization string) (int, *Principal) {
+	var seen *Principal
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = PrincipalFrom(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if principal != nil {
+		req = req.WithContext(WithPrincipal(req.Context(), principal))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, seen
+}
+
+func TestRequireAuthRejects(t *testing.T) {
+	// None of these reach the database: they are missing or malformed
+	for _, authorization := range []string{"", "Bearer ", "Bearer " + APIKeyPrefix + "short", APIKeyPrefix + "short"} {
+		for name, middleware := range map[string]func(http.Handler) http.Handler{
+			"RequireAuth":    RequireAuth,
+			"RequireSession": RequireSession,
+		} {
+			if code, _ := serve(middleware, nil, authorization); code != http.StatusUnauthorized {
+				t.Errorf("%s with Authorization %q: status %d, want %d", name, authorization, code, http.StatusUnauthorized)
+			}
+		}
+	}
+}
+
+func TestOptionalAuthAnonymous(t *testing.T) {
+	code, principal := serve(OptionalAuth, nil, "")
+	if code != http.StatusOK || principal != nil {
+		t.Errorf("anonymous request: status %d, principal %+v, want 200 and no principal", code, principal)
+	}
+	code, principal = serve(OptionalAuth, nil, "Bearer "+APIKeyPrefix+"short")
+	if code != http.StatusOK || principal != nil {
+		t.Errorf("invalid credentials: status %d, principal %+v, want 200 and no principal", code, principal)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	session := &Principal{UID: "alice", SessionID: "session"}
+	reader := &Principal{UID: "alice", APIKeyID: "key", Scopes: []string{ScopeVideosRead}}
+	writer := &Principal{UID: "alice", APIKeyID: "key", Scopes: []string{ScopeVideosWrite}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		scope     string
+		want      int
+	}{
+		{name: "anonymous", principal: nil, scope: ScopeVideosWrite, want: http.StatusOK},
+		{name: "session", principal: session, scope: ScopeVideosWrite, want: http.StatusOK},
+		{name: "granted", principal: reader, scope: ScopeVideosRead, want: http.StatusOK},
+		{name: "missing", principal: reader, scope: ScopeVideosWrite, want: http.StatusForbidden},
+		{name: "write implies read", principal: writer, scope: ScopeVideosRead, want: http.StatusOK},
+		{name: "other resource", principal: writer, scope: ScopeSocialRead, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, seen := serve(RequireScope(tt.scope), tt.principal, "")
+			if code != tt.want {
+				t.Fatalf("status %d, want %d", code, tt.want)
+			}
+			if code == http.StatusOK && seen != tt.principal {
+				t.Errorf("handler saw principal %+v, want %+v", seen, tt.principal)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	// The role is already loaded, so the database is not consulted
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      int
+	}{
+		{name: "anonymous", principal: nil, want: http.StatusUnauthorized},
+		{name: "admin", principal: &Principal{UID: "alice", Role: "admin"}, want: http.StatusOK},
+		{name: "creator", principal: &Principal{UID: "alice", Role: "creator"}, want: http.StatusOK},
+		{name: "user", principal: &Principal{UID: "alice", Role: "user"}, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := serve(RequireRole("admin", "creator"), tt.principal, ""); code != tt.want {
+				t.Errorf("status %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
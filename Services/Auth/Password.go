@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrInvalidResetToken      = errors.New("invalid or expired reset token")
+	ErrPasswordResetThrottled = errors.New("too many password reset emails")
+)
+
+// PasswordResetTokenValidity is how long an emailed reset token can be redeemed
+var PasswordResetTokenValidity = time.Hour
+
+// Password reset email throttling, per account
+const (
+	PasswordResetCooldown    = time.Minute // Minimum time between two reset emails
+	PasswordResetHourlyLimit = 5           // Maximum reset emails per user per hour
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// CreatePasswordResetToken issues a single-use reset token for a user
+// Any token issued earlier for the same user stops working
+// Returns ErrPasswordResetThrottled while the user is within PasswordResetCooldown or PasswordResetHourlyLimit
+func CreatePasswordResetToken(ctx context.Context, uid string) (string, error) {
+	token, tokenHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent requests cannot both pass the throttle
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE uid = $1 FOR UPDATE", uid); err != nil {
+		return "", fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	now := time.Now()
+	wait, err := passwordResetRetryAfter(ctx, tx, uid, now)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		return "", ErrPasswordResetThrottled
+	}
+
+	if err := invalidateResetTokens(ctx, tx, uid, now); err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO password_reset_tokens (token_hash, user_uid, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		tokenHash, uid, now, now.Add(PasswordResetTokenValidity),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit reset token: %w", err)
+	}
+	return token, nil
+}
+
+// passwordResetRetryAfter returns how long the user has to wait before another reset email can be sent
+// Zero means a new email can be sent now
+func passwordResetRetryAfter(ctx context.Context, tx *sql.Tx, uid string, now time.Time) (time.Duration, error) {
+	var lastSent sql.NullTime
+	var sentLastHour int
+	var oldestLastHour sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		`SELECT MAX(created_at), COUNT(*) FILTER (WHERE created_at > $2), MIN(created_at) FILTER (WHERE created_at > $2)
+		FROM password_reset_tokens WHERE user_uid = $1`,
+		uid, now.Add(-time.Hour),
+	).Scan(&lastSent, &sentLastHour, &oldestLastHour)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check reset throttle: %w", err)
+	}
+
+	var wait time.Duration
+	if lastSent.Valid {
+		if d := lastSent.Time.Add(PasswordResetCooldown).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	if sentLastHour >= PasswordResetHourlyLimit && oldestLastHour.Valid {
+		if d := oldestLastHour.Time.Add(time.Hour).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// ResetPassword redeems a reset token and sets a new password for its user
+// Returns the UID of the user whose password was changed
+func ResetPassword(ctx context.Context, token, newPassword string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidResetToken
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the token row so concurrent redemptions cannot both succeed
+	var uid string
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_uid FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		FOR UPDATE`,
+		HashOpaqueToken(token), time.Now(),
+	).Scan(&uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidResetToken
+		}
+		return "", fmt.Errorf("failed to fetch reset token: %w", err)
+	}
+
+	if err := setPassword(ctx, tx, uid, newPassword); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit password reset: %w", err)
+	}
+	return uid, nil
+}
+
+// ChangePassword sets a new password for a user
+// Every session, API key and outstanding reset token of the user is invalidated
+func ChangePassword(ctx context.Context, uid, newPassword string) error {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setPassword(ctx, tx, uid, newPassword); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit password change: %w", err)
+	}
+	return nil
+}
+
+// setPassword stores a new password hash and invalidates all sessions, API keys and reset tokens of the user
+// A leaked password may have been used to mint API keys, so they go along with the sessions
+func setPassword(ctx context.Context, tx *sql.Tx, uid, newPassword string) error {
+	passwordHash, err := HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"UPDATE users SET password_hash = $1, updated_at = $2 WHERE uid = $3",
+		passwordHash, now, uid,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := invalidateResetTokens(ctx, tx, uid, now); err != nil {
+		return err
+	}
+	if _, err := revokeUserSessions(ctx, tx, uid, now); err != nil {
+		return err
+	}
+	if _, err := RevokeUserAPIKeys(ctx, tx, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// invalidateResetTokens marks every unused reset token of a user as used
+func invalidateResetTokens(ctx context.Context, db execer, uid string, now time.Time) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE password_reset_tokens SET used_at = $1 WHERE user_uid = $2 AND used_at IS NULL",
+		now, uid,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate reset tokens: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrUnknownRole        = errors.New("unknown role")
+	ErrRoleAlreadyGranted = errors.New("role already granted")
+	ErrRoleNotGranted     = errors.New("role not granted")
+	ErrOwnRoleChange      = errors.New("cannot change own roles")
+)
+
+// Staff roles seeded by migration 022; more can be added to the roles table
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleSupport   = "support"
+	RoleAnalyst   = "analyst"
+)
+
+// Permissions gating the admin endpoints (see the permissions table)
+const (
+	PermUsersRead      = "users.read"
+	PermUsersDelete    = "users.delete"
+	PermUsersUnlock    = "users.unlock"
+	PermUsersSuspend   = "users.suspend"
+	PermVideosRead     = "videos.read"
+	PermVideosDelete   = "videos.delete"
+	PermCommentsRead   = "comments.read"
+	PermCommentsDelete = "comments.delete"
+	PermCountersRead   = "counters.read"
+	PermCountersResync = "counters.resync"
+	PermSessionsRead   = "sessions.read"
+	PermSessionsRevoke = "sessions.revoke"
+	PermAPIKeysRead    = "api_keys.read"
+	PermAPIKeysRevoke  = "api_keys.revoke"
+	PermRolesRead      = "roles.read"
+	PermRolesManage    = "roles.manage"
+	PermReportsRead    = "reports.read"
+	PermReportsManage  = "reports.manage"
+)
+
+// Role is a staff role and the permissions it carries
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RoleGrant is a role held by a user
+type RoleGrant struct {
+	Role      string    `json:"role"`
+	GrantedBy *string   `json:"granted_by,omitempty"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// RoleAuditEntry records a role being granted or revoked
+type RoleAuditEntry struct {
+	ID        int       `json:"id"`
+	ActorUID  string    `json:"actor_uid"`
+	TargetUID string    `json:"target_uid"`
+	Role      string    `json:"role"`
+	Action    string    `json:"action"` // grant or revoke
+	Reason    *string   `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HasPermission reports whether the principal's user holds a permission
+// Permissions are loaded by RequirePermission, so it is false before that
+func (t *Principal) HasPermission(permission string) bool {
+	return t.Permissions[permission]
+}
+
+// UserPermissions returns the permissions a user holds through their roles
+// users.role 'admin' holds every permission
+func UserPermissions(ctx context.Context, uid string) (map[string]bool, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT rp.permission FROM user_roles ur
+			JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_uid = $1
+		UNION
+		SELECT p.name FROM permissions p
+		WHERE EXISTS (SELECT 1 FROM users WHERE uid = $1 AND role = $2)`,
+		uid, RoleAdmin,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := map[string]bool{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		permissions[permission] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// IsStaff reports whether a user holds a staff role (or users.role 'admin')
+// Staff accounts must use two-factor authentication and may mint admin scoped API keys
+func IsStaff(ctx context.Context, uid string) (bool, error) {
+	var staff bool
+	err := Mdb.DB.QueryRowContext(ctx,
+		`SELECT role = $2 OR EXISTS (SELECT 1 FROM user_roles WHERE user_uid = $1)
+		FROM users WHERE uid = $1`,
+		uid, RoleAdmin,
+	).Scan(&staff)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to check staff roles: %w", err)
+	}
+	return staff, nil
+}
+
+// ListRoles returns every role with its permissions
+func ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT r.name, r.description, r.created_at,
+			COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM roles r
+			LEFT JOIN role_permissions rp ON rp.role = r.name
+		GROUP BY r.name, r.description, r.created_at
+		ORDER BY r.name`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt, pq.Array(&role.Permissions)); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate roles: %w", err)
+	}
+	return roles, nil
+}
+
+// ListUserRoles returns the roles granted to a user
+func ListUserRoles(ctx context.Context, uid string) ([]RoleGrant, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		"SELECT role, granted_by, granted_at FROM user_roles WHERE user_uid = $1 ORDER BY role",
+		uid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user roles: %w", err)
+	}
+	defer rows.Close()
+
+	grants := []RoleGrant{}
+	for rows.Next() {
+		var grant RoleGrant
+		var grantedBy sql.NullString
+		if err := rows.Scan(&grant.Role, &grantedBy, &grant.GrantedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user role: %w", err)
+		}
+		if grantedBy.Valid {
+			grant.GrantedBy = &grantedBy.String
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate user roles: %w", err)
+	}
+	return grants, nil
+}
+
+// GrantRole grants a role to a user and records it in the audit log
+func GrantRole(ctx context.Context, actorUID, targetUID, role, reason string) error {
+	if actorUID == targetUID {
+		return ErrOwnRoleChange
+	}
+	role = strings.ToLower(strings.TrimSpace(role))
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check role: %w", err)
+	}
+	if !exists {
+		return ErrUnknownRole
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO user_roles (user_uid, role, granted_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_uid, role) DO NOTHING`,
+		targetUID, role, actorUID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to grant role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRoleAlreadyGranted
+	}
+
+	if err := insertRoleAudit(ctx, tx, actorUID, targetUID, role, "grant", reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role grant: %w", err)
+	}
+	return nil
+}
+
+// RevokeRole revokes a role from a user and records it in the audit log
+// Revoking admin also demotes a users.role 'admin' account to a regular user
+func RevokeRole(ctx context.Context, actorUID, targetUID, role, reason string) error {
+	if actorUID == targetUID {
+		return ErrOwnRoleChange
+	}
+	role = strings.ToLower(strings.TrimSpace(role))
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM user_roles WHERE user_uid = $1 AND role = $2",
+		targetUID, role,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+	revoked, _ := result.RowsAffected()
+
+	if role == RoleAdmin {
+		result, err := tx.ExecContext(ctx,
+			"UPDATE users SET role = 'user', updated_at = $1 WHERE uid = $2 AND role = $3",
+			time.Now(), targetUID, RoleAdmin,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to demote admin: %w", err)
+		}
+		demoted, _ := result.RowsAffected()
+		revoked += demoted
+	}
+	if revoked == 0 {
+		return ErrRoleNotGranted
+	}
+
+	if err := insertRoleAudit(ctx, tx, actorUID, targetUID, role, "revoke", reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role revocation: %w", err)
+	}
+	return nil
+}
+
+func insertRoleAudit(ctx context.Context, db execer, actorUID, targetUID, role, action, reason string) error {
+	var reasonValue interface{}
+	if reason != "" {
+		reasonValue = reason
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO role_audit_log (actor_uid, target_uid, role, action, reason)
+		VALUES ($1, $2, $3, $4, $5)`,
+		actorUID, targetUID, role, action, reasonValue,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record role audit: %w", err)
+	}
+	return nil
+}
+
+// ListRoleAudit returns role changes, newest first, optionally only those of one user
+func ListRoleAudit(ctx context.Context, targetUID string, limit, offset int) ([]RoleAuditEntry, int, error) {
+	var total int
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM role_audit_log WHERE $1 = '' OR target_uid = $1",
+		targetUID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count role audit: %w", err)
+	}
+
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT id, actor_uid, target_uid, role, action, reason, created_at
+		FROM role_audit_log
+		WHERE $1 = '' OR target_uid = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`,
+		targetUID, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list role audit: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []RoleAuditEntry{}
+	for rows.Next() {
+		var entry RoleAuditEntry
+		var reason sql.NullString
+		if err := rows.Scan(
+			&entry.ID, &entry.ActorUID, &entry.TargetUID, &entry.Role, &entry.Action, &reason, &entry.CreatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan role audit: %w", err)
+		}
+		if reason.Valid {
+			entry.Reason = &reason.String
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate role audit: %w", err)
+	}
+	return entries, total, nil
+}
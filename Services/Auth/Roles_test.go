@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	moderator := &Principal{UID: "alice", Permissions: map[string]bool{PermCommentsDelete: true, PermVideosDelete: true}}
+	if !moderator.HasPermission(PermCommentsDelete) {
+		t.Error("moderator cannot delete comments")
+	}
+	if moderator.HasPermission(PermUsersDelete) {
+		t.Error("moderator can delete users")
+	}
+	if (&Principal{UID: "alice"}).HasPermission(PermCommentsDelete) {
+		t.Error("principal without loaded permissions has a permission")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	// The permissions are already loaded, so the database is not consulted
+	moderator := &Principal{UID: "alice", Permissions: map[string]bool{PermCommentsDelete: true}}
+	nobody := &Principal{UID: "bob", Permissions: map[string]bool{}}
+
+	tests := []struct {
+		name       string
+		principal  *Principal
+		permission string
+		want       int
+	}{
+		{name: "anonymous", principal: nil, permission: PermCommentsDelete, want: http.StatusUnauthorized},
+		{name: "held", principal: moderator, permission: PermCommentsDelete, want: http.StatusOK},
+		{name: "not held", principal: moderator, permission: PermUsersDelete, want: http.StatusForbidden},
+		{name: "no roles", principal: nobody, permission: PermCommentsDelete, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := serve(RequirePermission(tt.permission), tt.principal, ""); code != tt.want {
+				t.Errorf("status %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrSessionExpired      = errors.New("session has expired")
+)
+
+// lastSeenResolution limits how often last_seen_at is written for a session
+const lastSeenResolution = time.Minute
+
+// SessionMeta describes the device a session was created or refreshed from
+type SessionMeta struct {
+	UserAgent string
+	IPAddress string
+}
+
+// Session is a login session as shown to users and admins
+type Session struct {
+	SessionID  string    `json:"session_id"`
+	UserUID    string    `json:"user_uid"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // True for the session making the request
+}
+
+// TokenPair holds the credentials handed to a client when a session is created or refreshed
+type TokenPair struct {
+	SessionID        string
+	AccessToken      string
+	RefreshToken     string
+	AccessExpiresIn  time.Duration
+	RefreshExpiresIn time.Duration
+}
+
+// Response returns the token pair in the JSON shape used by the auth endpoints
+func (p *TokenPair) Response() map[string]interface{} {
+	return map[string]interface{}{
+		"token":              p.AccessToken,
+		"expires_in":         int(p.AccessExpiresIn.Seconds()),
+		"refresh_token":      p.RefreshToken,
+		"refresh_expires_in": int(p.RefreshExpiresIn.Seconds()),
+	}
+}
+
+// formatRefreshToken builds the refresh token handed to clients: "<session_id>.<secret>"
+func formatRefreshToken(sessionID, secret string) string {
+	return sessionID + "." + secret
+}
+
+// parseRefreshToken splits a refresh token into its session ID and secret
+func parseRefreshToken(refreshToken string) (sessionID, secret string, ok bool) {
+	sessionID, secret, ok = strings.Cut(strings.TrimSpace(refreshToken), ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", false
+	}
+	return sessionID, secret, true
+}
+
+// CreateSession starts a new login session for a user and returns its access and refresh tokens
+// Suspended users get ErrAccountSuspended
+func CreateSession(ctx context.Context, uid string, meta SessionMeta) (*TokenPair, error) {
+	if err := checkSuspended(ctx, uid); err != nil {
+		return nil, err
+	}
+
+	sessionID, err := generateID(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, secretHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = Mdb.DB.ExecContext(ctx,
+		`INSERT INTO auth_sessions (session_id, user_uid, refresh_token_hash, created_at, refreshed_at, expires_at,
+			user_agent, ip_address, last_seen_at)
+		VALUES ($1, $2, $3, $4, $4, $5, $6, $7, $4)`,
+		sessionID, uid, secretHash, now, now.Add(RefreshTokenValidity), meta.UserAgent, meta.IPAddress,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	accessToken, err := GenerateToken(uid, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		SessionID:        sessionID,
+		AccessToken:      accessToken,
+		RefreshToken:     formatRefreshToken(sessionID, secret),
+		AccessExpiresIn:  TokenValidity,
+		RefreshExpiresIn: RefreshTokenValidity,
+	}, nil
+}
+
+// RefreshSession rotates the refresh token of a session and issues a new access token
+// Presenting the refresh token the last refresh rotated away revokes the whole session,
+// since it means the token was copied by someone else. Any other wrong secret is
+// rejected with ErrInvalidRefreshToken and leaves the session alone
+func RefreshSession(ctx context.Context, refreshToken string, meta SessionMeta) (*TokenPair, error) {
+	sessionID, secret, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var uid, storedHash string
+	var previousHash sql.NullString
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_uid, refresh_token_hash, previous_refresh_token_hash, expires_at, revoked_at
+		FROM auth_sessions WHERE session_id = $1 FOR UPDATE`,
+		sessionID,
+	).Scan(&uid, &storedHash, &previousHash, &expiresAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to fetch session: %w", err)
+	}
+
+	if revokedAt.Valid {
+		return nil, ErrSessionRevoked
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	secretHash := HashOpaqueToken(secret)
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(storedHash)) != 1 {
+		if !previousHash.Valid || subtle.ConstantTimeCompare([]byte(secretHash), []byte(previousHash.String)) != 1 {
+			return nil, ErrInvalidRefreshToken
+		}
+		// Refresh token reuse: revoke the session so neither party can continue using it
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE auth_sessions SET revoked_at = $1 WHERE session_id = $2",
+			time.Now(), sessionID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit session revocation: %w", err)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newSecret, newHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE auth_sessions SET refresh_token_hash = $1, previous_refresh_token_hash = refresh_token_hash,
+			refreshed_at = $2, expires_at = $3, user_agent = $4, ip_address = $5, last_seen_at = $2
+		WHERE session_id = $6`,
+		newHash, now, now.Add(RefreshTokenValidity), meta.UserAgent, meta.IPAddress, sessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh: %w", err)
+	}
+
+	accessToken, err := GenerateToken(uid, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		SessionID:        sessionID,
+		AccessToken:      accessToken,
+		RefreshToken:     formatRefreshToken(sessionID, newSecret),
+		AccessExpiresIn:  TokenValidity,
+		RefreshExpiresIn: RefreshTokenValidity,
+	}, nil
+}
+
+// RevokeSession revokes a single session, invalidating its access and refresh tokens
+func RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := Mdb.DB.ExecContext(ctx,
+		"UPDATE auth_sessions SET revoked_at = $1 WHERE session_id = $2 AND revoked_at IS NULL",
+		time.Now(), sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSession revokes a session only if it belongs to the given user
+// Returns false if the user has no such active session
+func RevokeUserSession(ctx context.Context, uid, sessionID string) (bool, error) {
+	result, err := Mdb.DB.ExecContext(ctx,
+		"UPDATE auth_sessions SET revoked_at = $1 WHERE session_id = $2 AND user_uid = $3 AND revoked_at IS NULL",
+		time.Now(), sessionID, uid,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoke result: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func ListSessions(ctx context.Context, uid string) ([]Session, error) {
+	rows, err := Mdb.DB.QueryContext(ctx,
+		`SELECT session_id, user_uid, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+			created_at, last_seen_at, expires_at
+		FROM auth_sessions
+		WHERE user_uid = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_seen_at DESC`,
+		uid, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.SessionID, &session.UserUID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeUserSessions revokes every active session of a user ("logout everywhere")
+// Returns the number of sessions that were revoked
+func RevokeUserSessions(ctx context.Context, uid string) (int64, error) {
+	return revokeUserSessions(ctx, Mdb.DB, uid, time.Now())
+}
+
+func revokeUserSessions(ctx context.Context, db execer, uid string, now time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx,
+		"UPDATE auth_sessions SET revoked_at = $1 WHERE user_uid = $2 AND revoked_at IS NULL",
+		now, uid,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// checkSession returns an error if the session is unknown, revoked or expired
+// It also records the session as seen (at most once per lastSeenResolution)
+func checkSession(ctx context.Context, sessionID string) error {
+	var expiresAt, lastSeenAt time.Time
+	var revokedAt sql.NullTime
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT expires_at, revoked_at, last_seen_at FROM auth_sessions WHERE session_id = $1",
+		sessionID,
+	).Scan(&expiresAt, &revokedAt, &lastSeenAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionRevoked
+		}
+		return fmt.Errorf("failed to check session: %w", err)
+	}
+	if revokedAt.Valid {
+		return ErrSessionRevoked
+	}
+	if time.Now().After(expiresAt) {
+		return ErrSessionExpired
+	}
+
+	if time.Since(lastSeenAt) > lastSeenResolution {
+		go func() {
+			if _, err := Mdb.DB.ExecContext(context.Background(),
+				"UPDATE auth_sessions SET last_seen_at = $1 WHERE session_id = $2",
+				time.Now(), sessionID,
+			); err != nil {
+				log.Printf("checkSession: failed to update last_seen_at: %v", err)
+			}
+		}()
+	}
+	return nil
+}
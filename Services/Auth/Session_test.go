@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseRefreshToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		sessionID string
+		secret    string
+		ok        bool
+	}{
+		{name: "valid", token: "0123abcd.c2VjcmV0", sessionID: "0123abcd", secret: "c2VjcmV0", ok: true},
+		{name: "surrounding space", token: " 0123abcd.c2VjcmV0\n", sessionID: "0123abcd", secret: "c2VjcmV0", ok: true},
+		{name: "empty", token: ""},
+		{name: "no separator", token: "0123abcdc2VjcmV0"},
+		{name: "no session", token: ".c2VjcmV0"},
+		{name: "no secret", token: "0123abcd."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID, secret, ok := parseRefreshToken(tt.token)
+			if ok != tt.ok || sessionID != tt.sessionID || secret != tt.secret {
+				t.Errorf("parseRefreshToken(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.token, sessionID, secret, ok, tt.sessionID, tt.secret, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRoundTrip(t *testing.T) {
+	sessionID, err := generateID(16)
+	if err != nil {
+		t.Fatalf("generateID: %v", err)
+	}
+	secret, secretHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+
+	gotID, gotSecret, ok := parseRefreshToken(formatRefreshToken(sessionID, secret))
+	if !ok || gotID != sessionID || gotSecret != secret {
+		t.Fatalf("parsed %q, %q, %v, want %q, %q", gotID, gotSecret, ok, sessionID, secret)
+	}
+	// Only the hash is stored; the presented secret must hash to it
+	if HashOpaqueToken(gotSecret) != secretHash {
+		t.Error("secret does not hash to the stored hash")
+	}
+}
+
+func TestHashOpaqueToken(t *testing.T) {
+	// SHA-256 of "abc" (FIPS 180-2)
+	if got, want := HashOpaqueToken("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("HashOpaqueToken(abc) = %s, want %s", got, want)
+	}
+
+	token, hash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+	if len(token) != 43 { // 32 bytes, unpadded base64url
+		t.Errorf("token %q has length %d, want 43", token, len(token))
+	}
+	if hash != HashOpaqueToken(token) || len(hash) != 64 {
+		t.Errorf("hash %q does not match the token", hash)
+	}
+
+	other, _, err := GenerateOpaqueToken(32)
+	if err != nil {
+		t.Fatalf("GenerateOpaqueToken: %v", err)
+	}
+	if other == token {
+		t.Error("GenerateOpaqueToken returned the same token twice")
+	}
+}
+
+func TestRefreshSessionMalformed(t *testing.T) {
+	// Malformed tokens are rejected before the database is consulted
+	for _, token := range []string{"", "no-separator", ".secret", "session."} {
+		if _, err := RefreshSession(context.Background(), token, SessionMeta{}); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("RefreshSession(%q) = %v, want %v", token, err, ErrInvalidRefreshToken)
+		}
+	}
+}
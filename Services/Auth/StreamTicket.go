@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var ErrInvalidStreamTicket = errors.New("invalid or expired stream ticket")
+
+// StreamTicketValidity is how long a stream ticket can be redeemed
+// Tickets travel in the query string, so they are only good for opening the stream right away
+var StreamTicketValidity = 30 * time.Second
+
+// CreateStreamTicket issues a single-use ticket that opens one event stream as the principal
+// The ticket stands in for the credential the principal authenticated with (a session or an API key)
+func CreateStreamTicket(ctx context.Context, principal *Principal) (string, error) {
+	ticket, ticketHash, err := GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if _, err := Mdb.DB.ExecContext(ctx, "DELETE FROM stream_tickets WHERE expires_at <= $1", now); err != nil {
+		return "", fmt.Errorf("failed to delete expired stream tickets: %w", err)
+	}
+
+	var sessionID, apiKeyID sql.NullString
+	if principal.IsAPIKey() {
+		apiKeyID = sql.NullString{String: principal.APIKeyID, Valid: true}
+	} else {
+		sessionID = sql.NullString{String: principal.SessionID, Valid: true}
+	}
+	_, err = Mdb.DB.ExecContext(ctx,
+		`INSERT INTO stream_tickets (ticket_hash, user_uid, session_id, api_key_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		ticketHash, principal.UID, sessionID, apiKeyID, now, now.Add(StreamTicketValidity),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create stream ticket: %w", err)
+	}
+	return ticket, nil
+}
+
+// RedeemStreamTicket consumes a stream ticket and returns the principal it was issued to
+// The ticket cannot be used again, and is refused once its session or API key is revoked or expired
+func RedeemStreamTicket(ctx context.Context, ticket string) (*Principal, error) {
+	if ticket == "" {
+		return nil, ErrInvalidStreamTicket
+	}
+
+	principal := &Principal{}
+	var sessionID, apiKeyID sql.NullString
+	var scopes []string
+	err := Mdb.DB.QueryRowContext(ctx,
+		`WITH ticket AS (
+			DELETE FROM stream_tickets WHERE ticket_hash = $1
+			RETURNING user_uid, session_id, api_key_id, expires_at
+		)
+		SELECT t.user_uid, t.session_id, t.api_key_id, COALESCE(k.scopes, '{}')
+		FROM ticket t
+		LEFT JOIN auth_sessions s ON s.session_id = t.session_id
+			AND s.revoked_at IS NULL AND s.expires_at > $2
+		LEFT JOIN api_keys k ON k.key_id = t.api_key_id
+			AND k.revoked_at IS NULL AND k.expires_at > $2
+		WHERE t.expires_at > $2 AND (s.session_id IS NOT NULL OR k.key_id IS NOT NULL)`,
+		HashOpaqueToken(ticket), time.Now(),
+	).Scan(&principal.UID, &sessionID, &apiKeyID, pq.Array(&scopes))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidStreamTicket
+		}
+		return nil, fmt.Errorf("failed to redeem stream ticket: %w", err)
+	}
+	principal.SessionID = sessionID.String
+	principal.APIKeyID = apiKeyID.String
+	if principal.IsAPIKey() {
+		principal.Scopes = scopes
+	}
+	return principal, nil
+}
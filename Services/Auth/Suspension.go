@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrAccountSuspended = errors.New("account is suspended")
+	ErrUserNotFound     = errors.New("user not found")
+)
+
+// SuspendedUntil returns when a user's suspension ends; ok is false when the user is not suspended
+func SuspendedUntil(ctx context.Context, uid string) (until time.Time, ok bool, err error) {
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT suspended_until FROM users WHERE uid = $1 AND suspended_until > $2",
+		uid, time.Now(),
+	).Scan(&until)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, fmt.Errorf("failed to check suspension: %w", err)
+	}
+	return until, true, nil
+}
+
+// checkSuspended returns ErrAccountSuspended, with the end of the suspension, if the user is suspended
+func checkSuspended(ctx context.Context, uid string) error {
+	until, suspended, err := SuspendedUntil(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return fmt.Errorf("%w until %s", ErrAccountSuspended, until.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SuspendUser suspends a user until the given time and signs them out everywhere:
+// their sessions and API keys are revoked in the same transaction
+func SuspendUser(ctx context.Context, tx *sql.Tx, uid string, until time.Time) error {
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"UPDATE users SET suspended_until = $1, updated_at = $2 WHERE uid = $3",
+		until, now, uid,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to suspend user: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to suspend user: %w", err)
+	} else if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	if _, err := revokeUserSessions(ctx, tx, uid, now); err != nil {
+		return err
+	}
+	if _, err := RevokeUserAPIKeys(ctx, tx, uid); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LiftSuspension ends a user's suspension early; it returns false if the user was not suspended
+func LiftSuspension(ctx context.Context, tx *sql.Tx, uid string) (bool, error) {
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"UPDATE users SET suspended_until = NULL, updated_at = $1 WHERE uid = $2 AND suspended_until > $1",
+		now, uid,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to lift suspension: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to lift suspension: %w", err)
+	}
+	return rows > 0, nil
+}
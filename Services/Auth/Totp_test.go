@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 Appendix B test vectors ("12345678901234567890")
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 Appendix B lists 8 digit codes; the 6 digit codes are their last 6 digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},          // 94287082
+	{1111111109, "081804"},  // 07081804
+	{1111111111, "050471"},  // 14050471
+	{1234567890, "005924"},  // 89005924
+	{2000000000, "279037"},  // 69279037
+	{20000000000, "353130"}, // 65353130
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := totpCode([]byte("12345678901234567890"), step); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		at := time.Unix(tt.unix, 0)
+		step, ok := validateTOTP(rfc6238Secret, tt.code, at)
+		if !ok {
+			t.Errorf("validateTOTP rejected the code for %d", tt.unix)
+			continue
+		}
+		if step != totpStep(at) {
+			t.Errorf("validateTOTP at %d matched step %d, want %d", tt.unix, step, totpStep(at))
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	// 1111111111 is step 37037037; its code is accepted one step before and after, no further
+	issued := time.Unix(1111111111, 0)
+	period := time.Duration(totpPeriod) * time.Second
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		valid bool
+	}{
+		{name: "same step", at: issued, valid: true},
+		{name: "one step later", at: issued.Add(period), valid: true},
+		{name: "one step earlier", at: issued.Add(-period), valid: true},
+		{name: "two steps later", at: issued.Add(2 * period), valid: false},
+		{name: "two steps earlier", at: issued.Add(-2 * period), valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(rfc6238Secret, "050471", tt.at)
+			if ok != tt.valid {
+				t.Fatalf("validateTOTP = %v, want %v", ok, tt.valid)
+			}
+			if ok && step != totpStep(issued) {
+				t.Errorf("matched step %d, want %d", step, totpStep(issued))
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejects(t *testing.T) {
+	at := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{name: "wrong code", secret: rfc6238Secret, code: "287083"},
+		{name: "too short", secret: rfc6238Secret, code: "28708"},
+		{name: "eight digits", secret: rfc6238Secret, code: "94287082"},
+		{name: "invalid secret", secret: "not base32!", code: "287082"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := validateTOTP(tt.secret, tt.code, at); ok {
+				t.Fatal("validateTOTP accepted the code, want rejection")
+			}
+		})
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	defer func(key []byte) { keyEncryptionKey = key }(keyEncryptionKey)
+
+	keyEncryptionKey = nil
+	stored, err := sealSecret([]byte(rfc6238Secret))
+	if err != nil {
+		t.Fatalf("sealSecret without a key: %v", err)
+	}
+	if stored != rfc6238Secret || isSealed(stored) {
+		t.Fatalf("sealSecret without a key = %q, want the secret as is", stored)
+	}
+
+	keyEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	stored, err = sealSecret([]byte(rfc6238Secret))
+	if err != nil {
+		t.Fatalf("sealSecret: %v", err)
+	}
+	if !isSealed(stored) {
+		t.Fatalf("sealSecret = %q, want the %s prefix", stored, encryptedKeyPrefix)
+	}
+	plain, err := openSecret(stored)
+	if err != nil {
+		t.Fatalf("openSecret: %v", err)
+	}
+	if string(plain) != rfc6238Secret {
+		t.Errorf("openSecret = %q, want %q", plain, rfc6238Secret)
+	}
+
+	// Secrets stored before the key was configured are still readable
+	if plain, err := openSecret(rfc6238Secret); err != nil || string(plain) != rfc6238Secret {
+		t.Errorf("openSecret of a plain secret = %q, %v", plain, err)
+	}
+
+	keyEncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+	if _, err := openSecret(stored); err == nil {
+		t.Error("openSecret with another key succeeded, want error")
+	}
+	keyEncryptionKey = nil
+	if _, err := openSecret(stored); err == nil {
+		t.Error("openSecret without a key succeeded, want error")
+	}
+}
@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingEnrollment  = errors.New("no pending two-factor enrollment")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired challenge token")
+)
+
+// Challenge purposes
+const (
+	ChallengeTwoFactor  = "2fa"        // Password was correct, a TOTP or recovery code is still needed
+	ChallengeEnrollment = "2fa_enroll" // Password was correct, but the account must enroll in 2FA first
+)
+
+const (
+	challengeAudience  = "hifi-login-challenge"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10 // Characters, shown as two groups of five
+)
+
+// ChallengeTokenValidity is how long the second login step can be completed
+var ChallengeTokenValidity = 5 * time.Minute
+
+// ChallengeClaims are the claims of a login challenge token
+// They carry no session, so a challenge token is never accepted as an access token
+type ChallengeClaims struct {
+	UID     string `json:"uid"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// GenerateChallengeToken issues a short-lived token proving that the password step of a login succeeded
+func GenerateChallengeToken(uid, purpose string) (string, error) {
+	now := time.Now()
+	claims := ChallengeClaims{
+		UID:     uid,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeTokenValidity)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "hifi-backend",
+			Audience:  jwt.ClaimStrings{challengeAudience},
+		},
+	}
+
+	return signToken(claims)
+}
+
+// VerifyChallengeToken validates a challenge token for the given purpose and returns the user UID
+func VerifyChallengeToken(tokenString, purpose string) (string, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &ChallengeClaims{}, verificationKeyFunc,
+		jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}), jwt.WithAudience(challengeAudience))
+	if err != nil {
+		return "", ErrInvalidChallenge
+	}
+
+	claims, ok := token.Claims.(*ChallengeClaims)
+	if !ok || !token.Valid || claims.UID == "" || claims.Purpose != purpose {
+		return "", ErrInvalidChallenge
+	}
+	return claims.UID, nil
+}
+
+// TwoFactorEnabled reports whether a user has completed TOTP enrollment
+func TwoFactorEnabled(ctx context.Context, uid string) (bool, error) {
+	var enabled bool
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_uid = $1 AND enabled_at IS NOT NULL)",
+		uid,
+	).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check two-factor status: %w", err)
+	}
+	return enabled, nil
+}
+
+// RecoveryCodesRemaining returns the number of unused recovery codes of a user
+func RecoveryCodesRemaining(ctx context.Context, uid string) (int, error) {
+	var count int
+	err := Mdb.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM user_recovery_codes WHERE user_uid = $1 AND used_at IS NULL",
+		uid,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// BeginTOTPEnrollment creates a new pending TOTP secret for a user
+// Starting again replaces a pending secret; enrollment only takes effect once confirmed with a code
+func BeginTOTPEnrollment(ctx context.Context, uid, account string) (string, string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	storedSecret, err := sealSecret([]byte(secret))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encrypt totp secret: %w", err)
+	}
+
+	result, err := Mdb.DB.ExecContext(ctx,
+		`INSERT INTO user_totp (user_uid, secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_uid) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = 0
+		WHERE user_totp.enabled_at IS NULL`,
+		uid, storedSecret, time.Now(),
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store totp secret: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to check enrollment result: %w", err)
+	}
+	if rowsAffected == 0 {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	return secret, TOTPURI(secret, account), nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the user proves their app generates valid codes
+// Returns the recovery codes, which are only shown this one time
+func ConfirmTOTPEnrollment(ctx context.Context, uid, code string) ([]string, error) {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var storedSecret string
+	var enabledAt sql.NullTime
+	err = tx.QueryRowContext(ctx,
+		"SELECT secret, enabled_at FROM user_totp WHERE user_uid = $1 FOR UPDATE",
+		uid,
+	).Scan(&storedSecret, &enabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoPendingEnrollment
+		}
+		return nil, fmt.Errorf("failed to fetch totp secret: %w", err)
+	}
+	if enabledAt.Valid {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := openSecret(storedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open totp secret: %w", err)
+	}
+
+	step, ok := validateTOTP(string(secret), normalizeTwoFactorCode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET enabled_at = $1, last_used_step = $2 WHERE user_uid = $3",
+		time.Now(), step, uid,
+	); err != nil {
+		return nil, fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit enrollment: %w", err)
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or an unused recovery code for a user
+// TOTP codes cannot be reused and recovery codes are consumed
+func VerifySecondFactor(ctx context.Context, uid, code string) error {
+	code = normalizeTwoFactorCode(code)
+	if code == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the row so the same code cannot be accepted twice by concurrent requests
+	var storedSecret string
+	var lastUsedStep int64
+	err = tx.QueryRowContext(ctx,
+		"SELECT secret, last_used_step FROM user_totp WHERE user_uid = $1 AND enabled_at IS NOT NULL FOR UPDATE",
+		uid,
+	).Scan(&storedSecret, &lastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTwoFactorNotEnabled
+		}
+		return fmt.Errorf("failed to fetch totp secret: %w", err)
+	}
+	secret, err := openSecret(storedSecret)
+	if err != nil {
+		return fmt.Errorf("failed to open totp secret: %w", err)
+	}
+
+	now := time.Now()
+	if step, ok := validateTOTP(string(secret), code, now); ok {
+		if step <= lastUsedStep {
+			return ErrInvalidTwoFactorCode
+		}
+		// Secrets enrolled before JWT_KEY_ENCRYPTION_KEY was set are sealed on their next use
+		if !isSealed(storedSecret) {
+			if storedSecret, err = sealSecret(secret); err != nil {
+				return fmt.Errorf("failed to encrypt totp secret: %w", err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE user_totp SET last_used_step = $1, secret = $2 WHERE user_uid = $3",
+			step, storedSecret, uid,
+		); err != nil {
+			return fmt.Errorf("failed to record totp use: %w", err)
+		}
+	} else {
+		result, err := tx.ExecContext(ctx,
+			"UPDATE user_recovery_codes SET used_at = $1 WHERE user_uid = $2 AND code_hash = $3 AND used_at IS NULL",
+			now, uid, HashOpaqueToken(code),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to redeem recovery code: %w", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to check recovery code result: %w", err)
+		}
+		if rowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit two-factor verification: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of a user with new ones
+func RegenerateRecoveryCodes(ctx context.Context, uid string) ([]string, error) {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user
+func DisableTOTP(ctx context.Context, uid string) error {
+	tx, err := Mdb.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_uid = $1", uid); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_uid = $1", uid); err != nil {
+		return fmt.Errorf("failed to delete totp secret: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit two-factor removal: %w", err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and stores a fresh set
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, uid string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_uid = $1", uid); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO user_recovery_codes (user_uid, code_hash, created_at) VALUES ($1, $2, $3)",
+			uid, HashOpaqueToken(normalizeTwoFactorCode(code)), now,
+		); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a random code formatted as "xxxxx-xxxxx"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:recoveryCodeLength]
+	return raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:], nil
+}
+
+// normalizeTwoFactorCode strips formatting users commonly type into codes
+func normalizeTwoFactorCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
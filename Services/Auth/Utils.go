@@ -1,10 +1,41 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strings"
 )
 
 func GetAuthToken(r *http.Request) string {
 	return strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", 1)
-}
\ No newline at end of file
+}
+
+// GenerateOpaqueToken returns a URL-safe random token of n bytes along with its SHA-256 hash
+// Only the hash should be persisted, the token itself is handed to the client once
+func GenerateOpaqueToken(n int) (string, string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of a token
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateID returns a random hex identifier of n bytes
+func generateID(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,130 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email messages
+// Implementations: SMTPSender for production, LogSender for development and tests
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// DefaultSender is the sender used by Send, configured by InitMail
+// Until InitMail runs (e.g. in tests) messages go to the log
+var DefaultSender Sender = &LogSender{}
+
+// InitMail configures the default sender from the environment
+// MAIL_DRIVER selects the implementation and must be set: "smtp", or "log" to opt in to
+// writing messages (with their reset and verification links) to MAIL_LOG_PATH or the server log
+func InitMail() {
+	driver := strings.ToLower(strings.TrimSpace(os.Getenv("MAIL_DRIVER")))
+	switch driver {
+	case "smtp":
+		sender := &SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if sender.Port == "" {
+			sender.Port = "587"
+		}
+		if sender.Host == "" || sender.From == "" {
+			log.Fatal("MAIL_DRIVER=smtp requires SMTP_HOST and MAIL_FROM")
+		}
+		DefaultSender = sender
+		fmt.Printf("Mail initialized! Driver: smtp, Host: %s:%s\n", sender.Host, sender.Port)
+	case "log":
+		DefaultSender = &LogSender{Path: os.Getenv("MAIL_LOG_PATH")}
+		fmt.Println("Mail initialized! Driver: log (emails are not delivered)")
+	case "":
+		log.Fatal("MAIL_DRIVER is not set: use smtp, or log for development and tests")
+	default:
+		log.Fatalf("Unknown MAIL_DRIVER %q: use smtp or log", driver)
+	}
+}
+
+// Send delivers a message using the default sender
+func Send(ctx context.Context, msg Message) error {
+	return DefaultSender.Send(ctx, msg)
+}
+
+// SMTPSender delivers messages through an SMTP server using PLAIN auth (STARTTLS when offered)
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	body := "From: " + s.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body
+
+	if err := smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+// LogSender writes messages to a file (one block per message) or to the log when Path is empty
+// Messages are never delivered, which makes it suitable for development and tests
+type LogSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	entry := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n---\n",
+		time.Now().Format("2006-01-02 15:04:05"), msg.To, msg.Subject, msg.Body)
+
+	if s.Path == "" {
+		log.Printf("mail: %s", entry)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(entry); err != nil {
+		return fmt.Errorf("failed to write mail log: %w", err)
+	}
+	return nil
+}
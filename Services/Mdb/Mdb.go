@@ -67,6 +67,11 @@ func InitPostgres() {
 	fmt.Println("PostgreSQL connected!")
 }
 
+// URI returns the connection string of the database, for connections outside the pool (e.g. LISTEN)
+func URI() string {
+	return postgresURI
+}
+
 // RunMigrations runs all SQL migration files in order
 func RunMigrations() error {
 	migrations := []string{
@@ -81,6 +86,30 @@ func RunMigrations() error {
 		"DB/migrations/010_add_views_counter.sql",
 		"DB/migrations/011_add_videos_created_at_index.sql",
 		"DB/migrations/012_add_composite_indexes.sql",
+		"DB/migrations/013_create_auth_sessions_table.sql",
+		"DB/migrations/014_add_session_device_info.sql",
+		"DB/migrations/015_create_password_reset_tokens_table.sql",
+		"DB/migrations/016_add_email_verification.sql",
+		"DB/migrations/017_create_two_factor_tables.sql",
+		"DB/migrations/018_create_auth_attempts_table.sql",
+		"DB/migrations/019_create_jwt_signing_keys_table.sql",
+		"DB/migrations/020_create_user_identities_table.sql",
+		"DB/migrations/021_create_api_keys_table.sql",
+		"DB/migrations/022_create_roles_tables.sql",
+		"DB/migrations/023_create_mutes_tables.sql",
+		"DB/migrations/024_add_private_accounts.sql",
+		"DB/migrations/025_create_anonymous_views_table.sql",
+		"DB/migrations/026_add_comment_edits.sql",
+		"DB/migrations/027_create_comment_likes_tables.sql",
+		"DB/migrations/028_add_comment_pins_and_hearts.sql",
+		"DB/migrations/029_create_mentions_table.sql",
+		"DB/migrations/030_create_notifications_tables.sql",
+		"DB/migrations/031_add_video_counts_notify.sql",
+		"DB/migrations/032_create_direct_messages_tables.sql",
+		"DB/migrations/033_create_reports_tables.sql",
+		"DB/migrations/034_encrypt_totp_secrets.sql",
+		"DB/migrations/035_add_previous_refresh_token_hash.sql",
+		"DB/migrations/036_create_stream_tickets_table.sql",
 	}
 
 	for _, migrationFile := range migrations {
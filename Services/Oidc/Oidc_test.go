@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a local OpenID provider serving discovery, JWKS and a token endpoint
+type mockIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	code     string
+	verifier string
+	claims   jwt.MapClaims // Claims of the id_token returned for the code
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	m := &mockIssuer{key: key, clientID: "hifi-test", code: "auth-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		// The PKCE verifier must hash to the challenge sent in the authorization URL
+		if r.PostForm.Get("code") != m.code || CodeChallenge(r.PostForm.Get("code_verifier")) != CodeChallenge(m.verifier) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+		token.Header["kid"] = "mock-key"
+		signed, err := token.SignedString(m.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "id_token": signed})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return &Provider{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    m.clientID,
+		RedirectURL: "http://localhost/auth/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+		HTTPClient:  m.server.Client(),
+	}
+}
+
+func (m *mockIssuer) issue(nonce string, overrides jwt.MapClaims) {
+	now := time.Now()
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "subject-123",
+		"aud":            m.clientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "Jane.Doe@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+	for k, v := range overrides {
+		m.claims[k] = v
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+
+	raw, err := p.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("invalid url: %v", err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             m.clientID,
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        CodeChallenge("verifier-1"),
+		"code_challenge_method": "S256",
+		"scope":                 "openid email profile",
+	}
+	for k, v := range want {
+		if got := q.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIssuer(t)
+	m.verifier = "verifier-abc"
+	m.issue("nonce-abc", nil)
+
+	claims, err := m.provider().Exchange(context.Background(), m.code, m.verifier, "nonce-abc")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "subject-123" || claims.Email != "Jane.Doe@example.com" || !claims.EmailVerified || claims.Name != "Jane Doe" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		nonce     string
+		overrides jwt.MapClaims
+	}{
+		{name: "wrong code verifier", verifier: "other-verifier", nonce: "nonce-abc"},
+		{name: "wrong nonce", verifier: "verifier-abc", nonce: "other-nonce"},
+		{name: "wrong audience", verifier: "verifier-abc", nonce: "nonce-abc", overrides: jwt.MapClaims{"aud": "someone-else"}},
+		{name: "wrong issuer", verifier: "verifier-abc", nonce: "nonce-abc", overrides: jwt.MapClaims{"iss": "https://evil.example.com"}},
+		{name: "expired", verifier: "verifier-abc", nonce: "nonce-abc", overrides: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "missing subject", verifier: "verifier-abc", nonce: "nonce-abc", overrides: jwt.MapClaims{"sub": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockIssuer(t)
+			m.verifier = "verifier-abc"
+			m.issue("nonce-abc", tt.overrides)
+
+			if _, err := m.provider().Exchange(context.Background(), m.code, tt.verifier, tt.nonce); err == nil {
+				t.Fatal("Exchange succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenUnknownKey(t *testing.T) {
+	m := newMockIssuer(t)
+	m.issue("nonce-abc", nil)
+
+	// Signed by a key the issuer does not publish
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+	token.Header["kid"] = "mock-key"
+	signed, err := token.SignedString(other)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	_, err = m.provider().VerifyIDToken(context.Background(), signed, "nonce-abc")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("VerifyIDToken error = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	p.Issuer = m.server.URL + "/other"
+
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Fatal("AuthCodeURL succeeded with a mismatched issuer")
+	}
+}
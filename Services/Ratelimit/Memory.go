@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired counters are dropped from memory
+const sweepInterval = 10 * time.Minute
+
+type memoryEntry struct {
+	failures    int
+	lastFailure time.Time
+	expires     time.Time
+}
+
+// MemoryStore keeps counters in process memory; counters are lost on restart and not shared between replicas
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+	now       func() time.Time // Replaced in tests
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry), lastSweep: time.Now(), now: time.Now}
+}
+
+// Attempt checks and records under the same lock, so concurrent attempts are counted one by one
+func (s *MemoryStore) Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &memoryEntry{}
+		s.entries[key] = entry
+	}
+	if wait := policy.wait(entry.failures, entry.lastFailure, now); wait > 0 {
+		return wait, nil
+	}
+	entry.failures++
+	entry.lastFailure = now
+	entry.expires = now.Add(policy.window())
+	return 0, nil
+}
+
+func (s *MemoryStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.failures > 0 {
+		entry.failures--
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops expired entries so memory does not grow with every IP ever seen (caller holds mu)
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+// PostgresStore keeps counters in the auth_attempts table so all replicas share them
+type PostgresStore struct{}
+
+// NewPostgresStore returns a store backed by the auth_attempts table and starts removing expired rows
+func NewPostgresStore() *PostgresStore {
+	s := &PostgresStore{}
+	go s.cleanup()
+	return s
+}
+
+// Attempt records the attempt in a single UPSERT so concurrent attempts on different replicas are checked
+// and counted one by one: the row is only updated when the policy's delay since the last failure has passed.
+// The delays come from Policy.schedule, indexed by the failures counted so far.
+func (s *PostgresStore) Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	schedule := policy.schedule()
+	delays := make([]int64, len(schedule))
+	for i, d := range schedule {
+		delays[i] = d.Milliseconds()
+	}
+
+	now := time.Now()
+	var failures int
+	err := Mdb.DB.QueryRowContext(ctx,
+		`INSERT INTO auth_attempts (key, failures, last_failure_at, expires_at)
+		VALUES ($1, 1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN auth_attempts.expires_at <= $2 THEN 1 ELSE auth_attempts.failures + 1 END,
+			last_failure_at = $2,
+			expires_at = $3
+		WHERE auth_attempts.expires_at <= $2
+			OR auth_attempts.last_failure_at
+				+ ($4::bigint[])[LEAST(auth_attempts.failures + 1, cardinality($4::bigint[]))] * INTERVAL '1 millisecond' <= $2
+		RETURNING failures`,
+		key, now, now.Add(policy.window()), pq.Array(delays),
+	).Scan(&failures)
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	// Not recorded: the key has to wait, read how long
+	var lastFailure time.Time
+	err = Mdb.DB.QueryRowContext(ctx,
+		"SELECT failures, last_failure_at FROM auth_attempts WHERE key = $1 AND expires_at > $2",
+		key, now,
+	).Scan(&failures, &lastFailure)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+	return policy.wait(failures, lastFailure, now), nil
+}
+
+func (s *PostgresStore) Forgive(ctx context.Context, key string) error {
+	if _, err := Mdb.DB.ExecContext(ctx,
+		"UPDATE auth_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0",
+		key,
+	); err != nil {
+		return fmt.Errorf("failed to forgive attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	if _, err := Mdb.DB.ExecContext(ctx, "DELETE FROM auth_attempts WHERE key = $1", key); err != nil {
+		return fmt.Errorf("failed to reset attempts: %w", err)
+	}
+	return nil
+}
+
+// cleanup periodically deletes expired counters
+func (s *PostgresStore) cleanup() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := Mdb.DB.ExecContext(context.Background(),
+			"DELETE FROM auth_attempts WHERE expires_at < $1", time.Now(),
+		); err != nil {
+			log.Printf("ratelimit: failed to clean up auth_attempts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes how failed attempts are throttled
+// After FreeAttempts failures each further attempt has to wait BaseDelay, doubling with every failure
+// up to MaxDelay; after LockoutAfter failures the key is locked for LockoutDuration.
+// Failures are forgotten once no new failure happened for Window.
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int // 0 disables the lockout
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// window returns how long failures are remembered (never shorter than a lockout)
+func (p Policy) window() time.Duration {
+	if p.LockoutDuration > p.Window {
+		return p.LockoutDuration
+	}
+	return p.Window
+}
+
+// delay returns how long after its last failure a key with the given failures has to wait
+func (p Policy) delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+
+	delay := p.MaxDelay
+	if shift := failures - p.FreeAttempts - 1; shift < 32 {
+		if d := p.BaseDelay << uint(shift); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// wait returns how long a key with the given failures must wait before its next attempt
+func (p Policy) wait(failures int, lastFailure, now time.Time) time.Duration {
+	if d := lastFailure.Add(p.delay(failures)).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// schedule returns the delay after 0, 1, ... failures up to the count from which the delay stays the same
+// Lets a store evaluate the policy in SQL
+func (p Policy) schedule() []time.Duration {
+	last := p.FreeAttempts + 33 // The doubling reaches MaxDelay within 32 steps
+	if p.LockoutAfter > 0 && p.LockoutAfter < last {
+		last = p.LockoutAfter
+	}
+	delays := make([]time.Duration, last+1)
+	for failures := range delays {
+		delays[failures] = p.delay(failures)
+	}
+	return delays
+}
+
+// Store keeps failure counters; MemoryStore serves a single instance, PostgresStore is shared by replicas
+type Store interface {
+	// Attempt records an attempt on a key unless the policy makes it wait, in one atomic step
+	// Returns the wait (0 when the attempt was recorded)
+	Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error)
+	// Forgive removes one recorded attempt of a key
+	Forgive(ctx context.Context, key string) error
+	// Reset forgets all failures of a key
+	Reset(ctx context.Context, key string) error
+}
+
+// Limiter throttles attempts per key (e.g. per IP address or per username)
+type Limiter struct {
+	Name   string // Key prefix, keeps limiters sharing a store apart
+	Policy Policy
+	Store  Store
+}
+
+func (l *Limiter) key(id string) string {
+	return l.Name + ":" + strings.ToLower(id)
+}
+
+// Attempt returns how long the caller must wait before an attempt for id is allowed (0 means allowed)
+// An allowed attempt is counted as a failure right away, so concurrent requests cannot all slip
+// through before the first failure is recorded; callers Forgive or Reset it when it succeeds.
+func (l *Limiter) Attempt(ctx context.Context, id string) (time.Duration, error) {
+	wait, err := l.Store.Attempt(ctx, l.key(id), l.Policy)
+	if err != nil {
+		return 0, fmt.Errorf("ratelimit %s: %w", l.Name, err)
+	}
+	return wait, nil
+}
+
+// Forgive takes back an attempt of id that turned out not to be a failure
+func (l *Limiter) Forgive(ctx context.Context, id string) error {
+	if err := l.Store.Forgive(ctx, l.key(id)); err != nil {
+		return fmt.Errorf("ratelimit %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+// Reset clears the failures of id (after a successful login or an admin unlock)
+func (l *Limiter) Reset(ctx context.Context, id string) error {
+	if err := l.Store.Reset(ctx, l.key(id)); err != nil {
+		return fmt.Errorf("ratelimit %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+// Limiters used by the auth endpoints, configured by InitRatelimit
+var (
+	LoginIP         *Limiter // Failed logins per client IP
+	LoginUser       *Limiter // Failed logins per username (account lockout)
+	LoginTwoFactor  *Limiter // Failed second factor codes per user UID
+	RegisterIP      *Limiter // Registration attempts per client IP
+	PasswordResetIP *Limiter // Forgot password requests per client IP
+)
+
+func init() {
+	configure(NewMemoryStore(), 10, 15*time.Minute)
+}
+
+// InitRatelimit configures the auth limiters from the environment
+// RATELIMIT_BACKEND selects the store: "memory" (default, single instance) or "postgres" (multiple replicas)
+func InitRatelimit() {
+	maxFailures := 10
+	if v, err := strconv.Atoi(os.Getenv("AUTH_MAX_FAILED_ATTEMPTS")); err == nil && v > 0 {
+		maxFailures = v
+	}
+	lockout := 15 * time.Minute
+	if v, err := strconv.Atoi(os.Getenv("AUTH_LOCKOUT_MINUTES")); err == nil && v > 0 {
+		lockout = time.Duration(v) * time.Minute
+	}
+
+	var store Store
+	backend := strings.ToLower(os.Getenv("RATELIMIT_BACKEND"))
+	switch backend {
+	case "postgres":
+		store = NewPostgresStore()
+	case "", "memory":
+		backend = "memory"
+		store = NewMemoryStore()
+	default:
+		log.Fatalf("Unknown RATELIMIT_BACKEND %q (expected memory or postgres)", backend)
+	}
+
+	configure(store, maxFailures, lockout)
+	fmt.Printf("Rate limiting initialized! Backend: %s, lockout after %d failures for %s\n", backend, maxFailures, lockout)
+}
+
+func configure(store Store, maxFailures int, lockout time.Duration) {
+	LoginUser = &Limiter{
+		Name:  "login_user",
+		Store: store,
+		Policy: Policy{
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutAfter:    maxFailures,
+			LockoutDuration: lockout,
+			Window:          time.Hour,
+		},
+	}
+	LoginTwoFactor = &Limiter{
+		Name:   "login_2fa",
+		Store:  store,
+		Policy: LoginUser.Policy,
+	}
+	// Many users can share an IP (NAT, offices), so IPs get more room before they are slowed down
+	LoginIP = &Limiter{
+		Name:  "login_ip",
+		Store: store,
+		Policy: Policy{
+			FreeAttempts:    20,
+			BaseDelay:       time.Second,
+			MaxDelay:        5 * time.Minute,
+			LockoutAfter:    maxFailures * 10,
+			LockoutDuration: lockout,
+			Window:          time.Hour,
+		},
+	}
+	RegisterIP = &Limiter{
+		Name:  "register_ip",
+		Store: store,
+		Policy: Policy{
+			FreeAttempts:    10,
+			BaseDelay:       time.Second,
+			MaxDelay:        5 * time.Minute,
+			LockoutAfter:    50,
+			LockoutDuration: time.Hour,
+			Window:          time.Hour,
+		},
+	}
+	PasswordResetIP = &Limiter{
+		Name:  "password_reset_ip",
+		Store: store,
+		Policy: Policy{
+			FreeAttempts:    10,
+			BaseDelay:       time.Second,
+			MaxDelay:        5 * time.Minute,
+			LockoutAfter:    30,
+			LockoutDuration: time.Hour,
+			Window:          time.Hour,
+		},
+	}
+}
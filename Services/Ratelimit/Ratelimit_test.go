@@ -0,0 +1,221 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// testLimiter returns a limiter on a memory store whose clock is moved by the returned function
+func testLimiter(policy Policy) (*Limiter, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	advance := func(d time.Duration) { now = now.Add(d) }
+	return &Limiter{Name: "test", Policy: policy, Store: store}, advance
+}
+
+func TestPolicyWait(t *testing.T) {
+	last := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		failures int
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		{name: "no failures", failures: 0, want: 0},
+		{name: "free attempts", failures: 3, want: 0},
+		{name: "first delay", failures: 4, want: time.Second},
+		{name: "doubles", failures: 5, want: 2 * time.Second},
+		{name: "doubles again", failures: 6, want: 4 * time.Second},
+		{name: "last delay before lockout", failures: 9, want: 32 * time.Second},
+		{name: "lockout", failures: 10, want: 15 * time.Minute},
+		{name: "lockout beyond threshold", failures: 25, want: 15 * time.Minute},
+		{name: "partly elapsed", failures: 6, elapsed: 3 * time.Second, want: time.Second},
+		{name: "elapsed", failures: 6, elapsed: 5 * time.Second, want: 0},
+		{name: "lockout elapsed", failures: 10, elapsed: 15 * time.Minute, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testPolicy.wait(tt.failures, last, last.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("wait(%d) after %s = %s, want %s", tt.failures, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyWaitWithoutLockout(t *testing.T) {
+	policy := testPolicy
+	policy.LockoutAfter = 0
+	last := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, failures := range []int{10, 40, 1000} {
+		if got := policy.wait(failures, last, last); got != time.Minute {
+			t.Errorf("wait(%d) = %s, want the max delay", failures, got)
+		}
+	}
+}
+
+func TestPolicySchedule(t *testing.T) {
+	for _, policy := range []Policy{testPolicy, {FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Hour, Window: time.Hour}} {
+		schedule := policy.schedule()
+		for failures := 0; failures < len(schedule)+50; failures++ {
+			i := failures
+			if i >= len(schedule) {
+				i = len(schedule) - 1
+			}
+			if schedule[i] != policy.delay(failures) {
+				t.Fatalf("schedule gives %s after %d failures, delay gives %s", schedule[i], failures, policy.delay(failures))
+			}
+		}
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	ctx := context.Background()
+	limiter, advance := testLimiter(testPolicy)
+
+	for i := 1; i <= testPolicy.FreeAttempts+1; i++ {
+		if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("attempt %d: wait %s, want 0", i, wait)
+		}
+	}
+	wait, _ := limiter.Attempt(ctx, "alice")
+	if wait != time.Second {
+		t.Fatalf("attempt after %d failures: wait %s, want 1s", testPolicy.FreeAttempts+1, wait)
+	}
+
+	// A throttled attempt is not counted
+	advance(time.Second)
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+		t.Fatalf("attempt after the delay: wait %s, want 0", wait)
+	}
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != 2*time.Second {
+		t.Fatalf("next attempt: wait %s, want 2s", wait)
+	}
+
+	// Keys are case insensitive and independent of each other
+	if wait, _ := limiter.Attempt(ctx, "ALICE"); wait == 0 {
+		t.Error("ALICE is not throttled like alice")
+	}
+	if wait, _ := limiter.Attempt(ctx, "bob"); wait != 0 {
+		t.Errorf("bob: wait %s, want 0", wait)
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	ctx := context.Background()
+	limiter, advance := testLimiter(testPolicy)
+
+	for i := 1; i <= testPolicy.LockoutAfter; i++ {
+		if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("attempt %d: wait %s, want 0", i, wait)
+		}
+		advance(time.Minute)
+	}
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != testPolicy.LockoutDuration-time.Minute {
+		t.Fatalf("locked out attempt: wait %s, want %s", wait, testPolicy.LockoutDuration-time.Minute)
+	}
+
+	advance(testPolicy.LockoutDuration)
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+		t.Fatalf("attempt after the lockout: wait %s, want 0", wait)
+	}
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != testPolicy.LockoutDuration {
+		t.Fatalf("failure after the lockout: wait %s, want a new lockout", wait)
+	}
+}
+
+func TestLimiterReset(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := testLimiter(testPolicy)
+
+	for i := 0; i < testPolicy.LockoutAfter; i++ {
+		limiter.Attempt(ctx, "alice")
+	}
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait == 0 {
+		t.Fatal("alice is not locked out")
+	}
+
+	if err := limiter.Reset(ctx, "alice"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	for i := 1; i <= testPolicy.FreeAttempts+1; i++ {
+		if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("attempt %d after reset: wait %s, want 0", i, wait)
+		}
+	}
+}
+
+func TestLimiterForgive(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := testLimiter(testPolicy)
+
+	// Attempts that are forgiven do not add up
+	for i := 0; i < 2*testPolicy.LockoutAfter; i++ {
+		if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("attempt %d: wait %s, want 0", i+1, wait)
+		}
+		if err := limiter.Forgive(ctx, "alice"); err != nil {
+			t.Fatalf("Forgive: %v", err)
+		}
+	}
+}
+
+func TestLimiterExpiry(t *testing.T) {
+	ctx := context.Background()
+	limiter, advance := testLimiter(testPolicy)
+
+	for i := 0; i < testPolicy.LockoutAfter-1; i++ {
+		limiter.Attempt(ctx, "alice")
+		advance(time.Minute)
+	}
+
+	// Failures are forgotten once none happened for the window
+	advance(testPolicy.Window)
+	for i := 1; i <= testPolicy.FreeAttempts+1; i++ {
+		if wait, _ := limiter.Attempt(ctx, "alice"); wait != 0 {
+			t.Fatalf("attempt %d after expiry: wait %s, want 0", i, wait)
+		}
+	}
+	if wait, _ := limiter.Attempt(ctx, "alice"); wait != time.Second {
+		t.Fatalf("attempt after expiry: wait %s, want the first delay", wait)
+	}
+}
+
+func TestLimiterConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := testLimiter(testPolicy)
+
+	// All attempts arrive at the same moment, only the free ones and the first delayed one may pass
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait, _ := limiter.Attempt(ctx, "alice"); wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if want := testPolicy.FreeAttempts + 1; allowed != want {
+		t.Errorf("%d concurrent attempts allowed, want %d", allowed, want)
+	}
+}
@@ -0,0 +1,184 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	Mdb "hifi/Services/Mdb"
+)
+
+// Channel is the Postgres channel every instance listens on
+// Events are published with pg_notify, so each instance receives the events of all instances
+const Channel = "hifi_stream"
+
+// maxPayload keeps payloads below the 8000 byte limit of pg_notify
+const maxPayload = 7900
+
+// SubscriptionBuffer is how many events a subscriber may fall behind before it is disconnected
+const SubscriptionBuffer = 64
+
+// Events that are not about a topic
+const (
+	EventResync = "resync" // The listener reconnected and events may have been missed; clients should refetch
+)
+
+// Message is an event published on a topic
+type Message struct {
+	Topic string          `json:"topic"`           // e.g. video:<video_id> or user:<uid>; empty for every subscriber
+	Event string          `json:"event"`           // e.g. comment, counts or notification
+	Actor string          `json:"actor,omitempty"` // UID of the user who caused the event, if any
+	Data  json.RawMessage `json:"data,omitempty"`  // Left out when it does not fit in a notification
+}
+
+// VideoTopic is the topic of the events about a video (new comments and replies, counts)
+func VideoTopic(videoID string) string {
+	return "video:" + videoID
+}
+
+// UserTopic is the topic of the events for a user (notifications)
+func UserTopic(uid string) string {
+	return "user:" + uid
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Publish sends an event to the subscribers of a topic on every instance
+// Published in a transaction, the event is delivered when it commits and dropped when it rolls back
+// Data that does not fit in a notification is left out; subscribers refetch instead
+func Publish(ctx context.Context, db execer, topic, event, actor string, data interface{}) error {
+	msg := Message{Topic: topic, Event: event, Actor: actor}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", event, err)
+		}
+		msg.Data = encoded
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+	if len(payload) > maxPayload {
+		msg.Data = nil
+		if payload, err = json.Marshal(msg); err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", event, err)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "SELECT pg_notify($1, $2)", Channel, string(payload)); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", event, err)
+	}
+	return nil
+}
+
+// Subscription receives the events of its topics on C
+// C is closed when the subscriber falls more than SubscriptionBuffer events behind
+type Subscription struct {
+	C      <-chan Message
+	c      chan Message
+	topics map[string]bool
+}
+
+var hub = struct {
+	sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}{subscriptions: make(map[*Subscription]struct{})}
+
+// Subscribe returns a subscription to the events of the topics on this instance
+// Close it when done
+func Subscribe(topics ...string) *Subscription {
+	c := make(chan Message, SubscriptionBuffer)
+	s := &Subscription{C: c, c: c, topics: make(map[string]bool, len(topics))}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+
+	hub.Lock()
+	hub.subscriptions[s] = struct{}{}
+	hub.Unlock()
+	return s
+}
+
+// Close stops the subscription; closing it again is a no-op
+func (s *Subscription) Close() {
+	hub.Lock()
+	defer hub.Unlock()
+	if _, ok := hub.subscriptions[s]; ok {
+		delete(hub.subscriptions, s)
+		close(s.c)
+	}
+}
+
+// dispatch hands a message to the subscribers of its topic without blocking
+// A subscriber whose buffer is full is closed, so a slow client reconnects rather than stalling the others
+func dispatch(msg Message) {
+	hub.Lock()
+	defer hub.Unlock()
+	for s := range hub.subscriptions {
+		if msg.Topic != "" && !s.topics[msg.Topic] {
+			continue
+		}
+		select {
+		case s.c <- msg:
+		default:
+			delete(hub.subscriptions, s)
+			close(s.c)
+		}
+	}
+}
+
+// Start listens on Channel with its own connection and dispatches the events to the subscribers
+func Start(uri string) error {
+	listener := pq.NewListener(uri, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Realtime: listener: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %w", Channel, err)
+	}
+	go listen(listener)
+	return nil
+}
+
+func listen(listener *pq.Listener) {
+	for {
+		select {
+		case n := <-listener.Notify:
+			// nil after the connection was re-established: events published meanwhile are lost
+			if n == nil {
+				dispatch(Message{Event: EventResync})
+				continue
+			}
+			var msg Message
+			if err := json.Unmarshal([]byte(n.Extra), &msg); err != nil {
+				log.Printf("Realtime: failed to decode event: %v", err)
+				continue
+			}
+			dispatch(msg)
+		case <-time.After(90 * time.Second):
+			// Detect a dead connection while the channel is quiet
+			go listener.Ping()
+		}
+	}
+}
+
+// InitRealtime starts listening for events published by any instance
+func InitRealtime() {
+	if err := Start(Mdb.URI()); err != nil {
+		log.Fatalf("Failed to start realtime listener: %v", err)
+	}
+	fmt.Println("Realtime initialized! Channel: " + Channel)
+}
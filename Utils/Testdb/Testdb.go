@@ -0,0 +1,215 @@
+// Package testdb is the Postgres fixture shared by the handler tests
+//
+// The tests need a Postgres database they may write to, e.g.
+//
+//	HIFI_TEST_POSTGRES="host=localhost user=hiffi password=... dbname=hiffi_test sslmode=disable" go test ./Events/...
+//
+// and are skipped when HIFI_TEST_POSTGRES is not set. The migrations are run against the
+// database first; every test removes the users (and with them the rows) it created.
+package testdb
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+
+	Auth "hifi/Services/Auth"
+	Mdb "hifi/Services/Mdb"
+	Realtime "hifi/Services/Realtime"
+)
+
+// Main is the TestMain of packages using the fixture: it connects to HIFI_TEST_POSTGRES, if set,
+// runs the migrations, loads the signing keys and starts the realtime listener before running the tests
+func Main(m *testing.M) {
+	if dsn := os.Getenv("HIFI_TEST_POSTGRES"); dsn != "" {
+		if err := setup(dsn); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up test database: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func setup(dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(25)
+	if err := db.Ping(); err != nil {
+		return err
+	}
+	Mdb.DB = db
+
+	if err := Realtime.Start(dsn); err != nil {
+		return err
+	}
+
+	// Migration paths are relative to the repository root
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, err := repositoryRoot(wd)
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+	if err := Mdb.RunMigrations(); err != nil {
+		return err
+	}
+
+	// Sessions sign their access tokens with the key ring
+	Auth.Initauth()
+	return nil
+}
+
+// repositoryRoot returns the closest directory above dir that holds go.mod
+func repositoryRoot(dir string) (string, error) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// Require skips the test when no test database is configured
+func Require(t *testing.T) {
+	t.Helper()
+	if Mdb.DB == nil {
+		t.Skip("HIFI_TEST_POSTGRES is not set")
+	}
+}
+
+type User struct {
+	UID      string
+	Username string
+}
+
+func RandomHex(t *testing.T, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to read random bytes: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// CreateUsers inserts n users and removes them (cascading to their rows) when the test ends
+func CreateUsers(t *testing.T, n int) []User {
+	t.Helper()
+	run := RandomHex(t, 4)
+	users := make([]User, n)
+	uids := make([]string, n)
+	for i := range users {
+		users[i] = User{
+			UID:      fmt.Sprintf("test_%s_%02d", run, i),
+			Username: fmt.Sprintf("test_%s_%02d", run, i),
+		}
+		uids[i] = users[i].UID
+		if _, err := Mdb.DB.Exec(
+			"INSERT INTO users (uid, username, name) VALUES ($1, $2, $3)",
+			users[i].UID, users[i].Username, "Test User",
+		); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		Mdb.DB.Exec("DELETE FROM videos WHERE user_uid = ANY($1)", pq.Array(uids))
+		Mdb.DB.Exec("DELETE FROM users WHERE uid = ANY($1)", pq.Array(uids))
+	})
+	return users
+}
+
+func CreateVideo(t *testing.T, owner User) string {
+	t.Helper()
+	videoID := "test_" + RandomHex(t, 16)
+	if _, err := Mdb.DB.Exec(
+		"INSERT INTO videos (video_id, video_url, video_title, user_uid, user_username) VALUES ($1, $2, $3, $4, $5)",
+		videoID, "videos/"+videoID, "Test Video", owner.UID, owner.Username,
+	); err != nil {
+		t.Fatalf("failed to create video: %v", err)
+	}
+	return videoID
+}
+
+// Router mounts routes behind a fake auth middleware that trusts X-Test-UID
+// Requests without the header are anonymous
+func Router(routes func(r chi.Router)) http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if uid := req.Header.Get("X-Test-UID"); uid != "" {
+				req = req.WithContext(Auth.WithPrincipal(req.Context(), &Auth.Principal{UID: uid}))
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+	routes(r)
+	return r
+}
+
+// Request sends a request as uid (anonymous if empty) and fails the test on a server error
+func Request(t *testing.T, router http.Handler, uid, method, path, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if uid != "" {
+		req.Header.Set("X-Test-UID", uid)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code >= 500 {
+		t.Errorf("%s %s as %s: status %d: %s", method, path, uid, rec.Code, rec.Body.String())
+	}
+	return rec.Code
+}
+
+// RequestData is Request that also decodes the data of a successful response into out
+func RequestData(t *testing.T, router http.Handler, uid, method, path, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Test-UID", uid)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code >= 500 {
+		t.Errorf("%s %s as %s: status %d: %s", method, path, uid, rec.Code, rec.Body.String())
+	}
+	if rec.Code == http.StatusOK {
+		resp := struct {
+			Data interface{} `json:"data"`
+		}{Data: out}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func QueryInt(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := Mdb.DB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("query %q failed: %v", query, err)
+	}
+	return n
+}
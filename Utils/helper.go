@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,10 +17,41 @@ import (
 
 var PythonServer string
 var LocalStorage string
+var TrustProxyHeaders bool
+var AppBaseURL string
+var ViewHashSalt string
 
 func InitEnv(){
 	LocalStorage = os.Getenv("LocalStorage_PATH")
 	PythonServer = os.Getenv("PYTHON_SERVER")
+	TrustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+	AppBaseURL = strings.TrimRight(os.Getenv("APP_BASE_URL"), "/") // Frontend URL used in emailed links
+	ViewHashSalt = os.Getenv("VIEW_HASH_SALT")                     // Salt of the hashed IP address and user agent of anonymous views
+}
+
+// ClientIP returns the IP address of the client that sent the request
+// Proxy headers (CF-Connecting-IP, X-Forwarded-For, X-Real-IP) are only honoured
+// when TRUST_PROXY_HEADERS=true, since clients can set them freely otherwise
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if ip := strings.TrimSpace(r.Header.Get("CF-Connecting-IP")); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func GenerateRandomString(length int) string {
@@ -102,6 +136,16 @@ func SendErrorResponse(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// SendTooManyRequests sends a 429 error response with a Retry-After header (whole seconds, rounded up)
+func SendTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	SendErrorResponse(w, http.StatusTooManyRequests, message)
+}
+
 // SendSuccessResponse sends a standardized success response
 // Use this for all successful responses to maintain consistency
 func SendSuccessResponse(w http.ResponseWriter, data interface{}) {
@@ -15,6 +15,10 @@ import (
 	Event "hifi/Events"
 	Auth "hifi/Services/Auth"
 	ES "hifi/Services/Elasticsearch"
+	Mail "hifi/Services/Mail"
+	Oidc "hifi/Services/Oidc"
+	Ratelimit "hifi/Services/Ratelimit"
+	Realtime "hifi/Services/Realtime"
 	Mdb "hifi/Services/Mdb"
 	Utils "hifi/Utils"
 	Storage "hifi/Services/Storage"
@@ -75,8 +79,11 @@ func main() {
 	Utils.InitEnv()
 	Utils.InitEnv()
 	Storage.InitStorage()
+	Mail.InitMail()
+	Ratelimit.InitRatelimit()
+	Oidc.InitOidc()
 	ES.InitElasticsearch()
-	Event.Init()
+	Realtime.InitRealtime()
 	
 	mux := chi.NewRouter()
 	mux.Use(corsMiddleware,loggingMiddleware)